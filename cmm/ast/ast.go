@@ -54,6 +54,9 @@ type LetStatement struct {
 	// one identifier can be used at multiple places
 	// so we need to keep track of it's value globally
 	Value Expression
+
+	// Type is the type the let is annotated with, or nil.
+	Type *TypeExpression
 }
 
 func (l *LetStatement) statementNode()       {}
@@ -63,6 +66,9 @@ func (l *LetStatement) String() string {
 
 	out.WriteString(l.TokenLiteral() + " ")
 	out.WriteString(l.Name.String())
+	if l.Type != nil {
+		out.WriteString(": " + l.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if l.Value != nil {
@@ -80,6 +86,18 @@ func (l *LetStatement) String() string {
 type Identifier struct {
 	Token token.Token
 	Value string
+
+	// Binding is set by the resolver when the identifier names a
+	// parameter or let of a function. Identifiers without one are globals
+	// and are looked up by name.
+	Binding *Binding
+}
+
+// Binding locates a function's variable: Slot in the environment of the
+// function Depth levels out from the one the identifier appears in.
+type Binding struct {
+	Depth int
+	Slot  int
 }
 
 func (i *Identifier) expressionNode()      {}
@@ -132,9 +150,43 @@ func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+type StringLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (sl *StringLiteral) expressionNode()      {}
+func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) String() string       { return QuoteString(sl.Value) }
+
+// QuoteString returns s as a string literal, escaping what the lexer
+// unescapes.
+func QuoteString(s string) string {
+	var out bytes.Buffer
+
+	out.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\\':
+			out.WriteByte('\\')
+			out.WriteByte(s[i])
+		case '\n':
+			out.WriteString("\\n")
+		case '\t':
+			out.WriteString("\\t")
+		default:
+			out.WriteByte(s[i])
+		}
+	}
+	out.WriteByte('"')
+
+	return out.String()
+}
+
 type PrefixExpression struct {
 	Token    token.Token
 	Operator string
+	Op       Operator
 	Right    Expression
 }
 
@@ -154,6 +206,7 @@ func (pe *PrefixExpression) String() string {
 type InfixExpression struct {
 	Token    token.Token
 	Operator string
+	Op       Operator
 	Right    Expression
 	Left     Expression
 }
@@ -210,6 +263,9 @@ func (ife *IfExpression) String() string {
 type BlockStatement struct {
 	Token      token.Token
 	Statements []Statement
+
+	// Rbrace is the closing brace, or the EOF token if it is missing.
+	Rbrace token.Token
 }
 
 func (bs *BlockStatement) statementNode()       {}
@@ -226,6 +282,16 @@ type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
 	Body       *BlockStatement
+
+	// ParameterTypes holds the type each parameter is annotated with, or
+	// nil for those that are not. It is nil if none are. ReturnType is
+	// the annotated result type, or nil.
+	ParameterTypes []*TypeExpression
+	ReturnType     *TypeExpression
+
+	// NumLocals is the number of slots the resolver gave the parameters
+	// and lets of the function.
+	NumLocals int
 }
 
 func (fl *FunctionLiteral) expressionNode() {}
@@ -236,14 +302,21 @@ func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	for i, p := range fl.Parameters {
+		if i < len(fl.ParameterTypes) && fl.ParameterTypes[i] != nil {
+			params = append(params, p.String()+": "+fl.ParameterTypes[i].String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 
 	out.WriteString(fl.Token.Literal)
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(")")
+	if fl.ReturnType != nil {
+		out.WriteString(" -> " + fl.ReturnType.String() + " ")
+	}
 	out.WriteString(fl.Body.String())
 
 	return out.String()
@@ -269,3 +342,79 @@ func (ce *CallExpression) String() string {
 	out.WriteString(")")
 	return out.String()
 }
+
+// IndexExpression is an element of an array: Left[Index].
+type IndexExpression struct {
+	Token token.Token // the [ token
+	Left  Expression
+	Index Expression
+}
+
+func (ie *IndexExpression) expressionNode()      {}
+func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) String() string {
+	return "(" + ie.Left.String() + "[" + ie.Index.String() + "])"
+}
+
+// TypeExpression is a type annotation: the name of a type, such as int, or
+// a function type, such as fn(int, int) -> int.
+type TypeExpression struct {
+	Token token.Token // the name, or the fn token
+
+	Name string
+
+	// Parameters and Result are set for function types.
+	Parameters []*TypeExpression
+	Result     *TypeExpression
+}
+
+// IsFunction reports whether te is a function type.
+func (te *TypeExpression) IsFunction() bool { return te.Token.Type == token.FN }
+
+func (te *TypeExpression) String() string {
+	if !te.IsFunction() {
+		return te.Name
+	}
+
+	params := make([]string, len(te.Parameters))
+	for i, p := range te.Parameters {
+		params[i] = p.String()
+	}
+	return "fn(" + strings.Join(params, ", ") + ") -> " + te.Result.String()
+}
+
+// Position returns the token where node starts in the source, or the zero
+// token if node is nil.
+func Position(node Node) token.Token {
+	switch node := node.(type) {
+	case *LetStatement:
+		return node.Token
+	case *ReturnStatement:
+		return node.Token
+	case *ExpressionStatement:
+		return Position(node.Expression)
+	case *BlockStatement:
+		return node.Token
+	case *Identifier:
+		return node.Token
+	case *IntegerLiteral:
+		return node.Token
+	case *StringLiteral:
+		return node.Token
+	case *BooleanExpression:
+		return node.Token
+	case *PrefixExpression:
+		return node.Token
+	case *InfixExpression:
+		return Position(node.Left)
+	case *IfExpression:
+		return node.Token
+	case *FunctionLiteral:
+		return node.Token
+	case *CallExpression:
+		return Position(node.Function)
+	case *IndexExpression:
+		return Position(node.Left)
+	}
+	return token.Token{}
+}
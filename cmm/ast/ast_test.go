@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/shoebilyas123/cminusminus/cmm/token"
@@ -27,3 +28,43 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got %q\n", program.String())
 	}
 }
+
+func TestInspect(t *testing.T) {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	// let f = fn(x) { if (x) { g(x) } }; return f[1];
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{Name: ident("f"), Value: &FunctionLiteral{
+				Parameters: []*Identifier{ident("x")},
+				Body: &BlockStatement{Statements: []Statement{
+					&ExpressionStatement{Expression: &IfExpression{
+						Condition: ident("x"),
+						Consequence: &BlockStatement{Statements: []Statement{
+							&ExpressionStatement{Expression: &CallExpression{Function: ident("g"), Arguments: []Expression{ident("x")}}},
+						}},
+					}},
+				}},
+			}},
+			&ReturnStatement{ReturnValue: &IndexExpression{Left: ident("f"), Index: &IntegerLiteral{Value: 1}}},
+		},
+	}
+
+	var visited []string
+	Inspect(program, func(node Node) bool {
+		switch node := node.(type) {
+		case *Identifier:
+			visited = append(visited, node.Value)
+		case *IntegerLiteral:
+			visited = append(visited, "1")
+		case *IfExpression:
+			visited = append(visited, "if")
+		}
+		// skip the arguments of calls
+		_, isCall := node.(*CallExpression)
+		return !isCall
+	})
+
+	if got := strings.Join(visited, " "); got != "f x if x f 1" {
+		t.Errorf("visited %s", got)
+	}
+}
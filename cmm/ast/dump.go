@@ -0,0 +1,93 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Fprint prints the tree of node to w, a node per line, indented by its
+// depth, with what sets it apart from others of its kind and its
+// position.
+func Fprint(w io.Writer, node Node) {
+	dump(w, node, 0)
+}
+
+// dump prints node and its children, indented by depth.
+func dump(w io.Writer, node Node, depth int) {
+	var detail string
+	var children []Node
+
+	switch node := node.(type) {
+	case *Program:
+		io.WriteString(w, "Program\n")
+		for _, s := range node.Statements {
+			dump(w, s, depth+1)
+		}
+		return
+	case *LetStatement:
+		detail = node.Name.Value
+		if node.Type != nil {
+			detail += ": " + node.Type.String()
+		}
+		children = []Node{node.Value}
+	case *ReturnStatement:
+		children = []Node{node.ReturnValue}
+	case *ExpressionStatement:
+		children = []Node{node.Expression}
+	case *BlockStatement:
+		for _, s := range node.Statements {
+			children = append(children, s)
+		}
+	case *Identifier:
+		detail = node.Value
+	case *IntegerLiteral:
+		detail = node.Token.Literal
+	case *StringLiteral:
+		detail = QuoteString(node.Value)
+	case *BooleanExpression:
+		detail = node.Token.Literal
+	case *PrefixExpression:
+		detail = node.Op.String()
+		children = []Node{node.Right}
+	case *InfixExpression:
+		detail = node.Op.String()
+		children = []Node{node.Left, node.Right}
+	case *IfExpression:
+		children = []Node{node.Condition, node.Consequence}
+		if node.Alternative != nil {
+			children = append(children, node.Alternative)
+		}
+	case *FunctionLiteral:
+		params := make([]string, len(node.Parameters))
+		for i, p := range node.Parameters {
+			params[i] = p.Value
+			if i < len(node.ParameterTypes) && node.ParameterTypes[i] != nil {
+				params[i] += ": " + node.ParameterTypes[i].String()
+			}
+		}
+		detail = "(" + strings.Join(params, ", ") + ")"
+		if node.ReturnType != nil {
+			detail += " -> " + node.ReturnType.String()
+		}
+		children = []Node{node.Body}
+	case *CallExpression:
+		children = []Node{node.Function}
+		for _, a := range node.Arguments {
+			children = append(children, a)
+		}
+	case *IndexExpression:
+		children = []Node{node.Left, node.Index}
+	}
+
+	name := strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+	if detail != "" {
+		name += " " + detail
+	}
+	pos := Position(node)
+	fmt.Fprintf(w, "%s%s (%d:%d)\n", strings.Repeat("  ", depth), name, pos.Line, pos.Column)
+
+	for _, child := range children {
+		dump(w, child, depth+1)
+	}
+}
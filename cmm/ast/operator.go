@@ -0,0 +1,73 @@
+package ast
+
+// Operator identifies the operator of a PrefixExpression or an
+// InfixExpression, so that evaluating one does not compare strings.
+type Operator byte
+
+const (
+	IllegalOperator Operator = iota
+
+	// infix
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpLess
+	OpGreater
+	OpEqual
+	OpNotEqual
+
+	// prefix
+	OpNot
+	OpNegate
+)
+
+var operatorSymbols = [...]string{
+	IllegalOperator: "ILLEGAL",
+	OpAdd:           "+",
+	OpSub:           "-",
+	OpMul:           "*",
+	OpDiv:           "/",
+	OpLess:          "<",
+	OpGreater:       ">",
+	OpEqual:         "==",
+	OpNotEqual:      "!=",
+	OpNot:           "!",
+	OpNegate:        "-",
+}
+
+// String returns the operator as it is written in source.
+func (op Operator) String() string {
+	if int(op) < len(operatorSymbols) {
+		return operatorSymbols[op]
+	}
+	return operatorSymbols[IllegalOperator]
+}
+
+var infixOperators = map[string]Operator{
+	"+":  OpAdd,
+	"-":  OpSub,
+	"*":  OpMul,
+	"/":  OpDiv,
+	"<":  OpLess,
+	">":  OpGreater,
+	"==": OpEqual,
+	"!=": OpNotEqual,
+}
+
+var prefixOperators = map[string]Operator{
+	"!": OpNot,
+	"-": OpNegate,
+}
+
+// LookupInfix returns the infix operator written as symbol, or
+// IllegalOperator.
+func LookupInfix(symbol string) Operator {
+	return infixOperators[symbol]
+}
+
+// LookupPrefix returns the prefix operator written as symbol, or
+// IllegalOperator.
+func LookupPrefix(symbol string) Operator {
+	return prefixOperators[symbol]
+}
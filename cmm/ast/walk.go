@@ -0,0 +1,51 @@
+package ast
+
+// Inspect calls f for node and then, if f returns true, for each of the
+// nodes in it in source order. Type annotations are not visited.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		for _, stmt := range node.Statements {
+			Inspect(stmt, f)
+		}
+	case *LetStatement:
+		Inspect(node.Name, f)
+		Inspect(node.Value, f)
+	case *ReturnStatement:
+		Inspect(node.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(node.Expression, f)
+	case *BlockStatement:
+		for _, stmt := range node.Statements {
+			Inspect(stmt, f)
+		}
+	case *PrefixExpression:
+		Inspect(node.Right, f)
+	case *InfixExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Right, f)
+	case *IfExpression:
+		Inspect(node.Condition, f)
+		Inspect(node.Consequence, f)
+		if node.Alternative != nil {
+			Inspect(node.Alternative, f)
+		}
+	case *FunctionLiteral:
+		for _, param := range node.Parameters {
+			Inspect(param, f)
+		}
+		Inspect(node.Body, f)
+	case *CallExpression:
+		Inspect(node.Function, f)
+		for _, arg := range node.Arguments {
+			Inspect(arg, f)
+		}
+	case *IndexExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Index, f)
+	}
+}
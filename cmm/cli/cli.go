@@ -0,0 +1,187 @@
+// Package cli implements the cminusminus command.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/shoebilyas123/cminusminus/cmm/repl"
+)
+
+const usage = `usage: cminusminus [command] [flags] [arguments]
+
+With no command, starts the REPL. A FILE in place of the command runs it,
+so that scripts can start with #!/usr/bin/env cminusminus. The commands are:
+
+	run [-engine=eval|vm] [-O] [-cache] [-cover=PROFILE] [-cpuprofile=FILE] FILE|-e CODE [ARGS...]
+	               run a program, with ARGS in its args array
+	repl [-engine=eval|vm]
+	               start the REPL
+	check FILE...  report the errors of programs without running them
+	fmt [-w|-check|-d] FILE...
+	               format source files
+	lint [-json] [-config=FILE] FILE...
+	               report likely mistakes in source files
+	ast FILE|-e CODE
+	               print the syntax tree of a program
+	tokens FILE|-e CODE
+	               print the tokens of a program
+	compile [-O] FILE
+	               compile a source file to a .cmmc file next to it
+	disasm [-O] FILE
+	               print the bytecode of a source or .cmmc file
+	test [-run=REGEXP] [-v] [-format=text|tap|junit] [-timeout=D] [-cover=PROFILE] [PATH...]
+	               run the tests in *_test.cmm files, below . by default
+	cover [-html=FILE] PROFILE
+	               print the coverage in a profile, or write it as HTML
+	lsp            run a language server for editors on stdin and stdout
+	dap            run a debugger for editors on stdin and stdout
+
+-e CODE [ARGS...] is short for run -e CODE [ARGS...].
+
+The exit status is 0 on success, 1 if the program fails, such as with an
+uncaught runtime error, or a command finds problems in it, and 2 for bad
+usage.
+`
+
+// The exit statuses.
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+)
+
+// command runs a subcommand with its arguments and returns its exit status.
+type command func(c *cli, args []string) int
+
+var commands = map[string]command{
+	"run":     (*cli).run,
+	"repl":    (*cli).repl,
+	"check":   (*cli).check,
+	"fmt":     (*cli).format,
+	"lint":    (*cli).lint,
+	"ast":     (*cli).ast,
+	"tokens":  (*cli).tokens,
+	"compile": (*cli).compile,
+	"disasm":  (*cli).disasm,
+	"test":    (*cli).test,
+	"cover":   (*cli).cover,
+	"lsp":     (*cli).lsp,
+	"dap":     (*cli).dap,
+}
+
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+// Main runs the command with args, which leave out the program name, and
+// returns its exit status.
+func Main(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	if len(args) == 0 {
+		return c.repl(nil)
+	}
+
+	name := args[0]
+	if cmd, ok := commands[name]; ok {
+		return cmd(c, args[1:])
+	}
+
+	switch {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		io.WriteString(stdout, usage)
+		return ExitOK
+	case name == "-e":
+		return c.run(args)
+	case strings.HasPrefix(name, "-"):
+		return c.usageError("unknown flag %s", name)
+	}
+	return c.run(args)
+}
+
+func (c *cli) usageError(format string, a ...interface{}) int {
+	fmt.Fprintf(c.stderr, format+"\n", a...)
+	io.WriteString(c.stderr, usage)
+	return ExitUsage
+}
+
+// fail prints err and returns ExitFailure.
+func (c *cli) fail(err error) int {
+	fmt.Fprintln(c.stderr, err)
+	return ExitFailure
+}
+
+// flags returns a flag set for the named command, which prints its errors
+// to stderr.
+func (c *cli) flags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	return flags
+}
+
+// parse parses args with flags and returns the exit status to stop with, if
+// they are bad or ask for help.
+func parse(flags *flag.FlagSet, args []string) (int, bool) {
+	err := flags.Parse(args)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return ExitOK, false
+	case err != nil:
+		return ExitUsage, false
+	}
+	return 0, true
+}
+
+// engineFlag adds the -engine flag to flags.
+func engineFlag(flags *flag.FlagSet) *string {
+	return flags.String("engine", repl.EngineEval, "engine to run programs on: eval or vm")
+}
+
+func validEngine(engine string) bool {
+	return engine == repl.EngineEval || engine == repl.EngineVM
+}
+
+// source returns the program to work on: code, if -e gave it, or else the
+// file named by the first of args. rest holds the arguments after the
+// program.
+func source(code string, args []string) (name, src string, rest []string, err error) {
+	if code != "" {
+		return "-e", code, args, nil
+	}
+	if len(args) == 0 {
+		return "", "", nil, errNoProgram
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return "", "", nil, err
+	}
+	return args[0], string(data), args[1:], nil
+}
+
+var errNoProgram = errors.New("no program given: name a FILE or use -e CODE")
+
+func (c *cli) repl(args []string) int {
+	flags := c.flags("repl")
+	engine := engineFlag(flags)
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+	if !validEngine(*engine) {
+		return c.usageError("unknown engine %q", *engine)
+	}
+
+	if u, err := user.Current(); err == nil {
+		fmt.Fprintf(c.stdout, "Hello %s! This is the cminusminus programming language!\n", u.Username)
+	}
+	fmt.Fprintf(c.stdout, "Feel free to type in commands\n")
+	repl.Start(c.stdin, c.stdout, *engine)
+	return ExitOK
+}
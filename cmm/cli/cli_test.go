@@ -0,0 +1,268 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runMain(args ...string) (status int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	status = Main(args, strings.NewReader(""), &out, &errOut)
+	return status, out.String(), errOut.String()
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "greet.cmm")
+	source := "#!/usr/bin/env cminusminus\nif (len(args) > 0) { \"hello \" + args[0] } else { \"hello\" }\n"
+	if err := os.WriteFile(script, []byte(source), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args   []string
+		status int
+		stdout string
+		stderr string
+	}{
+		{[]string{"-e", "1 + 2"}, ExitOK, "3\n", ""},
+		{[]string{"-e", "args[1]", "a", "b"}, ExitOK, "b\n", ""},
+		{[]string{"run", "-e", "len(args)", "a", "b"}, ExitOK, "2\n", ""},
+		{[]string{"run", "-engine=vm", "-e", "args[0]", "x"}, ExitOK, "x\n", ""},
+		{[]string{"run", "-O", "-e", "let x = 1;"}, ExitOK, "1\n", ""},
+		{[]string{script, "world"}, ExitOK, "hello world\n", ""},
+		{[]string{"run", script}, ExitOK, "hello\n", ""},
+		{[]string{"run", "-engine=vm", script, "vm"}, ExitOK, "hello vm\n", ""},
+		{[]string{"-e", "1 / 0"}, ExitFailure, "", "runtime error: division by zero\n"},
+		{[]string{"run", "-engine=vm", "-e", "args[3]"}, ExitFailure, "", "runtime error: index out of range: 3 with length 0\n"},
+		{[]string{"-e", "1 +"}, ExitFailure, "", "parse error: no prefix parse function for EOF found\n"},
+		{[]string{"-e", "1 + true"}, ExitFailure, "", "runtime error: type mismatch: INTEGER + BOOLEAN\n"},
+		{[]string{"-e", "let x: int = true;"}, ExitFailure, "", "1:14: cannot use true (bool) as int in let x\n"},
+		{[]string{"-e", "if (false) { 1 + true } else { 2 }"}, ExitOK, "2\n", ""},
+		{[]string{"run", "-engine=vm", "-e", "if (false) { 1 + true } else { 2 }"}, ExitOK, "2\n", ""},
+		{[]string{"-e", "let f = fn(n) { 1 + f(n) }; f(1)"}, ExitFailure, "", "runtime error: stack overflow\n"},
+		{[]string{"run", "-engine=vm", "-e", "let f = fn(n) { 1 + f(n) }; f(1)"}, ExitFailure, "", "runtime error: stack overflow\n"},
+		{[]string{"-e", "missing"}, ExitFailure, "", "runtime error: NOT FOUND: undefined identifier - missing\n"},
+		{[]string{"-e", "let unused = fn() { nope }; 1;"}, ExitOK, "1\n", ""},
+		{[]string{"run", "-engine=vm", "-e", "let unused = fn() { nope }; 1;"}, ExitOK, "1\n", ""},
+		{[]string{"-e", "let x = 1; let f = fn() { let g = fn() { x }; let y = g(); let x = 2; y }; f()"}, ExitOK, "1\n", ""},
+		{[]string{"run", "-engine=vm", "-e", "let x = 1; let f = fn() { let g = fn() { x }; let y = g(); let x = 2; y }; f()"}, ExitOK, "1\n", ""},
+		{[]string{filepath.Join(dir, "none.cmm")}, ExitFailure, "", "no such file or directory"},
+	}
+
+	for _, tt := range tests {
+		status, stdout, stderr := runMain(tt.args...)
+		if status != tt.status || stdout != tt.stdout || !strings.Contains(stderr, tt.stderr) {
+			t.Errorf("%v: got status=%d stdout=%q stderr=%q, want %d %q %q",
+				tt.args, status, stdout, stderr, tt.status, tt.stdout, tt.stderr)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "main.cmm")
+	os.WriteFile(script, []byte("1 + 2\n"), 0o644)
+	cache := filepath.Join(dir, "main.cmmc")
+
+	if status, stdout, _ := runMain("run", "-engine=vm", script); status != ExitOK || stdout != "3\n" {
+		t.Errorf("run -engine=vm: got status=%d stdout=%q", status, stdout)
+	}
+	if _, err := os.Stat(cache); !os.IsNotExist(err) {
+		t.Errorf("run -engine=vm wrote %s: %v", cache, err)
+	}
+
+	if status, stdout, _ := runMain("run", "-engine=vm", "-cache", script); status != ExitOK || stdout != "3\n" {
+		t.Errorf("run -cache: got status=%d stdout=%q", status, stdout)
+	}
+	if _, err := os.Stat(cache); err != nil {
+		t.Errorf("run -cache wrote no cache: %v", err)
+	}
+	if status, _, _ := runMain("run", "-cache", script); status != ExitUsage {
+		t.Errorf("run -cache on eval: got status=%d", status)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	tests := [][]string{
+		{"-x"},
+		{"run"},
+		{"run", "-engine=jit", "-e", "1"},
+		{"ast"},
+		{"fmt"},
+	}
+
+	for _, args := range tests {
+		status, _, stderr := runMain(args...)
+		if status != ExitUsage || !strings.Contains(stderr, "usage: cminusminus") {
+			t.Errorf("%v: got status=%d stderr=%q", args, status, stderr)
+		}
+	}
+
+	if status, stdout, _ := runMain("help"); status != ExitOK || !strings.HasPrefix(stdout, "usage:") {
+		t.Errorf("help: got status=%d stdout=%q", status, stdout)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.cmm")
+	os.WriteFile(path, []byte("let x = 1;\nx(2);\ny\n"), 0o644)
+
+	status, _, stderr := runMain("check", path)
+	want := path + ":3:1: undefined variable y\n" + path + ":2:1: cannot call x (int)\n"
+	if status != ExitFailure || stderr != want {
+		t.Errorf("got status=%d stderr=%q", status, stderr)
+	}
+
+	if status, _, stderr := runMain("check", "-e", "let x = args[0]; x"); status != ExitOK {
+		t.Errorf("got status=%d stderr=%q", status, stderr)
+	}
+}
+
+// TestPolymorphicRuns checks that programs without annotations that
+// inference rejects still run on both engines, and that check reports
+// them.
+func TestPolymorphicRuns(t *testing.T) {
+	tests := []struct {
+		src    string
+		stdout string
+		check  string
+	}{
+		{`let apply = fn(h) { h(1); h("a") }; apply(fn(x) { x })`, "a\n", "cannot use"},
+		{`let pick = fn(b, x) { if (b) { x + 1 } else { x } }; pick(false, "s")`, "s\n", "cannot use"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{"-engine=eval", "-engine=vm"} {
+			if status, stdout, stderr := runMain("run", engine, "-e", tt.src); status != ExitOK || stdout != tt.stdout {
+				t.Errorf("run %s %q: got status=%d stdout=%q stderr=%q", engine, tt.src, status, stdout, stderr)
+			}
+		}
+		if status, _, stderr := runMain("check", "-e", tt.src); status != ExitFailure || !strings.Contains(stderr, tt.check) {
+			t.Errorf("check %q: got status=%d stderr=%q", tt.src, status, stderr)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	_, stdout, _ := runMain("tokens", "-e", "a[1]")
+	want := "1:1\tIDENT\t\"a\"\n1:2\t[\t\"[\"\n1:3\tINT\t\"1\"\n1:4\t]\t\"]\"\n1:5\tEOF\t\"\"\n"
+	if stdout != want {
+		t.Errorf("tokens wrong. got=\n%s", stdout)
+	}
+
+	_, stdout, _ = runMain("ast", "-e", "let f = fn(a: int) { -a };")
+	want = `Program
+  LetStatement f (1:1)
+    FunctionLiteral (a: int) (1:9)
+      BlockStatement (1:20)
+        ExpressionStatement (1:22)
+          PrefixExpression - (1:22)
+            Identifier a (1:23)
+`
+	if stdout != want {
+		t.Errorf("ast wrong. got=\n%s", stdout)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.cmm")
+	os.WriteFile(path, []byte("let x=1"), 0o644)
+
+	if status, stdout, _ := runMain("fmt", "-check", path); status != ExitFailure || stdout != path+"\n" {
+		t.Errorf("fmt -check: got status=%d stdout=%q", status, stdout)
+	}
+	if status, _, _ := runMain("fmt", "-w", path); status != ExitOK {
+		t.Errorf("fmt -w: got status=%d", status)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "let x = 1;\n" {
+		t.Errorf("fmt -w wrote %q", data)
+	}
+}
+
+func TestTest(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "math.cmm"), []byte("let double = fn(x) { x * 2 };\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "math_test.cmm"), []byte(`let test_double = fn() { assert_eq(double(2), 4) };
+let test_wrong = fn() { assert_eq(double(2), 5) };
+`), 0o644)
+
+	status, stdout, _ := runMain("test", dir)
+	want := "--- FAIL: test_wrong (0.00s)\n    " + filepath.Join(dir, "math_test.cmm") + ":2:25: assert_eq failed: got 4, want 5\nFAIL\t"
+	if status != ExitFailure || !strings.HasPrefix(stdout, want) {
+		t.Errorf("test: got status=%d stdout=%q", status, stdout)
+	}
+
+	status, stdout, _ = runMain("test", "-run", "double", "-format=tap", dir)
+	if status != ExitOK || stdout != "TAP version 13\n1..1\nok 1 - "+filepath.Join(dir, "math_test.cmm")+": test_double\n" {
+		t.Errorf("test -format=tap: got status=%d stdout=%q", status, stdout)
+	}
+
+	status, stdout, _ = runMain("test", "-format=junit", dir)
+	if status != ExitFailure || !strings.Contains(stdout, `<testsuites tests="2" failures="1" errors="0"`) {
+		t.Errorf("test -format=junit: got status=%d stdout=%q", status, stdout)
+	}
+
+	for _, args := range [][]string{{"test", "-format=xml"}, {"test", "-run", "("}} {
+		if status, _, _ := runMain(args...); status != ExitUsage {
+			t.Errorf("%v: got status=%d", args, status)
+		}
+	}
+}
+
+func TestCover(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "abs.cmm")
+	os.WriteFile(script, []byte("let abs = fn(n) { if (n < 0) { -n } else { n } };\nabs(2)\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "abs_test.cmm"), []byte("let test_abs = fn() { assert_eq(abs(-2), 2) };\n"), 0o644)
+	profile := filepath.Join(dir, "cover.out")
+
+	status, stdout, stderr := runMain("run", "-cover="+profile, script)
+	if status != ExitOK || stdout != "2\n" || stderr != script+": 80.0% of 5 statements, 50.0% of 2 branches\n" {
+		t.Errorf("run -cover: got status=%d stdout=%q stderr=%q", status, stdout, stderr)
+	}
+	if status, _, _ := runMain("run", "-engine=vm", "-cover="+profile, script); status != ExitUsage {
+		t.Errorf("run -engine=vm -cover: got status=%d", status)
+	}
+
+	status, stdout, _ = runMain("test", "-cover="+profile, dir)
+	want := script + ": 100.0% of 5 statements, 100.0% of 2 branches\n"
+	if status != ExitOK || !strings.Contains(stdout, want) {
+		t.Errorf("test -cover: got status=%d stdout=%q", status, stdout)
+	}
+
+	if status, stdout, _ := runMain("cover", profile); status != ExitOK || !strings.HasPrefix(stdout, want) {
+		t.Errorf("cover: got status=%d stdout=%q", status, stdout)
+	}
+	html := filepath.Join(dir, "cover.html")
+	if status, _, stderr := runMain("cover", "-html="+html, profile); status != ExitOK {
+		t.Errorf("cover -html: got status=%d stderr=%q", status, stderr)
+	}
+	if data, _ := os.ReadFile(html); !strings.Contains(string(data), `<tr class="covered">`) {
+		t.Errorf("cover -html wrote %s", data)
+	}
+	if status, _, _ := runMain("cover"); status != ExitUsage {
+		t.Errorf("cover without a profile: got status=%d", status)
+	}
+}
+
+func TestCPUProfile(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "fib.cmm")
+	os.WriteFile(script, []byte("let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };\nfib(10)\n"), 0o644)
+	profile := filepath.Join(dir, "cpu.pprof")
+
+	if status, stdout, stderr := runMain("run", "-cpuprofile="+profile, script); status != ExitOK || stdout != "55\n" {
+		t.Errorf("run -cpuprofile: got status=%d stdout=%q stderr=%q", status, stdout, stderr)
+	}
+	data, err := os.ReadFile(profile)
+	if err != nil || len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		t.Errorf("run -cpuprofile wrote no gzipped profile: %v", err)
+	}
+	if status, _, _ := runMain("run", "-engine=vm", "-cpuprofile="+profile, script); status != ExitUsage {
+		t.Errorf("run -engine=vm -cpuprofile: got status=%d", status)
+	}
+}
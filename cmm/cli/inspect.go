@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+)
+
+// inspectSource parses the flags of the ast and tokens commands and
+// returns the program they name.
+func (c *cli) inspectSource(name string, args []string) (string, int, bool) {
+	flags := c.flags(name)
+	code := flags.String("e", "", "use `CODE` instead of a file")
+	if status, ok := parse(flags, args); !ok {
+		return "", status, false
+	}
+
+	_, src, rest, err := source(*code, flags.Args())
+	switch {
+	case err == errNoProgram || len(rest) != 0:
+		return "", c.usageError("%s: name one FILE or use -e CODE", name), false
+	case err != nil:
+		return "", c.fail(err), false
+	}
+	return src, ExitOK, true
+}
+
+// tokens prints the tokens of a program, one per line.
+func (c *cli) tokens(args []string) int {
+	src, status, ok := c.inspectSource("tokens", args)
+	if !ok {
+		return status
+	}
+
+	l := lexer.New(src)
+	for {
+		tok := l.NextToken()
+		fmt.Fprintf(c.stdout, "%d:%d\t%s\t%q\n", tok.Line, tok.Column, tok.Type, tok.Literal)
+		if tok.Type == token.EOF {
+			return ExitOK
+		}
+	}
+}
+
+// ast prints the syntax tree of a program, a node per line.
+func (c *cli) ast(args []string) int {
+	src, status, ok := c.inspectSource("ast", args)
+	if !ok {
+		return status
+	}
+
+	program, err := parseProgram(src)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	ast.Fprint(c.stdout, program)
+	return ExitOK
+}
@@ -0,0 +1,261 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/cover"
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/module"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/optimizer"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+	"github.com/shoebilyas123/cminusminus/cmm/profiler"
+	"github.com/shoebilyas123/cminusminus/cmm/repl"
+	"github.com/shoebilyas123/cminusminus/cmm/resolver"
+	"github.com/shoebilyas123/cminusminus/cmm/types"
+	"github.com/shoebilyas123/cminusminus/cmm/vm"
+)
+
+// argsName is the global that holds the arguments of a script.
+const argsName = "args"
+
+// run runs a program and prints its result. The vm engine loads a file
+// through its .cmmc cache, which it writes only with -cache.
+func (c *cli) run(args []string) int {
+	flags := c.flags("run")
+	engine := engineFlag(flags)
+	optimize := flags.Bool("O", false, "optimize the program before it runs")
+	code := flags.String("e", "", "run `CODE` instead of a file")
+	coverFile := flags.String("cover", "", "write a coverage profile to `FILE`; needs the eval engine")
+	cpuFile := flags.String("cpuprofile", "", "write a pprof profile of the cmm functions to `FILE`; needs the eval engine")
+	cache := flags.Bool("cache", false, "save the compiled program next to the file as a .cmmc; needs the vm engine")
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+	if !validEngine(*engine) {
+		return c.usageError("unknown engine %q", *engine)
+	}
+	if *coverFile != "" && (*engine != repl.EngineEval || *optimize) {
+		return c.usageError("-cover needs the eval engine and no -O")
+	}
+	if *cpuFile != "" && *engine != repl.EngineEval {
+		return c.usageError("-cpuprofile needs the eval engine")
+	}
+	if *cache && *engine != repl.EngineVM {
+		return c.usageError("-cache needs the vm engine")
+	}
+
+	name, src, scriptArgs, err := source(*code, flags.Args())
+	if err == errNoProgram {
+		return c.usageError("%s", err)
+	}
+	if err != nil {
+		return c.fail(err)
+	}
+
+	argsArray := &object.ArrayObject{Elements: make([]object.Object, len(scriptArgs))}
+	for i, arg := range scriptArgs {
+		argsArray.Elements[i] = &object.StringObject{Value: arg}
+	}
+
+	var in instruments
+	if *coverFile != "" {
+		in.cover = cover.New()
+	}
+	if *cpuFile != "" {
+		in.cpu = profiler.New(0)
+	}
+
+	var result object.Object
+	if *engine == repl.EngineVM {
+		result, err = runVM(name, src, *code != "", *optimize, *cache, argsArray)
+	} else {
+		result, err = runEval(name, src, *optimize, argsArray, in)
+	}
+	if in.cover != nil {
+		if err := c.writeProfile(in.cover, *coverFile, c.stderr); err != nil {
+			return c.fail(err)
+		}
+	}
+	if in.cpu != nil {
+		if err := writeCPUProfile(in.cpu, *cpuFile); err != nil {
+			return c.fail(err)
+		}
+	}
+	if err != nil {
+		return c.fail(err)
+	}
+
+	if result != nil && result != object.NULL {
+		fmt.Fprintln(c.stdout, result.Inspect())
+	}
+	return ExitOK
+}
+
+// instruments follow a program as it runs on the evaluator. Each may be
+// nil.
+type instruments struct {
+	cover *cover.Profile
+	cpu   *profiler.Profiler
+}
+
+// runEval runs src, the file name, on the evaluator.
+func runEval(name, src string, optimize bool, args *object.ArrayObject, in instruments) (object.Object, error) {
+	program, err := parseProgram(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if errs := types.Blocking(types.Check(program)); len(errs) != 0 {
+		return nil, joinErrors(errs)
+	}
+
+	if optimize {
+		optimizer.Optimize(program)
+	}
+
+	// Undefined variables are left to cmm check: the program fails only if
+	// it reaches one, as it does on the vm.
+	resolver.Resolve(program, isArgs)
+
+	env := object.NewEnvironment()
+	env.Set(argsName, args)
+
+	var hooks eval.Hooks
+	if in.cover != nil {
+		in.cover.Add(name, program)
+		hooks = in.cover.Hooks()
+	}
+	if in.cpu != nil {
+		in.cpu.Add(name, program)
+		hooks = joinHooks(hooks, in.cpu.Hooks())
+	}
+	e := eval.New(eval.Config{})
+	e.SetHooks(hooks)
+	result := e.Eval(program, env)
+	if errObj, ok := result.(*object.ErrorObject); ok {
+		return nil, &object.RuntimeError{Message: errObj.Message}
+	}
+	return result, nil
+}
+
+// joinHooks returns hooks that call those of a and then those of b.
+func joinHooks(a, b eval.Hooks) eval.Hooks {
+	return eval.Hooks{
+		Statement: join(a.Statement, b.Statement),
+		Call:      join(a.Call, b.Call),
+		Return:    join(a.Return, b.Return),
+		Branch:    join(a.Branch, b.Branch),
+	}
+}
+
+// join returns a hook that calls f and then g, either of which may be nil.
+func join[X, Y any](f, g func(X, Y)) func(X, Y) {
+	switch {
+	case f == nil:
+		return g
+	case g == nil:
+		return f
+	}
+	return func(x X, y Y) {
+		f(x, y)
+		g(x, y)
+	}
+}
+
+// writeCPUProfile writes the profile p to the file path.
+func writeCPUProfile(p *profiler.Profiler, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := p.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runVM(name, src string, inline, optimize, cache bool, args *object.ArrayObject) (object.Object, error) {
+	var m *module.Module
+	var err error
+	if inline {
+		m, err = module.Compile(src, optimize)
+	} else {
+		m, err = module.Load(name, optimize, cache)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	globals := vm.NewGlobalsStore()
+	if slot := slices.Index(m.Bytecode.Globals, argsName); slot >= 0 {
+		globals[slot] = args
+	}
+
+	machine := vm.NewWithGlobalsStore(m.Bytecode, globals)
+	if err := machine.Run(); err != nil {
+		return nil, &object.RuntimeError{Message: err.Error()}
+	}
+	return machine.LastPoppedStackElem(), nil
+}
+
+func isArgs(name string) bool {
+	return name == argsName
+}
+
+func parseProgram(src string) (*ast.Program, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+// check reports the parse, resolve and type errors of files.
+func (c *cli) check(args []string) int {
+	flags := c.flags("check")
+	code := flags.String("e", "", "check `CODE` instead of files")
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+
+	files := flags.Args()
+	if *code != "" {
+		files = []string{""}
+	} else if len(files) == 0 {
+		return c.usageError("%s", errNoProgram)
+	}
+
+	status := ExitOK
+	for _, file := range files {
+		name, src, _, err := source(*code, []string{file})
+		if err != nil {
+			status = c.fail(err)
+			continue
+		}
+
+		program, err := parseProgram(src)
+		if err != nil {
+			status = c.fail(fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		var errs []error
+		for _, err := range resolver.Resolve(program, isArgs) {
+			errs = append(errs, err)
+		}
+		for _, err := range types.Check(program) {
+			errs = append(errs, err)
+		}
+		for _, err := range errs {
+			status = c.fail(fmt.Errorf("%s:%w", name, err))
+		}
+	}
+	return status
+}
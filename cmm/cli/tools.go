@@ -0,0 +1,360 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/shoebilyas123/cminusminus/cmm/cover"
+	"github.com/shoebilyas123/cminusminus/cmm/dap"
+	"github.com/shoebilyas123/cminusminus/cmm/format"
+	"github.com/shoebilyas123/cminusminus/cmm/lint"
+	"github.com/shoebilyas123/cminusminus/cmm/lsp"
+	"github.com/shoebilyas123/cminusminus/cmm/module"
+	"github.com/shoebilyas123/cminusminus/cmm/tester"
+)
+
+// format formats files, printing them, rewriting them, listing those that
+// are not formatted or printing diffs.
+func (c *cli) format(args []string) int {
+	flags := c.flags("fmt")
+	write := flags.Bool("w", false, "rewrite the files instead of printing them")
+	check := flags.Bool("check", false, "list the files that are not formatted")
+	diff := flags.Bool("d", false, "print the changes formatting would make as diffs")
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() == 0 {
+		return c.usageError("fmt: no files given")
+	}
+
+	status := ExitOK
+	for _, file := range flags.Args() {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			status = c.fail(err)
+			continue
+		}
+
+		formatted, err := format.Source(source)
+		if err != nil {
+			status = c.fail(fmt.Errorf("%s: %w", file, err))
+			continue
+		}
+
+		changed := string(formatted) != string(source)
+		switch {
+		case *check:
+			if changed {
+				fmt.Fprintln(c.stdout, file)
+				status = ExitFailure
+			}
+		case *diff:
+			c.stdout.Write(format.Diff(file, source, formatted))
+		case *write:
+			if changed {
+				if err := os.WriteFile(file, formatted, 0644); err != nil {
+					status = c.fail(err)
+				}
+			}
+		default:
+			c.stdout.Write(formatted)
+		}
+	}
+	return status
+}
+
+// lint prints the reports of the linter for files, as text or JSON.
+func (c *cli) lint(args []string) int {
+	flags := c.flags("lint")
+	asJSON := flags.Bool("json", false, "print the reports as a JSON array")
+	configPath := flags.String("config", "", "config file (default "+lint.DefaultConfigFile+" if it exists)")
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() == 0 {
+		return c.usageError("lint: no files given")
+	}
+
+	var config *lint.Config
+	path := *configPath
+	if path == "" {
+		if _, err := os.Stat(lint.DefaultConfigFile); err == nil {
+			path = lint.DefaultConfigFile
+		}
+	}
+	if path != "" {
+		var err error
+		if config, err = lint.LoadConfig(path); err != nil {
+			fmt.Fprintln(c.stderr, err)
+			return ExitUsage
+		}
+	}
+
+	status := ExitOK
+	diagnostics := []lint.Diagnostic{}
+	for _, file := range flags.Args() {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			status = c.fail(err)
+			continue
+		}
+
+		found, err := lint.Lint(string(source), config)
+		if err != nil {
+			status = c.fail(fmt.Errorf("%s: %w", file, err))
+			continue
+		}
+		for _, d := range found {
+			d.File = file
+			diagnostics = append(diagnostics, d)
+		}
+	}
+
+	if *asJSON {
+		out := json.NewEncoder(c.stdout)
+		out.SetIndent("", "  ")
+		out.Encode(diagnostics)
+	} else {
+		for _, d := range diagnostics {
+			fmt.Fprintln(c.stdout, d)
+		}
+	}
+
+	if len(diagnostics) != 0 {
+		status = ExitFailure
+	}
+	return status
+}
+
+// compile writes the .cmmc file of a source file next to it.
+func (c *cli) compile(args []string) int {
+	flags := c.flags("compile")
+	optimize := flags.Bool("O", false, "optimize the program")
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() != 1 {
+		return c.usageError("compile: name one FILE")
+	}
+
+	path := flags.Arg(0)
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	m, err := module.Compile(string(source), *optimize)
+	if err != nil {
+		return c.fail(err)
+	}
+	if err := m.WriteFile(module.CachePath(path)); err != nil {
+		return c.fail(err)
+	}
+	return ExitOK
+}
+
+// disasm prints the bytecode of a source or .cmmc file.
+func (c *cli) disasm(args []string) int {
+	flags := c.flags("disasm")
+	optimize := flags.Bool("O", false, "optimize the program")
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() != 1 {
+		return c.usageError("disasm: name one FILE")
+	}
+
+	m, err := module.Load(flags.Arg(0), *optimize, false)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	module.Disassemble(c.stdout, m)
+	return ExitOK
+}
+
+// joinErrors returns the errors of a pass over the program as one error,
+// one per line.
+func joinErrors[E error](errs []E) error {
+	joined := make([]error, len(errs))
+	for i, err := range errs {
+		joined[i] = err
+	}
+	return errors.Join(joined...)
+}
+
+// lsp runs a language server, which talks to an editor on stdin and
+// stdout.
+func (c *cli) lsp(args []string) int {
+	flags := c.flags("lsp")
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() != 0 {
+		return c.usageError("lsp: takes no arguments")
+	}
+	if err := lsp.Serve(c.stdin, c.stdout); err != nil {
+		return c.fail(fmt.Errorf("lsp: %w", err))
+	}
+	return ExitOK
+}
+
+// dap runs a debugger, which talks to an editor on stdin and stdout.
+func (c *cli) dap(args []string) int {
+	flags := c.flags("dap")
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() != 0 {
+		return c.usageError("dap: takes no arguments")
+	}
+	if err := dap.Serve(c.stdin, c.stdout); err != nil {
+		return c.fail(fmt.Errorf("dap: %w", err))
+	}
+	return ExitOK
+}
+
+// The formats test reports in.
+const (
+	testText  = "text"
+	testTAP   = "tap"
+	testJUnit = "junit"
+)
+
+// test runs the tests in the test files named by its arguments, or found
+// below them, and reports how they went.
+func (c *cli) test(args []string) int {
+	flags := c.flags("test")
+	run := flags.String("run", "", "run only the tests whose names match `REGEXP`")
+	verbose := flags.Bool("v", false, "list every test as it runs")
+	reportFormat := flags.String("format", testText, "report format: text, tap or junit")
+	timeout := flags.Duration("timeout", 10*time.Second, "time each test may take, 0 for no limit")
+	coverFile := flags.String("cover", "", "write a coverage profile of the tests to `FILE`")
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+
+	opts := tester.Options{Timeout: *timeout}
+	if *coverFile != "" {
+		opts.Cover = cover.New()
+	}
+	if *run != "" {
+		re, err := regexp.Compile(*run)
+		if err != nil {
+			return c.usageError("test: bad -run: %s", err)
+		}
+		opts.Run = re
+	}
+	if *reportFormat != testText && *reportFormat != testTAP && *reportFormat != testJUnit {
+		return c.usageError("test: unknown format %q", *reportFormat)
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	names, err := tester.Find(paths)
+	if err != nil {
+		return c.fail(err)
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(c.stderr, "no test files")
+		return ExitOK
+	}
+
+	status := ExitOK
+	var files []*tester.File
+	for _, name := range names {
+		f := tester.Run(name, opts)
+		if f.Failed() {
+			status = ExitFailure
+		}
+		if *reportFormat == testText {
+			tester.WriteText(c.stdout, f, *verbose)
+		}
+		files = append(files, f)
+	}
+
+	switch *reportFormat {
+	case testTAP:
+		tester.WriteTAP(c.stdout, files)
+	case testJUnit:
+		if err := tester.WriteJUnit(c.stdout, files); err != nil {
+			return c.fail(err)
+		}
+	}
+
+	if opts.Cover != nil {
+		// the summary would spoil a TAP or JUnit report
+		summary := c.stdout
+		if *reportFormat != testText {
+			summary = c.stderr
+		}
+		if err := c.writeProfile(opts.Cover, *coverFile, summary); err != nil {
+			return c.fail(err)
+		}
+	}
+	return status
+}
+
+// writeProfile writes profile to the file path and its summary to w.
+func (c *cli) writeProfile(profile *cover.Profile, path string, w io.Writer) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := profile.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	profile.WriteSummary(w)
+	return nil
+}
+
+// cover reads a coverage profile and prints how much of each file ran, or
+// writes an HTML view of the files.
+func (c *cli) cover(args []string) int {
+	flags := c.flags("cover")
+	htmlFile := flags.String("html", "", "write an HTML view of the covered source to `FILE`")
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() != 1 {
+		return c.usageError("cover: give one profile")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return c.fail(err)
+	}
+	profile, err := cover.Read(f)
+	f.Close()
+	if err != nil {
+		return c.fail(fmt.Errorf("%s: %w", flags.Arg(0), err))
+	}
+
+	if *htmlFile == "" {
+		profile.WriteSummary(c.stdout)
+		return ExitOK
+	}
+	out, err := os.Create(*htmlFile)
+	if err != nil {
+		return c.fail(err)
+	}
+	if err := profile.WriteHTML(out); err != nil {
+		out.Close()
+		return c.fail(err)
+	}
+	if err := out.Close(); err != nil {
+		return c.fail(err)
+	}
+	return ExitOK
+}
@@ -0,0 +1,251 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Instructions is a sequence of encoded instructions: one opcode byte
+// followed by its operands in big endian.
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		text, next := ins.Instruction(i)
+		fmt.Fprintf(&out, "%04d %s\n", i, text)
+		i = next
+	}
+
+	return out.String()
+}
+
+// Instruction formats the instruction at offset i and returns it along with
+// the offset of the instruction after it.
+func (ins Instructions) Instruction(i int) (string, int) {
+	def, err := Lookup(ins[i])
+	if err != nil {
+		return fmt.Sprintf("ERROR: %s", err), i + 1
+	}
+
+	operands, read := ReadOperands(def, ins[i+1:])
+	return ins.fmtInstruction(def, operands), i + 1 + read
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+// LineTable maps instructions back to the source lines they were compiled
+// from. An entry covers the instructions from its Offset up to the Offset of
+// the next one.
+type LineTable []LineEntry
+
+type LineEntry struct {
+	Offset int
+	Line   int
+}
+
+// Line returns the source line of the instruction at offset, or 0 when the
+// table does not cover it.
+func (t LineTable) Line(offset int) int {
+	line := 0
+	for _, e := range t {
+		if e.Offset > offset {
+			break
+		}
+		line = e.Line
+	}
+	return line
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+	OpMinus
+	OpBang
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	OpJump
+	OpJumpNotTruthy
+
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpGetFree
+	OpCurrentClosure
+	OpGetLocalCell
+	OpGetFreeCell
+
+	OpClosure
+	OpCall
+	OpTailCall
+	OpReturnValue
+	OpReturn
+
+	OpIndex
+)
+
+// Definition describes an opcode: its readable name and the width in bytes
+// of each of its operands.
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{4}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpAdd:         {"OpAdd", []int{}},
+	OpSub:         {"OpSub", []int{}},
+	OpMul:         {"OpMul", []int{}},
+	OpDiv:         {"OpDiv", []int{}},
+	OpEqual:       {"OpEqual", []int{}},
+	OpNotEqual:    {"OpNotEqual", []int{}},
+	OpGreaterThan: {"OpGreaterThan", []int{}},
+	OpLessThan:    {"OpLessThan", []int{}},
+	OpMinus:       {"OpMinus", []int{}},
+	OpBang:        {"OpBang", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpJump:          {"OpJump", []int{4}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{4}},
+
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetLocal:       {"OpGetLocal", []int{2}},
+	OpSetLocal:       {"OpSetLocal", []int{2}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	// OpGetLocalCell and OpGetFreeCell push the cell holding a variable
+	// rather than its value, for a closure to capture. OpGetLocalCell
+	// moves the local into a new cell if it is not in one yet.
+	OpGetLocalCell: {"OpGetLocalCell", []int{2}},
+	OpGetFreeCell:  {"OpGetFreeCell", []int{1}},
+
+	// OpClosure takes the index of the compiled function in the constant
+	// pool and the number of free variables on the stack.
+	OpClosure:     {"OpClosure", []int{4, 1}},
+	OpCall:        {"OpCall", []int{1}},
+	OpTailCall:    {"OpTailCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+
+	// OpIndex replaces an array and an index on the stack with the
+	// element.
+	OpIndex: {"OpIndex", []int{}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// Make encodes op and its operands as one instruction.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction described by def and
+// returns them with the number of bytes read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
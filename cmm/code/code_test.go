@@ -0,0 +1,116 @@
+package code
+
+import "testing"
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 0, 0, 255, 254}},
+		{OpConstant, []int{70000}, []byte{byte(OpConstant), 0, 1, 17, 112}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 0, 255}},
+		{OpGetLocal, []int{300}, []byte{byte(OpGetLocal), 1, 44}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 0, 0, 255, 254, 255}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		if len(instruction) != len(tt.expected) {
+			t.Errorf("instruction has wrong length. want=%d, got=%d",
+				len(tt.expected), len(instruction))
+		}
+
+		for i, b := range tt.expected {
+			if instruction[i] != tt.expected[i] {
+				t.Errorf("wrong byte at pos %d. want=%d, got=%d",
+					i, b, instruction[i])
+			}
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpAdd),
+		Make(OpGetLocal, 1),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetLocal 1
+0004 OpConstant 2
+0009 OpConstant 65535
+0014 OpClosure 65535 255
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q",
+			expected, concatted.String())
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op        Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{OpConstant, []int{70000}, 4},
+		{OpGetLocal, []int{300}, 2},
+		{OpJump, []int{1 << 20}, 4},
+		{OpClosure, []int{70000, 255}, 5},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q\n", err)
+		}
+
+		operandsRead, n := ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
+		}
+
+		for i, want := range tt.operands {
+			if operandsRead[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operandsRead[i])
+			}
+		}
+	}
+}
+
+func TestLineTable(t *testing.T) {
+	lines := LineTable{{Offset: 0, Line: 1}, {Offset: 4, Line: 3}, {Offset: 9, Line: 4}}
+
+	tests := []struct {
+		offset   int
+		expected int
+	}{
+		{0, 1},
+		{3, 1},
+		{4, 3},
+		{8, 3},
+		{9, 4},
+		{100, 4},
+	}
+
+	for _, tt := range tests {
+		if line := lines.Line(tt.offset); line != tt.expected {
+			t.Errorf("wrong line for offset %d. want=%d, got=%d", tt.offset, tt.expected, line)
+		}
+	}
+}
@@ -0,0 +1,595 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/code"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// Compiler lowers an AST to bytecode for the vm package. The bytecode
+// behaves like eval.Eval on the same program: names are looked up in the
+// same order, and errors carry the same messages.
+type Compiler struct {
+	constants []object.Object
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// err is the first operand emit found too large for its instruction,
+	// which Compile returns.
+	err error
+}
+
+// CompilationScope holds the instructions of the function being compiled.
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+	lines               code.LineTable
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// Bytecode is the compiled program: the instructions of the program's top
+// level and the constants they refer to. Globals names the global slots.
+type Bytecode struct {
+	Instructions code.Instructions
+	Lines        code.LineTable
+	Constants    []object.Object
+	Globals      []string
+}
+
+func New() *Compiler {
+	mainScope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+
+	symbolTable := NewSymbolTable()
+	for i, builtin := range object.Builtins {
+		symbolTable.DefineBuiltin(i, builtin.Name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// NewWithState returns a Compiler that continues from the globals and
+// constants of an earlier one, as the REPL does between lines.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		c.markLine(node.Token.Line)
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		c.markLine(node.Token.Line)
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+			if err := c.compileFunction(fn, node.Name.Value); err != nil {
+				return err
+			}
+		} else if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		symbol := c.symbolTable.Define(node.Name.Value)
+		if symbol.Scope == GlobalScope {
+			c.emit(code.OpSetGlobal, symbol.Index)
+		} else {
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+
+	case *ast.ReturnStatement:
+		c.markLine(node.Token.Line)
+		if call, ok := node.ReturnValue.(*ast.CallExpression); ok && c.scopeIndex > 0 {
+			if err := c.compileCall(call, code.OpTailCall); err != nil {
+				return err
+			}
+		} else if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			// Globals are bound late, as in eval: the name may be defined
+			// by the time this runs. The VM reports it if it is not.
+			symbol = c.symbolTable.global().Define(node.Value)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.IntegerLiteral:
+		integer := object.NewInteger(node.Value)
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.StringLiteral:
+		str := &object.StringObject{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.BooleanExpression:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Op {
+		case ast.OpNot:
+			c.emit(code.OpBang)
+		case ast.OpNegate:
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Op {
+		case ast.OpAdd:
+			c.emit(code.OpAdd)
+		case ast.OpSub:
+			c.emit(code.OpSub)
+		case ast.OpMul:
+			c.emit(code.OpMul)
+		case ast.OpDiv:
+			c.emit(code.OpDiv)
+		case ast.OpGreater:
+			c.emit(code.OpGreaterThan)
+		case ast.OpLess:
+			c.emit(code.OpLessThan)
+		case ast.OpEqual:
+			c.emit(code.OpEqual)
+		case ast.OpNotEqual:
+			c.emit(code.OpNotEqual)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.IfExpression:
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.compileBlockValue(node.Consequence); err != nil {
+			return err
+		}
+
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else if err := c.compileBlockValue(node.Alternative); err != nil {
+			return err
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node, "")
+
+	case *ast.CallExpression:
+		return c.compileCall(node, code.OpCall)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	}
+
+	return c.err
+}
+
+// compileBlockValue compiles block so that it leaves its value on the
+// stack, as an if expression needs: the value of its last statement, or
+// null when it has none.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	if len(block.Statements) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	switch last := block.Statements[len(block.Statements)-1].(type) {
+	case *ast.ExpressionStatement:
+		c.removeLastPop()
+	case *ast.LetStatement:
+		symbol, _ := c.symbolTable.Resolve(last.Name.Value)
+		c.loadSymbol(symbol)
+	}
+
+	return nil
+}
+
+// compileFunction compiles a function literal to a closure. name is the
+// name a let statement binds it to, if any, so that it can call itself.
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral, name string) error {
+	c.enterScope()
+
+	if name != "" {
+		c.symbolTable.DefineFunctionName(name)
+	}
+
+	params := make([]string, len(node.Parameters))
+	for i, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+		params[i] = p.Value
+	}
+	c.declareLets(node.Body)
+
+	if err := c.compileTailBlock(node.Body); err != nil {
+		return err
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.Names()
+	lines := c.scopes[c.scopeIndex].lines
+	instructions := c.leaveScope()
+
+	for _, s := range freeSymbols {
+		c.loadCaptured(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		Lines:         lines,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		Name:          name,
+		Parameters:    params,
+		LocalNames:    localNames,
+		Body:          node.Body.String(),
+	}
+
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	return c.err
+}
+
+// declareLets declares the lets of a function body, including those in
+// nested blocks but not in nested functions, as the resolver does for eval.
+func (c *Compiler) declareLets(body *ast.BlockStatement) {
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			c.symbolTable.Declare(node.Name.Value)
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	})
+}
+
+// compileTailBlock compiles a block whose value is returned from the
+// function, so that calls in tail position become OpTailCall.
+func (c *Compiler) compileTailBlock(block *ast.BlockStatement) error {
+	if len(block.Statements) == 0 {
+		c.emit(code.OpReturn)
+		return nil
+	}
+
+	last := len(block.Statements) - 1
+	for _, s := range block.Statements[:last] {
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+
+	switch s := block.Statements[last].(type) {
+	case *ast.ExpressionStatement:
+		c.markLine(s.Token.Line)
+		return c.compileTail(s.Expression)
+	case *ast.LetStatement:
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+		symbol, _ := c.symbolTable.Resolve(s.Name.Value)
+		c.loadSymbol(symbol)
+		c.emit(code.OpReturnValue)
+	default:
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+	}
+
+	return nil
+}
+
+// compileTail compiles an expression in tail position: its value is
+// returned from the function.
+func (c *Compiler) compileTail(node ast.Expression) error {
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		if err := c.compileCall(node, code.OpTailCall); err != nil {
+			return err
+		}
+
+	case *ast.IfExpression:
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		if err := c.compileTailBlock(node.Consequence); err != nil {
+			return err
+		}
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+			c.emit(code.OpReturnValue)
+			return nil
+		}
+		return c.compileTailBlock(node.Alternative)
+
+	default:
+		if err := c.Compile(node); err != nil {
+			return err
+		}
+	}
+
+	c.emit(code.OpReturnValue)
+	return nil
+}
+
+func (c *Compiler) compileCall(node *ast.CallExpression, op code.Opcode) error {
+	if err := c.Compile(node.Function); err != nil {
+		return err
+	}
+
+	for _, a := range node.Arguments {
+		if err := c.Compile(a); err != nil {
+			return err
+		}
+	}
+
+	c.emit(op, len(node.Arguments))
+	return c.err
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// loadCaptured pushes the value of s for a closure to capture, or the cell
+// holding it, so that the closure shares the variable.
+func (c *Compiler) loadCaptured(s Symbol) {
+	switch {
+	case s.Cell && s.Scope == LocalScope:
+		c.emit(code.OpGetLocalCell, s.Index)
+	case s.Cell && s.Scope == FreeScope:
+		c.emit(code.OpGetFreeCell, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Lines:        c.scopes[c.scopeIndex].lines,
+		Constants:    c.constants,
+		Globals:      c.symbolTable.global().Names(),
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands...)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+// markLine records that the instructions emitted from here on come from
+// source line line.
+func (c *Compiler) markLine(line int) {
+	scope := &c.scopes[c.scopeIndex]
+	offset := len(scope.instructions)
+
+	if n := len(scope.lines); n > 0 {
+		last := &scope.lines[n-1]
+		if last.Line == line {
+			return
+		}
+		if last.Offset == offset {
+			last.Line = line
+			return
+		}
+	}
+
+	scope.lines = append(scope.lines, code.LineEntry{Offset: offset, Line: line})
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	c.scopes[c.scopeIndex].instructions = old[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, operand)
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+// checkOperands records an error if an operand of op is too large for its
+// width in the instruction, where the VM would read back a different value.
+func (c *Compiler) checkOperands(op code.Opcode, operands ...int) {
+	def, err := code.Lookup(byte(op))
+	if err != nil || c.err != nil {
+		return
+	}
+
+	for i, operand := range operands {
+		limit := 1<<(8*def.OperandWidths[i]) - 1
+		if operand <= limit {
+			continue
+		}
+
+		switch {
+		case op == code.OpConstant || op == code.OpClosure && i == 0:
+			c.err = fmt.Errorf("too many constants: the limit is %d", limit+1)
+		case op == code.OpJump || op == code.OpJumpNotTruthy:
+			c.err = fmt.Errorf("function too long: the limit is %d bytes of bytecode", limit)
+		case op == code.OpGetGlobal || op == code.OpSetGlobal:
+			c.err = fmt.Errorf("too many globals: the limit is %d", limit+1)
+		case op == code.OpGetLocal || op == code.OpSetLocal || op == code.OpGetLocalCell:
+			c.err = fmt.Errorf("too many local variables in one function: the limit is %d", limit+1)
+		case op == code.OpGetFree || op == code.OpGetFreeCell || op == code.OpClosure:
+			c.err = fmt.Errorf("too many free variables in one function: the limit is %d", limit)
+		case op == code.OpCall || op == code.OpTailCall:
+			c.err = fmt.Errorf("too many arguments in one call: the limit is %d", limit)
+		default:
+			c.err = fmt.Errorf("operand %d of %s too large: the limit is %d", operand, def.Name, limit)
+		}
+		return
+	}
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
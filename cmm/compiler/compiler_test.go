@@ -0,0 +1,381 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/shoebilyas123/cminusminus/cmm/code"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestCompile(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 < 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-1; !true",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 17),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let one = 1; let one = "one"; one`,
+			expectedConstants: []interface{}{1, "one"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `len("")`,
+			expectedConstants: []interface{}{""},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn() { }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { let b = a; b }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { fn(b) { a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "let loop = fn(n) { if (n) { loop(n) } else { n } };",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpJumpNotTruthy, 15),
+					// 0005
+					code.Make(code.OpCurrentClosure),
+					// 0006
+					code.Make(code.OpGetLocal, 0),
+					// 0008
+					code.Make(code.OpTailCall, 1),
+					// 0010
+					code.Make(code.OpReturnValue),
+					// 0011
+					code.Make(code.OpGetLocal, 0),
+					// 0013
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: "fn() { later }; let later = 1;",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	first := NewEnclosedSymbolTable(global)
+	first.Define("b")
+
+	second := NewEnclosedSymbolTable(first)
+	second.Define("c")
+
+	tests := []struct {
+		name     string
+		expected Symbol
+	}{
+		{"a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{"b", Symbol{Name: "b", Scope: FreeScope, Index: 0}},
+		{"c", Symbol{Name: "c", Scope: LocalScope, Index: 0}},
+	}
+
+	for _, tt := range tests {
+		result, ok := second.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.name, tt.expected, result)
+		}
+	}
+
+	if len(second.FreeSymbols) != 1 || second.FreeSymbols[0].Name != "b" {
+		t.Errorf("wrong free symbols. got=%+v", second.FreeSymbols)
+	}
+}
+
+func TestDeclare(t *testing.T) {
+	outer := NewEnclosedSymbolTable(NewSymbolTable())
+	outer.Declare("h")
+
+	if _, ok := outer.Resolve("h"); ok {
+		t.Errorf("h resolved in its own function before its let")
+	}
+
+	inner := NewEnclosedSymbolTable(outer)
+	free, ok := inner.Resolve("h")
+	expected := Symbol{Name: "h", Scope: FreeScope, Index: 0, Cell: true}
+	if !ok || free != expected {
+		t.Errorf("expected h to resolve to %+v, got=%+v", expected, free)
+	}
+
+	local := outer.Define("h")
+	expected = Symbol{Name: "h", Scope: LocalScope, Index: 0, Cell: true}
+	if local != expected {
+		t.Errorf("expected the let of h to define %+v, got=%+v", expected, local)
+	}
+}
+
+// TestLimits checks that operands too large for their instruction are
+// reported rather than wrapped around.
+func TestLimits(t *testing.T) {
+	// lets returns n lets of distinct names and their sum
+	lets := func(n int) (string, string) {
+		var out strings.Builder
+		names := make([]string, n)
+		for i := range names {
+			names[i] = varName(i)
+			fmt.Fprintf(&out, "let %s = %d; ", names[i], i)
+		}
+		return out.String(), strings.Join(names, " + ")
+	}
+	args := strings.Repeat("1, ", 255) + "1"
+	lets256, sum256 := lets(256)
+	lets255, sum255 := lets(255)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"len(" + args + ")", "too many arguments in one call: the limit is 255"},
+		{"fn() { len(" + args + ") }", "too many arguments in one call: the limit is 255"},
+		{"fn() { " + lets256 + "fn() { " + sum256 + " } }", "too many free variables in one function: the limit is 255"},
+		{"fn() { " + lets255 + "fn() { " + sum255 + " } }", ""},
+		{"fn() { " + lets256 + sum256 + " }", ""},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %.40q: %v", tt.input, p.Errors())
+		}
+
+		err := New().Compile(program)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%.40q: compiler error: %s", tt.input, err)
+			}
+		} else if err == nil || err.Error() != tt.expected {
+			t.Errorf("%.40q: want error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+// varName returns a distinct identifier for each i: va, vb, ..., vz, vba,
+// and so on, as identifiers cannot hold digits.
+func varName(i int) string {
+	name := string(rune('a' + i%26))
+	for i /= 26; i > 0; i /= 26 {
+		name = string(rune('a'+i%26)) + name
+	}
+	return "v" + name
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %q: %v", tt.input, p.Errors())
+		}
+
+		compiler := New()
+		if err := compiler.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		if err := testInstructions(tt.expectedInstructions, bytecode.Instructions); err != "" {
+			t.Errorf("%q: %s", tt.input, err)
+		}
+
+		if err := testConstants(tt.expectedConstants, bytecode.Constants); err != "" {
+			t.Errorf("%q: %s", tt.input, err)
+		}
+	}
+}
+
+func testInstructions(expected []code.Instructions, actual code.Instructions) string {
+	concatted := code.Instructions{}
+	for _, ins := range expected {
+		concatted = append(concatted, ins...)
+	}
+
+	if actual.String() != concatted.String() {
+		return "wrong instructions.\nwant=\n" + concatted.String() + "got=\n" + actual.String()
+	}
+	return ""
+}
+
+func testConstants(expected []interface{}, actual []object.Object) string {
+	if len(expected) != len(actual) {
+		return "wrong number of constants"
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.IntegerObject)
+			if !ok || integer.Value != int64(constant) {
+				return "wrong integer constant: " + actual[i].Inspect()
+			}
+		case string:
+			str, ok := actual[i].(*object.StringObject)
+			if !ok || str.Value != constant {
+				return "wrong string constant: " + actual[i].Inspect()
+			}
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return "constant is not a function: " + actual[i].Inspect()
+			}
+			if err := testInstructions(constant, fn.Instructions); err != "" {
+				return err
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,157 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+
+	// Cell is whether the variable lives in an object.Cell, because a
+	// closure captured it before it was set.
+	Cell bool
+}
+
+// SymbolTable maps the names of one function, or of the program for the
+// outermost table, to where their values live at runtime.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+
+	// declared holds the lets of this function that have a slot but have
+	// not been compiled yet. Only functions nested in it can see them.
+	declared map[string]Symbol
+
+	// names lists the defined names by index
+	names []string
+
+	// FreeSymbols are the symbols of enclosing functions this function
+	// refers to, in the order the closure captures them.
+	FreeSymbols []Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol), declared: make(map[string]Symbol)}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Define binds name in this table. Defining a name again reuses its slot,
+// just as a second let overwrites the binding in an environment.
+func (s *SymbolTable) Define(name string) Symbol {
+	scope := LocalScope
+	if s.Outer == nil {
+		scope = GlobalScope
+	}
+
+	if symbol, ok := s.store[name]; ok && symbol.Scope == scope {
+		return symbol
+	}
+	if symbol, ok := s.declared[name]; ok {
+		delete(s.declared, name)
+		s.store[name] = symbol
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: scope}
+	s.store[name] = symbol
+	s.names = append(s.names, name)
+	s.numDefinitions++
+	return symbol
+}
+
+// Declare gives a slot to a let that comes later in the function, so that
+// the functions nested before it can refer to it, as they can in eval.
+// The function itself sees the let once Define is called for it.
+func (s *SymbolTable) Declare(name string) {
+	if symbol, ok := s.store[name]; ok && symbol.Scope == LocalScope {
+		return
+	}
+	if _, ok := s.declared[name]; ok {
+		return
+	}
+
+	s.declared[name] = Symbol{Name: name, Index: s.numDefinitions, Scope: LocalScope}
+	s.names = append(s.names, name)
+	s.numDefinitions++
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName lets a function literal bound by let refer to itself
+// before the binding exists.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope, Cell: original.Cell}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	return s.resolve(name, false)
+}
+
+// resolve looks name up for this function, or for a function nested in it
+// if nested is set. A nested function may capture a let declared here
+// before it is set, which then has to live in a cell.
+func (s *SymbolTable) resolve(name string, nested bool) (Symbol, bool) {
+	if symbol, ok := s.declared[name]; ok && nested {
+		symbol.Cell = true
+		s.declared[name] = symbol
+		return symbol, true
+	}
+
+	symbol, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = s.Outer.resolve(name, true)
+	if !ok {
+		return symbol, ok
+	}
+
+	if symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+
+	return s.defineFree(symbol), true
+}
+
+// global returns the outermost table, which holds the global names.
+func (s *SymbolTable) global() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
+
+// Names returns the names defined in this table, indexed by slot.
+func (s *SymbolTable) Names() []string {
+	return s.names
+}
@@ -0,0 +1,273 @@
+// Package cover measures which statements of cmm programs run, and which
+// arms of their if expressions are taken.
+//
+// A Profile learns the statements and if expressions of a program with
+// Add, and counts them with the hooks of an evaluator:
+//
+//	profile := cover.New()
+//	profile.Add("script.cmm", program)
+//	e := eval.New(eval.Config{})
+//	e.SetHooks(profile.Hooks())
+//	e.Eval(program, env)
+//
+// A profile is written as text, one block per line:
+//
+//	mode: count
+//	script.cmm:1:1 stmt 1
+//	script.cmm:2:9 then 0
+//	script.cmm:2:9 else 1
+//
+// which gives the position of a statement, or of the if expression an arm
+// belongs to, the kind of block and how many times it ran. An if without
+// else still has an else arm, which counts the times its condition failed.
+package cover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// The kinds of blocks.
+const (
+	Stmt = "stmt"
+	Then = "then"
+	Else = "else"
+)
+
+// Block is a statement, or an arm of an if expression, and the number of
+// times it ran.
+type Block struct {
+	Line, Column int
+	Kind         string
+	Count        int64
+}
+
+// File holds the blocks of a source file, in the order of their positions.
+type File struct {
+	Name   string
+	Blocks []*Block
+}
+
+// Statements returns how many of the statements of f ran, and how many
+// there are.
+func (f *File) Statements() (covered, total int) {
+	return f.count(func(b *Block) bool { return b.Kind == Stmt })
+}
+
+// Branches returns how many of the arms of the if expressions of f were
+// taken, and how many there are.
+func (f *File) Branches() (covered, total int) {
+	return f.count(func(b *Block) bool { return b.Kind != Stmt })
+}
+
+func (f *File) count(match func(*Block) bool) (covered, total int) {
+	for _, b := range f.Blocks {
+		if match(b) {
+			total++
+			if b.Count > 0 {
+				covered++
+			}
+		}
+	}
+	return covered, total
+}
+
+// key identifies a block within its file.
+type key struct {
+	line, column int
+	kind         string
+}
+
+// Profile counts the blocks of the programs added to it as they run. It is
+// not safe for concurrent use.
+type Profile struct {
+	files  map[string]*File
+	blocks map[string]map[key]*Block
+
+	statements map[ast.Statement]*Block
+	branches   map[*ast.IfExpression][2]*Block
+}
+
+// New returns an empty profile.
+func New() *Profile {
+	return &Profile{
+		files:      make(map[string]*File),
+		blocks:     make(map[string]map[key]*Block),
+		statements: make(map[ast.Statement]*Block),
+		branches:   make(map[*ast.IfExpression][2]*Block),
+	}
+}
+
+// block returns the block of file at the position with kind, adding it if
+// it is new.
+func (p *Profile) block(file string, k key) *Block {
+	f, ok := p.files[file]
+	if !ok {
+		f = &File{Name: file}
+		p.files[file] = f
+		p.blocks[file] = make(map[key]*Block)
+	}
+	if b, ok := p.blocks[file][k]; ok {
+		return b
+	}
+
+	b := &Block{Line: k.line, Column: k.column, Kind: k.kind}
+	p.blocks[file][k] = b
+	i := sort.Search(len(f.Blocks), func(i int) bool { return !less(f.Blocks[i], b) })
+	f.Blocks = append(f.Blocks, nil)
+	copy(f.Blocks[i+1:], f.Blocks[i:])
+	f.Blocks[i] = b
+	return b
+}
+
+// kinds orders the blocks at the same position.
+var kinds = map[string]int{Stmt: 0, Then: 1, Else: 2}
+
+func less(a, b *Block) bool {
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	if a.Column != b.Column {
+		return a.Column < b.Column
+	}
+	return kinds[a.Kind] < kinds[b.Kind]
+}
+
+// Add adds the statements and if expressions of program, the source file
+// name, to the profile. Adding a file again, parsed anew, counts the runs
+// of both in the same blocks.
+func (p *Profile) Add(name string, program *ast.Program) {
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement, *ast.ReturnStatement, *ast.ExpressionStatement:
+			pos := ast.Position(node)
+			p.statements[node.(ast.Statement)] = p.block(name, key{pos.Line, pos.Column, Stmt})
+		case *ast.IfExpression:
+			p.branches[node] = [2]*Block{
+				p.block(name, key{node.Token.Line, node.Token.Column, Then}),
+				p.block(name, key{node.Token.Line, node.Token.Column, Else}),
+			}
+		}
+		return true
+	})
+}
+
+// Statement counts a run of stmt. It is an eval.Hooks Statement hook.
+func (p *Profile) Statement(stmt ast.Statement, _ *object.Environment) {
+	if b, ok := p.statements[stmt]; ok {
+		b.Count++
+	}
+}
+
+// Branch counts the arm of node that was taken. It is an eval.Hooks Branch
+// hook.
+func (p *Profile) Branch(node *ast.IfExpression, consequence bool) {
+	arms, ok := p.branches[node]
+	if !ok {
+		return
+	}
+	if consequence {
+		arms[0].Count++
+	} else {
+		arms[1].Count++
+	}
+}
+
+// Hooks returns the hooks that count the runs of blocks.
+func (p *Profile) Hooks() eval.Hooks {
+	return eval.Hooks{Statement: p.Statement, Branch: p.Branch}
+}
+
+// Files returns the files of the profile, sorted by name.
+func (p *Profile) Files() []*File {
+	files := make([]*File, 0, len(p.files))
+	for _, f := range p.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
+
+// Write writes the profile in its text form.
+func (p *Profile) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "mode: count")
+	for _, f := range p.Files() {
+		for _, b := range f.Blocks {
+			fmt.Fprintf(bw, "%s:%d:%d %s %d\n", f.Name, b.Line, b.Column, b.Kind, b.Count)
+		}
+	}
+	return bw.Flush()
+}
+
+// Read reads a profile that Write wrote. The counts of a block listed more
+// than once are added up.
+func Read(r io.Reader) (*Profile, error) {
+	p := New()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if n == 1 {
+			if line != "mode: count" {
+				return nil, fmt.Errorf("line 1: bad mode line %q", line)
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+
+		name, k, count, err := parseBlock(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		p.block(name, k).Count += count
+	}
+	return p, scanner.Err()
+}
+
+// parseBlock parses the line of a block. The name of the file may hold
+// colons and spaces, so the line is taken apart from the end.
+func parseBlock(text string) (name string, k key, count int64, err error) {
+	bad := fmt.Errorf("bad block %q", text)
+
+	rest, countText, ok1 := cutLast(text, ' ')
+	pos, kind, ok2 := cutLast(rest, ' ')
+	rest, columnText, ok3 := cutLast(pos, ':')
+	name, lineText, ok4 := cutLast(rest, ':')
+	if !ok1 || !ok2 || !ok3 || !ok4 || name == "" {
+		return "", k, 0, bad
+	}
+
+	k.kind = kind
+	if _, ok := kinds[kind]; !ok {
+		return "", k, 0, bad
+	}
+	if count, err = strconv.ParseInt(countText, 10, 64); err != nil || count < 0 {
+		return "", k, 0, bad
+	}
+	if k.line, err = strconv.Atoi(lineText); err != nil || k.line < 1 {
+		return "", k, 0, bad
+	}
+	if k.column, err = strconv.Atoi(columnText); err != nil || k.column < 1 {
+		return "", k, 0, bad
+	}
+	return name, k, count, nil
+}
+
+// cutLast splits s around the last sep in it.
+func cutLast(s string, sep byte) (before, after string, found bool) {
+	i := strings.LastIndexByte(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+1:], true
+}
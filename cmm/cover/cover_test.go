@@ -0,0 +1,168 @@
+package cover
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+)
+
+const source = `let abs = fn(n) {
+	if (n < 0) { -n } else { n }
+};
+let check = fn(n) { if (n > 100) { return 1; } };
+check(1);
+abs(3) + abs(4)
+`
+
+// run runs source, as the file name, with p counting it.
+func run(t *testing.T, p *Profile, name string) {
+	t.Helper()
+	program := parser.New(lexer.New(source)).ParseProgram()
+	p.Add(name, program)
+	e := eval.New(eval.Config{})
+	e.SetHooks(p.Hooks())
+	if result := e.Eval(program, object.NewEnvironment()); result.Inspect() != "7" {
+		t.Fatalf("got %s", result.Inspect())
+	}
+}
+
+func TestProfile(t *testing.T) {
+	p := New()
+	run(t, p, "abs.cmm")
+
+	var out bytes.Buffer
+	if err := p.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `mode: count
+abs.cmm:1:1 stmt 1
+abs.cmm:2:2 stmt 2
+abs.cmm:2:2 then 0
+abs.cmm:2:2 else 2
+abs.cmm:2:15 stmt 0
+abs.cmm:2:27 stmt 2
+abs.cmm:4:1 stmt 1
+abs.cmm:4:21 stmt 1
+abs.cmm:4:21 then 0
+abs.cmm:4:21 else 1
+abs.cmm:4:36 stmt 0
+abs.cmm:5:1 stmt 1
+abs.cmm:6:1 stmt 1
+`
+	if out.String() != want {
+		t.Errorf("profile:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	f := p.Files()[0]
+	if covered, total := f.Statements(); covered != 7 || total != 9 {
+		t.Errorf("statements: got %d of %d", covered, total)
+	}
+	if covered, total := f.Branches(); covered != 2 || total != 4 {
+		t.Errorf("branches: got %d of %d", covered, total)
+	}
+}
+
+// TestAdd checks that a file added again counts in the same blocks.
+func TestAdd(t *testing.T) {
+	p := New()
+	run(t, p, "abs.cmm")
+	run(t, p, "abs.cmm")
+	run(t, p, "other.cmm")
+
+	files := p.Files()
+	if len(files) != 2 || files[0].Name != "abs.cmm" || files[1].Name != "other.cmm" {
+		t.Fatalf("got files %v", files)
+	}
+	if b := files[0].Blocks[1]; b.Line != 2 || b.Count != 4 {
+		t.Errorf("got block %+v, want line 2 run 4 times", b)
+	}
+	if b := files[1].Blocks[1]; b.Count != 2 {
+		t.Errorf("got block %+v, want it run 2 times", b)
+	}
+}
+
+func TestRead(t *testing.T) {
+	p := New()
+	run(t, p, "dir with space/a:b.cmm")
+	var out bytes.Buffer
+	p.Write(&out)
+
+	// a profile listed twice adds up
+	read, err := Read(strings.NewReader(out.String() + strings.TrimPrefix(out.String(), "mode: count\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := read.Files()[0]
+	if f.Name != "dir with space/a:b.cmm" || len(f.Blocks) != len(p.Files()[0].Blocks) {
+		t.Fatalf("read back %s with %d blocks", f.Name, len(f.Blocks))
+	}
+	for i, b := range p.Files()[0].Blocks {
+		if got := *f.Blocks[i]; got.Line != b.Line || got.Column != b.Column || got.Kind != b.Kind || got.Count != 2*b.Count {
+			t.Errorf("block %d: read back %+v, want %+v counted twice", i, got, *b)
+		}
+	}
+
+	for _, bad := range []string{
+		"mode: set\n",
+		"mode: count\na.cmm:1:1 stmt\n",
+		"mode: count\na.cmm:1 stmt 1\n",
+		"mode: count\na.cmm:0:1 stmt 1\n",
+		"mode: count\na.cmm:1:1 loop 1\n",
+		"mode: count\na.cmm:1:1 stmt -1\n",
+		"mode: count\n:1:1 stmt 1\n",
+	} {
+		if _, err := Read(strings.NewReader(bad)); err == nil {
+			t.Errorf("no error reading %q", bad)
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	p := New()
+	run(t, p, "abs.cmm")
+	p.block("empty.cmm", key{1, 1, Stmt})
+
+	var out bytes.Buffer
+	p.WriteSummary(&out)
+	want := `abs.cmm: 77.8% of 9 statements, 50.0% of 4 branches
+empty.cmm: 0.0% of 1 statements, no branches
+total: 70.0% of 10 statements, 50.0% of 4 branches
+`
+	if out.String() != want {
+		t.Errorf("summary:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "abs.cmm")
+	os.WriteFile(path, []byte(source), 0o644)
+	p := New()
+	run(t, p, path)
+
+	var out bytes.Buffer
+	if err := p.WriteHTML(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<p>77.8% of 9 statements, 50.0% of 4 branches</p>",
+		`<tr class="covered"><td class="number">1</td><td class="count">1</td><td class="text">let abs = fn(n) {</td></tr>`,
+		`<tr class="partial" title="if at column 2: taken 0 times` + "\n" + `if at column 2: not taken 2 times"><td class="number">2</td><td class="count">2</td><td class="text">    if (n &lt; 0) { -n } else { n }</td></tr>`,
+		`<tr><td class="number">3</td><td class="count"></td><td class="text">};</td></tr>`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("HTML has no %s:\n%s", want, out.String())
+		}
+	}
+
+	p.block(filepath.Join(t.TempDir(), "gone.cmm"), key{1, 1, Stmt})
+	if err := p.WriteHTML(&out); err == nil {
+		t.Error("no error for a missing source file")
+	}
+}
@@ -0,0 +1,152 @@
+package cover
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+)
+
+// WriteSummary writes how much of each file of p ran, and of all of them.
+func (p *Profile) WriteSummary(w io.Writer) {
+	files := p.Files()
+	var stmts, stmtTotal, branches, branchTotal int
+	for _, f := range files {
+		s, st := f.Statements()
+		b, bt := f.Branches()
+		fmt.Fprintf(w, "%s: %s\n", f.Name, summary(s, st, b, bt))
+		stmts, stmtTotal, branches, branchTotal = stmts+s, stmtTotal+st, branches+b, branchTotal+bt
+	}
+	if len(files) > 1 {
+		fmt.Fprintf(w, "total: %s\n", summary(stmts, stmtTotal, branches, branchTotal))
+	}
+}
+
+func summary(stmts, stmtTotal, branches, branchTotal int) string {
+	return percent(stmts, stmtTotal, "statements") + ", " + percent(branches, branchTotal, "branches")
+}
+
+// percent says what part of total items were covered.
+func percent(covered, total int, items string) string {
+	if total == 0 {
+		return "no " + items
+	}
+	return fmt.Sprintf("%.1f%% of %d %s", 100*float64(covered)/float64(total), total, items)
+}
+
+// The classes of the lines of the HTML view.
+const (
+	lineCovered   = "covered"
+	lineUncovered = "uncovered"
+	linePartial   = "partial"
+)
+
+type htmlLine struct {
+	Number int
+	Text   string
+	Class  string
+	Count  string
+	Title  string
+}
+
+type htmlFile struct {
+	Name    string
+	Summary string
+	Lines   []htmlLine
+}
+
+// WriteHTML writes a page that shows the source of each file of p, which
+// it reads from disk, with the lines that ran and those that did not
+// marked. A line is partly covered if some of its blocks did not run, such
+// as an arm of an if expression that was never taken.
+func (p *Profile) WriteHTML(w io.Writer) error {
+	var files []htmlFile
+	for _, f := range p.Files() {
+		src, err := os.ReadFile(f.Name)
+		if err != nil {
+			return err
+		}
+		s, st := f.Statements()
+		b, bt := f.Branches()
+		files = append(files, htmlFile{Name: f.Name, Summary: summary(s, st, b, bt), Lines: annotate(f, string(src))})
+	}
+	return page.Execute(w, files)
+}
+
+// annotate returns the lines of src, the source of f, with the coverage of
+// the blocks that start on each.
+func annotate(f *File, src string) []htmlLine {
+	lines := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+	annotated := make([]htmlLine, len(lines))
+	for i, text := range lines {
+		annotated[i] = htmlLine{Number: i + 1, Text: strings.ReplaceAll(text, "\t", "    ")}
+	}
+
+	notes := make([][]string, len(lines))
+	hit := make([]int, len(lines))
+	missed := make([]int, len(lines))
+	counts := make([]int64, len(lines))
+	for _, b := range f.Blocks {
+		i := b.Line - 1
+		if i >= len(lines) {
+			continue
+		}
+		if b.Count > 0 {
+			hit[i]++
+		} else {
+			missed[i]++
+		}
+		switch b.Kind {
+		case Stmt:
+			counts[i] = max(counts[i], b.Count)
+		case Then:
+			notes[i] = append(notes[i], fmt.Sprintf("if at column %d: taken %d times", b.Column, b.Count))
+		case Else:
+			notes[i] = append(notes[i], fmt.Sprintf("if at column %d: not taken %d times", b.Column, b.Count))
+		}
+	}
+
+	for i := range annotated {
+		switch {
+		case hit[i] > 0 && missed[i] > 0:
+			annotated[i].Class = linePartial
+		case hit[i] > 0:
+			annotated[i].Class = lineCovered
+		case missed[i] > 0:
+			annotated[i].Class = lineUncovered
+		}
+		if hit[i]+missed[i] > 0 {
+			annotated[i].Count = fmt.Sprint(counts[i])
+		}
+		annotated[i].Title = strings.Join(notes[i], "\n")
+	}
+	return annotated
+}
+
+var page = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>cminusminus coverage</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+h2 { font-size: 1.1em; margin-bottom: 0.2em; }
+p { color: #555; margin-top: 0; }
+table { border-collapse: collapse; font-family: monospace; white-space: pre; }
+td { padding: 0 0.5em; }
+td.number, td.count { color: #999; text-align: right; }
+tr.covered td.text { background: #d7f5d7; }
+tr.uncovered td.text { background: #f8d0d0; }
+tr.partial td.text { background: #f8ecc0; }
+</style>
+</head>
+<body>
+{{range .}}<h2>{{.Name}}</h2>
+<p>{{.Summary}}</p>
+<table>
+{{range .Lines}}<tr{{if .Class}} class="{{.Class}}"{{end}}{{if .Title}} title="{{.Title}}"{{end}}><td class="number">{{.Number}}</td><td class="count">{{.Count}}</td><td class="text">{{.Text}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
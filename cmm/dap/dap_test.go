@@ -0,0 +1,419 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// client drives a server over pipes, the way an editor does.
+type client struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	msgs chan message
+	seq  int
+	done chan error
+
+	// events holds the events read while waiting for responses
+	events []map[string]json.RawMessage
+}
+
+// message is a response or event as the client reads it.
+type message map[string]json.RawMessage
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{t: t, in: inW, msgs: make(chan message, 100), done: make(chan error, 1)}
+	go func() {
+		err := Serve(inR, outW)
+		outW.Close()
+		c.done <- err
+	}()
+
+	// the server is read all the time, as it may send events whenever
+	go func() {
+		defer close(c.msgs)
+		r := textproto.NewReader(bufio.NewReader(outR))
+		for {
+			header, err := r.ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+			length, _ := strconv.Atoi(header.Get("Content-Length"))
+			body := make([]byte, length)
+			if _, err := io.ReadFull(r.R, body); err != nil {
+				return
+			}
+			var msg message
+			json.Unmarshal(body, &msg)
+			c.msgs <- msg
+		}
+	}()
+
+	t.Cleanup(func() {
+		inW.Close()
+		go func() {
+			for range c.msgs {
+			}
+		}()
+		select {
+		case <-c.done:
+		case <-time.After(5 * time.Second):
+			t.Error("the server did not end")
+		}
+	})
+	return c
+}
+
+// launch starts a debugging session of src, with the breakpoints given as
+// line numbers or as line numbers and conditions.
+func launch(t *testing.T, src string, stopOnEntry bool, breakpoints ...SourceBreakpoint) *client {
+	path := filepath.Join(t.TempDir(), "main.cmm")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t)
+	c.request("initialize", map[string]interface{}{"adapterID": "cminusminus"})
+	c.request("launch", LaunchArguments{Program: path, StopOnEntry: stopOnEntry})
+	c.event("initialized")
+	c.request("setBreakpoints", SetBreakpointsArguments{Source: Source{Path: path}, Breakpoints: breakpoints})
+	c.request("configurationDone", nil)
+	return c
+}
+
+// read returns the next message the server sent.
+func (c *client) read() message {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatal("the server closed its output")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the server")
+	}
+	return nil
+}
+
+// send sends a request and returns its sequence number.
+func (c *client) send(command string, args interface{}) int {
+	c.seq++
+	body, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return c.seq
+}
+
+// call sends a request and returns its response.
+func (c *client) call(command string, args interface{}) message {
+	c.t.Helper()
+	seq := c.send(command, args)
+	for {
+		msg := c.read()
+		if string(msg["type"]) == `"event"` {
+			c.events = append(c.events, msg)
+			continue
+		}
+		var requestSeq int
+		json.Unmarshal(msg["request_seq"], &requestSeq)
+		if requestSeq == seq {
+			return msg
+		}
+	}
+}
+
+// request sends a request that has to succeed and decodes its body into
+// body, if it is not nil.
+func (c *client) request(command string, args interface{}, body ...interface{}) {
+	c.t.Helper()
+	resp := c.call(command, args)
+	if string(resp["success"]) != "true" {
+		c.t.Fatalf("%s failed: %s", command, resp["message"])
+	}
+	if len(body) != 0 {
+		if err := json.Unmarshal(resp["body"], body[0]); err != nil {
+			c.t.Fatalf("%s: %v", command, err)
+		}
+	}
+}
+
+// event waits for the event name and decodes its body into body, if it
+// is not nil.
+func (c *client) event(name string, body ...interface{}) {
+	c.t.Helper()
+	for {
+		var msg message
+		if len(c.events) != 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.read()
+		}
+		if string(msg["event"]) != `"`+name+`"` {
+			continue
+		}
+		if len(body) != 0 {
+			if err := json.Unmarshal(msg["body"], body[0]); err != nil {
+				c.t.Fatalf("%s: %v", name, err)
+			}
+		}
+		return
+	}
+}
+
+// stopped waits for the program to stop and returns the reason and where
+// its frames are, innermost first, as name:line.
+func (c *client) stopped() (string, []string) {
+	c.t.Helper()
+	var event StoppedEventBody
+	c.event("stopped", &event)
+
+	var trace struct {
+		StackFrames []StackFrame `json:"stackFrames"`
+	}
+	c.request("stackTrace", StackTraceArguments{ThreadID: threadID}, &trace)
+	var frames []string
+	for _, f := range trace.StackFrames {
+		frames = append(frames, fmt.Sprintf("%s:%d", f.Name, f.Line))
+	}
+	return event.Reason, frames
+}
+
+// variables returns the variables of a scope of a frame as name=value.
+func (c *client) variables(frame int, scope string) []string {
+	c.t.Helper()
+	var scopes struct {
+		Scopes []Scope `json:"scopes"`
+	}
+	c.request("scopes", ScopesArguments{FrameID: frame}, &scopes)
+	for _, s := range scopes.Scopes {
+		if s.Name != scope {
+			continue
+		}
+		var vars struct {
+			Variables []Variable `json:"variables"`
+		}
+		c.request("variables", VariablesArguments{VariablesReference: s.VariablesReference}, &vars)
+		got := []string{}
+		for _, v := range vars.Variables {
+			got = append(got, v.Name+"="+v.Value)
+		}
+		return got
+	}
+	c.t.Fatalf("frame %d has no scope %s", frame, scope)
+	return nil
+}
+
+func (c *client) evaluate(frame int, expression string) string {
+	c.t.Helper()
+	var result struct {
+		Result string `json:"result"`
+	}
+	c.request("evaluate", EvaluateArguments{Expression: expression, FrameID: frame}, &result)
+	return result.Result
+}
+
+func (c *client) wantStop(reason string, frames ...string) {
+	c.t.Helper()
+	gotReason, gotFrames := c.stopped()
+	if gotReason != reason || !reflect.DeepEqual(gotFrames, frames) {
+		c.t.Fatalf("stopped for %s at %v, want %s at %v", gotReason, gotFrames, reason, frames)
+	}
+}
+
+// wantExit continues the program and checks what it prints and its exit
+// status.
+func (c *client) wantExit(output string, code int) {
+	c.t.Helper()
+	c.request("continue", map[string]interface{}{"threadId": threadID})
+	if output != "" {
+		var out OutputEventBody
+		c.event("output", &out)
+		if out.Output != output {
+			c.t.Errorf("output = %q, want %q", out.Output, output)
+		}
+	}
+	var exited ExitedEventBody
+	c.event("exited", &exited)
+	if exited.ExitCode != code {
+		c.t.Errorf("exit code = %d, want %d", exited.ExitCode, code)
+	}
+	c.event("terminated")
+}
+
+const program = `let add = fn(a, b) {
+    let sum = a + b;
+    sum
+};
+
+let x = add(1, 2);
+let y = x * 2;
+y
+`
+
+func TestBreakpointsAndStepping(t *testing.T) {
+	c := launch(t, program, false, SourceBreakpoint{Line: 2})
+
+	c.wantStop("breakpoint", "add:2", "main:6")
+	if got, want := c.variables(1, "Locals"), []string{"a=1", "b=2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("locals of add = %v, want %v", got, want)
+	}
+	if got, want := c.variables(2, "Globals"), []string{"add=fn(a, b) { ... }", "args=[]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("globals = %v, want %v", got, want)
+	}
+	if got := c.evaluate(1, "a + b * 10"); got != "21" {
+		t.Errorf("a + b * 10 = %s, want 21", got)
+	}
+
+	c.request("next", nil)
+	c.wantStop("step", "add:3", "main:6")
+	if got, want := c.variables(1, "Locals"), []string{"a=1", "b=2", "sum=3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("locals of add = %v, want %v", got, want)
+	}
+
+	c.request("stepOut", nil)
+	c.wantStop("step", "main:7")
+	c.request("next", nil)
+	c.wantStop("step", "main:8")
+	if got := c.evaluate(1, "x"); got != "3" {
+		t.Errorf("x = %s, want 3", got)
+	}
+	c.wantExit("6\n", 0)
+}
+
+func TestStepIn(t *testing.T) {
+	c := launch(t, program, true)
+
+	c.wantStop("entry", "main:1")
+	c.request("next", nil)
+	c.wantStop("step", "main:6")
+	c.request("stepIn", nil)
+	c.wantStop("step", "add:2", "main:6")
+	c.request("next", nil)
+	c.wantStop("step", "add:3", "main:6")
+	c.request("next", nil)
+	c.wantStop("step", "main:7")
+	c.wantExit("6\n", 0)
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	src := `let sum = fn(n) {
+    if (n == 0) {
+        0
+    } else {
+        n + sum(n - 1)
+    }
+};
+sum(4)`
+	c := launch(t, src, false, SourceBreakpoint{Line: 2, Condition: "n == 1"})
+
+	c.wantStop("breakpoint", "sum:2", "sum:5", "sum:5", "sum:5", "main:8")
+	if got, want := c.variables(1, "Locals"), []string{"n=1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("locals = %v, want %v", got, want)
+	}
+	if got := c.evaluate(3, "n"); got != "3" {
+		t.Errorf("n in frame 3 = %s, want 3", got)
+	}
+	c.wantExit("10\n", 0)
+}
+
+func TestSetBreakpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.cmm")
+	os.WriteFile(path, []byte(program), 0644)
+
+	c := newClient(t)
+	c.request("initialize", nil)
+	c.request("launch", LaunchArguments{Program: path})
+	var body struct {
+		Breakpoints []Breakpoint `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", SetBreakpointsArguments{
+		Source:      Source{Path: path},
+		Breakpoints: []SourceBreakpoint{{Line: 4}, {Line: 7, Condition: "x =="}, {Line: 20}},
+	}, &body)
+
+	want := []Breakpoint{
+		{ID: 1, Verified: true, Line: 6},
+		{ID: 2, Line: 7, Message: "bad condition: no prefix parse function for EOF found"},
+		{ID: 3, Line: 20, Message: "no statement on or after this line"},
+	}
+	if !reflect.DeepEqual(body.Breakpoints, want) {
+		t.Errorf("breakpoints =\n%+v\nwant\n%+v", body.Breakpoints, want)
+	}
+}
+
+func TestPauseAndDisconnect(t *testing.T) {
+	c := launch(t, "let loop = fn(n) {\n    loop(n + 1)\n};\nloop(0)", false)
+
+	time.Sleep(50 * time.Millisecond) // let the loop start
+	c.request("pause", map[string]interface{}{"threadId": threadID})
+	c.wantStop("pause", "loop:2", "main:4")
+	if got := c.evaluate(1, "n > 0"); got != "true" {
+		t.Errorf("n > 0 = %s, want true", got)
+	}
+
+	c.request("disconnect", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+	c.done <- nil
+}
+
+func TestRuntimeError(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"let f = fn(a) { a / 0 };\nf(1)", "runtime error: division by zero\n"},
+		{"let f = fn(n) { 1 + f(n) };\nf(1)", "runtime error: stack overflow\n"},
+	}
+
+	for _, tt := range tests {
+		c := launch(t, tt.src, false)
+
+		var out OutputEventBody
+		c.event("output", &out)
+		if out.Output != tt.want || out.Category != "stderr" {
+			t.Errorf("output = %s %q, want stderr %q", out.Category, out.Output, tt.want)
+		}
+		var exited ExitedEventBody
+		c.event("exited", &exited)
+		if exited.ExitCode != 1 {
+			t.Errorf("exit code = %d, want 1", exited.ExitCode)
+		}
+	}
+}
+
+func TestLaunchErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"let x = ;", " no prefix parse function for ; found"},
+		{`let x: int = "a";`, `1:14: cannot use "a" (string) as int in let x`},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "main.cmm")
+		os.WriteFile(path, []byte(tt.src), 0644)
+
+		c := newClient(t)
+		c.request("initialize", nil)
+		resp := c.call("launch", LaunchArguments{Program: path})
+
+		var msg string
+		json.Unmarshal(resp["message"], &msg)
+		if want := path + ":" + tt.want; string(resp["success"]) != "false" || msg != want {
+			t.Errorf("launch of %q: %s, want %q", tt.src, resp["message"], want)
+		}
+	}
+}
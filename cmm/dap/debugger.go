@@ -0,0 +1,486 @@
+package dap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+)
+
+// frame is the program or a call in progress.
+type frame struct {
+	name string
+	env  *object.Environment
+
+	// line and column are where the statement being run starts
+	line, column int
+}
+
+// mode is how far the program runs when it resumes.
+type mode int
+
+const (
+	modeRun mode = iota
+	modeStepIn
+	modeStepOver
+	modeStepOut
+)
+
+type breakpoint struct {
+	id   int
+	line int
+
+	// condition is the expression that has to be true for the program to
+	// stop, or nil
+	condition *ast.Program
+}
+
+// debugger runs a program with eval hooks that stop it at breakpoints,
+// after steps and when it is asked to pause.
+//
+// The program runs on its own goroutine, which the hooks block while it is
+// stopped. The frames are only changed by that goroutine, and only read
+// by others while it is stopped.
+type debugger struct {
+	program *ast.Program
+
+	// names holds the names of the functions lets bind, by their body
+	names map[*ast.BlockStatement]string
+
+	// lines holds the lines statements start on
+	lines []int
+
+	// stopped is called on the program's goroutine when it stops, with
+	// the reason and the breakpoints it hit
+	stopped func(reason string, hit []int)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	resume chan struct{}
+
+	// done is closed once the program and the reports of how it ended
+	// are over
+	done chan struct{}
+
+	frames []*frame
+
+	mu          sync.Mutex
+	breakpoints map[int]*breakpoint
+	nextID      int
+	paused      bool
+	pause       bool
+	entry       bool
+	mode        mode
+	depth       int
+
+	// refs holds what each variables reference, counting from 1, refers
+	// to while the program is stopped: an environment or an array
+	refs []interface{}
+}
+
+func newDebugger(program *ast.Program, stopped func(reason string, hit []int)) *debugger {
+	d := &debugger{
+		program:     program,
+		names:       make(map[*ast.BlockStatement]string),
+		stopped:     stopped,
+		resume:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		breakpoints: make(map[int]*breakpoint),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	seen := map[int]bool{}
+	var visit func(statements []ast.Statement)
+	visit = func(statements []ast.Statement) {
+		for _, stmt := range statements {
+			if stmt == nil {
+				continue
+			}
+			if line := ast.Position(stmt).Line; !seen[line] {
+				seen[line] = true
+				d.lines = append(d.lines, line)
+			}
+			if let, ok := stmt.(*ast.LetStatement); ok {
+				if fn, ok := let.Value.(*ast.FunctionLiteral); ok {
+					d.names[fn.Body] = let.Name.Value
+				}
+			}
+			for _, block := range blocks(stmt) {
+				visit(block.Statements)
+			}
+		}
+	}
+	visit(program.Statements)
+	sort.Ints(d.lines)
+	return d
+}
+
+// blocks returns the blocks in node that hold statements: the bodies of
+// functions and the arms of ifs.
+func blocks(node ast.Node) []*ast.BlockStatement {
+	var found []*ast.BlockStatement
+	var visit func(node ast.Node)
+	visit = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			visit(node.Value)
+		case *ast.ReturnStatement:
+			visit(node.ReturnValue)
+		case *ast.ExpressionStatement:
+			visit(node.Expression)
+		case *ast.PrefixExpression:
+			visit(node.Right)
+		case *ast.InfixExpression:
+			visit(node.Left)
+			visit(node.Right)
+		case *ast.IfExpression:
+			visit(node.Condition)
+			found = append(found, node.Consequence)
+			if node.Alternative != nil {
+				found = append(found, node.Alternative)
+			}
+		case *ast.FunctionLiteral:
+			found = append(found, node.Body)
+		case *ast.CallExpression:
+			visit(node.Function)
+			for _, arg := range node.Arguments {
+				visit(arg)
+			}
+		case *ast.IndexExpression:
+			visit(node.Left)
+			visit(node.Index)
+		}
+	}
+	visit(node)
+	return found
+}
+
+// setBreakpoints replaces the breakpoints. One on a line no statement
+// starts on moves to the next line one does.
+func (d *debugger) setBreakpoints(requested []SourceBreakpoint) []Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.breakpoints = make(map[int]*breakpoint)
+	result := make([]Breakpoint, 0, len(requested))
+	for _, req := range requested {
+		d.nextID++
+		bp := Breakpoint{ID: d.nextID, Line: req.Line}
+
+		i := sort.SearchInts(d.lines, req.Line)
+		if i == len(d.lines) {
+			bp.Message = "no statement on or after this line"
+			result = append(result, bp)
+			continue
+		}
+		bp.Line = d.lines[i]
+
+		var condition *ast.Program
+		if req.Condition != "" {
+			var err error
+			if condition, err = parse(req.Condition); err != nil {
+				bp.Message = "bad condition: " + err.Error()
+				result = append(result, bp)
+				continue
+			}
+		}
+
+		bp.Verified = true
+		d.breakpoints[bp.Line] = &breakpoint{id: bp.ID, line: bp.Line, condition: condition}
+		result = append(result, bp)
+	}
+	return result
+}
+
+// run runs the program in env until it ends, and returns its result. The
+// program stops before its first statement if entry is set.
+func (d *debugger) run(env *object.Environment, entry bool) (object.Object, error) {
+	d.mu.Lock()
+	d.entry = entry
+	d.mu.Unlock()
+
+	d.frames = []*frame{{name: "main", env: env}}
+	e := eval.New(eval.Config{})
+	e.SetHooks(eval.Hooks{Statement: d.statement, Call: d.call, Return: d.returned})
+	return e.Run(d.ctx, d.program, env)
+}
+
+func (d *debugger) call(fn *object.Function, env *object.Environment) {
+	name, ok := d.names[fn.Body]
+	if !ok {
+		name = "fn"
+	}
+	d.frames = append(d.frames, &frame{name: name, env: env})
+}
+
+func (d *debugger) returned(*object.Function, object.Object) {
+	d.frames = d.frames[:len(d.frames)-1]
+}
+
+// statement stops the program before stmt if it should. Only the first
+// statement on a line of a frame can stop it, so that a step goes to the
+// next line.
+func (d *debugger) statement(stmt ast.Statement, env *object.Environment) {
+	if d.ctx.Err() != nil {
+		return
+	}
+
+	pos := ast.Position(stmt)
+	f := d.frames[len(d.frames)-1]
+	f.env = env
+	newLine := pos.Line != f.line
+	f.line, f.column = pos.Line, pos.Column
+	if !newLine {
+		return
+	}
+
+	if reason, hit := d.shouldStop(pos.Line, env); reason != "" {
+		d.stop(reason, hit)
+	}
+}
+
+// shouldStop returns why the program should stop before a statement on
+// line, if it should, and the breakpoints it hit.
+func (d *debugger) shouldStop(line int, env *object.Environment) (string, []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	depth := len(d.frames)
+	switch {
+	case d.entry:
+		d.entry = false
+		return "entry", nil
+	case d.pause:
+		return "pause", nil
+	case d.mode == modeStepIn,
+		d.mode == modeStepOver && depth <= d.depth,
+		d.mode == modeStepOut && depth < d.depth:
+		return "step", nil
+	}
+
+	bp := d.breakpoints[line]
+	if bp == nil {
+		return "", nil
+	}
+	if bp.condition != nil {
+		value, err := evaluate(bp.condition, env)
+		if err != nil || !truthy(value) {
+			return "", nil
+		}
+	}
+	return "breakpoint", []int{bp.id}
+}
+
+// stop blocks the program until it is resumed or the debugger ends.
+func (d *debugger) stop(reason string, hit []int) {
+	d.mu.Lock()
+	d.paused, d.pause, d.mode = true, false, modeRun
+	d.refs = nil
+	d.mu.Unlock()
+
+	d.stopped(reason, hit)
+	select {
+	case <-d.resume:
+	case <-d.ctx.Done():
+	}
+}
+
+var errRunning = errors.New("the program is running")
+
+// continueWith resumes the stopped program in mode m.
+func (d *debugger) continueWith(m mode) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.paused {
+		return errRunning
+	}
+	d.paused, d.mode, d.depth = false, m, len(d.frames)
+	d.resume <- struct{}{}
+	return nil
+}
+
+// requestPause asks the running program to stop before its next
+// statement.
+func (d *debugger) requestPause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.paused {
+		d.pause = true
+	}
+}
+
+// end stops the program wherever it is and waits for it to finish.
+func (d *debugger) end() {
+	d.cancel()
+	<-d.done
+}
+
+// stoppedFrames returns the frames of the stopped program, innermost
+// first.
+func (d *debugger) stoppedFrames() ([]*frame, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.paused {
+		return nil, errRunning
+	}
+	frames := make([]*frame, len(d.frames))
+	for i, f := range d.frames {
+		frames[len(frames)-1-i] = f
+	}
+	return frames, nil
+}
+
+// frame returns the frame with id, which counts from 1 for the innermost.
+func (d *debugger) frame(id int) (*frame, error) {
+	frames, err := d.stoppedFrames()
+	if err != nil {
+		return nil, err
+	}
+	if id < 1 || id > len(frames) {
+		return nil, fmt.Errorf("no frame %d", id)
+	}
+	return frames[id-1], nil
+}
+
+// reference returns the variables reference of an environment or an
+// array.
+func (d *debugger) reference(target interface{}) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refs = append(d.refs, target)
+	return len(d.refs)
+}
+
+// scopes returns the scopes of a frame: the environment of the call, the
+// ones of the functions it is nested in, and the globals.
+func (d *debugger) scopes(f *frame) []Scope {
+	var scopes []Scope
+	for env := f.env; env != nil; env = env.Outer() {
+		name := "Closure"
+		switch {
+		case env.Outer() == nil:
+			name = "Globals"
+		case env == f.env:
+			name = "Locals"
+		}
+		scopes = append(scopes, Scope{Name: name, VariablesReference: d.reference(env)})
+	}
+	return scopes
+}
+
+// variables returns the variables of an environment, or the elements of an
+// array, that ref refers to.
+func (d *debugger) variables(ref int) ([]Variable, error) {
+	d.mu.Lock()
+	if !d.paused {
+		d.mu.Unlock()
+		return nil, errRunning
+	}
+	if ref < 1 || ref > len(d.refs) {
+		d.mu.Unlock()
+		return nil, fmt.Errorf("no variables reference %d", ref)
+	}
+	target := d.refs[ref-1]
+	d.mu.Unlock()
+
+	variables := []Variable{}
+	switch target := target.(type) {
+	case *object.Environment:
+		values := target.Variables()
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			variables = append(variables, d.variable(name, values[name]))
+		}
+	case *object.ArrayObject:
+		for i, element := range target.Elements {
+			variables = append(variables, d.variable(fmt.Sprintf("[%d]", i), element))
+		}
+	}
+	return variables, nil
+}
+
+func (d *debugger) variable(name string, value object.Object) Variable {
+	v := Variable{Name: name, Value: display(value), Type: strings.ToLower(string(value.Type()))}
+	if array, ok := value.(*object.ArrayObject); ok && len(array.Elements) != 0 {
+		v.VariablesReference = d.reference(array)
+	}
+	return v
+}
+
+// maxDisplay is the length values are cut to where they are shown.
+const maxDisplay = 100
+
+// display returns how a value is shown: a function by its parameters and
+// strings quoted, cut to maxDisplay.
+func display(value object.Object) string {
+	var s string
+	switch value := value.(type) {
+	case *object.Function:
+		params := make([]string, len(value.Parameters))
+		for i, p := range value.Parameters {
+			params[i] = p.Value
+		}
+		s = "fn(" + strings.Join(params, ", ") + ") { ... }"
+	case *object.StringObject:
+		s = ast.QuoteString(value.Value)
+	default:
+		s = value.Inspect()
+	}
+	if len(s) > maxDisplay {
+		s = s[:maxDisplay-3] + "..."
+	}
+	return s
+}
+
+// The limits an expression evaluated in a stopped program runs under, so
+// that a mistake in one does not hang the debugger.
+const (
+	evaluateSteps   = 1000000
+	evaluateTimeout = time.Second
+)
+
+// evaluate evaluates a parsed expression in env.
+func evaluate(program *ast.Program, env *object.Environment) (object.Object, error) {
+	e := eval.New(eval.Config{MaxSteps: evaluateSteps, Timeout: evaluateTimeout})
+	result, err := e.Run(context.Background(), program, env)
+	if err != nil {
+		return nil, err
+	}
+	if errObj, ok := result.(*object.ErrorObject); ok {
+		return nil, errors.New(errObj.Message)
+	}
+	if result == nil {
+		result = object.NULL
+	}
+	return result, nil
+}
+
+// parse parses src, reporting its first syntax error.
+func parse(src string) (*ast.Program, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		return nil, errors.New(errs[0])
+	}
+	return program, nil
+}
+
+// truthy reports whether a condition holds, the way if decides.
+func truthy(value object.Object) bool {
+	return value != object.NULL && value != object.FALSE
+}
@@ -0,0 +1,206 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// The parts of the Debug Adapter Protocol the server uses, see
+// https://microsoft.github.io/debug-adapter-protocol/specification.
+
+// request is a message from the client. Its Seq is echoed in the
+// response.
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// conn reads and writes messages with the Content-Length headers of the
+// base protocol. Responses and events may be written from several
+// goroutines.
+type conn struct {
+	in *textproto.Reader
+
+	mu  sync.Mutex
+	out io.Writer
+	seq int
+}
+
+func newConn(in io.Reader, out io.Writer) *conn {
+	return &conn{in: textproto.NewReader(bufio.NewReader(in)), out: out}
+}
+
+// read returns the next request, or io.EOF at the end of the input.
+func (c *conn) read() (*request, error) {
+	header, err := c.in.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("bad Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.in.R, body); err != nil {
+		return nil, err
+	}
+
+	req := &request{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, fmt.Errorf("bad message: %w", err)
+	}
+	return req, nil
+}
+
+// respond answers req with body, or with err if it failed.
+func (c *conn) respond(req *request, body interface{}, err error) error {
+	resp := &response{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+		resp.Body = nil
+	}
+	return c.write(func(seq int) interface{} {
+		resp.Seq = seq
+		return resp
+	})
+}
+
+// event sends the event name with body.
+func (c *conn) event(name string, body interface{}) error {
+	return c.write(func(seq int) interface{} {
+		return &event{Seq: seq, Type: "event", Event: name, Body: body}
+	})
+}
+
+// write writes the message msg makes with the next sequence number.
+func (c *conn) write(msg func(seq int) interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	body, err := json.Marshal(msg(c.seq))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+type Source struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type SourceBreakpoint struct {
+	Line      int    `json:"line"`
+	Condition string `json:"condition,omitempty"`
+}
+
+type Breakpoint struct {
+	ID       int    `json:"id"`
+	Verified bool   `json:"verified"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type Thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type StackFrame struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Source *Source `json:"source,omitempty"`
+	Line   int     `json:"line"`
+	Column int     `json:"column"`
+}
+
+type Scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type Variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type LaunchArguments struct {
+	Program     string   `json:"program"`
+	Args        []string `json:"args"`
+	StopOnEntry bool     `json:"stopOnEntry"`
+	NoDebug     bool     `json:"noDebug"`
+}
+
+type SetBreakpointsArguments struct {
+	Source      Source             `json:"source"`
+	Breakpoints []SourceBreakpoint `json:"breakpoints"`
+}
+
+type StackTraceArguments struct {
+	ThreadID   int `json:"threadId"`
+	StartFrame int `json:"startFrame"`
+	Levels     int `json:"levels"`
+}
+
+type ScopesArguments struct {
+	FrameID int `json:"frameId"`
+}
+
+type VariablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type EvaluateArguments struct {
+	Expression string `json:"expression"`
+	FrameID    int    `json:"frameId"`
+	Context    string `json:"context"`
+}
+
+type StoppedEventBody struct {
+	Reason            string `json:"reason"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+	HitBreakpointIDs  []int  `json:"hitBreakpointIds,omitempty"`
+}
+
+type OutputEventBody struct {
+	Category string `json:"category"`
+	Output   string `json:"output"`
+}
+
+type ExitedEventBody struct {
+	ExitCode int `json:"exitCode"`
+}
@@ -0,0 +1,360 @@
+// Package dap implements a debugger for cmm, which editors talk to with
+// the Debug Adapter Protocol over stdin and stdout.
+//
+// The server launches one program on the tree-walking evaluator, whose
+// hooks stop it at line breakpoints, which may have a condition, after a
+// step in, over or out of a statement, and when the editor asks it to
+// pause. While it is stopped the editor can list its stack frames, the
+// environments of each as scopes, and evaluate expressions in them.
+package dap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/types"
+)
+
+// threadID is the ID of the only thread a program has.
+const threadID = 1
+
+// argsName is the global that holds the arguments of the program.
+const argsName = "args"
+
+type server struct {
+	conn *conn
+
+	debugger *debugger
+	source   Source
+	launch   LaunchArguments
+	started  bool
+
+	// then is run once the response to the current request is sent
+	then func()
+}
+
+// handler handles a request and returns the body of its response.
+type handler func(s *server, args json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":              (*server).initialize,
+	"launch":                  (*server).launchRequest,
+	"setBreakpoints":          (*server).setBreakpoints,
+	"setExceptionBreakpoints": func(*server, json.RawMessage) (interface{}, error) { return nil, nil },
+	"configurationDone":       (*server).configurationDone,
+	"threads":                 (*server).threads,
+	"stackTrace":              (*server).stackTrace,
+	"scopes":                  (*server).scopes,
+	"variables":               (*server).variables,
+	"evaluate":                (*server).evaluate,
+	"continue":                resume(modeRun),
+	"next":                    resume(modeStepOver),
+	"stepIn":                  resume(modeStepIn),
+	"stepOut":                 resume(modeStepOut),
+	"pause":                   (*server).pause,
+	"terminate":               (*server).terminate,
+}
+
+// Serve runs a debug adapter that reads requests from in and writes
+// responses and events to out, until the client disconnects or in ends.
+func Serve(in io.Reader, out io.Writer) error {
+	s := &server{conn: newConn(in, out)}
+	defer s.end()
+
+	for {
+		req, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if req.Command == "disconnect" {
+			s.end()
+			return s.conn.respond(req, nil, nil)
+		}
+
+		var body interface{}
+		if h, ok := handlers[req.Command]; ok {
+			body, err = h(s, req.Arguments)
+		} else {
+			err = fmt.Errorf("unknown command %s", req.Command)
+		}
+		if err := s.conn.respond(req, body, err); err != nil {
+			return err
+		}
+		if s.then != nil {
+			s.then()
+			s.then = nil
+		}
+	}
+}
+
+// end stops the program, if it started, and waits for it.
+func (s *server) end() {
+	if s.started {
+		s.debugger.end()
+	}
+}
+
+var errNotLaunched = errors.New("no program has been launched")
+
+func (s *server) initialize(json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"supportsConfigurationDoneRequest": true,
+		"supportsConditionalBreakpoints":   true,
+		"supportsEvaluateForHovers":        true,
+		"supportsTerminateRequest":         true,
+	}, nil
+}
+
+// launchRequest loads the program, which starts once the client is done
+// setting breakpoints.
+func (s *server) launchRequest(args json.RawMessage) (interface{}, error) {
+	if s.debugger != nil {
+		return nil, errors.New("a program has already been launched")
+	}
+	if err := json.Unmarshal(args, &s.launch); err != nil {
+		return nil, err
+	}
+
+	src, err := os.ReadFile(s.launch.Program)
+	if err != nil {
+		return nil, err
+	}
+	program, err := load(s.launch.Program, string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := filepath.Abs(s.launch.Program)
+	if err != nil {
+		return nil, err
+	}
+	s.source = Source{Name: filepath.Base(path), Path: path}
+	s.debugger = newDebugger(program, s.stopped)
+	s.then = func() { s.conn.event("initialized", nil) }
+	return nil, nil
+}
+
+// load parses src and checks it the way running it does. The program it
+// returns is not resolved, so that its variables are kept by name, which
+// the scopes list and expressions typed in the debugger look up.
+func load(name, src string) (*ast.Program, error) {
+	program, err := parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if errs := types.Blocking(types.Check(program)); len(errs) != 0 {
+		return nil, fmt.Errorf("%s:%w", name, errs[0])
+	}
+	return program, nil
+}
+
+func (s *server) setBreakpoints(args json.RawMessage) (interface{}, error) {
+	if s.debugger == nil {
+		return nil, errNotLaunched
+	}
+	var a SetBreakpointsArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"breakpoints": s.debugger.setBreakpoints(a.Breakpoints)}, nil
+}
+
+// configurationDone starts the program.
+func (s *server) configurationDone(json.RawMessage) (interface{}, error) {
+	if s.debugger == nil {
+		return nil, errNotLaunched
+	}
+	if s.started {
+		return nil, nil
+	}
+
+	env := object.NewEnvironment()
+	argsArray := &object.ArrayObject{Elements: make([]object.Object, len(s.launch.Args))}
+	for i, arg := range s.launch.Args {
+		argsArray.Elements[i] = &object.StringObject{Value: arg}
+	}
+	env.Set(argsName, argsArray)
+
+	if s.launch.NoDebug {
+		s.debugger.setBreakpoints(nil)
+	}
+	s.started = true
+	s.then = func() {
+		go func() {
+			defer close(s.debugger.done)
+			result, err := s.debugger.run(env, s.launch.StopOnEntry && !s.launch.NoDebug)
+			s.exited(result, err)
+		}()
+	}
+	return nil, nil
+}
+
+// exited reports how the program ended.
+func (s *server) exited(result object.Object, err error) {
+	code := 0
+	switch {
+	case errors.Is(err, eval.ErrCanceled):
+		// the client ended it
+	case err != nil:
+		s.output("stderr", err.Error()+"\n")
+		code = 1
+	case result != nil && result.Type() == object.ERROR_OBJ:
+		s.output("stderr", "runtime error: "+result.(*object.ErrorObject).Message+"\n")
+		code = 1
+	case result != nil && result != object.NULL:
+		s.output("stdout", result.Inspect()+"\n")
+	}
+	s.conn.event("exited", ExitedEventBody{ExitCode: code})
+	s.conn.event("terminated", nil)
+}
+
+func (s *server) output(category, text string) {
+	s.conn.event("output", OutputEventBody{Category: category, Output: text})
+}
+
+// stopped tells the client that the program stopped.
+func (s *server) stopped(reason string, hit []int) {
+	s.conn.event("stopped", StoppedEventBody{
+		Reason:            reason,
+		ThreadID:          threadID,
+		AllThreadsStopped: true,
+		HitBreakpointIDs:  hit,
+	})
+}
+
+func (s *server) threads(json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{"threads": []Thread{{ID: threadID, Name: "main"}}}, nil
+}
+
+func (s *server) stackTrace(args json.RawMessage) (interface{}, error) {
+	if !s.started {
+		return nil, errNotLaunched
+	}
+	var a StackTraceArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	frames, err := s.debugger.stoppedFrames()
+	if err != nil {
+		return nil, err
+	}
+
+	stackFrames := []StackFrame{}
+	for i, f := range frames {
+		if i < a.StartFrame || a.Levels > 0 && i >= a.StartFrame+a.Levels {
+			continue
+		}
+		stackFrames = append(stackFrames, StackFrame{
+			ID:     i + 1,
+			Name:   f.name,
+			Source: &s.source,
+			Line:   f.line,
+			Column: f.column,
+		})
+	}
+	return map[string]interface{}{"stackFrames": stackFrames, "totalFrames": len(frames)}, nil
+}
+
+func (s *server) scopes(args json.RawMessage) (interface{}, error) {
+	if !s.started {
+		return nil, errNotLaunched
+	}
+	var a ScopesArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	f, err := s.debugger.frame(a.FrameID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"scopes": s.debugger.scopes(f)}, nil
+}
+
+func (s *server) variables(args json.RawMessage) (interface{}, error) {
+	if !s.started {
+		return nil, errNotLaunched
+	}
+	var a VariablesArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	variables, err := s.debugger.variables(a.VariablesReference)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+// evaluate evaluates an expression in a frame of the stopped program, the
+// innermost if none is given. It may change the variables of the frame.
+func (s *server) evaluate(args json.RawMessage) (interface{}, error) {
+	if !s.started {
+		return nil, errNotLaunched
+	}
+	var a EvaluateArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if a.FrameID == 0 {
+		a.FrameID = 1
+	}
+	f, err := s.debugger.frame(a.FrameID)
+	if err != nil {
+		return nil, err
+	}
+
+	program, err := parse(a.Expression)
+	if err != nil {
+		return nil, err
+	}
+	value, err := evaluate(program, f.env)
+	if err != nil {
+		return nil, err
+	}
+	v := s.debugger.variable("", value)
+	return map[string]interface{}{"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}, nil
+}
+
+// resume returns the handler of a request that resumes the stopped
+// program in mode m. It resumes once the response is sent, so that the
+// client gets that before the program stops again.
+func resume(m mode) handler {
+	return func(s *server, _ json.RawMessage) (interface{}, error) {
+		if !s.started {
+			return nil, errNotLaunched
+		}
+		if _, err := s.debugger.stoppedFrames(); err != nil {
+			return nil, err
+		}
+		s.then = func() { s.debugger.continueWith(m) }
+		return map[string]interface{}{"allThreadsContinued": true}, nil
+	}
+}
+
+func (s *server) pause(json.RawMessage) (interface{}, error) {
+	if !s.started {
+		return nil, errNotLaunched
+	}
+	s.debugger.requestPause()
+	return nil, nil
+}
+
+// terminate ends the program.
+func (s *server) terminate(json.RawMessage) (interface{}, error) {
+	if !s.started {
+		return nil, errNotLaunched
+	}
+	s.then = s.debugger.end
+	return nil, nil
+}
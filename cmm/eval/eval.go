@@ -1,100 +1,310 @@
 package eval
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/shoebilyas123/cminusminus/cmm/ast"
 	"github.com/shoebilyas123/cminusminus/cmm/object"
 )
 
 var (
-	NULL  = &object.NullObject{}
-	TRUE  = &object.BooleanObject{Value: true}
-	FALSE = &object.BooleanObject{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
+// Eval evaluates node with an Evaluator that has no limits.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return New(Config{}).Eval(node, env)
+}
+
+// Evaluator holds the state of one evaluation: the limits it runs under and
+// how far it has got. An Evaluator is not safe for concurrent use.
+type Evaluator struct {
+	config Config
+
+	ctx   context.Context
+	start time.Time
+	steps int64
+	depth int
+	line  int
+
+	// allocated is the estimated memory in use, see memory.go, and
+	// measured what it was found to be when it was last measured, which
+	// measurements counts. frames holds the environment of the program and
+	// of every call in progress.
+	allocated    int64
+	measured     int64
+	measurements int
+	frames       []*object.Environment
+
+	// halted is set once a limit has been hit. From then on every step
+	// fails with the same error so that evaluation unwinds.
+	halted *HaltError
+
+	hooks Hooks
+}
+
+func New(config Config) *Evaluator {
+	return &Evaluator{config: config, ctx: context.Background(), start: time.Now()}
+}
+
+func (e *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
+	if halted := e.step(node); halted != nil {
+		return halted
+	}
+	if e.hooks.Statement != nil {
+		e.statement(node, env)
+	}
+
+	return e.eval(node, env)
+}
+
+func (e *Evaluator) eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
-		return evalProgram(node, env)
+		return e.evalProgram(node, env)
 	case *ast.FunctionLiteral:
-		return &object.Function{Body: node.Body, Env: env, Parameters: node.Parameters}
+		return e.track(&object.Function{
+			Body:       node.Body,
+			Env:        env,
+			Parameters: node.Parameters,
+			NumLocals:  node.NumLocals,
+		})
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
+		function := e.Eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
 
-		args := evalExpressions(node.Arguments, env)
+		args := e.evalExpressions(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		return e.applyFunction(function, args)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.LetStatement:
-		rvalue := evalLetStatement(node, env)
+		rvalue := e.evalLetStatement(node, env)
 
 		if isError(rvalue) {
 			return rvalue
 		}
 
+		if binding := node.Name.Binding; binding != nil {
+			return env.SetAt(binding.Slot, rvalue)
+		}
+
+		if halted := e.alloc(object.BindingSize(node.Name.Value)); halted != nil {
+			return halted
+		}
+
 		env.Set(node.Name.Value, rvalue)
 		return rvalue
 	case *ast.IntegerLiteral:
-		return &object.IntegerObject{Value: node.Value}
+		return e.track(object.NewInteger(node.Value))
+	case *ast.StringLiteral:
+		return e.track(&object.StringObject{Value: node.Value})
 	case *ast.BooleanExpression:
 		return getBooleanObject(node.Value)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return e.Eval(node.Expression, env)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := e.Eval(node.Right, env)
 
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return e.track(object.Prefix(node.Op, right))
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
+		left := e.Eval(node.Left, env)
 
 		if isError(left) {
 			return left
 		}
 
-		right := Eval(node.Right, env)
+		right := e.Eval(node.Right, env)
 
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, right, left)
+		return e.track(object.Infix(node.Op, left, right))
+	case *ast.IndexExpression:
+		left := e.Eval(node.Left, env)
+
+		if isError(left) {
+			return left
+		}
+
+		index := e.Eval(node.Index, env)
+
+		if isError(index) {
+			return index
+		}
+		return object.Index(left, index)
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return e.evalBlockStatement(node, env)
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return e.evalIfExpression(node, env)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := e.Eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
-		return &object.ReturnObject{Value: val}
+		return e.track(&object.ReturnObject{Value: val})
 	}
 
 	return nil
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
-	function, ok := fn.(*object.Function)
+// applyFunction runs fn as a trampoline: whenever the body ends in a call
+// in tail position, evalTail hands that call back as a tailCall and it is
+// run here instead of one Go frame deeper. Tail recursion therefore runs in
+// constant stack.
+func (e *Evaluator) applyFunction(fn object.Object, args []object.Object) object.Object {
+	for {
+		if builtin, ok := fn.(*object.Builtin); ok {
+			return e.track(builtin.Call(e.call, args...))
+		}
+
+		function, ok := fn.(*object.Function)
+
+		if !ok {
+			return newError("not a function: %s", fn.Type())
+		}
+
+		if len(args) != len(function.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d",
+				len(function.Parameters), len(args))
+		}
+
+		if halted := e.enter(); halted != nil {
+			return halted
+		}
+
+		// The new environment is pushed before it is charged for, so that
+		// the arguments count as live if memory has to be measured.
+		extendedEnv := extendFuncEnv(function, args)
+		e.frames = append(e.frames, extendedEnv)
+
+		if e.hooks.Call != nil {
+			e.hooks.Call(function, extendedEnv)
+		}
+
+		var evaluated object.Object
+		if halted := e.alloc(extendedEnv.Size()); halted != nil {
+			evaluated = halted
+		} else {
+			evaluated = unwrapReturnValue(e.evalTail(function.Body, extendedEnv, true))
+		}
+
+		if e.hooks.Return != nil {
+			e.returned(function, evaluated)
+		}
+
+		e.frames = e.frames[:len(e.frames)-1]
+		e.depth--
+
+		call, ok := evaluated.(*tailCall)
+		if !ok {
+			return evaluated
+		}
+
+		fn, args = call.function, call.args
+	}
+}
+
+// call calls fn for a builtin.
+func (e *Evaluator) call(fn object.Object, args ...object.Object) object.Object {
+	return e.applyFunction(fn, args)
+}
+
+// tailCall is a call that has been evaluated up to, but not including,
+// applying the function. It only ever travels from evalTail back to the
+// applyFunction loop and is never visible to programs.
+type tailCall struct {
+	function object.Object
+	args     []object.Object
+}
 
-	if !ok {
-		return newError("not a function: %s", function.Type())
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// evalTail evaluates the statements of a function body. It differs from Eval
+// only for the nodes a tail position can pass through: the last statement of
+// a block, both arms of an if, and the value of a return statement. A call
+// found in tail position is returned as a tailCall rather than applied.
+//
+// Returns are in tail position wherever they appear in the body, so
+// evalTail is also used for statements that are not last with tail=false.
+func (e *Evaluator) evalTail(node ast.Node, env *object.Environment, tail bool) object.Object {
+	if halted := e.step(node); halted != nil {
+		return halted
+	}
+	if e.hooks.Statement != nil {
+		e.statement(node, env)
 	}
 
-	extendedEnv := extendFuncEnv(function, args)
-	evaluated := Eval(function.Body, extendedEnv)
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		var result object.Object
+		for i, statement := range node.Statements {
+			result = e.evalTail(statement, env, tail && i == len(node.Statements)-1)
+			if result != nil {
+				rt := result.Type()
+				if rt == object.RETURN_OBJ || rt == object.ERROR_OBJ {
+					return result
+				}
+			}
+		}
+		return result
+	case *ast.ExpressionStatement:
+		return e.evalTail(node.Expression, env, tail)
+	case *ast.IfExpression:
+		condition := e.Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		truthy := isTruthy(condition)
+		if e.hooks.Branch != nil {
+			e.hooks.Branch(node, truthy)
+		}
+
+		if truthy {
+			return e.evalTail(node.Consequence, env, tail)
+		} else if node.Alternative != nil {
+			return e.evalTail(node.Alternative, env, tail)
+		}
+		return NULL
+	case *ast.ReturnStatement:
+		val := e.evalTail(node.ReturnValue, env, true)
+		if isError(val) {
+			return val
+		}
+		return e.track(&object.ReturnObject{Value: val})
+	case *ast.CallExpression:
+		if !tail {
+			break
+		}
+
+		function := e.Eval(node.Function, env)
+		if isError(function) {
+			return function
+		}
+
+		args := e.evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
 
-	return unwrapReturnValue(evaluated)
+		return &tailCall{function: function, args: args}
+	}
 
+	return e.eval(node, env)
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -105,6 +315,13 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 func extendFuncEnv(fn *object.Function, args []object.Object) *object.Environment {
+	if fn.NumLocals > 0 {
+		newEnv := object.NewSlotEnvironment(fn.Env, fn.NumLocals)
+		for paramIndex, param := range fn.Parameters {
+			newEnv.SetAt(param.Binding.Slot, args[paramIndex])
+		}
+		return newEnv
+	}
 
 	newEnv := object.NewClosure(fn.Env)
 
@@ -115,11 +332,11 @@ func extendFuncEnv(fn *object.Function, args []object.Object) *object.Environmen
 	return newEnv
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (e *Evaluator) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 
 	for _, exp := range exps {
-		evalexp := Eval(exp, env)
+		evalexp := e.Eval(exp, env)
 
 		if isError(evalexp) {
 			return []object.Object{evalexp}
@@ -131,27 +348,42 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func evalLetStatement(node *ast.LetStatement, env *object.Environment) object.Object {
-	expVal := Eval(node.Value, env)
+func (e *Evaluator) evalLetStatement(node *ast.LetStatement, env *object.Environment) object.Object {
+	expVal := e.Eval(node.Value, env)
 
 	return expVal
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	// A variable read before its let has run is looked up by name, which
+	// finds the global of that name if there is one.
+	if binding := node.Binding; binding != nil {
+		if value := env.GetAt(binding.Depth, binding.Slot); value != nil {
+			return value
+		}
+	}
+
 	varVal, ok := env.Get(node.Value)
 
-	if !ok {
-		return newError("NOT FOUND: undefined identifier - %s", node.Value)
+	if ok {
+		return varVal
+	}
+
+	if builtin := object.GetBuiltinByName(node.Value); builtin != nil {
+		return builtin
 	}
 
-	return varVal
+	return newError("NOT FOUND: undefined identifier - %s", node.Value)
 }
 
-func evalProgram(node *ast.Program, env *object.Environment) object.Object {
+func (e *Evaluator) evalProgram(node *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
+	e.frames = append(e.frames, env)
+	defer func() { e.frames = e.frames[:len(e.frames)-1] }()
+
 	for _, statement := range node.Statements {
-		result = Eval(statement, env)
+		result = e.Eval(statement, env)
 		switch result := result.(type) {
 		case *object.ReturnObject:
 			return result.Value
@@ -163,10 +395,10 @@ func evalProgram(node *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (e *Evaluator) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
+		result = e.Eval(statement, env)
 		if result != nil {
 			rt := result.Type()
 			if rt == object.RETURN_OBJ || rt == object.ERROR_OBJ {
@@ -177,17 +409,22 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
-func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(node.Condition, env)
+func (e *Evaluator) evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
+	condition := e.Eval(node.Condition, env)
 
 	switch condition.(type) {
 	case *object.ErrorObject:
 		return condition
 	default:
-		if isTruthy(condition) {
-			return Eval(node.Consequence, env)
+		truthy := isTruthy(condition)
+		if e.hooks.Branch != nil {
+			e.hooks.Branch(node, truthy)
+		}
+
+		if truthy {
+			return e.Eval(node.Consequence, env)
 		} else if node.Alternative != nil {
-			return Eval(node.Alternative, env)
+			return e.Eval(node.Alternative, env)
 		}
 	}
 
@@ -207,34 +444,6 @@ func isTruthy(condition object.Object) bool {
 	}
 }
 
-func CanArithmeticAddVariables(t1 object.Object, t2 object.Object) bool {
-	if (t1.Type() == object.INTEGER_OBJ && t2.Type() == object.INTEGER_OBJ) ||
-		(t1.Type() == object.INTEGER_OBJ && t2.Type() == object.RETURN_OBJ && t2.(*object.ReturnObject).Value.Type() == object.INTEGER_OBJ) ||
-		(t2.Type() == object.INTEGER_OBJ && t1.Type() == object.RETURN_OBJ && t1.(*object.ReturnObject).Value.Type() == object.INTEGER_OBJ) {
-		return true
-	}
-
-	return false
-}
-
-func evalInfixExpression(op string, right, left object.Object) object.Object {
-	switch {
-	case CanArithmeticAddVariables(right, left):
-		return evalIntegerInfixExpression(op, right, left)
-	case op == "==":
-		return getBooleanObject(left == right)
-	case op == "!=":
-		return getBooleanObject(left != right)
-	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s",
-			left.Type(), op, right.Type())
-	default:
-		return newError("unknown operator: %s %s %s",
-			left.Type(), op, right.Type())
-
-	}
-}
-
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
@@ -242,85 +451,6 @@ func isError(obj object.Object) bool {
 	return false
 }
 
-func evalIntegerInfixExpression(op string, right, left object.Object) object.Object {
-	// 1.0: Assuming that the right and left expressions are integers;
-	var le_val int64
-	var re_val int64
-
-	if left.Type() == object.RETURN_OBJ {
-		le_val = left.(*object.ReturnObject).Value.(*object.IntegerObject).Value
-	} else {
-		le_val = left.(*object.IntegerObject).Value
-	}
-
-	if right.Type() == object.RETURN_OBJ {
-		re_val = right.(*object.ReturnObject).Value.(*object.IntegerObject).Value
-	} else {
-		re_val = right.(*object.IntegerObject).Value
-	}
-
-	switch op {
-	case "+":
-		return &object.IntegerObject{Value: le_val + re_val}
-	case "-":
-		return &object.IntegerObject{Value: le_val - re_val}
-	case "/":
-		return &object.IntegerObject{Value: le_val / re_val}
-	case "*":
-		return &object.IntegerObject{Value: le_val * re_val}
-	case "<":
-		return getBooleanObject(le_val < re_val)
-	case ">":
-		return getBooleanObject(le_val > re_val)
-	case "==":
-		return getBooleanObject(left == right)
-	case "!=":
-		return getBooleanObject(left != right)
-	default:
-		return newError("unknown operator: %s %s %s",
-			left.Type(), op, right.Type())
-	}
-
-}
-
-func evalPrefixExpression(op string, right object.Object) object.Object {
-	switch op {
-	case "!":
-		return evalBangOperatorExpression(right)
-	case "-":
-		return evalMinusOperatorExpression(right)
-	default:
-		return newError("unknown operator: %s%s", op, right.Type())
-	}
-}
-
-func evalMinusOperatorExpression(right object.Object) object.Object {
-	num, ok := right.(*object.IntegerObject)
-
-	if !ok {
-		return NULL
-	}
-
-	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
-	}
-
-	return &object.IntegerObject{Value: -num.Value}
-}
-
-func evalBangOperatorExpression(right object.Object) object.Object {
-	switch right {
-	case TRUE:
-		return FALSE
-	case FALSE:
-		return TRUE
-	case NULL:
-		return TRUE
-	default:
-		return FALSE
-	}
-}
-
 func getBooleanObject(i bool) *object.BooleanObject {
 	if i {
 		return TRUE
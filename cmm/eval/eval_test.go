@@ -1,8 +1,15 @@
 package eval
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
 	"testing"
+	"time"
 
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
 	"github.com/shoebilyas123/cminusminus/cmm/lexer"
 	"github.com/shoebilyas123/cminusminus/cmm/object"
 	"github.com/shoebilyas123/cminusminus/cmm/parser"
@@ -188,7 +195,7 @@ func TestErrorHandling(t *testing.T) {
 			"unknown operator: -BOOLEAN",
 		},
 		{
-			"ttrue + false;",
+			"true + false;",
 			"unknown operator: BOOLEAN + BOOLEAN",
 		},
 		{
@@ -228,3 +235,355 @@ let addTwo = newAdder(2);
 addTwo(2);`
 	testIntegerObject(t, testEval(input), 4)
 }
+
+func TestTailCalls(t *testing.T) {
+	// Without tail calls every iteration costs several Go frames, so a
+	// small stack cap turns a regression into a crash instead of a slow pass.
+	defer debug.SetMaxStack(debug.SetMaxStack(8 << 20))
+
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`
+let loop = fn(n, acc) {
+if (n == 0) { return acc; }
+loop(n - 1, acc + 1)
+};
+loop(1000000, 0);`, 1000000},
+		{`
+let count = fn(n, acc) {
+if (n == 0) { acc } else { return count(n - 1, acc + 2); }
+};
+count(100000, 0);`, 200000},
+		{`
+let isEven = fn(n) { if (n == 0) { 1 } else { isOdd(n - 1) } };
+let isOdd = fn(n) { if (n == 0) { 0 } else { isEven(n - 1) } };
+isEven(100001);`, 0},
+		{`
+let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } };
+fact(10);`, 3628800},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	input := `let double = fn(n) { n * 2 };
+let x = double(3);
+let last = fn(n) { double(n) };
+last(x)`
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	var events []string
+	depth := 0
+	e := New(Config{})
+	e.SetHooks(Hooks{
+		Statement: func(stmt ast.Statement, env *object.Environment) {
+			events = append(events, fmt.Sprintf("%d: line %d", depth, ast.Position(stmt).Line))
+		},
+		Call: func(fn *object.Function, env *object.Environment) {
+			depth++
+			n, _ := env.Get("n")
+			events = append(events, fmt.Sprintf("call n=%s", n.Inspect()))
+		},
+		Return: func(fn *object.Function, result object.Object) {
+			depth--
+			if result == nil {
+				events = append(events, "tail call")
+			} else {
+				events = append(events, "return "+result.Inspect())
+			}
+		},
+	})
+	testIntegerObject(t, e.Eval(program, object.NewEnvironment()), 12)
+
+	want := []string{
+		"0: line 1", "0: line 2", "call n=3", "1: line 1", "return 6",
+		"0: line 3", "0: line 4", "call n=6", "1: line 3", "tail call", "call n=6", "1: line 1", "return 12",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("hooks called\n%q\nwant\n%q", events, want)
+	}
+}
+
+func TestBranchHook(t *testing.T) {
+	input := `let sign = fn(n) { if (n < 0) { -1 } else { if (n > 0) { 1 } } };
+let x = if (sign(-5) < 0) { 1 };
+sign(0); sign(2)`
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	var branches []string
+	e := New(Config{})
+	e.SetHooks(Hooks{Branch: func(node *ast.IfExpression, consequence bool) {
+		pos := ast.Position(node)
+		branches = append(branches, fmt.Sprintf("%d:%d %t", pos.Line, pos.Column, consequence))
+	}})
+	e.Eval(program, object.NewEnvironment())
+
+	want := []string{"1:20 true", "2:9 true", "1:20 false", "1:45 false", "1:20 false", "1:45 true"}
+	if !reflect.DeepEqual(branches, want) {
+		t.Errorf("branches\n%q\nwant\n%q", branches, want)
+	}
+}
+
+func testRun(config Config, ctx context.Context, input string) (object.Object, error) {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	env := object.NewEnvironment()
+	return New(config).Run(ctx, program, env)
+}
+
+func TestLimits(t *testing.T) {
+	forever := `
+let spin = fn(n) { spin(n + 1) };
+spin(0);`
+	deep := `
+let dive = fn(n) { 1 + dive(n + 1) };
+dive(0);`
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		config   Config
+		ctx      context.Context
+		input    string
+		expected error
+	}{
+		{Config{MaxSteps: 10000}, context.Background(), forever, ErrBudgetExceeded},
+		{Config{MaxDepth: 100}, context.Background(), deep, ErrBudgetExceeded},
+		{Config{Timeout: 20 * time.Millisecond}, context.Background(), forever, ErrTimeout},
+		{Config{}, canceled, forever, ErrCanceled},
+	}
+
+	for _, tt := range tests {
+		result, err := testRun(tt.config, tt.ctx, tt.input)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("wrong error. expected=%v, got=%v (result %v)", tt.expected, err, result)
+			continue
+		}
+
+		var halt *HaltError
+		if !errors.As(err, &halt) {
+			t.Errorf("error is not a *HaltError. got=%T", err)
+			continue
+		}
+		if halt.Steps == 0 || halt.Line != 2 {
+			t.Errorf("halt does not report progress. got=%+v", halt)
+		}
+	}
+}
+
+func TestLimitsAllowFinishedPrograms(t *testing.T) {
+	result, err := testRun(Config{MaxSteps: 1000, MaxDepth: 10}, context.Background(), `
+let add = fn(a, b) { a + b };
+add(add(1, 2), 3);`)
+	if err != nil {
+		t.Fatalf("unexpected halt: %v", err)
+	}
+	testIntegerObject(t, result, 6)
+}
+
+func TestDefaultDepthLimit(t *testing.T) {
+	deep := "let dive = fn(n) { 1 + dive(n + 1) }; dive(0);"
+
+	result, err := testRun(Config{}, context.Background(), deep)
+	if err != nil {
+		t.Fatalf("unexpected halt: %v", err)
+	}
+	for _, result := range []object.Object{result, testEval(deep)} {
+		errObj, ok := result.(*object.ErrorObject)
+		if !ok || errObj.Message != "stack overflow" {
+			t.Errorf("expected a stack overflow. got=%v", result)
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	// Every closure keeps the previous one alive, so live memory grows
+	// with each iteration.
+	hoard := `
+let grow = fn(f, n) { grow(fn() { f() + n }, n + 1) };
+grow(fn() { 0 }, 0);`
+
+	_, err := testRun(Config{MaxMemory: 64 << 10}, context.Background(), hoard)
+	if !errors.Is(err, ErrMemoryLimit) {
+		t.Fatalf("wrong error. expected=%v, got=%v", ErrMemoryLimit, err)
+	}
+
+	var halt *HaltError
+	if !errors.As(err, &halt) || halt.Memory <= 64<<10 {
+		t.Errorf("halt does not report memory in use. got=%+v", halt)
+	}
+}
+
+func TestMemoryLimitCountsOnlyLiveValues(t *testing.T) {
+	// Each iteration allocates an environment and some integers, which
+	// adds up to far more than the limit but is garbage straight away.
+	churn := `
+let sum = fn(n, acc) { if (n == 0) { return acc; } sum(n - 1, acc + n) };
+sum(10000, 0);`
+
+	result, err := testRun(Config{MaxMemory: 16 << 10}, context.Background(), churn)
+	if err != nil {
+		t.Fatalf("unexpected halt: %v", err)
+	}
+	testIntegerObject(t, result, 50005000)
+}
+
+func TestMemoryLimitMeasuresRarely(t *testing.T) {
+	build := `
+let build = fn(f, n) { if (n == 0) { return f; } build(fn() { f() + n }, n - 1) };
+let kept = build(fn() { 0 }, 5000);`
+	churn := `
+let sum = fn(n, acc) { if (n == 0) { return acc; } sum(n - 1, acc + n) };
+sum(10000, 0);`
+
+	env := object.NewEnvironment()
+	Eval(parser.New(lexer.New(build)).ParseProgram(), env)
+	live := object.Reachable(env)
+
+	// What stays live is just under the limit, so that most allocations
+	// cross it.
+	e := New(Config{MaxMemory: live + 1<<10})
+	env = object.NewEnvironment()
+	result, err := e.Run(context.Background(), parser.New(lexer.New(build+churn)).ParseProgram(), env)
+	if err != nil {
+		t.Fatalf("unexpected halt: %v", err)
+	}
+	testIntegerObject(t, result, 50005000)
+
+	if e.measurements > 200 {
+		t.Errorf("live memory measured %d times", e.measurements)
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"Hello" + " " + "World!"`, "Hello World!"},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`len("four")`, int64(4)},
+		{`let len = fn(s) { 0 }; len("four")`, int64(0)},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case string:
+			str, ok := evaluated.(*object.StringObject)
+			if !ok || str.Value != expected {
+				t.Errorf("wrong string for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+			}
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case int64:
+			testIntegerObject(t, evaluated, expected)
+		}
+	}
+
+	errObj, ok := testEval(`"a" - "b"`).(*object.ErrorObject)
+	if !ok || errObj.Message != "unknown operator: STRING - STRING" {
+		t.Errorf("wrong error for string subtraction. got=%+v", errObj)
+	}
+}
+
+func TestIndexExpressions(t *testing.T) {
+	args := &object.ArrayObject{Elements: []object.Object{
+		&object.StringObject{Value: "a"},
+		object.NewInteger(2),
+	}}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"args[0]", "a"},
+		{"args[2 - 1] + 1", "3"},
+		{"len(args)", "2"},
+		{"args", `["a", 2]`},
+		{"args[2]", "ERROR: index out of range: 2 with length 2"},
+		{"args[-1]", "ERROR: index out of range: -1 with length 2"},
+		{`args["0"]`, "ERROR: array index must be INTEGER, got STRING"},
+		{"1[0]", "ERROR: index operator not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.Set("args", args)
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"assert(1 < 2)", "null"},
+		{"assert(1 > 2)", "ERROR: assertion failed"},
+		{"assert(1)", "ERROR: argument to `assert` must be BOOLEAN, got INTEGER"},
+		{`assert_eq(len("ab"), 2)`, "null"},
+		{`assert_eq("a", "b")`, `ERROR: assert_eq failed: got "a", want "b"`},
+		{"assert_eq(1, true)", "ERROR: assert_eq failed: got 1, want true"},
+		{"assert_error(fn() { 1 / 0 })", "division by zero"},
+		{"assert_error(fn() { 1 })", "ERROR: assert_error failed: the function returned 1 instead of failing"},
+		{"assert_error(1)", "ERROR: argument to `assert_error` must be FUNCTION, got INTEGER"},
+		{"let f = fn(x) { 10 / x }; assert_error(fn() { f(0) }); f(5)", "2"},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkIntegerLoop runs the same loop over small integers, which come
+// from object's cache, and over large ones, which are allocated. Comparing
+// allocs/op shows what the cache saves.
+func BenchmarkIntegerLoop(b *testing.B) {
+	benchmarks := []struct {
+		name  string
+		input string
+	}{
+		{"cached", `
+let loop = fn(n, acc) { if (n == 0) { return acc; } loop(n - 1, acc + 1) };
+loop(1000, 0);`},
+		{"uncached", `
+let loop = fn(n, acc) { if (n == 1000000) { return acc; } loop(n - 1, acc + 1) };
+loop(1001000, 1000000);`},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			program := parser.New(lexer.New(bm.input)).ParseProgram()
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				Eval(program, object.NewEnvironment())
+			}
+		})
+	}
+}
+
+func BenchmarkArithmetic(b *testing.B) {
+	program := parser.New(lexer.New("(5 + 10 * 2 + 15 / 3) * 2 + -10")).ParseProgram()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		Eval(program, object.NewEnvironment())
+	}
+}
@@ -0,0 +1,50 @@
+package eval
+
+import (
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// Hooks let a debugger or a coverage profile follow an evaluation. They
+// are called on the goroutine that evaluates, which waits for them to
+// return, so a hook can pause the program by blocking. Any of them may be
+// nil.
+type Hooks struct {
+	// Statement is called before each let, return and expression
+	// statement is evaluated, with the environment it is evaluated in.
+	Statement func(stmt ast.Statement, env *object.Environment)
+
+	// Call is called when a call to fn starts, with the environment that
+	// holds its arguments, and Return when it ends, with its result. A
+	// call in tail position ends the call it replaces first, whose result
+	// is then nil. Calls to builtins are not reported.
+	Call   func(fn *object.Function, env *object.Environment)
+	Return func(fn *object.Function, result object.Object)
+
+	// Branch is called when the condition of an if expression has been
+	// evaluated, with whether it held. It is called when it did not even
+	// if the if has no else.
+	Branch func(node *ast.IfExpression, consequence bool)
+}
+
+// SetHooks sets the hooks called as e evaluates.
+func (e *Evaluator) SetHooks(hooks Hooks) {
+	e.hooks = hooks
+}
+
+// statement calls the Statement hook if node is a statement it reports.
+func (e *Evaluator) statement(node ast.Node, env *object.Environment) {
+	switch node.(type) {
+	case *ast.LetStatement, *ast.ReturnStatement, *ast.ExpressionStatement:
+		e.hooks.Statement(node.(ast.Statement), env)
+	}
+}
+
+// returned calls the Return hook for a call to fn that evaluated to
+// result.
+func (e *Evaluator) returned(fn *object.Function, result object.Object) {
+	if _, ok := result.(*tailCall); ok {
+		result = nil
+	}
+	e.hooks.Return(fn, result)
+}
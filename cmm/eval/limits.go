@@ -0,0 +1,174 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// Config bounds how much work an Evaluator may do. The zero value of each
+// field means no limit, except for MaxDepth.
+type Config struct {
+	// MaxSteps is the number of AST nodes that may be evaluated.
+	MaxSteps int64
+	// MaxDepth is the number of nested function calls. Calls in tail
+	// position do not nest. If it is zero, calls nest up to
+	// DefaultMaxDepth, past which the program fails with a stack
+	// overflow, as it does on the vm.
+	MaxDepth int
+	// Timeout is the wall-clock time a single Run may take.
+	Timeout time.Duration
+	// MaxMemory is the estimated number of bytes the values and
+	// environments live in the Evaluator may take up. See object.SizeOf.
+	MaxMemory int64
+}
+
+// DefaultMaxDepth is how deeply calls nest when Config.MaxDepth is zero.
+// It is the number of frames of the vm, and keeps deep recursion well
+// short of overflowing the Go stack, which cannot be recovered from.
+const DefaultMaxDepth = 1 << 14
+
+var (
+	ErrTimeout        = errors.New("timeout")
+	ErrCanceled       = errors.New("canceled")
+	ErrBudgetExceeded = errors.New("budget exceeded")
+	ErrMemoryLimit    = errors.New("memory limit")
+)
+
+// HaltError reports that evaluation was stopped before it finished, and
+// how far it had got. It wraps one of ErrTimeout, ErrCanceled,
+// ErrBudgetExceeded or ErrMemoryLimit.
+type HaltError struct {
+	Err     error
+	Reason  string
+	Steps   int64
+	Depth   int
+	Line    int
+	Memory  int64
+	Elapsed time.Duration
+}
+
+func (he *HaltError) Error() string {
+	return fmt.Sprintf("%s: %s after %d steps (line %d, call depth %d, %s)",
+		he.Err, he.Reason, he.Steps, he.Line, he.Depth, he.Elapsed.Round(time.Microsecond))
+}
+
+func (he *HaltError) Unwrap() error { return he.Err }
+
+// ctxCheckInterval is how many steps pass between looks at the context.
+const ctxCheckInterval = 1024
+
+// Run evaluates node under ctx and the Evaluator's Config. Every Run gets
+// a fresh budget. Runtime errors of the program are returned as
+// *object.ErrorObject like Eval does; the error is only non-nil if
+// evaluation was halted, in which case it is a *HaltError.
+func (e *Evaluator) Run(ctx context.Context, node ast.Node, env *object.Environment) (object.Object, error) {
+	return e.run(ctx, func() object.Object { return e.Eval(node, env) })
+}
+
+// Call applies fn to args under ctx and the Evaluator's Config, the same
+// way Run evaluates a node. env is the environment fn is called from, such
+// as the globals of the program, whose values count as live toward
+// MaxMemory while fn runs.
+func (e *Evaluator) Call(ctx context.Context, fn object.Object, args []object.Object, env *object.Environment) (object.Object, error) {
+	return e.run(ctx, func() object.Object {
+		e.frames = append(e.frames, env)
+		defer func() { e.frames = e.frames[:len(e.frames)-1] }()
+
+		return e.applyFunction(fn, args)
+	})
+}
+
+func (e *Evaluator) run(ctx context.Context, evaluate func() object.Object) (object.Object, error) {
+	if e.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.config.Timeout)
+		defer cancel()
+	}
+
+	e.ctx = ctx
+	e.start = time.Now()
+	e.steps, e.depth, e.line = 0, 0, 0
+	e.halted = nil
+
+	result := evaluate()
+	if e.halted != nil {
+		return nil, e.halted
+	}
+
+	return result, nil
+}
+
+// Steps returns the number of steps taken by the current or last Run.
+func (e *Evaluator) Steps() int64 {
+	return e.steps
+}
+
+// step accounts for evaluating node. It returns a non-nil error object once
+// a limit has been hit.
+func (e *Evaluator) step(node ast.Node) *object.ErrorObject {
+	if e.halted != nil {
+		return haltObject(e.halted)
+	}
+
+	e.steps++
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		e.line = node.Token.Line
+	case *ast.ReturnStatement:
+		e.line = node.Token.Line
+	case *ast.ExpressionStatement:
+		e.line = node.Token.Line
+	}
+
+	if e.config.MaxSteps > 0 && e.steps > e.config.MaxSteps {
+		return e.halt(ErrBudgetExceeded, fmt.Sprintf("step limit of %d reached", e.config.MaxSteps))
+	}
+
+	if e.steps%ctxCheckInterval == 0 {
+		if err := e.ctx.Err(); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return e.halt(ErrTimeout, "deadline exceeded")
+			}
+			return e.halt(ErrCanceled, "context canceled")
+		}
+	}
+
+	return nil
+}
+
+// enter accounts for a function call. The caller decrements e.depth when
+// the call returns.
+func (e *Evaluator) enter() *object.ErrorObject {
+	switch {
+	case e.config.MaxDepth > 0 && e.depth >= e.config.MaxDepth:
+		return e.halt(ErrBudgetExceeded, fmt.Sprintf("call depth limit of %d reached", e.config.MaxDepth))
+	case e.config.MaxDepth == 0 && e.depth >= DefaultMaxDepth:
+		return newError("stack overflow")
+	}
+
+	e.depth++
+	return nil
+}
+
+func (e *Evaluator) halt(err error, reason string) *object.ErrorObject {
+	e.halted = &HaltError{
+		Err:     err,
+		Reason:  reason,
+		Steps:   e.steps,
+		Depth:   e.depth,
+		Line:    e.line,
+		Memory:  e.allocated,
+		Elapsed: time.Since(e.start),
+	}
+
+	return haltObject(e.halted)
+}
+
+func haltObject(he *HaltError) *object.ErrorObject {
+	return &object.ErrorObject{Message: he.Error()}
+}
@@ -0,0 +1,67 @@
+package eval
+
+import (
+	"fmt"
+
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// Memory accounting
+//
+// Every value and environment the evaluator creates is charged to
+// e.allocated using the estimates in object.SizeOf. Nothing is credited
+// back when values die, so e.allocated only ever overestimates what is
+// live. When it crosses Config.MaxMemory the evaluator measures what is
+// actually reachable from the program's environment and the calls in
+// progress, and only halts if that is still over the limit.
+//
+// A program whose live values stay close to the limit would cross it
+// again with nearly every allocation. So that it is not measured each
+// time, it is only measured again once it has allocated another
+// 1/remeasureFraction of the limit since the last measurement, which may
+// let it overrun the limit by that much in between.
+
+// remeasureFraction sets how much of the memory limit a program allocates
+// between two measurements.
+const remeasureFraction = 16
+
+// track charges obj to the allocation total and returns it, or returns an
+// error object if that exceeds the memory limit. Shared values such as
+// TRUE or small integers are never charged.
+func (e *Evaluator) track(obj object.Object) object.Object {
+	if object.IsShared(obj) {
+		return obj
+	}
+
+	if halted := e.alloc(object.SizeOf(obj)); halted != nil {
+		return halted
+	}
+	return obj
+}
+
+// alloc charges size bytes to the allocation total.
+func (e *Evaluator) alloc(size int64) *object.ErrorObject {
+	e.allocated += size
+	if e.config.MaxMemory <= 0 || e.allocated <= e.config.MaxMemory {
+		return nil
+	}
+
+	if e.allocated-e.measured < e.config.MaxMemory/remeasureFraction {
+		return nil
+	}
+
+	e.allocated = object.Reachable(e.frames...)
+	e.measured = e.allocated
+	e.measurements++
+	if e.allocated <= e.config.MaxMemory {
+		return nil
+	}
+
+	return e.halt(ErrMemoryLimit, fmt.Sprintf("limit of %d bytes exceeded", e.config.MaxMemory))
+}
+
+// Allocated returns the estimated number of bytes in use by values the
+// Evaluator has created.
+func (e *Evaluator) Allocated() int64 {
+	return e.allocated
+}
@@ -0,0 +1,136 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around a change.
+const context = 3
+
+type edit struct {
+	kind byte // ' ', '-' or '+'
+	line string
+
+	// the numbers of the line in the old and new text
+	old, new int
+}
+
+// Diff returns the changes from old to new as a unified diff of the file at
+// path, or nothing if they are the same.
+func Diff(path string, old, new []byte) []byte {
+	return Unified(path+".orig", path, old, new)
+}
+
+// Unified returns the changes from old to new as a unified diff with the
+// given names in its header, or nothing if they are the same.
+func Unified(oldName, newName string, old, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+
+	edits := lineEdits(splitLines(old), splitLines(new))
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// a hunk runs from context lines before a change to context
+		// lines after the last change closer than twice that to the one
+		// before it
+		start := max(i-context, 0)
+		end := i
+		for j := i; j < len(edits) && j < end+2*context+1; j++ {
+			if edits[j].kind != ' ' {
+				end = j
+			}
+		}
+		end = min(end+context+1, len(edits))
+
+		writeHunk(&out, edits[start:end])
+		i = end
+	}
+	return out.Bytes()
+}
+
+func writeHunk(out *bytes.Buffer, edits []edit) {
+	oldStart, newStart := edits[0].old, edits[0].new
+	var oldCount, newCount int
+	for _, e := range edits {
+		if e.kind != '+' {
+			oldCount++
+		}
+		if e.kind != '-' {
+			newCount++
+		}
+	}
+
+	// an empty range starts after the line before it
+	if oldCount == 0 {
+		oldStart--
+	}
+	if newCount == 0 {
+		newStart--
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+	for _, e := range edits {
+		out.WriteByte(e.kind)
+		out.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func splitLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lineEdits returns the edits that turn a into b, keeping the longest
+// common subsequence of their lines.
+func lineEdits(a, b []string) []edit {
+	// common[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i + 1, j + 1})
+			i++
+			j++
+		case j == len(b) || i < len(a) && common[i+1][j] >= common[i][j+1]:
+			edits = append(edits, edit{'-', a[i], i + 1, j + 1})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i + 1, j + 1})
+			j++
+		}
+	}
+	return edits
+}
@@ -0,0 +1,417 @@
+// Package format prints programs in a canonical layout.
+//
+// Statements go on lines of their own, indented by four spaces per block.
+// Operators are spaced and parenthesized only where their precedence needs
+// it, a block holding a single short expression stays on the line of its
+// braces, and a call too long for the line gets one argument per line.
+// Comments are kept, and so are single blank lines between statements.
+// Formatting formatted source leaves it as it is.
+package format
+
+import (
+	"math"
+	"strings"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+)
+
+const (
+	indent = "    "
+
+	// lineWidth is the length a call may extend a line to before its
+	// arguments are broken onto lines of their own.
+	lineWidth = 80
+
+	// inlineWidth is the length of the longest expression a block keeps
+	// on the line of its braces.
+	inlineWidth = 40
+)
+
+// The precedences of expressions, as the parser gives them.
+const (
+	lowest = iota
+	equals
+	lessGreater
+	sum
+	product
+	prefix
+	call // and index, which binds the same
+	operand
+)
+
+// Source returns source in the canonical layout.
+func Source(source []byte) ([]byte, error) {
+	l := lexer.New(string(source))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+
+	pr := &printer{lines: strings.Split(string(source), "\n"), blockStart: true}
+	for _, c := range l.Comments() {
+		before := pr.lines[c.Line-1][:c.Column-1]
+		pr.comments = append(pr.comments, comment{
+			Token: c,
+			text:  strings.TrimRight(c.Literal, " \t\r"),
+			alone: strings.TrimSpace(before) == "",
+		})
+	}
+
+	pr.statements(program.Statements, math.MaxInt, false)
+	return pr.out, nil
+}
+
+type comment struct {
+	token.Token
+	text string
+
+	// alone is set if nothing comes before the comment on its line.
+	alone bool
+}
+
+type printer struct {
+	out    []byte
+	indent int
+
+	// lines holds the lines of the source, to find the blank ones.
+	lines []string
+
+	// comments holds the comments of the source, of which those from
+	// next on have not been printed yet.
+	comments []comment
+	next     int
+
+	// blockStart is set until the first line of a block is printed.
+	blockStart bool
+}
+
+// write adds s, which holds no newline, to the current line.
+func (p *printer) write(s string) {
+	if p.atLineStart() {
+		p.out = append(p.out, strings.Repeat(indent, p.indent)...)
+	}
+	p.out = append(p.out, s...)
+}
+
+func (p *printer) newline() {
+	p.out = append(p.out, '\n')
+}
+
+func (p *printer) atLineStart() bool {
+	return len(p.out) == 0 || p.out[len(p.out)-1] == '\n'
+}
+
+// lineStart returns the offset in out of the current line.
+func (p *printer) lineStart() int {
+	return strings.LastIndexByte(string(p.out), '\n') + 1
+}
+
+// fork returns a copy of p to try a layout with. Its out holds only the
+// current line.
+func (p *printer) fork() *printer {
+	q := *p
+	q.out = append([]byte(nil), p.out[p.lineStart():]...)
+	return &q
+}
+
+// commit makes the layout q tried that of p.
+func (p *printer) commit(q *printer) {
+	p.out = append(p.out[:p.lineStart()], q.out...)
+	p.next = q.next
+	p.blockStart = q.blockStart
+}
+
+// firstLineFits reports whether the first line of out is no longer than
+// lineWidth.
+func firstLineFits(out []byte) bool {
+	line, _, _ := strings.Cut(string(out), "\n")
+	return len(line) <= lineWidth
+}
+
+// blankLine prints a blank line if there is one before line in the source,
+// and it does not start a block.
+func (p *printer) blankLine(line int) {
+	if !p.blockStart && line >= 2 && strings.TrimSpace(p.lines[line-2]) == "" {
+		p.newline()
+	}
+}
+
+// commentsBefore prints the comments that come before line. Those that
+// follow code in the source go at the end of the line printed last, the
+// others on lines of their own.
+func (p *printer) commentsBefore(line int) {
+	for ; p.next < len(p.comments) && p.comments[p.next].Line < line; p.next++ {
+		c := p.comments[p.next]
+		if !c.alone && len(p.out) != 0 {
+			p.out = append(p.out[:len(p.out)-1], " "+c.text+"\n"...)
+			continue
+		}
+
+		p.blankLine(c.Line)
+		p.write(c.text)
+		p.newline()
+		p.blockStart = false
+	}
+}
+
+// commentsWithin reports whether a comment not yet printed is on the lines
+// from first to before last.
+func (p *printer) commentsWithin(first, last int) bool {
+	for _, c := range p.comments[p.next:] {
+		if c.Line >= first && c.Line < last {
+			return true
+		}
+	}
+	return false
+}
+
+// statements prints statements, a line each, and the comments before end.
+// inBlock is set for the statements of a block, whose last is its value.
+func (p *printer) statements(statements []ast.Statement, end int, inBlock bool) {
+	for i, s := range statements {
+		line := ast.Position(s).Line
+		p.commentsBefore(line)
+		p.blankLine(line)
+
+		var next ast.Statement
+		if i+1 < len(statements) {
+			next = statements[i+1]
+		}
+		p.statement(s, next, inBlock)
+		p.newline()
+		p.blockStart = false
+	}
+	p.commentsBefore(end)
+}
+
+func (p *printer) statement(node ast.Statement, next ast.Statement, inBlock bool) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		p.write("let " + node.Name.Value)
+		if node.Type != nil {
+			p.write(": " + node.Type.String())
+		}
+		p.write(" = ")
+		p.expression(node.Value, lowest)
+		p.write(";")
+	case *ast.ReturnStatement:
+		p.write("return ")
+		p.expression(node.ReturnValue, lowest)
+		p.write(";")
+	case *ast.ExpressionStatement:
+		p.expression(node.Expression, lowest)
+		if p.needsSemicolon(node, next, inBlock) {
+			p.write(";")
+		}
+	case *ast.BlockStatement:
+		p.block(node)
+	}
+}
+
+// needsSemicolon reports whether node, followed by next, ends with a
+// semicolon. The last statement of a block does not, as it is the value of
+// the block, and nor does an if, unless next would continue it.
+func (p *printer) needsSemicolon(node *ast.ExpressionStatement, next ast.Statement, inBlock bool) bool {
+	if _, ok := node.Expression.(*ast.IfExpression); ok {
+		if next == nil {
+			return false
+		}
+		q := p.fork()
+		q.out = nil
+		q.statement(next, nil, false)
+		start := strings.TrimSpace(string(q.out))
+		return strings.HasPrefix(start, "(") || strings.HasPrefix(start, "-")
+	}
+	return next != nil || !inBlock
+}
+
+// expression prints node, in parentheses if it binds less tightly than
+// precedence.
+func (p *printer) expression(node ast.Expression, precedence int) {
+	if precedenceOf(node) < precedence {
+		p.write("(")
+		defer p.write(")")
+	}
+
+	switch node := node.(type) {
+	case *ast.Identifier:
+		p.write(node.Value)
+	case *ast.IntegerLiteral:
+		p.write(node.Token.Literal)
+	case *ast.StringLiteral:
+		p.write(ast.QuoteString(node.Value))
+	case *ast.BooleanExpression:
+		p.write(node.Token.Literal)
+	case *ast.PrefixExpression:
+		operandPrecedence := prefix
+		if right, ok := node.Right.(*ast.PrefixExpression); ok && right.Op == ast.OpNegate && node.Op == ast.OpNegate {
+			// --x would read as a decrement
+			operandPrecedence = operand
+		}
+		p.write(node.Op.String())
+		p.expression(node.Right, operandPrecedence)
+	case *ast.InfixExpression:
+		// operators group to the left, so the right operand needs
+		// parentheses at the same precedence
+		op := precedenceOf(node)
+		p.expression(node.Left, op)
+		p.write(" " + node.Op.String() + " ")
+		p.expression(node.Right, op+1)
+	case *ast.IfExpression:
+		p.ifExpression(node)
+	case *ast.FunctionLiteral:
+		p.function(node)
+	case *ast.CallExpression:
+		p.call(node)
+	case *ast.IndexExpression:
+		p.expression(node.Left, call)
+		p.write("[")
+		p.expression(node.Index, lowest)
+		p.write("]")
+	}
+}
+
+func precedenceOf(node ast.Expression) int {
+	switch node := node.(type) {
+	case *ast.PrefixExpression:
+		return prefix
+	case *ast.InfixExpression:
+		switch node.Op {
+		case ast.OpEqual, ast.OpNotEqual:
+			return equals
+		case ast.OpLess, ast.OpGreater:
+			return lessGreater
+		case ast.OpAdd, ast.OpSub:
+			return sum
+		default:
+			return product
+		}
+	case *ast.CallExpression, *ast.IndexExpression:
+		return call
+	}
+	return operand
+}
+
+func (p *printer) ifExpression(node *ast.IfExpression) {
+	p.write("if (")
+	p.expression(node.Condition, lowest)
+	p.write(") ")
+
+	// the branches go on one line only if both do
+	q := p.fork()
+	if q.inlineBlock(node.Consequence) {
+		if node.Alternative == nil {
+			p.commit(q)
+			return
+		}
+		q.write(" else ")
+		if q.inlineBlock(node.Alternative) && firstLineFits(q.out) {
+			p.commit(q)
+			return
+		}
+	}
+
+	p.block(node.Consequence)
+	if node.Alternative != nil {
+		p.write(" else ")
+		p.block(node.Alternative)
+	}
+}
+
+func (p *printer) function(node *ast.FunctionLiteral) {
+	params := make([]string, len(node.Parameters))
+	for i, param := range node.Parameters {
+		params[i] = param.Value
+		if i < len(node.ParameterTypes) && node.ParameterTypes[i] != nil {
+			params[i] += ": " + node.ParameterTypes[i].String()
+		}
+	}
+	p.write("fn(" + strings.Join(params, ", ") + ") ")
+	if node.ReturnType != nil {
+		p.write("-> " + node.ReturnType.String() + " ")
+	}
+
+	q := p.fork()
+	if q.inlineBlock(node.Body) {
+		p.commit(q)
+		return
+	}
+	p.block(node.Body)
+}
+
+// inlineBlock prints block on one line, and reports whether it could: if
+// it holds a single short expression and no comments.
+func (p *printer) inlineBlock(block *ast.BlockStatement) bool {
+	if p.commentsWithin(block.Token.Line, block.Rbrace.Line) {
+		return false
+	}
+	if len(block.Statements) == 0 {
+		p.write("{}")
+		return true
+	}
+
+	stmt, ok := block.Statements[0].(*ast.ExpressionStatement)
+	if !ok || len(block.Statements) != 1 {
+		return false
+	}
+
+	start := len(p.out)
+	p.write("{ ")
+	p.expression(stmt.Expression, lowest)
+	p.write(" }")
+
+	text := string(p.out[start:])
+	return !strings.Contains(text, "\n") && len(text) <= inlineWidth+len("{  }") && firstLineFits(p.out)
+}
+
+// block prints block over several lines.
+func (p *printer) block(block *ast.BlockStatement) {
+	if len(block.Statements) == 0 && !p.commentsWithin(block.Token.Line, block.Rbrace.Line) {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.newline()
+	p.indent++
+	p.blockStart = true
+	p.statements(block.Statements, block.Rbrace.Line, true)
+	p.indent--
+	p.write("}")
+}
+
+// call prints node on one line if it fits, or with an argument per line.
+func (p *printer) call(node *ast.CallExpression) {
+	p.expression(node.Function, call)
+
+	q := p.fork()
+	q.write("(")
+	for i, arg := range node.Arguments {
+		if i > 0 {
+			q.write(", ")
+		}
+		q.expression(arg, lowest)
+	}
+	q.write(")")
+	if len(node.Arguments) == 0 || firstLineFits(q.out) {
+		p.commit(q)
+		return
+	}
+
+	p.write("(")
+	p.newline()
+	p.indent++
+	for i, arg := range node.Arguments {
+		p.expression(arg, lowest)
+		if i < len(node.Arguments)-1 {
+			p.write(",")
+		}
+		p.newline()
+	}
+	p.indent--
+	p.write(")")
+}
@@ -0,0 +1,159 @@
+package format
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let x=5", "let x = 5;\n"},
+		{"let x: int=5;x", "let x: int = 5;\nx;\n"},
+		{"1+2*3", "1 + 2 * 3;\n"},
+		{"(1+2)*3", "(1 + 2) * 3;\n"},
+		{"1-(2-3)", "1 - (2 - 3);\n"},
+		{"(1-2)-3", "1 - 2 - 3;\n"},
+		{"(a<b)==(c>d)", "a < b == c > d;\n"},
+		{"a==(b==c)", "a == (b == c);\n"},
+		{"-(a+b)", "-(a + b);\n"},
+		{"-(-a)", "-(-a);\n"},
+		{"!!a", "!!a;\n"},
+		{"-f(x)", "-f(x);\n"},
+		{"(-f)(x)", "(-f)(x);\n"},
+		{"(f(x))[0][1]", "f(x)[0][1];\n"},
+		{"-(args[0])", "-args[0];\n"},
+		{"(a+b)[0]", "(a + b)[0];\n"},
+		{`"a\"b\n"`, `"a\"b\n";` + "\n"},
+		{"fn(a,b){a+b}", "fn(a, b) { a + b };\n"},
+		{"fn(a: int, f: fn(int)->int)->int{f(a)}", "fn(a: int, f: fn(int) -> int) -> int { f(a) };\n"},
+		{"fn(){}", "fn() {};\n"},
+		{"fn(x){return x;}", "fn(x) {\n    return x;\n};\n"},
+		{"fn(x){let y=x;y}", "fn(x) {\n    let y = x;\n    y\n};\n"},
+		{"if(a){1}else{2}", "if (a) { 1 } else { 2 }\n"},
+		{"if(a){1}\nif(b){2}", "if (a) { 1 }\nif (b) { 2 }\n"},
+		{"if(a){1};(b)(1)", "if (a) { 1 }\nb(1);\n"},
+		{"if(a){1};(b+c)*2", "if (a) { 1 };\n(b + c) * 2;\n"},
+		{"if(a){1};-1", "if (a) { 1 };\n-1;\n"},
+		{"if(a){let b=1;b}else{2}", "if (a) {\n    let b = 1;\n    b\n} else {\n    2\n}\n"},
+		{
+			"let f=fn(n){if(n<1){0}else{n+f(n-1)}}",
+			"let f = fn(n) { if (n < 1) { 0 } else { n + f(n - 1) } };\n",
+		},
+		{
+			"let fib=fn(n){if(n<2){n}else{fib(n-1)+fib(n-2)}}",
+			"let fib = fn(n) {\n    if (n < 2) { n } else { fib(n - 1) + fib(n - 2) }\n};\n",
+		},
+		{
+			"let x = longfunctionname(firstargument, secondargument, thirdargument, fourthone);",
+			"let x = longfunctionname(\n    firstargument,\n    secondargument,\n    thirdargument,\n    fourthone\n);\n",
+		},
+		{
+			"apply(fn(x){let y=x*2;y},1)",
+			"apply(fn(x) {\n    let y = x * 2;\n    y\n}, 1);\n",
+		},
+		{"let a=1;\n\n\n\nlet b=2;\nlet c=3;", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+		{"fn(){\n\n1\n\n}", "fn() { 1 };\n"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Fatalf("Source(%q): %v", tt.input, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Source(%q) =\n%s\nwant\n%s", tt.input, got, tt.want)
+		}
+		testIdempotent(t, tt.want)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// header
+
+let add=fn(a,b){a+b}; // adds
+// before f
+
+let f = fn(n) { // trailing brace
+  // inside
+  let m = n*2; // double
+
+  m
+};
+let g = fn() {
+  // only a comment
+};
+// end`
+	want := `// header
+
+let add = fn(a, b) { a + b }; // adds
+// before f
+
+let f = fn(n) { // trailing brace
+    // inside
+    let m = n * 2; // double
+
+    m
+};
+let g = fn() {
+    // only a comment
+};
+// end
+`
+	got, err := Source([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("wrong output. got=\n%s", got)
+	}
+	testIdempotent(t, want)
+}
+
+func testIdempotent(t *testing.T, formatted string) {
+	t.Helper()
+
+	again, err := Source([]byte(formatted))
+	if err != nil {
+		t.Fatalf("formatted source does not parse: %v\n%s", err, formatted)
+	}
+	if string(again) != formatted {
+		t.Errorf("formatting again changed\n%s\nto\n%s", formatted, again)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	if _, err := Source([]byte("let = 1;")); err == nil || !strings.HasPrefix(err.Error(), "parse error: ") {
+		t.Errorf("expected a parse error. got=%v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	new := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+
+	want := `--- x.cmm.orig
++++ x.cmm
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -9,3 +9,4 @@
+ i
+ j
+ k
++l
+`
+	if got := string(Diff("x.cmm", []byte(old), []byte(new))); got != want {
+		t.Errorf("wrong diff. got=\n%s", got)
+	}
+	if got := Diff("x.cmm", []byte(old), []byte(old)); got != nil {
+		t.Errorf("expected no diff. got=\n%s", got)
+	}
+}
@@ -0,0 +1,195 @@
+// Package cmm embeds the cminusminus interpreter in Go programs.
+//
+//	in := cmm.New(cmm.WithTimeout(time.Second))
+//	in.SetGlobal("limit", 10)
+//	result, err := in.Run("limit * 2")
+//
+// Values cross between Go and cmm as int64, bool, string and nil; see
+// object.FromGo and object.ToGo for the details. Go functions are made
+// callable from cmm with Register.
+package cmm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+	"github.com/shoebilyas123/cminusminus/cmm/resolver"
+	"github.com/shoebilyas123/cminusminus/cmm/types"
+)
+
+// Interpreter runs cmm programs against one global environment, so that
+// bindings made by one Run are visible to the next. An Interpreter is not
+// safe for concurrent use.
+type Interpreter struct {
+	ctx       context.Context
+	config    eval.Config
+	env       *object.Environment
+	evaluator *eval.Evaluator
+}
+
+type Option func(*Interpreter)
+
+// WithContext makes every Run and Call stop with eval.ErrCanceled or
+// eval.ErrTimeout when ctx is done.
+func WithContext(ctx context.Context) Option {
+	return func(in *Interpreter) { in.ctx = ctx }
+}
+
+// WithStepLimit bounds the number of evaluation steps of each Run or Call.
+func WithStepLimit(steps int64) Option {
+	return func(in *Interpreter) { in.config.MaxSteps = steps }
+}
+
+// WithDepthLimit bounds how deeply function calls may nest, past which a
+// Run or Call stops with eval.ErrBudgetExceeded. Without it, calls nest
+// up to eval.DefaultMaxDepth and then fail with a stack overflow.
+func WithDepthLimit(depth int) Option {
+	return func(in *Interpreter) { in.config.MaxDepth = depth }
+}
+
+// WithTimeout bounds the wall-clock time of each Run or Call.
+func WithTimeout(timeout time.Duration) Option {
+	return func(in *Interpreter) { in.config.Timeout = timeout }
+}
+
+// WithMemoryLimit bounds the estimated memory used by the values the
+// Interpreter holds, across all runs.
+func WithMemoryLimit(bytes int64) Option {
+	return func(in *Interpreter) { in.config.MaxMemory = bytes }
+}
+
+func New(options ...Option) *Interpreter {
+	in := &Interpreter{ctx: context.Background(), env: object.NewEnvironment()}
+	for _, option := range options {
+		option(in)
+	}
+	in.evaluator = eval.New(in.config)
+
+	return in
+}
+
+// ParseError holds every error the parser reported for a source.
+type ParseError = parser.ParseError
+
+// TypeError lists the type errors of a program that come from its
+// annotations. The program is not run.
+type TypeError struct {
+	Errors []*types.Error
+}
+
+func (te *TypeError) Error() string {
+	messages := make([]string, len(te.Errors))
+	for i, err := range te.Errors {
+		messages[i] = err.Error()
+	}
+	return "type error: " + strings.Join(messages, "; ")
+}
+
+// RuntimeError is an error raised by the program while it ran.
+type RuntimeError = object.RuntimeError
+
+// Run parses and evaluates src and returns the value of its last
+// statement. The error is a *ParseError, a *TypeError, a *RuntimeError, or an *eval.HaltError if a limit stopped the program.
+func (in *Interpreter) Run(src string) (interface{}, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+
+	// a variable defined nowhere is an error only if the program reaches it
+	resolver.Resolve(program, in.isGlobal)
+
+	if errs := types.Blocking(types.Check(program)); len(errs) != 0 {
+		return nil, &TypeError{Errors: errs}
+	}
+
+	return in.result(in.evaluator.Run(in.ctx, program, in.env))
+}
+
+// RunFile runs the program in the file at path.
+func (in *Interpreter) RunFile(path string) (interface{}, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return in.Run(string(src))
+}
+
+func (in *Interpreter) isGlobal(name string) bool {
+	_, ok := in.env.Get(name)
+	return ok
+}
+
+// SetGlobal binds name to value in the global environment.
+func (in *Interpreter) SetGlobal(name string, value interface{}) error {
+	obj, err := object.FromGo(value)
+	if err != nil {
+		return err
+	}
+
+	in.env.Set(name, obj)
+	return nil
+}
+
+// Register binds name to a builtin that calls the Go function fn. See
+// object.NewBuiltin for the functions that can be registered.
+func (in *Interpreter) Register(name string, fn interface{}) error {
+	builtin, err := object.NewBuiltin(name, fn)
+	if err != nil {
+		return err
+	}
+
+	in.env.Set(name, builtin)
+	return nil
+}
+
+// GetGlobal returns the value bound to name in the global environment.
+func (in *Interpreter) GetGlobal(name string) (interface{}, bool) {
+	obj, ok := in.env.Get(name)
+	if !ok {
+		return nil, false
+	}
+
+	return object.ToGo(obj), true
+}
+
+// Call calls the global function fnName with args.
+func (in *Interpreter) Call(fnName string, args ...interface{}) (interface{}, error) {
+	fn, ok := in.env.Get(fnName)
+	if !ok {
+		return nil, fmt.Errorf("undefined function %s", fnName)
+	}
+
+	objs := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := object.FromGo(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		objs[i] = obj
+	}
+
+	return in.result(in.evaluator.Call(in.ctx, fn, objs, in.env))
+}
+
+func (in *Interpreter) result(obj object.Object, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if errObj, ok := obj.(*object.ErrorObject); ok {
+		return nil, &RuntimeError{Message: errObj.Message}
+	}
+
+	return object.ToGo(obj), nil
+}
@@ -0,0 +1,209 @@
+package cmm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"5 * (2 + 3)", int64(25)},
+		{"1 < 2", true},
+		{"if (false) { 1 }", nil},
+		{"let double = fn(x) { x * 2 }; double(21);", int64(42)},
+	}
+
+	for _, tt := range tests {
+		result, err := New().Run(tt.input)
+		if err != nil {
+			t.Errorf("Run(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("Run(%q) wrong. expected=%#v, got=%#v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	_, err := New().Run("let = 5;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || len(parseErr.Errors) == 0 {
+		t.Errorf("expected a *ParseError. got=%T (%v)", err, err)
+	}
+
+	// the checker cannot tell the type of a global set from Go, so the
+	// mismatch is found at runtime
+	in := New()
+	in.SetGlobal("flag", true)
+	_, err = in.Run("5 + flag;")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Message != "type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("expected a *RuntimeError. got=%T (%v)", err, err)
+	}
+
+	// an undefined variable is an error only once the program reaches it
+	if result, err := New().Run("let f = fn(a) { a + b }; 1"); err != nil || result != int64(1) {
+		t.Errorf("program with an unreached undefined variable did not run: got %v, %v", result, err)
+	}
+	_, err = New().Run("let f = fn(a) { a + b }; f(1)")
+	if !errors.As(err, &runtimeErr) || runtimeErr.Message != "NOT FOUND: undefined identifier - b" {
+		t.Errorf("expected a *RuntimeError for b. got=%T (%v)", err, err)
+	}
+
+	_, err = New().Run("let f = fn(x: int) { x }; f(true);")
+	var typeErr *TypeError
+	if !errors.As(err, &typeErr) || len(typeErr.Errors) != 1 {
+		t.Errorf("expected a *TypeError. got=%T (%v)", err, err)
+	}
+
+	// a type error that is not due to an annotation is met at runtime
+	_, err = New().Run("5 + true;")
+	if !errors.As(err, &runtimeErr) {
+		t.Errorf("expected a *RuntimeError. got=%T (%v)", err, err)
+	}
+	if result, err := New().Run("if (false) { 1 + true } else { 2 }"); err != nil || result != int64(2) {
+		t.Errorf("unannotated program did not run: got %v, %v", result, err)
+	}
+
+	_, err = New().Run("let f = fn(n) { 1 + f(n) }; f(1)")
+	if !errors.As(err, &runtimeErr) || runtimeErr.Message != "stack overflow" {
+		t.Errorf("expected a stack overflow. got=%T (%v)", err, err)
+	}
+
+	_, err = New(WithStepLimit(1000)).Run("let f = fn() { f() }; f();")
+	if !errors.Is(err, eval.ErrBudgetExceeded) {
+		t.Errorf("expected eval.ErrBudgetExceeded. got=%T (%v)", err, err)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	in := New()
+
+	if err := in.SetGlobal("base", 40); err != nil {
+		t.Fatalf("SetGlobal failed: %v", err)
+	}
+	if err := in.SetGlobal("enabled", false); err != nil {
+		t.Fatalf("SetGlobal failed: %v", err)
+	}
+	if err := in.SetGlobal("bad", 1.5); err == nil {
+		t.Errorf("SetGlobal accepted a float64")
+	}
+
+	if _, err := in.Run("let answer = if (enabled) { 0 } else { base + 2 };"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	answer, ok := in.GetGlobal("answer")
+	if !ok || answer != int64(42) {
+		t.Errorf("GetGlobal(answer) wrong. got=%#v, %t", answer, ok)
+	}
+	if _, ok := in.GetGlobal("missing"); ok {
+		t.Errorf("GetGlobal found an unbound name")
+	}
+}
+
+func TestCall(t *testing.T) {
+	in := New()
+	if _, err := in.Run("let max = fn(a, b) { if (a > b) { a } else { b } };"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	result, err := in.Call("max", 3, int64(7))
+	if err != nil || result != int64(7) {
+		t.Errorf("Call(max) wrong. got=%#v, %v", result, err)
+	}
+
+	if _, err := in.Call("min", 1, 2); err == nil {
+		t.Errorf("Call of an undefined function succeeded")
+	}
+}
+
+func TestCallCountsGlobals(t *testing.T) {
+	in := New(WithMemoryLimit(64 << 10))
+	in.SetGlobal("kept", strings.Repeat("x", 80<<10))
+	in.Register("chunk", func() string { return strings.Repeat("y", 8<<10) })
+
+	// The globals alone are over the limit, which the calls find out once
+	// they have allocated enough to measure what is live.
+	var err error
+	for i := 0; i < 20 && err == nil; i++ {
+		_, err = in.Call("chunk")
+	}
+	if !errors.Is(err, eval.ErrMemoryLimit) {
+		t.Errorf("expected eval.ErrMemoryLimit. got=%v", err)
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.cmm")
+	if err := os.WriteFile(path, []byte("let x = 3;\nx * x;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := New().RunFile(path)
+	if err != nil || result != int64(9) {
+		t.Errorf("RunFile wrong. got=%#v, %v", result, err)
+	}
+}
+
+func TestArrays(t *testing.T) {
+	in := New()
+	if err := in.SetGlobal("args", []string{"a", "bc"}); err != nil {
+		t.Fatalf("SetGlobal failed: %v", err)
+	}
+
+	result, err := in.Run("len(args) + len(args[1])")
+	if err != nil || result != int64(4) {
+		t.Errorf("Run wrong. got=%#v, %v", result, err)
+	}
+
+	args, _ := in.GetGlobal("args")
+	if !reflect.DeepEqual(args, []interface{}{"a", "bc"}) {
+		t.Errorf("GetGlobal(args) wrong. got=%#v", args)
+	}
+
+	_, err = in.Run("args[2]")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Message != "index out of range: 2 with length 2" {
+		t.Errorf("expected an out of range error. got=%v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	in := New()
+
+	err := in.Register("allowed", func(userID int64, action string) (bool, error) {
+		if action == "" {
+			return false, errors.New("empty action")
+		}
+		return userID == 7 && action == "read", nil
+	})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	result, err := in.Run(`allowed(7, "read")`)
+	if err != nil || result != true {
+		t.Errorf("wrong result. got=%#v, %v", result, err)
+	}
+
+	_, err = in.Run(`allowed(7, "")`)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Message != "allowed: empty action" {
+		t.Errorf("Go error not returned as a *RuntimeError. got=%v", err)
+	}
+
+	if err := in.Register("broken", 42); err == nil {
+		t.Errorf("Register accepted a non-function")
+	}
+}
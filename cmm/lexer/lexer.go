@@ -1,6 +1,8 @@
 package lexer
 
 import (
+	"strings"
+
 	"github.com/shoebilyas123/cminusminus/cmm/token"
 )
 
@@ -9,15 +11,28 @@ type Lexer struct {
 	nextPosition int
 	currPosition int
 	ch           byte
+
+	// line and column of ch
+	line   int
+	column int
+
+	// comments holds the comments read so far
+	comments []token.Token
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input, currPosition: -1, nextPosition: 0}
+	l := &Lexer{input: input, currPosition: -1, nextPosition: 0, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
 	if l.nextPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -28,16 +43,55 @@ func (l *Lexer) readChar() {
 
 }
 
+// consumeWhitespace skips whitespace and comments, which run from // to
+// the end of the line. A #! line that starts the input, as in a script
+// run by the shell, is a comment too.
 func (l *Lexer) consumeWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case l.ch == '/' && l.peakChar() == '/':
+			l.readComment()
+		case l.ch == '#' && l.currPosition == 0 && l.peakChar() == '!':
+			l.readComment()
+		default:
+			return
+		}
+	}
+}
+
+func (l *Lexer) readComment() {
+	tok := token.Token{Type: token.COMMENT, Line: l.line, Column: l.column}
+	pos := l.currPosition
+
+	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
+
+	tok.Literal = l.input[pos:l.currPosition]
+	l.comments = append(l.comments, tok)
+}
+
+// Comments returns the comments the lexer has skipped so far, with their
+// positions and the leading //.
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
 }
 
 func (l *Lexer) NextToken() token.Token {
+	l.consumeWhitespace()
+
+	line, column := l.line, l.column
+	tok := l.readToken()
+	tok.Line, tok.Column = line, column
+
+	return tok
+}
+
+func (l *Lexer) readToken() token.Token {
 	var tok token.Token
 
-	l.consumeWhitespace()
 	switch l.ch {
 	case '=':
 		if l.peakChar() == '=' {
@@ -63,12 +117,21 @@ func (l *Lexer) NextToken() token.Token {
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
 		break
+	case '[':
+		tok = newToken(token.LBRACKET, l.ch)
+		break
+	case ']':
+		tok = newToken(token.RBRACKET, l.ch)
+		break
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 		break
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 		break
+	case ':':
+		tok = newToken(token.COLON, l.ch)
+		break
 	case '!':
 		if l.peakChar() == '=' {
 			ch := l.ch
@@ -91,7 +154,20 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.FOR_SLASH, l.ch)
 		break
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peakChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
+		break
+	case '"':
+		if str, ok := l.readString(); ok {
+			tok = token.Token{Type: token.STRING, Literal: str}
+		} else {
+			tok = token.Token{Type: token.ILLEGAL, Literal: str}
+		}
 		break
 	case 0:
 		tok.Literal = ""
@@ -142,6 +218,37 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[pos:l.currPosition]
 }
 
+// readString reads the string literal starting at the opening quote and
+// returns its value with escape sequences replaced. It stops on the closing
+// quote, or reports false if the input ends first.
+func (l *Lexer) readString() (string, bool) {
+	var out strings.Builder
+
+	for {
+		l.readChar()
+		switch l.ch {
+		case '"':
+			return out.String(), true
+		case 0:
+			return out.String(), false
+		case '\\':
+			l.readChar()
+			switch l.ch {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 0:
+				return out.String(), false
+			default:
+				out.WriteByte(l.ch)
+			}
+		default:
+			out.WriteByte(l.ch)
+		}
+	}
+}
+
 func (l *Lexer) peakChar() byte {
 	if l.nextPosition >= len(l.input) {
 		return 0
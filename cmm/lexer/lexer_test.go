@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/shoebilyas123/cminusminus/cmm/token"
@@ -22,6 +23,8 @@ return false;
 }
 10 == 10;
 10 != 9;
+fn(a: int) -> int { a - 1 };
+args[0];
 `
 	tests := []struct {
 		expectedType    token.TokenType
@@ -99,7 +102,92 @@ return false;
 		{token.NOT_EQ, "!="},
 		{token.INT, "9"},
 		{token.SEMICOLON, ";"},
+		{token.FN, "fn"},
+		{token.LPAREN, "("},
+		{token.IDENT, "a"},
+		{token.COLON, ":"},
+		{token.IDENT, "int"},
+		{token.RPAREN, ")"},
+		{token.ARROW, "->"},
+		{token.IDENT, "int"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "a"},
+		{token.MINUS, "-"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "args"},
+		{token.LBRACKET, "["},
+		{token.INT, "0"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
+
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  x == 10;
+`
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"x", 2, 3},
+		{"==", 2, 5},
+		{"10", 2, 8},
+		{";", 2, 10},
+		{"", 3, 1},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+		}
+	}
+}
 
+func TestStringTokens(t *testing.T) {
+	input := `"foobar" "foo bar" "say \"hi\"\n" "unterminated`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING, "foobar"},
+		{token.STRING, "foo bar"},
+		{token.STRING, "say \"hi\"\n"},
+		{token.ILLEGAL, "unterminated"},
 		{token.EOF, ""},
 	}
 
@@ -118,3 +206,35 @@ return false;
 		}
 	}
 }
+
+func TestComments(t *testing.T) {
+	input := `#!/usr/bin/env cminusminus
+// header
+let x = 1; // trailing
+x / 2 // last`
+
+	l := New(input)
+	var literals []string
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		literals = append(literals, tok.Literal)
+	}
+	if got := strings.Join(literals, " "); got != "let x = 1 ; x / 2" {
+		t.Errorf("tokens wrong. got=%q", got)
+	}
+
+	want := []token.Token{
+		{Type: token.COMMENT, Literal: "#!/usr/bin/env cminusminus", Line: 1, Column: 1},
+		{Type: token.COMMENT, Literal: "// header", Line: 2, Column: 1},
+		{Type: token.COMMENT, Literal: "// trailing", Line: 3, Column: 12},
+		{Type: token.COMMENT, Literal: "// last", Line: 4, Column: 7},
+	}
+	comments := l.Comments()
+	if len(comments) != len(want) {
+		t.Fatalf("wrong number of comments. got=%v", comments)
+	}
+	for i, c := range comments {
+		if c != want[i] {
+			t.Errorf("comments[%d] wrong. expected=%+v, got=%+v", i, want[i], c)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package lint
+
+import (
+	"fmt"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+)
+
+// decl is a let or parameter.
+type decl struct {
+	name  string
+	pos   token.Token
+	param bool
+	used  bool
+
+	// fn is the function literal a let binds, or nil
+	fn *ast.FunctionLiteral
+}
+
+// scope holds the variables of a function, or the globals. Blocks share
+// the scope of their function, as they share its environment.
+type scope struct {
+	outer *scope
+
+	// decls holds every let of the function by name, so that functions
+	// nested in it, which may run after any of them, can refer to them.
+	decls map[string][]*decl
+
+	// current holds the declaration of each name that the statement
+	// being checked sees.
+	current map[string]*decl
+
+	order []*decl
+}
+
+type checker struct {
+	scope       *scope
+	diagnostics []Diagnostic
+}
+
+func (c *checker) report(rule string, node ast.Node, format string, a ...interface{}) {
+	c.reportAt(rule, ast.Position(node), format, a...)
+}
+
+func (c *checker) reportAt(rule string, pos token.Token, format string, a ...interface{}) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Rule:    rule,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (c *checker) program(program *ast.Program) {
+	c.enter(program.Statements)
+	c.statements(program.Statements)
+	c.leave()
+}
+
+// enter starts the scope of a function with the given body.
+func (c *checker) enter(body []ast.Statement) {
+	s := &scope{outer: c.scope, decls: make(map[string][]*decl), current: make(map[string]*decl)}
+	for _, stmt := range body {
+		collectLets(stmt, s)
+	}
+	c.scope = s
+}
+
+// leave ends the current scope and reports its unused variables.
+func (c *checker) leave() {
+	for _, d := range c.scope.order {
+		if d.used || d.name == "_" || d.name[0] == '_' {
+			continue
+		}
+		if d.param {
+			c.reportAt(UnusedParameter, d.pos, "parameter %s is never used", d.name)
+		} else {
+			c.reportAt(UnusedVariable, d.pos, "%s is never used", d.name)
+		}
+	}
+	c.scope = c.scope.outer
+}
+
+// collectLets adds the lets of node, outside nested functions, to s.
+func collectLets(node ast.Node, s *scope) {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for _, stmt := range node.Statements {
+			collectLets(stmt, s)
+		}
+	case *ast.LetStatement:
+		d := &decl{name: node.Name.Value, pos: node.Name.Token}
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+			d.fn = fn
+		}
+		s.decls[d.name] = append(s.decls[d.name], d)
+		s.order = append(s.order, d)
+		collectLets(node.Value, s)
+	case *ast.ReturnStatement:
+		collectLets(node.ReturnValue, s)
+	case *ast.ExpressionStatement:
+		collectLets(node.Expression, s)
+	case *ast.PrefixExpression:
+		collectLets(node.Right, s)
+	case *ast.InfixExpression:
+		collectLets(node.Left, s)
+		collectLets(node.Right, s)
+	case *ast.IfExpression:
+		collectLets(node.Condition, s)
+		collectLets(node.Consequence, s)
+		if node.Alternative != nil {
+			collectLets(node.Alternative, s)
+		}
+	case *ast.CallExpression:
+		collectLets(node.Function, s)
+		for _, a := range node.Arguments {
+			collectLets(a, s)
+		}
+	case *ast.IndexExpression:
+		collectLets(node.Left, s)
+		collectLets(node.Index, s)
+	}
+}
+
+// declare makes d the declaration of its name that the rest of the
+// function sees, and reports it if it hides another.
+func (c *checker) declare(d *decl) {
+	if _, ok := c.scope.current[d.name]; !ok {
+		c.checkShadow(d)
+	}
+	c.scope.current[d.name] = d
+}
+
+func (c *checker) checkShadow(d *decl) {
+	for s := c.scope.outer; s != nil; s = s.outer {
+		if outer := s.decls[d.name]; len(outer) != 0 {
+			pos := outer[0].pos
+			c.reportAt(Shadow, d.pos, "%s shadows the variable declared at %d:%d", d.name, pos.Line, pos.Column)
+			return
+		}
+	}
+	if object.GetBuiltinByName(d.name) != nil {
+		c.reportAt(Shadow, d.pos, "%s shadows the builtin %s", d.name, d.name)
+	}
+}
+
+// resolve marks the declarations name may refer to as used, and returns
+// the one it refers to if that is certain.
+func (c *checker) resolve(name string) *decl {
+	nested := false
+	for s := c.scope; s != nil; s = s.outer {
+		if !nested {
+			if d, ok := s.current[name]; ok {
+				d.used = true
+				return d
+			}
+		} else if decls := s.decls[name]; len(decls) != 0 {
+			for _, d := range decls {
+				d.used = true
+			}
+			if len(decls) == 1 {
+				return decls[0]
+			}
+			return nil
+		}
+		nested = true
+	}
+	return nil
+}
+
+func (c *checker) statements(statements []ast.Statement) {
+	reported := false
+	for i, s := range statements {
+		if i > 0 && terminates(statements[i-1]) && !reported {
+			c.report(Unreachable, s, "unreachable code")
+			reported = true
+		}
+		c.statement(s)
+	}
+}
+
+// terminates reports whether node always returns.
+func terminates(node ast.Statement) bool {
+	switch node := node.(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.BlockStatement:
+		return blockTerminates(node)
+	case *ast.ExpressionStatement:
+		ifExpr, ok := node.Expression.(*ast.IfExpression)
+		return ok && ifExpr.Alternative != nil &&
+			blockTerminates(ifExpr.Consequence) && blockTerminates(ifExpr.Alternative)
+	}
+	return false
+}
+
+func blockTerminates(block *ast.BlockStatement) bool {
+	for _, s := range block.Statements {
+		if terminates(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checker) statement(node ast.Statement) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		c.value(node.Value)
+		d := c.scope.decls[node.Name.Value][0]
+		for _, other := range c.scope.decls[node.Name.Value] {
+			if other.pos == node.Name.Token {
+				d = other
+			}
+		}
+		c.declare(d)
+	case *ast.ReturnStatement:
+		c.value(node.ReturnValue)
+	case *ast.ExpressionStatement:
+		c.expression(node.Expression)
+	case *ast.BlockStatement:
+		c.statements(node.Statements)
+	}
+}
+
+// value checks node where its value is used.
+func (c *checker) value(node ast.Expression) {
+	if ifExpr, ok := node.(*ast.IfExpression); ok && ifExpr.Alternative == nil {
+		c.report(IfValue, node, "the value of an if without else is null when its condition is false")
+	}
+	c.expression(node)
+}
+
+func (c *checker) expression(node ast.Expression) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		c.resolve(node.Value)
+	case *ast.PrefixExpression:
+		c.value(node.Right)
+	case *ast.InfixExpression:
+		c.value(node.Left)
+		c.value(node.Right)
+		c.comparison(node)
+	case *ast.IfExpression:
+		c.value(node.Condition)
+		c.statements(node.Consequence.Statements)
+		if node.Alternative != nil {
+			c.statements(node.Alternative.Statements)
+		}
+	case *ast.FunctionLiteral:
+		c.function(node)
+	case *ast.CallExpression:
+		c.call(node)
+	case *ast.IndexExpression:
+		c.value(node.Left)
+		c.value(node.Index)
+	}
+}
+
+func (c *checker) function(fn *ast.FunctionLiteral) {
+	c.enter(fn.Body.Statements)
+	for _, p := range fn.Parameters {
+		d := &decl{name: p.Value, pos: p.Token, param: true}
+		c.scope.decls[d.name] = append(c.scope.decls[d.name], d)
+		c.scope.order = append(c.scope.order, d)
+		c.declare(d)
+	}
+	c.statements(fn.Body.Statements)
+	c.leave()
+}
+
+func (c *checker) call(node *ast.CallExpression) {
+	var fn *ast.FunctionLiteral
+	name := "the function"
+
+	switch callee := node.Function.(type) {
+	case *ast.Identifier:
+		if d := c.resolve(callee.Value); d != nil && d.fn != nil {
+			fn, name = d.fn, callee.Value
+		}
+	case *ast.FunctionLiteral:
+		fn = callee
+		c.function(callee)
+	default:
+		c.value(callee)
+	}
+
+	for _, a := range node.Arguments {
+		c.value(a)
+	}
+
+	if fn != nil && len(fn.Parameters) != len(node.Arguments) {
+		c.report(ArgCount, node, "%s takes %s, but is called with %d",
+			name, plural(len(fn.Parameters), "argument"), len(node.Arguments))
+	}
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// comparison reports a comparison of two literals, or of a variable with
+// itself.
+func (c *checker) comparison(node *ast.InfixExpression) {
+	var result bool
+
+	switch node.Op {
+	case ast.OpEqual, ast.OpNotEqual:
+		equal, ok := same(node.Left, node.Right)
+		if !ok {
+			return
+		}
+		result = equal == (node.Op == ast.OpEqual)
+	case ast.OpLess, ast.OpGreater:
+		if left, ok := node.Left.(*ast.IntegerLiteral); ok {
+			right, ok := node.Right.(*ast.IntegerLiteral)
+			if !ok {
+				return
+			}
+			result = left.Value < right.Value
+			if node.Op == ast.OpGreater {
+				result = left.Value > right.Value
+			}
+		} else if !sameVariable(node.Left, node.Right) {
+			return
+		}
+	default:
+		return
+	}
+
+	c.report(ConstantComparison, node, "%s is always %t", node, result)
+}
+
+// same reports whether left and right are always equal, if ok.
+func same(left, right ast.Expression) (equal, ok bool) {
+	if sameVariable(left, right) {
+		return true, true
+	}
+
+	switch l := left.(type) {
+	case *ast.IntegerLiteral:
+		if r, ok := right.(*ast.IntegerLiteral); ok {
+			return l.Value == r.Value, true
+		}
+	case *ast.StringLiteral:
+		if r, ok := right.(*ast.StringLiteral); ok {
+			return l.Value == r.Value, true
+		}
+	case *ast.BooleanExpression:
+		if r, ok := right.(*ast.BooleanExpression); ok {
+			return l.Value == r.Value, true
+		}
+	default:
+		return false, false
+	}
+
+	// literals of different types are never equal
+	switch right.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.BooleanExpression:
+		return false, true
+	}
+	return false, false
+}
+
+func sameVariable(left, right ast.Expression) bool {
+	l, ok := left.(*ast.Identifier)
+	if !ok {
+		return false
+	}
+	r, ok := right.(*ast.Identifier)
+	return ok && l.Value == r.Value
+}
@@ -0,0 +1,175 @@
+// Package lint reports code that is legal but likely a mistake.
+//
+// Each kind of report comes from a rule, which a Config can turn off. A
+// single report is silenced by a comment on its line or the line before:
+//
+//	// lint:ignore unused-variable
+//	let scratch = 1;
+//
+// lint:ignore without rule names silences every rule.
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+)
+
+// The rules.
+const (
+	UnusedVariable     = "unused-variable"
+	UnusedParameter    = "unused-parameter"
+	Shadow             = "shadow"
+	Unreachable        = "unreachable"
+	ConstantComparison = "constant-comparison"
+	IfValue            = "if-value"
+	ArgCount           = "arg-count"
+)
+
+// Rules describes every rule.
+var Rules = map[string]string{
+	UnusedVariable:     "a let whose variable is never used",
+	UnusedParameter:    "a parameter that is never used",
+	Shadow:             "a variable that hides one of an enclosing function, or a builtin",
+	Unreachable:        "a statement after a return",
+	ConstantComparison: "a comparison whose result is always the same",
+	IfValue:            "an if without else whose value is used, which is null when the condition is false",
+	ArgCount:           "a call with the wrong number of arguments for the function it calls",
+}
+
+// Diagnostic is a report of a rule.
+type Diagnostic struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	pos := fmt.Sprintf("%d:%d", d.Line, d.Column)
+	if d.File != "" {
+		pos = d.File + ":" + pos
+	}
+	return fmt.Sprintf("%s: %s (%s)", pos, d.Message, d.Rule)
+}
+
+// Config selects the rules to run.
+type Config struct {
+	// Rules turns rules on or off by name. Rules it does not list are on.
+	Rules map[string]bool `json:"rules"`
+}
+
+// DefaultConfigFile is the name of the config file looked for in the
+// current directory.
+const DefaultConfigFile = ".cmmlint.json"
+
+// LoadConfig reads a config from the JSON file at path, such as
+//
+//	{"rules": {"shadow": false}}
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for rule := range config.Rules {
+		if _, ok := Rules[rule]; !ok {
+			return nil, fmt.Errorf("%s: unknown rule %q", path, rule)
+		}
+	}
+	return config, nil
+}
+
+// Enabled reports whether rule is on. A nil config turns every rule on.
+func (c *Config) Enabled(rule string) bool {
+	if c == nil {
+		return true
+	}
+	on, ok := c.Rules[rule]
+	return !ok || on
+}
+
+// Lint parses source and returns the reports of the rules config enables,
+// in the order of their positions.
+func Lint(source string, config *Config) ([]Diagnostic, error) {
+	l := lexer.New(source)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+
+	return Program(program, l.Comments(), config), nil
+}
+
+// Program returns the reports of the rules config enables for program,
+// leaving out those that comments silence.
+func Program(program *ast.Program, comments []token.Token, config *Config) []Diagnostic {
+	c := &checker{}
+	c.program(program)
+
+	ignored := ignores(comments)
+
+	var result []Diagnostic
+	for _, d := range c.diagnostics {
+		if config.Enabled(d.Rule) && !ignored.covers(d) {
+			result = append(result, d)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Line != result[j].Line {
+			return result[i].Line < result[j].Line
+		}
+		return result[i].Column < result[j].Column
+	})
+	return result
+}
+
+// ignoreSet maps lines to the rules silenced on them. An empty rule
+// silences them all.
+type ignoreSet map[int][]string
+
+const ignorePrefix = "lint:ignore"
+
+// ignores returns the lines the lint:ignore comments among comments apply
+// to: their own and the next.
+func ignores(comments []token.Token) ignoreSet {
+	set := make(ignoreSet)
+	for _, c := range comments {
+		text := strings.TrimSpace(strings.TrimPrefix(c.Literal, "//"))
+		rest, ok := strings.CutPrefix(text, ignorePrefix)
+		if !ok {
+			continue
+		}
+
+		rules := []string{""}
+		if fields := strings.FieldsFunc(rest, func(r rune) bool { return r == ',' || r == ' ' }); len(fields) != 0 {
+			rules = fields
+		}
+		set[c.Line] = append(set[c.Line], rules...)
+		set[c.Line+1] = append(set[c.Line+1], rules...)
+	}
+	return set
+}
+
+func (s ignoreSet) covers(d Diagnostic) bool {
+	for _, rule := range s[d.Line] {
+		if rule == "" || rule == d.Rule {
+			return true
+		}
+	}
+	return false
+}
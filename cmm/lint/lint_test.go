@@ -0,0 +1,111 @@
+package lint
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"let x = 1;", []string{"1:5: x is never used (unused-variable)"}},
+		{"let x = 1; x", nil},
+		{"let _scratch = 1;", nil},
+		{"let x = 1; let x = 2; x", []string{"1:5: x is never used (unused-variable)"}},
+		{"let f = fn() { x }; let x = 1; f()", nil},
+		{"let f = fn(a, b) { a }; f(1, 2)", []string{"1:15: parameter b is never used (unused-parameter)"}},
+		{"let f = fn(n) { if (n < 1) { 0 } else { f(n - 1) } }; f(3)", nil},
+		{"let x = 1; let f = fn(x) { x }; f(x)", []string{"1:23: x shadows the variable declared at 1:5 (shadow)"}},
+		{"let f = fn() { let len = 1; len }; f()", []string{"1:20: len shadows the builtin len (shadow)"}},
+		{"let f = fn(a) { let a = 2; a }; f(1)", []string{"1:12: parameter a is never used (unused-parameter)"}},
+		{"let f = fn() { return 1; 2 }; f()", []string{"1:26: unreachable code (unreachable)"}},
+		{"let f = fn(c) { if (c) { return 1; } else { return 2; } c }; f(1)", []string{"1:57: unreachable code (unreachable)"}},
+		{"let f = fn(c) { if (c) { return 1; } c }; f(1)", nil},
+		{"1 == 1", []string{"1:1: (1 == 1) is always true (constant-comparison)"}},
+		{`"a" != 1`, []string{`1:1: ("a" != 1) is always true (constant-comparison)`}},
+		{"let x = 1; x < x", []string{"1:12: (x < x) is always false (constant-comparison)"}},
+		{"2 > 1", []string{"1:1: (2 > 1) is always true (constant-comparison)"}},
+		{"let x = 1; x == 2", nil},
+		{"let x = if (true) { 1 }; x", []string{"1:9: the value of an if without else is null when its condition is false (if-value)"}},
+		{"let x = if (true) { 1 } else { 2 }; x", nil},
+		{"if (true) { 1 }", nil},
+		{"let f = fn(a) { a }; f(1, 2)", []string{"1:22: f takes 1 argument, but is called with 2 (arg-count)"}},
+		{"fn(a, b) { a + b }(1)", []string{"1:1: the function takes 2 arguments, but is called with 1 (arg-count)"}},
+		{"let f = fn(a) { a }; let f = fn() { 1 }; f()", []string{"1:5: f is never used (unused-variable)"}},
+	}
+
+	for _, tt := range tests {
+		diagnostics, err := Lint(tt.input, nil)
+		if err != nil {
+			t.Fatalf("Lint(%q): %v", tt.input, err)
+		}
+
+		var got []string
+		for _, d := range diagnostics {
+			got = append(got, d.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("Lint(%q) =\n%s\nwant\n%s", tt.input, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func TestIgnoreComments(t *testing.T) {
+	input := `// lint:ignore unused-variable
+let a = 1;
+let b = 1; // lint:ignore
+
+let c = 1; // lint:ignore shadow
+
+let d = 1;
+`
+	diagnostics, err := Lint(input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range diagnostics {
+		got = append(got, d.String())
+	}
+	want := "5:5: c is never used (unused-variable)\n7:5: d is never used (unused-variable)"
+	if strings.Join(got, "\n") != want {
+		t.Errorf("diagnostics wrong. got=\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultConfigFile)
+	os.WriteFile(path, []byte(`{"rules": {"unused-variable": false}}`), 0644)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diagnostics, _ := Lint("let x = 1; 1 == 1", config)
+	if len(diagnostics) != 1 || diagnostics[0].Rule != ConstantComparison {
+		t.Errorf("diagnostics wrong. got=%v", diagnostics)
+	}
+
+	os.WriteFile(path, []byte(`{"rules": {"unused": false}}`), 0644)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), `unknown rule "unused"`) {
+		t.Errorf("expected an unknown rule error. got=%v", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	d := Diagnostic{File: "a.cmm", Line: 1, Column: 5, Rule: UnusedVariable, Message: "x is never used"}
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"file":"a.cmm","line":1,"column":5,"rule":"unused-variable","message":"x is never used"}`
+	if string(data) != want {
+		t.Errorf("json wrong. got=%s", data)
+	}
+}
@@ -0,0 +1,247 @@
+package lsp
+
+import "github.com/shoebilyas123/cminusminus/cmm/ast"
+
+// scope holds the variables of a function, or the globals, which are
+// found the way the resolver finds them: a let is visible after it within
+// its function, and everywhere in the functions nested in it, while the
+// globals are visible everywhere.
+type scope struct {
+	outer *scope
+
+	// fn is the function, or nil for the globals
+	fn *ast.FunctionLiteral
+
+	// lets holds every let of the function by name, in order
+	lets map[string][]*symbol
+
+	// visible holds the variable each name refers to at the statement
+	// being bound
+	visible map[string]*symbol
+}
+
+// binder finds the variable each identifier of a document refers to. It
+// works on programs that did not parse, as far as they go.
+type binder struct {
+	doc   *document
+	scope *scope
+
+	// lets maps each let to the variable it declares
+	lets map[*ast.LetStatement]*symbol
+
+	// free holds the identifiers that refer to no variable, such as
+	// builtins
+	free []*ast.Identifier
+}
+
+func (b *binder) program(program *ast.Program) {
+	b.lets = make(map[*ast.LetStatement]*symbol)
+	b.enter(nil, program.Statements, nil)
+	b.statements(program.Statements)
+	b.scope = b.scope.outer
+	b.doc.free = b.free
+}
+
+// enter starts the scope of fn, or of the program if fn is nil, whose
+// statements are body. The lets in it become children of owner, if set.
+func (b *binder) enter(fn *ast.FunctionLiteral, body []ast.Statement, owner *symbol) {
+	s := &scope{outer: b.scope, fn: fn, lets: make(map[string][]*symbol), visible: make(map[string]*symbol)}
+	b.scope = s
+
+	for _, stmt := range body {
+		inspect(stmt, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.FunctionLiteral:
+				return false
+			case *ast.LetStatement:
+				if node == nil {
+					return false
+				}
+				sym := &symbol{name: node.Name.Value, decl: node.Name, let: node}
+				s.lets[sym.name] = append(s.lets[sym.name], sym)
+				b.lets[node] = sym
+				b.doc.idents[node.Name] = sym
+				b.doc.scopes[fn] = append(b.doc.scopes[fn], sym)
+				switch {
+				case owner != nil:
+					owner.children = append(owner.children, sym)
+				case fn == nil:
+					b.doc.globals = append(b.doc.globals, sym)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// resolve returns the variable name refers to in the current scope, or
+// nil.
+func (b *binder) resolve(name string) *symbol {
+	for s := b.scope; s != nil; s = s.outer {
+		if sym := s.visible[name]; sym != nil {
+			return sym
+		}
+		if s != b.scope || s.fn == nil {
+			if lets := s.lets[name]; len(lets) != 0 {
+				return lets[0]
+			}
+		}
+	}
+	return nil
+}
+
+func (b *binder) statements(statements []ast.Statement) {
+	for _, s := range statements {
+		b.statement(s)
+	}
+}
+
+func (b *binder) statement(node ast.Statement) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		if node == nil {
+			return
+		}
+		sym := b.lets[node]
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok && fn != nil {
+			b.function(fn, sym)
+		} else {
+			b.expression(node.Value)
+		}
+		b.scope.visible[sym.name] = sym
+	case *ast.ReturnStatement:
+		b.expression(node.ReturnValue)
+	case *ast.ExpressionStatement:
+		b.expression(node.Expression)
+	case *ast.BlockStatement:
+		if node != nil {
+			b.statements(node.Statements)
+		}
+	}
+}
+
+func (b *binder) expression(node ast.Expression) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if sym := b.resolve(node.Value); sym != nil {
+			sym.refs = append(sym.refs, node)
+			b.doc.idents[node] = sym
+		} else {
+			b.free = append(b.free, node)
+		}
+	case *ast.PrefixExpression:
+		b.expression(node.Right)
+	case *ast.InfixExpression:
+		b.expression(node.Left)
+		b.expression(node.Right)
+	case *ast.IfExpression:
+		b.expression(node.Condition)
+		b.statement(node.Consequence)
+		b.statement(node.Alternative)
+	case *ast.FunctionLiteral:
+		b.function(node, b.owner())
+	case *ast.CallExpression:
+		b.expression(node.Function)
+		for _, arg := range node.Arguments {
+			b.expression(arg)
+		}
+	case *ast.IndexExpression:
+		b.expression(node.Left)
+		b.expression(node.Index)
+	}
+}
+
+// owner returns the let whose function the current scope is, whose
+// children the lets of a function literal in it join.
+func (b *binder) owner() *symbol {
+	for s := b.scope; s != nil && s.fn != nil; s = s.outer {
+		for _, lets := range s.outer.lets {
+			for _, sym := range lets {
+				if sym.function() == s.fn {
+					return sym
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// function binds fn, which owner, if set, is the let of.
+func (b *binder) function(fn *ast.FunctionLiteral, owner *symbol) {
+	var body []ast.Statement
+	if fn.Body != nil {
+		body = fn.Body.Statements
+	}
+	b.enter(fn, body, owner)
+
+	for i, param := range fn.Parameters {
+		sym := &symbol{name: param.Value, decl: param, fn: fn, index: i}
+		b.scope.visible[sym.name] = sym
+		b.doc.idents[param] = sym
+		b.doc.scopes[fn] = append(b.doc.scopes[fn], sym)
+	}
+
+	b.statements(body)
+	b.scope = b.scope.outer
+}
+
+// inspect calls f for node and, as long as f returns true, for the nodes
+// in it, in the order they appear. Parts a program that did not parse
+// lacks are nil, which f is not called for.
+func inspect(node ast.Node, f func(ast.Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	var children []ast.Node
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			children = append(children, s)
+		}
+	case *ast.LetStatement:
+		if node != nil {
+			children = []ast.Node{node.Value}
+		}
+	case *ast.ReturnStatement:
+		children = []ast.Node{node.ReturnValue}
+	case *ast.ExpressionStatement:
+		children = []ast.Node{node.Expression}
+	case *ast.BlockStatement:
+		if node != nil {
+			for _, s := range node.Statements {
+				children = append(children, s)
+			}
+		}
+	case *ast.PrefixExpression:
+		children = []ast.Node{node.Right}
+	case *ast.InfixExpression:
+		children = []ast.Node{node.Left, node.Right}
+	case *ast.IfExpression:
+		children = []ast.Node{node.Condition}
+		if node.Consequence != nil {
+			children = append(children, node.Consequence)
+		}
+		if node.Alternative != nil {
+			children = append(children, node.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		for _, p := range node.Parameters {
+			children = append(children, p)
+		}
+		if node.Body != nil {
+			children = append(children, node.Body)
+		}
+	case *ast.CallExpression:
+		children = []ast.Node{node.Function}
+		for _, a := range node.Arguments {
+			children = append(children, a)
+		}
+	case *ast.IndexExpression:
+		children = []ast.Node{node.Left, node.Index}
+	}
+
+	for _, child := range children {
+		inspect(child, f)
+	}
+}
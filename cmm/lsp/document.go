@@ -0,0 +1,245 @@
+package lsp
+
+import (
+	"unicode/utf16"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/lint"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+	"github.com/shoebilyas123/cminusminus/cmm/resolver"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+	"github.com/shoebilyas123/cminusminus/cmm/types"
+)
+
+// symbol is a variable the program declares: a let or a parameter.
+type symbol struct {
+	name string
+	decl *ast.Identifier
+
+	// let is the let that declares the symbol, or nil for a parameter
+	let *ast.LetStatement
+
+	// fn is the function literal a parameter belongs to, and index its
+	// place among the parameters
+	fn    *ast.FunctionLiteral
+	index int
+
+	refs []*ast.Identifier
+
+	// children holds the lets in the body of the function a let binds
+	children []*symbol
+}
+
+// function returns the function literal a let binds, or nil.
+func (s *symbol) function() *ast.FunctionLiteral {
+	if s.let == nil {
+		return nil
+	}
+	fn, _ := s.let.Value.(*ast.FunctionLiteral)
+	return fn
+}
+
+// document is an open source file and what the server worked out about
+// it.
+type document struct {
+	uri   string
+	text  string
+	lines []string
+
+	program  *ast.Program
+	tokens   []token.Token
+	comments []token.Token
+
+	diagnostics []Diagnostic
+
+	// globals holds the top level lets, each with the lets of the
+	// function it binds as children
+	globals []*symbol
+
+	// idents maps the identifiers that declare or refer to a variable to
+	// it
+	idents map[*ast.Identifier]*symbol
+
+	// free holds the identifiers that refer to no variable
+	free []*ast.Identifier
+
+	// scopes holds the variables of each function, and the globals under
+	// nil, for completion
+	scopes map[*ast.FunctionLiteral][]*symbol
+
+	// types holds the type of each expression, if the program parsed
+	types map[ast.Expression]types.Type
+}
+
+// newDocument parses and checks text.
+func newDocument(uri, text string) *document {
+	d := &document{
+		uri:    uri,
+		text:   text,
+		lines:  splitLines(text),
+		idents: make(map[*ast.Identifier]*symbol),
+		scopes: make(map[*ast.FunctionLiteral][]*symbol),
+	}
+
+	l := lexer.New(text)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		d.tokens = append(d.tokens, tok)
+	}
+	d.comments = l.Comments()
+
+	l = lexer.New(text)
+	p := parser.New(l)
+	d.program = p.ParseProgram()
+
+	b := &binder{doc: d}
+	b.program(d.program)
+
+	if len(p.Errors()) != 0 {
+		for i, msg := range p.Errors() {
+			d.report(SeverityError, "syntax", p.ErrorTokens()[i], msg)
+		}
+		return d
+	}
+
+	for _, err := range resolver.Resolve(d.program, nil) {
+		d.report(SeverityError, "resolve", token.Token{Line: err.Line, Column: err.Column, Literal: err.Name},
+			"undefined variable "+err.Name)
+	}
+	var errs []*types.Error
+	d.types, errs = types.Info(d.program)
+	for _, err := range errs {
+		// only errors from annotations stop the program from running
+		severity := SeverityWarning
+		if err.Annotated {
+			severity = SeverityError
+		}
+		d.report(severity, "types", token.Token{Line: err.Line, Column: err.Column}, err.Message)
+	}
+	for _, diag := range lint.Program(d.program, l.Comments(), nil) {
+		pos := token.Token{Line: diag.Line, Column: diag.Column}
+		d.diagnostics = append(d.diagnostics, Diagnostic{
+			Range:    d.rangeOf(pos, d.wordLength(pos)),
+			Severity: SeverityWarning,
+			Code:     diag.Rule,
+			Source:   "cmm lint",
+			Message:  diag.Message,
+		})
+	}
+	return d
+}
+
+func (d *document) report(severity int, kind string, pos token.Token, msg string) {
+	d.diagnostics = append(d.diagnostics, Diagnostic{
+		Range:    d.rangeOf(pos, max(len(pos.Literal), d.wordLength(pos))),
+		Severity: severity,
+		Source:   "cmm " + kind,
+		Message:  msg,
+	})
+}
+
+// wordLength returns the length of the token at pos, or 1 if there is
+// none, so that a diagnostic there covers it.
+func (d *document) wordLength(pos token.Token) int {
+	for _, tok := range d.tokens {
+		if tok.Line == pos.Line && tok.Column == pos.Column && len(tok.Literal) > 0 {
+			return len(tok.Literal)
+		}
+	}
+	return 1
+}
+
+// splitLines splits text into lines, without their line breaks.
+func splitLines(text string) []string {
+	var lines []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lines = append(lines, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(lines, text[start:])
+}
+
+// position converts the line and byte column of tok, counting from 1, to
+// a Position.
+func (d *document) position(line, column int) Position {
+	if line < 1 || line > len(d.lines) {
+		return Position{Line: max(line-1, 0)}
+	}
+	text := d.lines[line-1]
+	column = min(max(column-1, 0), len(text))
+	return Position{Line: line - 1, Character: utf16Len(text[:column])}
+}
+
+// rangeOf returns the range of length bytes from pos.
+func (d *document) rangeOf(pos token.Token, length int) Range {
+	return Range{Start: d.position(pos.Line, pos.Column), End: d.position(pos.Line, pos.Column+length)}
+}
+
+// identRange returns the range of an identifier.
+func (d *document) identRange(ident *ast.Identifier) Range {
+	return d.rangeOf(ident.Token, len(ident.Value))
+}
+
+// offset converts a Position to a line and byte column counting from 1.
+func (d *document) offset(pos Position) (line, column int) {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return 0, 0
+	}
+	text := d.lines[pos.Line]
+	units := 0
+	for i, r := range text {
+		if units >= pos.Character {
+			return pos.Line + 1, i + 1
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return pos.Line + 1, len(text) + 1
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// identAt returns the identifier at pos that declares or refers to a
+// variable, and the variable, or nils.
+func (d *document) identAt(pos Position) (*ast.Identifier, *symbol) {
+	line, column := d.offset(pos)
+	for ident, s := range d.idents {
+		tok := ident.Token
+		if tok.Line == line && tok.Column <= column && column <= tok.Column+len(ident.Value) {
+			return ident, s
+		}
+	}
+	return nil, nil
+}
+
+// builtinAt returns the identifier at pos that refers to a builtin, or
+// nil.
+func (d *document) builtinAt(pos Position) *ast.Identifier {
+	line, column := d.offset(pos)
+	for _, ident := range d.free {
+		tok := ident.Token
+		if tok.Line == line && tok.Column <= column && column <= tok.Column+len(ident.Value) &&
+			object.GetBuiltinByName(ident.Value) != nil {
+			return ident
+		}
+	}
+	return nil
+}
+
+// before reports whether a comes before b in the source.
+func before(a, b token.Token) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
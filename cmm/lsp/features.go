@@ -0,0 +1,417 @@
+package lsp
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+	"github.com/shoebilyas123/cminusminus/cmm/types"
+)
+
+func (s *server) definition(params json.RawMessage) (interface{}, error) {
+	d, pos, err := s.at(params)
+	if err != nil {
+		return nil, err
+	}
+
+	_, sym := d.identAt(pos)
+	if sym == nil {
+		return nil, nil
+	}
+	return Location{URI: d.uri, Range: d.identRange(sym.decl)}, nil
+}
+
+func (s *server) references(params json.RawMessage) (interface{}, error) {
+	var p ReferenceParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	d, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	_, sym := d.identAt(p.Position)
+	if sym == nil {
+		return []Location{}, nil
+	}
+
+	idents := sym.refs
+	if p.Context.IncludeDeclaration {
+		idents = append([]*ast.Identifier{sym.decl}, idents...)
+	}
+	locations := []Location{}
+	for _, ident := range sortedIdents(idents) {
+		locations = append(locations, Location{URI: d.uri, Range: d.identRange(ident)})
+	}
+	return locations, nil
+}
+
+// sortedIdents returns idents in the order they appear.
+func sortedIdents(idents []*ast.Identifier) []*ast.Identifier {
+	sorted := append([]*ast.Identifier(nil), idents...)
+	sort.Slice(sorted, func(i, j int) bool { return before(sorted[i].Token, sorted[j].Token) })
+	return sorted
+}
+
+func (s *server) hover(params json.RawMessage) (interface{}, error) {
+	d, pos, err := s.at(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var ident *ast.Identifier
+	var text string
+	if i, sym := d.identAt(pos); sym != nil {
+		ident, text = i, d.describe(sym)
+	} else if i := d.builtinAt(pos); i != nil {
+		ident, text = i, "builtin "+i.Value
+		if t, ok := d.types[i]; ok {
+			text += ": " + t.String()
+		}
+	} else {
+		return nil, nil
+	}
+
+	return Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```cmm\n" + text + "\n```"},
+		Range:    d.identRange(ident),
+	}, nil
+}
+
+// typeOf returns the type of sym, or nil if it is not known.
+func (d *document) typeOf(sym *symbol) types.Type {
+	if sym.let != nil {
+		return d.types[sym.let.Value]
+	}
+	if f, ok := d.types[sym.fn].(*types.Func); ok && sym.index < len(f.Params) {
+		return f.Params[sym.index]
+	}
+	return nil
+}
+
+// describe returns how hover shows sym: a function bound by a let with the
+// names and types of its parameters, when they are known, and anything
+// else with its type.
+func (d *document) describe(sym *symbol) string {
+	t := d.typeOf(sym)
+	if sym.let == nil {
+		if t == nil {
+			return "(parameter) " + sym.name
+		}
+		return "(parameter) " + sym.name + ": " + t.String()
+	}
+
+	f, ok := t.(*types.Func)
+	fn := sym.function()
+	if ok && fn != nil && !hasVars(f) && len(f.Params) == len(fn.Parameters) {
+		sig := "let " + sym.name + " = fn("
+		for i, p := range fn.Parameters {
+			if i > 0 {
+				sig += ", "
+			}
+			sig += p.Value + ": " + f.Params[i].String()
+		}
+		return sig + ") -> " + f.Result.String()
+	}
+	if t == nil {
+		return "let " + sym.name
+	}
+	return "let " + sym.name + ": " + t.String()
+}
+
+// hasVars reports whether t has type variables, which have to be shown
+// in one type to be named consistently.
+func hasVars(t types.Type) bool {
+	switch t := t.(type) {
+	case *types.Var:
+		return true
+	case *types.Func:
+		for _, p := range t.Params {
+			if hasVars(p) {
+				return true
+			}
+		}
+		return hasVars(t.Result)
+	}
+	return false
+}
+
+func (s *server) documentSymbol(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+	}
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	d, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	return d.documentSymbols(d.globals), nil
+}
+
+func (d *document) documentSymbols(syms []*symbol) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	for _, sym := range syms {
+		ds := DocumentSymbol{
+			Name:           sym.name,
+			Kind:           SymbolVariable,
+			Range:          d.identRange(sym.decl),
+			SelectionRange: d.identRange(sym.decl),
+		}
+		ds.Range.Start = d.position(sym.let.Token.Line, sym.let.Token.Column)
+		if t := d.typeOf(sym); t != nil {
+			ds.Detail = t.String()
+		}
+		if fn := sym.function(); fn != nil {
+			ds.Kind = SymbolFunction
+			if fn.Body != nil && fn.Body.Rbrace.Type == token.RBRACE {
+				ds.Range.End = d.position(fn.Body.Rbrace.Line, fn.Body.Rbrace.Column+1)
+			}
+			if len(sym.children) != 0 {
+				ds.Children = d.documentSymbols(sym.children)
+			}
+		}
+		symbols = append(symbols, ds)
+	}
+	return symbols
+}
+
+func (s *server) completion(params json.RawMessage) (interface{}, error) {
+	d, pos, err := s.at(params)
+	if err != nil {
+		return nil, err
+	}
+	line, column := d.offset(pos)
+	cursor := token.Token{Line: line, Column: column}
+
+	items := []CompletionItem{}
+	seen := map[string]bool{}
+	add := func(item CompletionItem) {
+		if !seen[item.Label] {
+			seen[item.Label] = true
+			items = append(items, item)
+		}
+	}
+
+	// the variables of the functions around the cursor, innermost first
+	var fns []*ast.FunctionLiteral
+	for fn := range d.scopes {
+		if fn != nil && d.contains(fn, cursor) {
+			fns = append(fns, fn)
+		}
+	}
+	sort.Slice(fns, func(i, j int) bool { return before(fns[j].Token, fns[i].Token) })
+	for _, fn := range append(fns, nil) {
+		for _, sym := range d.scopes[fn] {
+			if fn != nil && sym.let != nil && !before(sym.decl.Token, cursor) {
+				continue
+			}
+			item := CompletionItem{Label: sym.name, Kind: CompletionVariable}
+			if sym.function() != nil {
+				item.Kind = CompletionFunction
+			}
+			if t := d.typeOf(sym); t != nil {
+				item.Detail = t.String()
+			}
+			add(item)
+		}
+	}
+
+	for _, builtin := range object.Builtins {
+		add(CompletionItem{Label: builtin.Name, Kind: CompletionFunction, Detail: "builtin"})
+	}
+	for _, keyword := range token.Keywords() {
+		add(CompletionItem{Label: keyword, Kind: CompletionKeyword})
+	}
+	return items, nil
+}
+
+// contains reports whether pos is within fn, from fn to its closing
+// brace, or to the end of the document if it has none.
+func (d *document) contains(fn *ast.FunctionLiteral, pos token.Token) bool {
+	if before(pos, fn.Token) {
+		return false
+	}
+	if fn.Body == nil || fn.Body.Rbrace.Type != token.RBRACE {
+		return true
+	}
+	return before(pos, fn.Body.Rbrace) || pos == fn.Body.Rbrace
+}
+
+func (s *server) semanticTokens(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+	}
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	d, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	return SemanticTokens{Data: d.semanticTokens()}, nil
+}
+
+// semanticTokens returns the tokens and comments of the document, encoded
+// as the protocol asks: five numbers for each, the line and start
+// relative to the one before, the length, the type and the modifiers.
+func (d *document) semanticTokens() []int {
+	idents := map[[2]int]*ast.Identifier{}
+	for ident := range d.idents {
+		idents[[2]int{ident.Token.Line, ident.Token.Column}] = ident
+	}
+
+	type semantic struct {
+		tok        token.Token
+		length     int
+		kind, mods int
+	}
+	var found []semantic
+	for _, tok := range append(append([]token.Token(nil), d.tokens...), d.comments...) {
+		kind, mods := d.classify(tok, idents)
+		if kind < 0 {
+			continue
+		}
+		length := len(tok.Literal)
+		if tok.Type == token.STRING || tok.Type == token.ILLEGAL && len(tok.Literal) != 1 {
+			length = stringLength(d.lines[tok.Line-1][tok.Column-1:])
+		}
+		found = append(found, semantic{tok, length, kind, mods})
+	}
+	sort.Slice(found, func(i, j int) bool { return before(found[i].tok, found[j].tok) })
+
+	data := []int{}
+	prev := Position{}
+	for _, s := range found {
+		start := d.position(s.tok.Line, s.tok.Column)
+		end := d.position(s.tok.Line, s.tok.Column+s.length)
+		delta := start.Character
+		if start.Line == prev.Line {
+			delta -= prev.Character
+		}
+		data = append(data, start.Line-prev.Line, delta, end.Character-start.Character, s.kind, s.mods)
+		prev = start
+	}
+	return data
+}
+
+// The indexes of tokenTypes and the bits of tokenModifiers.
+const (
+	semanticKeyword = iota
+	semanticString
+	semanticNumber
+	semanticComment
+	semanticOperator
+	semanticFunction
+	semanticParameter
+	semanticVariable
+	semanticType
+
+	modifierDeclaration    = 1
+	modifierDefaultLibrary = 2
+)
+
+// classify returns the semantic token type and modifiers of tok, or -1
+// for a token that has none, such as punctuation.
+func (d *document) classify(tok token.Token, idents map[[2]int]*ast.Identifier) (int, int) {
+	switch tok.Type {
+	case token.FN, token.LET, token.IF, token.ELSE, token.RETURN, token.TRUE, token.FALSE:
+		return semanticKeyword, 0
+	case token.STRING:
+		return semanticString, 0
+	case token.INT:
+		return semanticNumber, 0
+	case token.COMMENT:
+		return semanticComment, 0
+	case token.ASSIGN, token.PLUS, token.MINUS, token.ASTERISK, token.FOR_SLASH, token.EXCLAIM,
+		token.GREATER, token.SMALLER, token.EQ, token.NOT_EQ, token.ARROW:
+		return semanticOperator, 0
+	case token.IDENT:
+	default:
+		return -1, 0
+	}
+
+	ident, ok := idents[[2]int{tok.Line, tok.Column}]
+	if !ok {
+		for _, free := range d.free {
+			if free.Token.Line == tok.Line && free.Token.Column == tok.Column {
+				if object.GetBuiltinByName(free.Value) != nil {
+					return semanticFunction, modifierDefaultLibrary
+				}
+				return semanticVariable, 0
+			}
+		}
+		// an identifier that is not an expression names a type
+		return semanticType, 0
+	}
+
+	sym := d.idents[ident]
+	mods := 0
+	if ident == sym.decl {
+		mods = modifierDeclaration
+	}
+	switch {
+	case sym.let == nil:
+		return semanticParameter, mods
+	case sym.function() != nil:
+		return semanticFunction, mods
+	}
+	return semanticVariable, mods
+}
+
+// stringLength returns the length of the string literal line starts with,
+// up to the end of the line.
+func stringLength(line string) int {
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(line)
+}
+
+func (s *server) rename(params json.RawMessage) (interface{}, error) {
+	var p RenameParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	d, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	_, sym := d.identAt(p.Position)
+	if sym == nil {
+		return nil, &ResponseError{Code: codeInvalidParams, Message: "there is no variable to rename here"}
+	}
+	if !validName(p.NewName) {
+		return nil, &ResponseError{Code: codeInvalidParams, Message: p.NewName + " is not a valid name"}
+	}
+
+	edits := []TextEdit{}
+	for _, ident := range sortedIdents(append([]*ast.Identifier{sym.decl}, sym.refs...)) {
+		edits = append(edits, TextEdit{Range: d.identRange(ident), NewText: p.NewName})
+	}
+	return WorkspaceEdit{Changes: map[string][]TextEdit{d.uri: edits}}, nil
+}
+
+// validName reports whether name can name a variable: it is made of
+// letters and underscores, and is not a keyword.
+func validName(name string) bool {
+	if name == "" || token.LookupIdentifier(name) != token.IDENT {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
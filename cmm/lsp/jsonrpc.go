@@ -0,0 +1,88 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// message is a JSON-RPC 2.0 request, notification or response. Requests
+// have an ID and a method, notifications only a method and responses only
+// an ID.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *ResponseError   `json:"error,omitempty"`
+}
+
+// ResponseError is the error of a request that failed.
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+// The error codes of JSON-RPC and LSP.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeServerNotReady = -32002
+)
+
+// conn reads and writes messages with the Content-Length headers of the
+// base protocol.
+type conn struct {
+	in  *textproto.Reader
+	out io.Writer
+}
+
+func newConn(in io.Reader, out io.Writer) *conn {
+	return &conn{in: textproto.NewReader(bufio.NewReader(in)), out: out}
+}
+
+// read returns the next message, or io.EOF at the end of the input.
+func (c *conn) read() (*message, error) {
+	header, err := c.in.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("bad Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.in.R, body); err != nil {
+		return nil, err
+	}
+
+	msg := &message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, &ResponseError{Code: codeParseError, Message: err.Error()}
+	}
+	return msg, nil
+}
+
+func (c *conn) write(msg *message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
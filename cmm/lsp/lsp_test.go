@@ -0,0 +1,379 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const uri = "file:///main.cmm"
+
+// session runs a server on the messages a client sends, and collects its
+// replies by ID and the diagnostics it publishes.
+type session struct {
+	t   *testing.T
+	in  bytes.Buffer
+	ids int
+
+	results     map[int]json.RawMessage
+	errors      map[int]*ResponseError
+	diagnostics [][]Diagnostic
+	err         error
+}
+
+func newSession(t *testing.T, text string) *session {
+	s := &session{t: t}
+	s.request("initialize", map[string]interface{}{})
+	s.notify("initialized", map[string]interface{}{})
+	s.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: uri, Text: text}})
+	return s
+}
+
+func (s *session) write(msg map[string]interface{}) {
+	msg["jsonrpc"] = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	fmt.Fprintf(&s.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// request sends a request and returns its ID.
+func (s *session) request(method string, params interface{}) int {
+	s.ids++
+	s.write(map[string]interface{}{"id": s.ids, "method": method, "params": params})
+	return s.ids
+}
+
+func (s *session) notify(method string, params interface{}) {
+	s.write(map[string]interface{}{"method": method, "params": params})
+}
+
+// at sends a request about pos in the document.
+func (s *session) at(method string, line, character int) int {
+	return s.request(method, TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: line, Character: character},
+	})
+}
+
+// run serves what the client sent and reads the replies.
+func (s *session) run() {
+	var out bytes.Buffer
+	s.err = Serve(&s.in, &out)
+	s.results = make(map[int]json.RawMessage)
+	s.errors = make(map[int]*ResponseError)
+
+	c := newConn(&out, nil)
+	for {
+		msg, err := c.read()
+		if err != nil {
+			break
+		}
+		switch {
+		case msg.Method == "textDocument/publishDiagnostics":
+			var p PublishDiagnosticsParams
+			if err := json.Unmarshal(msg.Params, &p); err != nil {
+				s.t.Fatal(err)
+			}
+			s.diagnostics = append(s.diagnostics, p.Diagnostics)
+		case msg.ID != nil:
+			var id int
+			json.Unmarshal(*msg.ID, &id)
+			if msg.Error != nil {
+				s.errors[id] = msg.Error
+			} else if msg.Result == nil {
+				s.results[id] = json.RawMessage("null")
+			} else {
+				s.results[id] = *msg.Result
+			}
+		}
+	}
+}
+
+// result decodes the result of request id into v.
+func (s *session) result(id int, v interface{}) {
+	s.t.Helper()
+	if err := s.errors[id]; err != nil {
+		s.t.Fatalf("request %d failed: %s", id, err.Message)
+	}
+	if err := json.Unmarshal(s.results[id], v); err != nil {
+		s.t.Fatalf("request %d: %v", id, err)
+	}
+}
+
+func rng(line, start, end int) Range {
+	return Range{Start: Position{line, start}, End: Position{line, end}}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"let x = 1;\nx", nil},
+		{"let x = ;", []string{"0:8-0:9 error cmm syntax: no prefix parse function for ; found"}},
+		{"let x = 1;\ny", []string{"1:0-1:1 error cmm resolve: undefined variable y", "0:4-0:5 warning cmm lint: x is never used"}},
+		{`1 + "a"`, []string{`0:0-0:1 warning cmm types: type mismatch: int + string in (1 + "a")`}},
+		{`let f = fn(x: int) { x }; f("a")`, []string{`0:28-0:29 error cmm types: cannot use "a" (string) as int in argument 1 to f`}},
+		{`let s = "é"; let y = 1; s`, []string{"0:17-0:18 warning cmm lint: y is never used"}},
+	}
+
+	for _, tt := range tests {
+		s := newSession(t, tt.input)
+		s.run()
+
+		var got []string
+		for _, d := range s.diagnostics[0] {
+			severity := map[int]string{SeverityError: "error", SeverityWarning: "warning"}[d.Severity]
+			got = append(got, fmt.Sprintf("%d:%d-%d:%d %s %s: %s", d.Range.Start.Line, d.Range.Start.Character,
+				d.Range.End.Line, d.Range.End.Character, severity, d.Source, d.Message))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("diagnostics of %q:\ngot  %q\nwant %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDocumentLifecycle(t *testing.T) {
+	s := newSession(t, "let x = 1;\nx")
+	s.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: uri},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "y"}},
+	})
+	s.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	hover := s.at("textDocument/hover", 0, 0)
+	s.request("shutdown", nil)
+	s.notify("exit", nil)
+	s.run()
+
+	if s.err != nil {
+		t.Errorf("Serve: %v", s.err)
+	}
+	if len(s.diagnostics) != 3 || len(s.diagnostics[0]) != 0 || len(s.diagnostics[1]) != 1 || len(s.diagnostics[2]) != 0 {
+		t.Errorf("diagnostics = %v, want none, one, then none", s.diagnostics)
+	}
+	if err := s.errors[hover]; err == nil || err.Code != codeInvalidParams {
+		t.Errorf("hover of a closed document: got %v, want an invalid params error", err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	s := &session{t: t}
+	early := s.request("textDocument/hover", map[string]interface{}{})
+	s.request("initialize", map[string]interface{}{})
+	unknown := s.request("textDocument/frobnicate", map[string]interface{}{})
+	s.notify("$/cancelRequest", map[string]interface{}{"id": 1})
+	s.notify("exit", nil)
+	s.run()
+
+	if err := s.errors[early]; err == nil || err.Code != codeServerNotReady {
+		t.Errorf("request before initialize: got %v, want server not ready", err)
+	}
+	if err := s.errors[unknown]; err == nil || err.Code != codeMethodNotFound {
+		t.Errorf("unknown request: got %v, want method not found", err)
+	}
+	if s.err != errNoShutdown {
+		t.Errorf("Serve = %v, want %v", s.err, errNoShutdown)
+	}
+}
+
+const program = `let add = fn(a: int, b: int) -> int {
+  let sum = a + b;
+  sum
+};
+let id = fn(x) { x };
+let total = add(1, 2);
+len("abc") + total + id(3)`
+
+func TestDefinitionAndReferences(t *testing.T) {
+	s := newSession(t, program)
+	def := s.at("textDocument/definition", 5, 13)   // add in add(1, 2)
+	param := s.at("textDocument/definition", 1, 16) // b in a + b
+	none := s.at("textDocument/definition", 6, 0)   // len
+	refs := s.request("textDocument/references", ReferenceParams{
+		TextDocumentPositionParams: TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: 0, Character: 5},
+		},
+		Context: struct {
+			IncludeDeclaration bool `json:"includeDeclaration"`
+		}{true},
+	})
+	s.run()
+
+	var loc Location
+	s.result(def, &loc)
+	if want := (Location{URI: uri, Range: rng(0, 4, 7)}); loc != want {
+		t.Errorf("definition of add = %v, want %v", loc, want)
+	}
+	s.result(param, &loc)
+	if want := (Location{URI: uri, Range: rng(0, 21, 22)}); loc != want {
+		t.Errorf("definition of b = %v, want %v", loc, want)
+	}
+	if got := string(s.results[none]); got != "null" {
+		t.Errorf("definition of len = %s, want null", got)
+	}
+
+	var locs []Location
+	s.result(refs, &locs)
+	want := []Location{{uri, rng(0, 4, 7)}, {uri, rng(5, 12, 15)}}
+	if !reflect.DeepEqual(locs, want) {
+		t.Errorf("references of add = %v, want %v", locs, want)
+	}
+}
+
+func TestHover(t *testing.T) {
+	tests := []struct {
+		line, character int
+		want            string
+	}{
+		{0, 5, "let add = fn(a: int, b: int) -> int"},
+		{1, 8, "let sum: int"},
+		{1, 12, "(parameter) a: int"},
+		{4, 5, "let id: fn(a) -> a"},
+		{5, 6, "let total: int"},
+		{6, 1, "builtin len: fn(string) -> int"},
+	}
+
+	s := newSession(t, program)
+	var ids []int
+	for _, tt := range tests {
+		ids = append(ids, s.at("textDocument/hover", tt.line, tt.character))
+	}
+	none := s.at("textDocument/hover", 6, 11)
+	s.run()
+
+	for i, tt := range tests {
+		var h Hover
+		s.result(ids[i], &h)
+		if want := "```cmm\n" + tt.want + "\n```"; h.Contents.Value != want {
+			t.Errorf("hover at %d:%d = %q, want %q", tt.line, tt.character, h.Contents.Value, want)
+		}
+	}
+	if got := string(s.results[none]); got != "null" {
+		t.Errorf("hover on an operator = %s, want null", got)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	s := newSession(t, program)
+	id := s.request("textDocument/documentSymbol", map[string]interface{}{"textDocument": TextDocumentIdentifier{URI: uri}})
+	s.run()
+
+	var symbols []DocumentSymbol
+	s.result(id, &symbols)
+	want := []DocumentSymbol{
+		{Name: "add", Detail: "fn(int, int) -> int", Kind: SymbolFunction,
+			Range:          Range{Start: Position{0, 0}, End: Position{3, 1}},
+			SelectionRange: rng(0, 4, 7),
+			Children: []DocumentSymbol{
+				{Name: "sum", Detail: "int", Kind: SymbolVariable, Range: rng(1, 2, 9), SelectionRange: rng(1, 6, 9)},
+			}},
+		{Name: "id", Detail: "fn(a) -> a", Kind: SymbolFunction, Range: rng(4, 0, 20), SelectionRange: rng(4, 4, 6)},
+		{Name: "total", Detail: "int", Kind: SymbolVariable, Range: rng(5, 0, 9), SelectionRange: rng(5, 4, 9)},
+	}
+	if !reflect.DeepEqual(symbols, want) {
+		t.Errorf("symbols =\n%+v\nwant\n%+v", symbols, want)
+	}
+}
+
+func TestSemanticTokens(t *testing.T) {
+	s := newSession(t, "let f = fn(a) { len(a) }; // f\nf(\"é\")")
+	id := s.request("textDocument/semanticTokens/full", map[string]interface{}{"textDocument": TextDocumentIdentifier{URI: uri}})
+	s.run()
+
+	var got SemanticTokens
+	s.result(id, &got)
+	want := []int{
+		0, 0, 3, semanticKeyword, 0,
+		0, 4, 1, semanticFunction, modifierDeclaration,
+		0, 2, 1, semanticOperator, 0,
+		0, 2, 2, semanticKeyword, 0,
+		0, 3, 1, semanticParameter, modifierDeclaration,
+		0, 5, 3, semanticFunction, modifierDefaultLibrary,
+		0, 4, 1, semanticParameter, 0,
+		0, 6, 4, semanticComment, 0,
+		1, 0, 1, semanticFunction, 0,
+		0, 2, 3, semanticString, 0,
+	}
+	if !reflect.DeepEqual(got.Data, want) {
+		t.Errorf("semantic tokens =\n%v\nwant\n%v", got.Data, want)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	s := newSession(t, program)
+	inner := s.at("textDocument/completion", 2, 2) // before sum
+	outer := s.at("textDocument/completion", 5, 0)
+	s.run()
+
+	labels := func(id int) map[string]CompletionItem {
+		var items []CompletionItem
+		s.result(id, &items)
+		m := make(map[string]CompletionItem)
+		for _, item := range items {
+			m[item.Label] = item
+		}
+		return m
+	}
+
+	got := labels(inner)
+	for _, name := range []string{"a", "b", "sum", "add", "id", "total", "len", "let", "fn"} {
+		if _, ok := got[name]; !ok {
+			t.Errorf("completion in add lacks %s", name)
+		}
+	}
+	if got["a"].Detail != "int" || got["add"].Kind != CompletionFunction || got["let"].Kind != CompletionKeyword {
+		t.Errorf("completion items = %+v, %+v, %+v", got["a"], got["add"], got["let"])
+	}
+
+	got = labels(outer)
+	for _, name := range []string{"a", "sum", "x"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("completion outside the functions offers %s", name)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	s := newSession(t, program)
+	rename := func(line, character int, name string) int {
+		return s.request("textDocument/rename", RenameParams{
+			TextDocumentPositionParams: TextDocumentPositionParams{
+				TextDocument: TextDocumentIdentifier{URI: uri},
+				Position:     Position{Line: line, Character: character},
+			},
+			NewName: name,
+		})
+	}
+	ok := rename(1, 12, "first")
+	keyword := rename(1, 12, "let")
+	invalid := rename(1, 12, "a1")
+	nothing := rename(6, 1, "size")
+	s.run()
+
+	var edit WorkspaceEdit
+	s.result(ok, &edit)
+	want := []TextEdit{{rng(0, 13, 14), "first"}, {rng(1, 12, 13), "first"}}
+	if !reflect.DeepEqual(edit.Changes[uri], want) {
+		t.Errorf("rename a = %v, want %v", edit.Changes[uri], want)
+	}
+	for _, id := range []int{keyword, invalid, nothing} {
+		if err := s.errors[id]; err == nil || err.Code != codeInvalidParams {
+			t.Errorf("rename %d: got %v, want an invalid params error", id, err)
+		}
+	}
+
+	// the edits applied give a program with the same meaning
+	text := strings.Replace(program, "a: int", "first: int", 1)
+	text = strings.Replace(text, "a + b", "first + b", 1)
+	s = newSession(t, text)
+	s.run()
+	if len(s.diagnostics[0]) != 0 {
+		t.Errorf("renamed program has diagnostics %v", s.diagnostics[0])
+	}
+}
@@ -0,0 +1,247 @@
+// Package lsp implements a language server for cmm, which editors talk to
+// with the Language Server Protocol over stdin and stdout.
+//
+// The server keeps the documents the editor opened and, whenever one
+// changes, parses, resolves, type checks and lints it and publishes what
+// it found as diagnostics. It finds the definition and references of the
+// variables that lets and parameters declare, and renames them, shows
+// their types on hover, and lists the symbols, semantic tokens and
+// completions of a document.
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// errNoShutdown is returned by Serve when the client exits without asking
+// the server to shut down first.
+var errNoShutdown = errors.New("exit without shutdown")
+
+type server struct {
+	conn *conn
+	docs map[string]*document
+
+	initialized bool
+	shutdown    bool
+}
+
+// request handles a request, whose result is sent back.
+type request func(s *server, params json.RawMessage) (interface{}, error)
+
+// notification handles a notification, which has no reply.
+type notification func(s *server, params json.RawMessage) error
+
+var requests = map[string]request{
+	"initialize":                       (*server).initialize,
+	"shutdown":                         (*server).shutdownRequest,
+	"textDocument/definition":          (*server).definition,
+	"textDocument/references":          (*server).references,
+	"textDocument/hover":               (*server).hover,
+	"textDocument/documentSymbol":      (*server).documentSymbol,
+	"textDocument/semanticTokens/full": (*server).semanticTokens,
+	"textDocument/completion":          (*server).completion,
+	"textDocument/rename":              (*server).rename,
+}
+
+var notifications = map[string]notification{
+	"initialized":            func(*server, json.RawMessage) error { return nil },
+	"textDocument/didOpen":   (*server).didOpen,
+	"textDocument/didChange": (*server).didChange,
+	"textDocument/didClose":  (*server).didClose,
+}
+
+// Serve runs a language server that reads messages from in and writes
+// them to out, until the client exits or in ends.
+func Serve(in io.Reader, out io.Writer) error {
+	s := &server{conn: newConn(in, out), docs: make(map[string]*document)}
+	for {
+		msg, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		var rpcErr *ResponseError
+		if errors.As(err, &rpcErr) {
+			s.reply(nil, nil, rpcErr)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errNoShutdown
+			}
+			return nil
+		}
+		if msg.ID == nil {
+			if err := s.notify(msg); err != nil {
+				return err
+			}
+			continue
+		}
+		result, err := s.handle(msg)
+		if err := s.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+// handle runs a request and returns its result.
+func (s *server) handle(msg *message) (interface{}, error) {
+	handler, ok := requests[msg.Method]
+	switch {
+	case !ok:
+		return nil, &ResponseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+	case !s.initialized && msg.Method != "initialize":
+		return nil, &ResponseError{Code: codeServerNotReady, Message: "server not initialized"}
+	case s.shutdown:
+		return nil, &ResponseError{Code: codeInvalidRequest, Message: "server is shut down"}
+	}
+	return handler(s, msg.Params)
+}
+
+// notify runs a notification. Those the server does not know are
+// dropped, as the protocol asks.
+func (s *server) notify(msg *message) error {
+	if handler, ok := notifications[msg.Method]; ok && s.initialized {
+		return handler(s, msg.Params)
+	}
+	return nil
+}
+
+func (s *server) reply(id *json.RawMessage, result interface{}, err error) error {
+	msg := &message{ID: id}
+	if err != nil {
+		var rpcErr *ResponseError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &ResponseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		msg.Error = rpcErr
+	} else {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		raw := json.RawMessage(data)
+		msg.Result = &raw
+	}
+	if id == nil {
+		null := json.RawMessage("null")
+		msg.ID = &null
+	}
+	return s.conn.write(msg)
+}
+
+func (s *server) send(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.conn.write(&message{Method: method, Params: data})
+}
+
+// decode unmarshals params into v.
+func decode(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &ResponseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// The types and modifiers of the semantic tokens, in the order of the
+// legend.
+var (
+	tokenTypes     = []string{"keyword", "string", "number", "comment", "operator", "function", "parameter", "variable", "type"}
+	tokenModifiers = []string{"declaration", "defaultLibrary"}
+)
+
+func (s *server) initialize(json.RawMessage) (interface{}, error) {
+	s.initialized = true
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":       1, // full
+			"definitionProvider":     true,
+			"referencesProvider":     true,
+			"hoverProvider":          true,
+			"documentSymbolProvider": true,
+			"renameProvider":         true,
+			"completionProvider":     map[string]interface{}{},
+			"semanticTokensProvider": map[string]interface{}{
+				"legend": map[string]interface{}{
+					"tokenTypes":     tokenTypes,
+					"tokenModifiers": tokenModifiers,
+				},
+				"full": true,
+			},
+		},
+		"serverInfo": map[string]string{"name": "cminusminus"},
+	}, nil
+}
+
+func (s *server) shutdownRequest(json.RawMessage) (interface{}, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+func (s *server) didOpen(params json.RawMessage) error {
+	var p DidOpenTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil
+	}
+	return s.update(p.TextDocument.URI, p.TextDocument.Text)
+}
+
+func (s *server) didChange(params json.RawMessage) error {
+	var p DidChangeTextDocumentParams
+	if err := decode(params, &p); err != nil || len(p.ContentChanges) == 0 {
+		return nil
+	}
+	return s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+}
+
+func (s *server) didClose(params json.RawMessage) error {
+	var p DidCloseTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil
+	}
+	delete(s.docs, p.TextDocument.URI)
+	return s.send("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         p.TextDocument.URI,
+		Diagnostics: []Diagnostic{},
+	})
+}
+
+// update analyzes the new text of a document and publishes its
+// diagnostics.
+func (s *server) update(uri, text string) error {
+	d := newDocument(uri, text)
+	s.docs[uri] = d
+
+	diagnostics := d.diagnostics
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+	return s.send("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+}
+
+// document returns the open document uri names.
+func (s *server) document(uri string) (*document, error) {
+	d, ok := s.docs[uri]
+	if !ok {
+		return nil, &ResponseError{Code: codeInvalidParams, Message: "document not open: " + uri}
+	}
+	return d, nil
+}
+
+// at decodes the params of a request about a position in a document.
+func (s *server) at(params json.RawMessage) (*document, Position, error) {
+	var p TextDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, Position{}, err
+	}
+	d, err := s.document(p.TextDocument.URI)
+	return d, p.Position, err
+}
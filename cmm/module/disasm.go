@@ -0,0 +1,72 @@
+package module
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/code"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// Disassemble writes a listing of the instructions of m: the top level
+// first, then each function in the constant pool. Each run of instructions
+// is headed by the source line it was compiled from.
+func Disassemble(w io.Writer, m *Module) {
+	source := strings.Split(m.Source, "\n")
+
+	fmt.Fprintln(w, "== main ==")
+	disassemble(w, m.Bytecode.Instructions, m.Bytecode.Lines, m.Bytecode.Constants, source)
+
+	for i, c := range m.Bytecode.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		fmt.Fprintf(w, "\n== fn %s(%s) constant %d, %d locals ==\n",
+			name, strings.Join(fn.Parameters, ", "), i, fn.NumLocals)
+		disassemble(w, fn.Instructions, fn.Lines, m.Bytecode.Constants, source)
+	}
+}
+
+func disassemble(w io.Writer, ins code.Instructions, lines code.LineTable,
+	constants []object.Object, source []string) {
+	line := 0
+	for i := 0; i < len(ins); {
+		if l := lines.Line(i); l != line {
+			line = l
+			fmt.Fprintf(w, "%4d| %s\n", line, sourceLine(source, line))
+		}
+
+		text, next := ins.Instruction(i)
+		if code.Opcode(ins[i]) == code.OpConstant {
+			index := int(code.ReadUint32(ins[i+1:]))
+			if index < len(constants) {
+				text += "\t; " + inspectConstant(constants[index])
+			}
+		}
+		fmt.Fprintf(w, "%04d %s\n", i, text)
+
+		i = next
+	}
+}
+
+func sourceLine(source []string, line int) string {
+	if line < 1 || line > len(source) {
+		return ""
+	}
+	return strings.TrimSpace(source[line-1])
+}
+
+func inspectConstant(obj object.Object) string {
+	if str, ok := obj.(*object.StringObject); ok {
+		return ast.QuoteString(str.Value)
+	}
+	return obj.Inspect()
+}
@@ -0,0 +1,299 @@
+package module
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/shoebilyas123/cminusminus/cmm/code"
+	"github.com/shoebilyas123/cminusminus/cmm/compiler"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// Header flags.
+const flagOptimized byte = 1
+
+// Constant tags.
+const (
+	constInteger  byte = 'i'
+	constString   byte = 's'
+	constFunction byte = 'f'
+)
+
+// Marshal encodes m in the .cmmc format.
+func (m *Module) Marshal() []byte {
+	e := &encoder{}
+
+	e.buf.WriteString(Magic)
+	e.uint16(Version)
+	var flags byte
+	if m.Optimized {
+		flags |= flagOptimized
+	}
+	e.buf.WriteByte(flags)
+	e.uint32(m.SourceSum)
+
+	e.strings(m.Bytecode.Globals)
+	e.function(&object.CompiledFunction{
+		Instructions: m.Bytecode.Instructions,
+		Lines:        m.Bytecode.Lines,
+	})
+
+	e.uvarint(uint64(len(m.Bytecode.Constants)))
+	for _, c := range m.Bytecode.Constants {
+		e.constant(c)
+	}
+
+	e.string(m.Source)
+
+	e.uint32(crc32.ChecksumIEEE(e.buf.Bytes()))
+	return e.buf.Bytes()
+}
+
+// Unmarshal decodes a module in the .cmmc format.
+func Unmarshal(data []byte) (*Module, error) {
+	if len(data) < len(Magic)+2+1+4+4 || string(data[:len(Magic)]) != Magic {
+		return nil, ErrFormat
+	}
+
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, ErrChecksum
+	}
+
+	d := &decoder{data: body, pos: len(Magic)}
+	if version := d.uint16(); version != Version {
+		return nil, fmt.Errorf("%w: %d", ErrVersion, version)
+	}
+
+	m := &Module{Bytecode: &compiler.Bytecode{}}
+	if flags := d.next(1); flags != nil {
+		m.Optimized = flags[0]&flagOptimized != 0
+	}
+	m.SourceSum = d.uint32()
+	m.Bytecode.Globals = d.strings()
+
+	main := d.function()
+	m.Bytecode.Instructions = main.Instructions
+	m.Bytecode.Lines = main.Lines
+
+	n := d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		m.Bytecode.Constants = append(m.Bytecode.Constants, d.constant())
+	}
+
+	m.Source = d.string()
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrFormat, len(d.data)-d.pos)
+	}
+	return m, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf.Write(binary.BigEndian.AppendUint16(nil, v))
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf.Write(binary.BigEndian.AppendUint32(nil, v))
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf.Write(binary.AppendVarint(nil, v))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) strings(ss []string) {
+	e.uvarint(uint64(len(ss)))
+	for _, s := range ss {
+		e.string(s)
+	}
+}
+
+func (e *encoder) function(fn *object.CompiledFunction) {
+	e.string(fn.Name)
+	e.strings(fn.Parameters)
+	e.strings(fn.LocalNames)
+	e.uvarint(uint64(fn.NumLocals))
+	e.uvarint(uint64(fn.NumParameters))
+	e.string(fn.Body)
+	e.bytes(fn.Instructions)
+
+	e.uvarint(uint64(len(fn.Lines)))
+	for _, l := range fn.Lines {
+		e.uvarint(uint64(l.Offset))
+		e.uvarint(uint64(l.Line))
+	}
+}
+
+func (e *encoder) constant(obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.IntegerObject:
+		e.buf.WriteByte(constInteger)
+		e.varint(obj.Value)
+	case *object.StringObject:
+		e.buf.WriteByte(constString)
+		e.string(obj.Value)
+	case *object.CompiledFunction:
+		e.buf.WriteByte(constFunction)
+		e.function(obj)
+	default:
+		// the compiler only emits the constants above
+		panic(fmt.Sprintf("module: cannot encode constant %s", obj.Type()))
+	}
+}
+
+// decoder reads values from data. The first error sticks: after it every
+// read returns a zero value, so that callers only check err at the end.
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: truncated at byte %d", ErrFormat, d.pos)
+	}
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || n < 0 || len(d.data)-d.pos < n {
+		d.fail()
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+// length reads a count of items that each take at least one byte, so that
+// a corrupt count cannot make the decoder allocate more than the input.
+func (d *decoder) length() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)-d.pos) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	b := d.next(d.length())
+	return append([]byte(nil), b...)
+}
+
+func (d *decoder) string() string {
+	return string(d.next(d.length()))
+}
+
+func (d *decoder) strings() []string {
+	n := d.length()
+	ss := make([]string, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		ss = append(ss, d.string())
+	}
+	return ss
+}
+
+func (d *decoder) function() *object.CompiledFunction {
+	fn := &object.CompiledFunction{}
+	fn.Name = d.string()
+	fn.Parameters = d.strings()
+	fn.LocalNames = d.strings()
+	fn.NumLocals = int(d.uvarint())
+	fn.NumParameters = int(d.uvarint())
+	fn.Body = d.string()
+	fn.Instructions = code.Instructions(d.bytes())
+
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		offset := int(d.uvarint())
+		line := int(d.uvarint())
+		fn.Lines = append(fn.Lines, code.LineEntry{Offset: offset, Line: line})
+	}
+	return fn
+}
+
+func (d *decoder) constant() object.Object {
+	tag := d.next(1)
+	if tag == nil {
+		return nil
+	}
+
+	switch tag[0] {
+	case constInteger:
+		return object.NewInteger(d.varint())
+	case constString:
+		return &object.StringObject{Value: d.string()}
+	case constFunction:
+		return d.function()
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("%w: unknown constant tag %q", ErrFormat, tag[0])
+		}
+		return nil
+	}
+}
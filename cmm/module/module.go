@@ -0,0 +1,146 @@
+// Package module stores compiled programs in .cmmc files, so that they can
+// be run or inspected without lexing and parsing their source again.
+//
+// A .cmmc file is laid out as follows, with integers as varints unless
+// noted otherwise:
+//
+//	magic        "CMMC"
+//	version      uint16, big endian
+//	flags        byte: 1 if the program was optimized
+//	source sum   uint32, big endian: CRC-32 of the source it was compiled from
+//	globals      count, then each name
+//	main         the prototype of the program's top level
+//	constants    count, then each tagged constant
+//	source       the source text, for disassembly
+//	checksum     uint32, big endian: CRC-32 of everything before it
+//
+// A function prototype holds its name, parameter and local names, number
+// of locals and parameters, body text, instructions and line table.
+package module
+
+import (
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shoebilyas123/cminusminus/cmm/compiler"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/optimizer"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+	"github.com/shoebilyas123/cminusminus/cmm/types"
+)
+
+const (
+	Magic   = "CMMC"
+	Version = 4
+
+	// Ext is the extension of compiled module files.
+	Ext = ".cmmc"
+)
+
+var (
+	ErrFormat   = errors.New("not a .cmmc file")
+	ErrVersion  = errors.New("unsupported .cmmc version")
+	ErrChecksum = errors.New(".cmmc checksum mismatch")
+)
+
+// Module is a compiled program along with the source it came from.
+type Module struct {
+	Bytecode *compiler.Bytecode
+	Source   string
+
+	// SourceSum is the CRC-32 of Source. A cached module is only used
+	// when it matches the source file.
+	SourceSum uint32
+
+	// Optimized is whether the program went through the optimizer.
+	Optimized bool
+}
+
+// Compile parses, type checks and compiles source, optimizing it first if
+// optimize is set.
+func Compile(source string, optimize bool) (*Module, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+
+	if errs := types.Blocking(types.Check(program)); len(errs) != 0 {
+		joined := make([]error, len(errs))
+		for i, err := range errs {
+			joined[i] = err
+		}
+		return nil, errors.Join(joined...)
+	}
+
+	if optimize {
+		optimizer.Optimize(program)
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+
+	return &Module{
+		Bytecode:  comp.Bytecode(),
+		Source:    source,
+		SourceSum: crc32.ChecksumIEEE([]byte(source)),
+		Optimized: optimize,
+	}, nil
+}
+
+// CachePath returns the path of the .cmmc file compiled from the source
+// file at path.
+func CachePath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + Ext
+}
+
+// ReadFile reads the .cmmc file at path.
+func ReadFile(path string) (*Module, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Unmarshal(data)
+}
+
+// WriteFile writes m to the .cmmc file at path.
+func (m *Module) WriteFile(path string) error {
+	return os.WriteFile(path, m.Marshal(), 0644)
+}
+
+// Load returns the program at path. A .cmmc file is read as is. For a
+// source file, the .cmmc next to it is used when it was compiled from the
+// same source, with the same optimize setting; otherwise the source is
+// compiled, and written to that .cmmc only if save is set.
+func Load(path string, optimize, save bool) (*Module, error) {
+	if filepath.Ext(path) == Ext {
+		return ReadFile(path)
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := CachePath(path)
+	m, err := ReadFile(cache)
+	if err == nil && m.SourceSum == crc32.ChecksumIEEE(source) && m.Optimized == optimize {
+		return m, nil
+	}
+
+	m, err = Compile(string(source), optimize)
+	if err != nil {
+		return nil, err
+	}
+	if save {
+		if err := m.WriteFile(cache); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
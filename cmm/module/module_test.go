@@ -0,0 +1,211 @@
+package module
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+	"github.com/shoebilyas123/cminusminus/cmm/vm"
+)
+
+const program = `let greet = fn(name) {
+  "hello " + name
+};
+let n = 40 + 2;
+greet("cmm")`
+
+func compileProgram(t *testing.T, source string) *Module {
+	t.Helper()
+
+	m, err := Compile(source, false)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	return m
+}
+
+func runModule(t *testing.T, m *Module) string {
+	t.Helper()
+
+	machine := vm.New(m.Bytecode)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	return machine.LastPoppedStackElem().Inspect()
+}
+
+func TestRoundTrip(t *testing.T) {
+	m := compileProgram(t, program)
+
+	decoded, err := Unmarshal(m.Marshal())
+	if err != nil {
+		t.Fatalf("unmarshal error: %s", err)
+	}
+
+	if decoded.Source != m.Source || decoded.SourceSum != m.SourceSum {
+		t.Errorf("source not preserved")
+	}
+
+	var want, got bytes.Buffer
+	Disassemble(&want, m)
+	Disassemble(&got, decoded)
+	if want.String() != got.String() {
+		t.Errorf("listing changed.\nwant=\n%s\ngot=\n%s", want.String(), got.String())
+	}
+
+	if result := runModule(t, decoded); result != "hello cmm" {
+		t.Errorf("wrong result. want=%q, got=%q", "hello cmm", result)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	data := compileProgram(t, program).Marshal()
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)/2] ^= 0xff
+
+	badVersion := append([]byte(nil), data[:len(data)-4]...)
+	badVersion[len(Magic)+1] = 99
+	badVersion = binary.BigEndian.AppendUint32(badVersion, crc32.ChecksumIEEE(badVersion))
+
+	truncated := append([]byte(nil), data[:len(data)/2]...)
+	truncated = binary.BigEndian.AppendUint32(truncated, crc32.ChecksumIEEE(truncated))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"source", []byte(program), ErrFormat},
+		{"empty", nil, ErrFormat},
+		{"corrupt", corrupt, ErrChecksum},
+		{"version", badVersion, ErrVersion},
+		{"truncated", truncated, ErrFormat},
+	}
+
+	for _, tt := range tests {
+		_, err := Unmarshal(tt.data)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. want=%v, got=%v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestLoadUsesCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.cmm")
+	if err := os.WriteFile(path, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Load(path, false, false)
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if runModule(t, m) != "hello cmm" {
+		t.Fatalf("wrong result from source")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.cmmc")); !os.IsNotExist(err) {
+		t.Fatalf("cache written without save: %v", err)
+	}
+
+	if _, err := Load(path, false, true); err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.cmmc")); err != nil {
+		t.Fatalf("cache not written: %s", err)
+	}
+
+	// A cache compiled from the same source is used as is, without
+	// compiling the source again.
+	other := compileProgram(t, `"from cache"`)
+	other.SourceSum = m.SourceSum
+	if err := other.WriteFile(CachePath(path)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = Load(path, false, false)
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if result := runModule(t, m); result != "from cache" {
+		t.Errorf("cache not used. got=%q", result)
+	}
+
+	// Changing the source makes the cache stale.
+	if err := os.WriteFile(path, []byte(`"changed"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = Load(path, false, true)
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if result := runModule(t, m); result != "changed" {
+		t.Errorf("stale cache used. got=%q", result)
+	}
+
+	// So does asking for an optimized program.
+	m, err = Load(path, true, true)
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if !m.Optimized {
+		t.Errorf("cache compiled without optimizing used")
+	}
+
+	m, err = ReadFile(CachePath(path))
+	if err != nil || !m.Optimized {
+		t.Errorf("optimized program not cached: %v", err)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	var out bytes.Buffer
+	Disassemble(&out, compileProgram(t, program))
+
+	expected := `== main ==
+   1| let greet = fn(name) {
+0000 OpClosure 1 0
+0006 OpSetGlobal 0
+   4| let n = 40 + 2;
+0009 OpConstant 2	; 40
+0014 OpConstant 3	; 2
+0019 OpAdd
+0020 OpSetGlobal 1
+   5| greet("cmm")
+0023 OpGetGlobal 0
+0026 OpConstant 4	; "cmm"
+0031 OpCall 1
+0033 OpPop
+
+== fn greet(name) constant 1, 1 locals ==
+   2| "hello " + name
+0000 OpConstant 0	; "hello "
+0005 OpGetLocal 0
+0008 OpAdd
+0009 OpReturnValue
+`
+
+	if out.String() != expected {
+		t.Errorf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestCompileParseError(t *testing.T) {
+	_, err := Compile("let = 1;", false)
+
+	var parseErr *parser.ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected *parser.ParseError, got=%T (%v)", err, err)
+	}
+	if !strings.HasPrefix(err.Error(), "parse error: ") {
+		t.Errorf("wrong message: %q", err.Error())
+	}
+}
@@ -0,0 +1,114 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// NewBuiltin wraps the Go function fn as a Builtin called name. Arguments
+// are converted to fn's parameter types when it is called, as described in
+// FromGo and ToGo, and fn may be variadic.
+//
+// fn may return nothing, a value, an error, or a value and an error. A
+// non-nil error, a failed conversion or a panic in fn all become an
+// *ErrorObject for the calling program.
+func NewBuiltin(name string, fn interface{}) (*Builtin, error) {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func || fv.IsNil() {
+		return nil, fmt.Errorf("%s: cannot make a builtin of %T", name, fn)
+	}
+
+	ft := fv.Type()
+	switch ft.NumOut() {
+	case 0:
+	case 1:
+	case 2:
+		if ft.Out(1) != errorType {
+			return nil, fmt.Errorf("%s: second result of %s is not an error", name, ft)
+		}
+	default:
+		return nil, fmt.Errorf("%s: %s returns more than two values", name, ft)
+	}
+
+	builtin := &Builtin{Name: name}
+	builtin.Fn = func(args ...Object) Object {
+		in, err := convertArguments(ft, args)
+		if err != nil {
+			return &ErrorObject{Message: fmt.Sprintf("%s: %s", name, err)}
+		}
+
+		out, err := callGo(fv, in)
+		if err != nil {
+			return &ErrorObject{Message: fmt.Sprintf("%s: %s", name, err)}
+		}
+
+		return convertResults(name, out)
+	}
+
+	return builtin, nil
+}
+
+func convertArguments(ft reflect.Type, args []Object) ([]reflect.Value, error) {
+	fixed := ft.NumIn()
+	if ft.IsVariadic() {
+		fixed--
+		if len(args) < fixed {
+			return nil, fmt.Errorf("wrong number of arguments. got=%d, want at least %d", len(args), fixed)
+		}
+	} else if len(args) != fixed {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), fixed)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var t reflect.Type
+		if i < fixed {
+			t = ft.In(i)
+		} else {
+			t = ft.In(fixed).Elem()
+		}
+
+		v, err := toValue(arg, t)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i+1, err)
+		}
+		in[i] = v
+	}
+
+	return in, nil
+}
+
+// callGo calls fv and turns a panic into an error.
+func callGo(fv reflect.Value, in []reflect.Value) (out []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return fv.Call(in), nil
+}
+
+func convertResults(name string, out []reflect.Value) Object {
+	if len(out) == 0 {
+		return NULL
+	}
+
+	last := out[len(out)-1]
+	if last.Type() == errorType {
+		if !last.IsNil() {
+			return &ErrorObject{Message: fmt.Sprintf("%s: %s", name, last.Interface().(error))}
+		}
+		out = out[:len(out)-1]
+		if len(out) == 0 {
+			return NULL
+		}
+	}
+
+	obj, err := fromValue(out[0])
+	if err != nil {
+		return &ErrorObject{Message: fmt.Sprintf("%s: %s", name, err)}
+	}
+
+	return obj
+}
@@ -0,0 +1,118 @@
+package object
+
+import (
+	"errors"
+	"testing"
+)
+
+type userID int32
+
+func TestNewBuiltin(t *testing.T) {
+	tests := []struct {
+		fn       interface{}
+		args     []Object
+		expected Object
+	}{
+		{
+			func(a, b int64) int64 { return a + b },
+			[]Object{&IntegerObject{Value: 2}, &IntegerObject{Value: 3}},
+			&IntegerObject{Value: 5},
+		},
+		{
+			func(id userID, name string) (string, error) { return name, nil },
+			[]Object{&IntegerObject{Value: 1}, &StringObject{Value: "ada"}},
+			&StringObject{Value: "ada"},
+		},
+		{
+			func(parts ...string) int { return len(parts) },
+			[]Object{&StringObject{Value: "a"}, &StringObject{Value: "b"}},
+			&IntegerObject{Value: 2},
+		},
+		{
+			func(v interface{}) bool { return v == nil },
+			[]Object{NULL},
+			TRUE,
+		},
+		{
+			func(obj Object) Object { return obj },
+			[]Object{FALSE},
+			FALSE,
+		},
+		{
+			func() {},
+			[]Object{},
+			NULL,
+		},
+	}
+
+	for i, tt := range tests {
+		builtin, err := NewBuiltin("fn", tt.fn)
+		if err != nil {
+			t.Fatalf("tests[%d] - NewBuiltin failed: %v", i, err)
+		}
+
+		result := builtin.Fn(tt.args...)
+		if result.Type() != tt.expected.Type() || result.Inspect() != tt.expected.Inspect() {
+			t.Errorf("tests[%d] - wrong result. expected=%s, got=%s", i, tt.expected.Inspect(), result.Inspect())
+		}
+	}
+}
+
+func TestNewBuiltinErrors(t *testing.T) {
+	tests := []struct {
+		fn       interface{}
+		args     []Object
+		expected string
+	}{
+		{
+			func(a int64) int64 { return a },
+			[]Object{},
+			"fn: wrong number of arguments. got=0, want=1",
+		},
+		{
+			func(a int64) int64 { return a },
+			[]Object{TRUE},
+			"fn: argument 1: cannot use BOOLEAN as int64",
+		},
+		{
+			func(a int8) int8 { return a },
+			[]Object{&IntegerObject{Value: 300}},
+			"fn: argument 1: 300 overflows int8",
+		},
+		{
+			func() error { return errors.New("no route") },
+			[]Object{},
+			"fn: no route",
+		},
+		{
+			func() int { panic("boom") },
+			[]Object{},
+			"fn: panic: boom",
+		},
+		{
+			func() float64 { return 1.5 },
+			[]Object{},
+			"fn: cannot convert float64 to a cmm value",
+		},
+	}
+
+	for i, tt := range tests {
+		builtin, err := NewBuiltin("fn", tt.fn)
+		if err != nil {
+			t.Fatalf("tests[%d] - NewBuiltin failed: %v", i, err)
+		}
+
+		errObj, ok := builtin.Fn(tt.args...).(*ErrorObject)
+		if !ok {
+			t.Errorf("tests[%d] - no error object returned", i)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("tests[%d] - wrong error message. expected=%q, got=%q", i, tt.expected, errObj.Message)
+		}
+	}
+
+	if _, err := NewBuiltin("fn", func() (int, int) { return 0, 0 }); err == nil {
+		t.Errorf("NewBuiltin accepted a function whose second result is not an error")
+	}
+}
@@ -0,0 +1,132 @@
+package object
+
+import (
+	"fmt"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+)
+
+// Builtins are the functions available to every program. The compiler
+// refers to them by their index in this list, so new builtins go at the
+// end.
+var Builtins = []*Builtin{
+	{
+		Name: "len",
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *StringObject:
+				return NewInteger(int64(len(arg.Value)))
+			case *ArrayObject:
+				return NewInteger(int64(len(arg.Elements)))
+			default:
+				return newError("argument to `len` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	{
+		Name: "assert",
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch args[0] {
+			case TRUE:
+				return NULL
+			case FALSE:
+				return newError("assertion failed")
+			default:
+				return newError("argument to `assert` must be BOOLEAN, got %s", args[0].Type())
+			}
+		},
+	},
+	{
+		Name: "assert_eq",
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+
+			if !Equal(args[0], args[1]) {
+				return newError("assert_eq failed: got %s, want %s", Quote(args[0]), Quote(args[1]))
+			}
+			return NULL
+		},
+	},
+	{
+		Name: "assert_error",
+		CallFn: func(call Caller, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch args[0].Type() {
+			case FUNCTION_OBJ, BUILTIN_OBJ:
+			default:
+				return newError("argument to `assert_error` must be FUNCTION, got %s", args[0].Type())
+			}
+
+			result := call(args[0])
+			if err, ok := result.(*ErrorObject); ok {
+				return &StringObject{Value: err.Message}
+			}
+			return newError("assert_error failed: the function returned %s instead of failing", Quote(result))
+		},
+	},
+}
+
+// Equal reports whether two values are the same: integers, strings and
+// booleans with the same value, arrays with equal elements, or the same
+// function.
+func Equal(a, b Object) bool {
+	switch a := a.(type) {
+	case *IntegerObject:
+		b, ok := b.(*IntegerObject)
+		return ok && a.Value == b.Value
+	case *StringObject:
+		b, ok := b.(*StringObject)
+		return ok && a.Value == b.Value
+	case *BooleanObject:
+		b, ok := b.(*BooleanObject)
+		return ok && a.Value == b.Value
+	case *ArrayObject:
+		b, ok := b.(*ArrayObject)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !Equal(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// Quote returns how a value is written in messages: as Inspect does, but
+// with strings quoted.
+func Quote(obj Object) string {
+	if s, ok := obj.(*StringObject); ok {
+		return ast.QuoteString(s.Value)
+	}
+	return obj.Inspect()
+}
+
+// GetBuiltinByName returns the builtin called name, or nil.
+func GetBuiltinByName(name string) *Builtin {
+	for _, builtin := range Builtins {
+		if builtin.Name == name {
+			return builtin
+		}
+	}
+	return nil
+}
+
+func newError(format string, a ...interface{}) *ErrorObject {
+	return &ErrorObject{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,150 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+var (
+	objectType = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// FromGo converts a Go value to the Object that represents it. It accepts
+// nil, booleans, integers, strings, slices of those (as ARRAY), nil
+// pointers and interfaces (as NULL) and Objects, which are returned
+// unchanged. Named types are converted by their underlying kind.
+func FromGo(v interface{}) (Object, error) {
+	if v == nil {
+		return NULL, nil
+	}
+	if obj, ok := v.(Object); ok {
+		return obj, nil
+	}
+
+	return fromValue(reflect.ValueOf(v))
+}
+
+func fromValue(v reflect.Value) (Object, error) {
+	if v.Kind() != reflect.Interface && v.Type().Implements(objectType) {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return NULL, nil
+		}
+		return v.Interface().(Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return NativeBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewInteger(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fromUnsigned(v.Uint())
+	case reflect.String:
+		return &StringObject{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		array := &ArrayObject{Elements: make([]Object, v.Len())}
+		for i := range array.Elements {
+			e, err := fromValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			array.Elements[i] = e
+		}
+		return array, nil
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		if v.Kind() == reflect.Interface {
+			return FromGo(v.Elem().Interface())
+		}
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to a cmm value", v.Type())
+}
+
+func fromUnsigned(v uint64) (Object, error) {
+	if v > math.MaxInt64 {
+		return nil, fmt.Errorf("%d overflows INTEGER", v)
+	}
+
+	return NewInteger(int64(v)), nil
+}
+
+// ToGo converts obj to the Go value it represents: int64 for INTEGER, bool
+// for BOOLEAN, string for STRING, []interface{} for ARRAY and nil for
+// NULL. Values that have no Go counterpart, such as functions, are returned
+// as they are.
+func ToGo(obj Object) interface{} {
+	switch obj := obj.(type) {
+	case nil, *NullObject:
+		return nil
+	case *IntegerObject:
+		return obj.Value
+	case *BooleanObject:
+		return obj.Value
+	case *StringObject:
+		return obj.Value
+	case *ReturnObject:
+		return ToGo(obj.Value)
+	case *ArrayObject:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, e := range obj.Elements {
+			elements[i] = ToGo(e)
+		}
+		return elements
+	}
+
+	return obj
+}
+
+// toValue converts obj to a Go value of type t. Parameters of type Object,
+// or of a concrete Object type, receive obj itself, and empty interfaces
+// receive ToGo(obj).
+func toValue(obj Object, t reflect.Type) (reflect.Value, error) {
+	if reflect.TypeOf(obj).AssignableTo(t) && (t.Kind() != reflect.Interface || t.Implements(objectType)) {
+		v := reflect.New(t).Elem()
+		v.Set(reflect.ValueOf(obj))
+		return v, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			if goValue := ToGo(obj); goValue != nil {
+				return reflect.ValueOf(goValue), nil
+			}
+			return reflect.Zero(t), nil
+		}
+	case reflect.Bool:
+		if b, ok := obj.(*BooleanObject); ok {
+			return reflect.ValueOf(b.Value).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*IntegerObject); ok {
+			v := reflect.New(t).Elem()
+			if v.OverflowInt(i.Value) {
+				return v, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return v, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*IntegerObject); ok {
+			v := reflect.New(t).Elem()
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return v, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return v, nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*StringObject); ok {
+			return reflect.ValueOf(s.Value).Convert(t), nil
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+}
@@ -1,11 +1,20 @@
 package object
 
+import "sort"
+
+// Environment holds the variables of the program or of one function call.
+// Variables the resolver bound live in slots; the others, globals among
+// them, are kept by name.
 type Environment struct {
 	store      map[string]Object
+	slots      []Object
 	outerScope *Environment
 }
 
 func (env *Environment) Set(key string, value Object) Object {
+	if env.store == nil {
+		env.store = make(map[string]Object)
+	}
 	env.store[key] = value
 	return value
 }
@@ -19,6 +28,54 @@ func (env *Environment) Get(key string) (Object, bool) {
 	return value, ok
 }
 
+// Names returns the names of the variables kept by name in env and the
+// scopes around it, sorted and without repeats.
+func (env *Environment) Names() []string {
+	seen := map[string]bool{}
+	for ; env != nil; env = env.outerScope {
+		for name := range env.store {
+			seen[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Outer returns the environment env is nested in, or nil for the
+// environment of a program.
+func (env *Environment) Outer() *Environment {
+	return env.outerScope
+}
+
+// Variables returns a copy of the variables kept by name in env itself,
+// leaving out the scopes around it.
+func (env *Environment) Variables() map[string]Object {
+	variables := make(map[string]Object, len(env.store))
+	for name, value := range env.store {
+		variables[name] = value
+	}
+	return variables
+}
+
+// GetAt returns the value in slot of the environment depth levels out from
+// env, or nil if nothing has been stored there yet.
+func (env *Environment) GetAt(depth, slot int) Object {
+	for ; depth > 0; depth-- {
+		env = env.outerScope
+	}
+	return env.slots[slot]
+}
+
+func (env *Environment) SetAt(slot int, value Object) Object {
+	env.slots[slot] = value
+	return value
+}
+
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
 
@@ -28,3 +85,9 @@ func NewEnvironment() *Environment {
 func NewClosure(outerScope *Environment) *Environment {
 	return &Environment{outerScope: outerScope, store: make(map[string]Object)}
 }
+
+// NewSlotEnvironment returns the environment of a call to a function whose
+// variables the resolver gave numSlots slots.
+func NewSlotEnvironment(outerScope *Environment, numSlots int) *Environment {
+	return &Environment{outerScope: outerScope, slots: make([]Object, numSlots)}
+}
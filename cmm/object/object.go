@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/code"
 )
 
 type ObjectType string
@@ -17,6 +18,12 @@ const (
 	RETURN_OBJ   = "RETURN VALUE"
 	ERROR_OBJ    = "ERROR_OBJ"
 	FUNCTION_OBJ = "FUNCTION"
+	STRING_OBJ   = "STRING"
+	BUILTIN_OBJ  = "BUILTIN"
+	ARRAY_OBJ    = "ARRAY"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CELL_OBJ              = "CELL"
 )
 
 type Object interface {
@@ -24,10 +31,64 @@ type Object interface {
 	Inspect() string
 }
 
+// There is only ever one null, true and false value, so they can be
+// compared by pointer.
+var (
+	NULL  = &NullObject{}
+	TRUE  = &BooleanObject{Value: true}
+	FALSE = &BooleanObject{Value: false}
+)
+
+func NativeBool(b bool) *BooleanObject {
+	if b {
+		return TRUE
+	}
+
+	return FALSE
+}
+
 type IntegerObject struct {
 	Value int64
 }
 
+// Integers from MinCachedInteger to MaxCachedInteger are preallocated and
+// shared, as loop counters and small results are by far the most common.
+// Integer objects must therefore never be modified.
+const (
+	MinCachedInteger = -256
+	MaxCachedInteger = 1024
+)
+
+var integers = func() []*IntegerObject {
+	cache := make([]*IntegerObject, MaxCachedInteger-MinCachedInteger+1)
+	for i := range cache {
+		cache[i] = &IntegerObject{Value: int64(i + MinCachedInteger)}
+	}
+	return cache
+}()
+
+// NewInteger returns the integer object for v, which is shared if v is in
+// the cached range. Every integer should be made by it.
+func NewInteger(v int64) *IntegerObject {
+	if v >= MinCachedInteger && v <= MaxCachedInteger {
+		return integers[v-MinCachedInteger]
+	}
+	return &IntegerObject{Value: v}
+}
+
+// IsShared reports whether obj is one of the preallocated values: null,
+// true, false or a cached integer. They are never allocated by a program.
+func IsShared(obj Object) bool {
+	switch obj := obj.(type) {
+	case *NullObject, *BooleanObject:
+		return obj == NULL || obj == TRUE || obj == FALSE
+	case *IntegerObject:
+		return obj.Value >= MinCachedInteger && obj.Value <= MaxCachedInteger &&
+			obj == integers[obj.Value-MinCachedInteger]
+	}
+	return false
+}
+
 func (iob *IntegerObject) Type() ObjectType {
 	return INTEGER_OBJ
 }
@@ -35,6 +96,13 @@ func (iob *IntegerObject) Inspect() string {
 	return fmt.Sprintf("%d", iob.Value)
 }
 
+type StringObject struct {
+	Value string
+}
+
+func (so *StringObject) Type() ObjectType { return STRING_OBJ }
+func (so *StringObject) Inspect() string  { return so.Value }
+
 type BooleanObject struct {
 	Value bool
 }
@@ -42,6 +110,21 @@ type BooleanObject struct {
 func (bo *BooleanObject) Type() ObjectType { return BOOLEAN_OBJ }
 func (bo *BooleanObject) Inspect() string  { return fmt.Sprintf("%t", bo.Value) }
 
+// ArrayObject is a list of values. Programs read them with an index
+// expression; they are made by Go, such as the args of a script.
+type ArrayObject struct {
+	Elements []Object
+}
+
+func (ao *ArrayObject) Type() ObjectType { return ARRAY_OBJ }
+func (ao *ArrayObject) Inspect() string {
+	elements := make([]string, len(ao.Elements))
+	for i, e := range ao.Elements {
+		elements[i] = Quote(e)
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
 type NullObject struct{}
 
 func (nullo *NullObject) Type() ObjectType { return NULL_OBJ }
@@ -61,24 +144,120 @@ type ErrorObject struct {
 func (eo *ErrorObject) Type() ObjectType { return ERROR_OBJ }
 func (eo *ErrorObject) Inspect() string  { return "ERROR: " + eo.Message }
 
+// RuntimeError is the Go error for an error the program raised and did not
+// handle.
+type RuntimeError struct {
+	Message string
+}
+
+func (re *RuntimeError) Error() string {
+	return "runtime error: " + re.Message
+}
+
 type Function struct {
 	Env        *Environment
 	Body       *ast.BlockStatement
 	Parameters []*ast.Identifier
+
+	// NumLocals is the number of slots its calls need, see
+	// ast.FunctionLiteral.
+	NumLocals int
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
-	var out bytes.Buffer
 	params := []string{}
 	for _, p := range f.Parameters {
 		params = append(params, p.String())
 	}
+	return inspectFunction(params, f.Body.String())
+}
+
+func inspectFunction(params []string, body string) string {
+	var out bytes.Buffer
 	out.WriteString("fn")
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
-	out.WriteString(f.Body.String())
+	out.WriteString(body)
 	out.WriteString("\n}")
 	return out.String()
 }
+
+// CompiledFunction is a function literal compiled to bytecode. Parameters
+// and Body keep the source form so that it inspects like a Function.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	Lines         code.LineTable
+	NumLocals     int
+	NumParameters int
+
+	Name       string
+	Parameters []string
+	LocalNames []string
+	Body       string
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return inspectFunction(cf.Parameters, cf.Body)
+}
+
+// Closure is a CompiledFunction together with the values of the free
+// variables it captured when it was created. To programs it is a function
+// like any other.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string  { return c.Fn.Inspect() }
+
+// Cell holds a local that a closure captured before the let setting it had
+// run. The function and the closure share the cell, so the closure sees
+// the value once it is set. Programs never see a Cell itself.
+type Cell struct {
+	Name  string
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string {
+	if c.Value == nil {
+		return c.Name
+	}
+	return c.Value.Inspect()
+}
+
+// BuiltinFunction is the Go implementation of a builtin. It reports
+// failures by returning an *ErrorObject.
+type BuiltinFunction func(args ...Object) Object
+
+// Caller calls a function of the running program for a builtin, and
+// returns its result or the *ErrorObject the call failed with.
+type Caller func(fn Object, args ...Object) Object
+
+// CallerFunction is the Go implementation of a builtin that calls
+// functions of the program, which it does with call.
+type CallerFunction func(call Caller, args ...Object) Object
+
+// Builtin is a function implemented in Go rather than in cmm. It is
+// implemented by Fn, or by CallFn if it calls functions of the program.
+type Builtin struct {
+	Name   string
+	Fn     BuiltinFunction
+	CallFn CallerFunction
+}
+
+// Call runs the builtin with args. call is how the engine running the
+// program calls its functions.
+func (b *Builtin) Call(call Caller, args ...Object) Object {
+	if b.CallFn != nil {
+		return b.CallFn(call, args...)
+	}
+	return b.Fn(args...)
+}
+
+func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
+func (b *Builtin) Inspect() string  { return "builtin function " + b.Name }
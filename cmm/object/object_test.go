@@ -0,0 +1,127 @@
+package object
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+)
+
+func TestNewInteger(t *testing.T) {
+	tests := []struct {
+		value  int64
+		shared bool
+	}{
+		{0, true},
+		{-1, true},
+		{MinCachedInteger, true},
+		{MaxCachedInteger, true},
+		{MinCachedInteger - 1, false},
+		{MaxCachedInteger + 1, false},
+		{1 << 40, false},
+	}
+
+	for _, tt := range tests {
+		a, b := NewInteger(tt.value), NewInteger(tt.value)
+		if a.Value != tt.value || b.Value != tt.value {
+			t.Errorf("NewInteger(%d) has wrong value %d", tt.value, a.Value)
+		}
+		if (a == b) != tt.shared {
+			t.Errorf("NewInteger(%d) shared=%t, want %t", tt.value, a == b, tt.shared)
+		}
+		if IsShared(a) != tt.shared {
+			t.Errorf("IsShared(NewInteger(%d))=%t, want %t", tt.value, IsShared(a), tt.shared)
+		}
+	}
+
+	if IsShared(&IntegerObject{Value: 1}) {
+		t.Errorf("an integer made without NewInteger is shared")
+	}
+	for _, obj := range []Object{NULL, TRUE, FALSE} {
+		if !IsShared(obj) {
+			t.Errorf("%s is not shared", obj.Inspect())
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		op          ast.Operator
+		left, right Object
+		want        string
+	}{
+		{ast.OpAdd, NewInteger(2), NewInteger(3), "5"},
+		{ast.OpDiv, NewInteger(7), NewInteger(2), "3"},
+		{ast.OpDiv, NewInteger(1), NewInteger(0), "ERROR: division by zero"},
+		{ast.OpLess, NewInteger(1), NewInteger(2), "true"},
+		{ast.OpAdd, &StringObject{Value: "a"}, &StringObject{Value: "b"}, "ab"},
+		{ast.OpEqual, &StringObject{Value: "a"}, &StringObject{Value: "a"}, "true"},
+		{ast.OpSub, &StringObject{Value: "a"}, &StringObject{Value: "b"}, "ERROR: unknown operator: STRING - STRING"},
+		{ast.OpEqual, TRUE, TRUE, "true"},
+		{ast.OpNotEqual, NewInteger(1), TRUE, "true"},
+		{ast.OpAdd, NewInteger(1), TRUE, "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{ast.OpAdd, TRUE, FALSE, "ERROR: unknown operator: BOOLEAN + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		if got := Infix(tt.op, tt.left, tt.right).Inspect(); got != tt.want {
+			t.Errorf("%s %s %s = %s, want %s", tt.left.Inspect(), tt.op, tt.right.Inspect(), got, tt.want)
+		}
+	}
+
+	prefix := []struct {
+		op    ast.Operator
+		right Object
+		want  string
+	}{
+		{ast.OpNegate, NewInteger(5), "-5"},
+		{ast.OpNegate, TRUE, "ERROR: unknown operator: -BOOLEAN"},
+		{ast.OpNot, NULL, "true"},
+		{ast.OpNot, NewInteger(0), "false"},
+	}
+
+	for _, tt := range prefix {
+		if got := Prefix(tt.op, tt.right).Inspect(); got != tt.want {
+			t.Errorf("%s%s = %s, want %s", tt.op, tt.right.Inspect(), got, tt.want)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	fn := &Function{}
+	tests := []struct {
+		a, b Object
+		want bool
+	}{
+		{NewInteger(1), NewInteger(1), true},
+		{NewInteger(1), NewInteger(2), false},
+		{NewInteger(1), TRUE, false},
+		{&StringObject{Value: "a"}, &StringObject{Value: "a"}, true},
+		{&ArrayObject{Elements: []Object{NewInteger(1), &StringObject{Value: "a"}}}, &ArrayObject{Elements: []Object{NewInteger(1), &StringObject{Value: "a"}}}, true},
+		{&ArrayObject{Elements: []Object{NewInteger(1)}}, &ArrayObject{Elements: []Object{NewInteger(1), NewInteger(1)}}, false},
+		{NULL, NULL, true},
+		{fn, fn, true},
+		{fn, &Function{}, false},
+	}
+
+	for _, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.want {
+			t.Errorf("Equal(%s, %s) = %t, want %t", Quote(tt.a), Quote(tt.b), got, tt.want)
+		}
+	}
+}
+
+func TestRegisterInfix(t *testing.T) {
+	defer func() { delete(infixOperators, infixKey{STRING_OBJ, ast.OpMul, INTEGER_OBJ}) }()
+
+	RegisterInfix(STRING_OBJ, ast.OpMul, INTEGER_OBJ, func(left, right Object) Object {
+		return &StringObject{Value: strings.Repeat(left.(*StringObject).Value, int(right.(*IntegerObject).Value))}
+	})
+
+	if got := Infix(ast.OpMul, &StringObject{Value: "ab"}, NewInteger(3)).Inspect(); got != "ababab" {
+		t.Errorf("registered operator gave %s, want ababab", got)
+	}
+	if got := Infix(ast.OpMul, NewInteger(3), &StringObject{Value: "ab"}).Inspect(); got != "ERROR: type mismatch: INTEGER * STRING" {
+		t.Errorf("operands in the other order gave %s", got)
+	}
+}
@@ -0,0 +1,141 @@
+package object
+
+import "github.com/shoebilyas123/cminusminus/cmm/ast"
+
+// InfixFunc applies an infix operator to its operands. It returns an
+// *ErrorObject if the operation fails.
+type InfixFunc func(left, right Object) Object
+
+// PrefixFunc applies a prefix operator to its operand. It returns an
+// *ErrorObject if the operation fails.
+type PrefixFunc func(right Object) Object
+
+type infixKey struct {
+	left  ObjectType
+	op    ast.Operator
+	right ObjectType
+}
+
+type prefixKey struct {
+	op    ast.Operator
+	right ObjectType
+}
+
+var (
+	infixOperators  = make(map[infixKey]InfixFunc)
+	prefixOperators = make(map[prefixKey]PrefixFunc)
+)
+
+// RegisterInfix makes fn the implementation of op for operands of the
+// types left and right, replacing any previous one. Operators must be
+// registered before programs run.
+func RegisterInfix(left ObjectType, op ast.Operator, right ObjectType, fn InfixFunc) {
+	infixOperators[infixKey{left, op, right}] = fn
+}
+
+// RegisterPrefix makes fn the implementation of op for an operand of type
+// right, replacing any previous one. Operators must be registered before
+// programs run.
+func RegisterPrefix(op ast.Operator, right ObjectType, fn PrefixFunc) {
+	prefixOperators[prefixKey{op, right}] = fn
+}
+
+// HasInfix reports whether a function is registered for op with operands
+// of the types left and right.
+func HasInfix(left ObjectType, op ast.Operator, right ObjectType) bool {
+	_, ok := infixOperators[infixKey{left, op, right}]
+	return ok
+}
+
+// HasPrefix reports whether a function is registered for op with an
+// operand of type right.
+func HasPrefix(op ast.Operator, right ObjectType) bool {
+	_, ok := prefixOperators[prefixKey{op, right}]
+	return ok
+}
+
+// Infix applies op to left and right with the function registered for
+// their types. Without one, == and != compare identity, and anything else
+// is an error.
+func Infix(op ast.Operator, left, right Object) Object {
+	leftType, rightType := left.Type(), right.Type()
+	if fn, ok := infixOperators[infixKey{leftType, op, rightType}]; ok {
+		return fn(left, right)
+	}
+
+	switch {
+	case op == ast.OpEqual:
+		return NativeBool(left == right)
+	case op == ast.OpNotEqual:
+		return NativeBool(left != right)
+	case leftType != rightType:
+		return newError("type mismatch: %s %s %s", leftType, op, rightType)
+	default:
+		return newError("unknown operator: %s %s %s", leftType, op, rightType)
+	}
+}
+
+// Prefix applies op to right with the function registered for its type.
+// Without one, ! negates right's truthiness, and anything else is an
+// error.
+func Prefix(op ast.Operator, right Object) Object {
+	if fn, ok := prefixOperators[prefixKey{op, right.Type()}]; ok {
+		return fn(right)
+	}
+
+	if op == ast.OpNot {
+		return NativeBool(right == FALSE || right == NULL)
+	}
+	return newError("unknown operator: %s%s", op, right.Type())
+}
+
+// Index returns the element of left at index, or an error if left is not an
+// array or index is out of its range.
+func Index(left, index Object) Object {
+	array, ok := left.(*ArrayObject)
+	if !ok {
+		return newError("index operator not supported: %s", left.Type())
+	}
+	i, ok := index.(*IntegerObject)
+	if !ok {
+		return newError("array index must be INTEGER, got %s", index.Type())
+	}
+	if i.Value < 0 || i.Value >= int64(len(array.Elements)) {
+		return newError("index out of range: %d with length %d", i.Value, len(array.Elements))
+	}
+	return array.Elements[i.Value]
+}
+
+func init() {
+	integer := func(op ast.Operator, fn func(l, r int64) Object) {
+		RegisterInfix(INTEGER_OBJ, op, INTEGER_OBJ, func(left, right Object) Object {
+			return fn(left.(*IntegerObject).Value, right.(*IntegerObject).Value)
+		})
+	}
+	integer(ast.OpAdd, func(l, r int64) Object { return NewInteger(l + r) })
+	integer(ast.OpSub, func(l, r int64) Object { return NewInteger(l - r) })
+	integer(ast.OpMul, func(l, r int64) Object { return NewInteger(l * r) })
+	integer(ast.OpDiv, func(l, r int64) Object {
+		if r == 0 {
+			return newError("division by zero")
+		}
+		return NewInteger(l / r)
+	})
+	integer(ast.OpLess, func(l, r int64) Object { return NativeBool(l < r) })
+	integer(ast.OpGreater, func(l, r int64) Object { return NativeBool(l > r) })
+	integer(ast.OpEqual, func(l, r int64) Object { return NativeBool(l == r) })
+	integer(ast.OpNotEqual, func(l, r int64) Object { return NativeBool(l != r) })
+
+	RegisterPrefix(ast.OpNegate, INTEGER_OBJ, func(right Object) Object {
+		return NewInteger(-right.(*IntegerObject).Value)
+	})
+
+	str := func(op ast.Operator, fn func(l, r string) Object) {
+		RegisterInfix(STRING_OBJ, op, STRING_OBJ, func(left, right Object) Object {
+			return fn(left.(*StringObject).Value, right.(*StringObject).Value)
+		})
+	}
+	str(ast.OpAdd, func(l, r string) Object { return &StringObject{Value: l + r} })
+	str(ast.OpEqual, func(l, r string) Object { return NativeBool(l == r) })
+	str(ast.OpNotEqual, func(l, r string) Object { return NativeBool(l != r) })
+}
@@ -0,0 +1,124 @@
+package object
+
+// Estimated sizes, in bytes, of the values the interpreter allocates. They
+// follow the layout of the structs on a 64-bit platform rounded up to the
+// Go allocator's size classes. They are meant for accounting, not as exact
+// figures from the runtime.
+const (
+	integerSize  = 8
+	stringSize   = 16
+	booleanSize  = 8
+	returnSize   = 16
+	errorSize    = 16
+	functionSize = 48
+	closureSize  = 32
+	arraySize    = 24
+	cellSize     = 32
+
+	// an Environment is its struct plus an empty map
+	environmentSize = 64
+	// a binding is a map entry holding a string key and an Object
+	bindingSize = 32
+	// a slot is an Object in an Environment's slice
+	slotSize = 16
+)
+
+// SizeOf estimates the memory used by obj itself, not counting the values
+// it refers to.
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *IntegerObject:
+		return integerSize
+	case *StringObject:
+		return stringSize + int64(len(obj.Value))
+	case *BooleanObject:
+		return booleanSize
+	case *NullObject:
+		return 0
+	case *ReturnObject:
+		return returnSize
+	case *ErrorObject:
+		return errorSize + int64(len(obj.Message))
+	case *Function:
+		return functionSize
+	case *Closure:
+		return closureSize + int64(len(obj.Free))*16
+	case *ArrayObject:
+		return arraySize + int64(len(obj.Elements))*16
+	case *Cell:
+		return cellSize
+	}
+
+	return 0
+}
+
+// Size estimates the memory used by env itself and its bindings, not
+// counting the bound values.
+func (env *Environment) Size() int64 {
+	size := int64(environmentSize) + int64(len(env.slots))*slotSize
+	for name := range env.store {
+		size += bindingSize + int64(len(name))
+	}
+	return size
+}
+
+// BindingSize estimates the memory a new binding of name adds to an
+// Environment.
+func BindingSize(name string) int64 {
+	return bindingSize + int64(len(name))
+}
+
+// Reachable estimates the memory used by the environments in roots and
+// everything reachable from them. Every environment and value is counted
+// once however many times it is referenced.
+func Reachable(roots ...*Environment) int64 {
+	r := &reachability{seen: make(map[interface{}]bool)}
+	for _, env := range roots {
+		r.environment(env)
+	}
+	return r.size
+}
+
+type reachability struct {
+	seen map[interface{}]bool
+	size int64
+}
+
+func (r *reachability) environment(env *Environment) {
+	for env != nil && !r.seen[env] {
+		r.seen[env] = true
+		r.size += env.Size()
+		for _, value := range env.store {
+			r.object(value)
+		}
+		for _, value := range env.slots {
+			r.object(value)
+		}
+		env = env.outerScope
+	}
+}
+
+func (r *reachability) object(obj Object) {
+	if obj == nil || r.seen[obj] || IsShared(obj) {
+		return
+	}
+	r.seen[obj] = true
+	r.size += SizeOf(obj)
+
+	switch obj := obj.(type) {
+	case *ReturnObject:
+		r.object(obj.Value)
+	case *Function:
+		r.environment(obj.Env)
+	case *Closure:
+		for _, free := range obj.Free {
+			r.object(free)
+		}
+	case *ArrayObject:
+		for _, e := range obj.Elements {
+			r.object(e)
+		}
+	case *Cell:
+		r.object(obj.Value)
+	}
+}
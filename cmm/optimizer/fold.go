@@ -0,0 +1,94 @@
+package optimizer
+
+import (
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+)
+
+// foldPrefix returns the literal node evaluates to, or nil if it cannot be
+// evaluated before the program runs.
+func foldPrefix(node *ast.PrefixExpression) ast.Expression {
+	switch node.Op {
+	case ast.OpNegate:
+		if right, ok := node.Right.(*ast.IntegerLiteral); ok {
+			return integerLiteral(node, -right.Value)
+		}
+	case ast.OpNot:
+		if truthy, known := truthiness(node.Right); known {
+			return booleanLiteral(node, !truthy)
+		}
+	}
+	return nil
+}
+
+// foldInfix returns the literal node evaluates to, or nil if it cannot be
+// evaluated before the program runs or would fail.
+func foldInfix(node *ast.InfixExpression) ast.Expression {
+	switch left := node.Left.(type) {
+	case *ast.IntegerLiteral:
+		if right, ok := node.Right.(*ast.IntegerLiteral); ok {
+			return foldIntegers(node, left.Value, right.Value)
+		}
+	case *ast.StringLiteral:
+		if right, ok := node.Right.(*ast.StringLiteral); ok {
+			return foldStrings(node, left.Value, right.Value)
+		}
+	case *ast.BooleanExpression:
+		if right, ok := node.Right.(*ast.BooleanExpression); ok {
+			return foldEquality(node, left.Value == right.Value)
+		}
+	}
+
+	// Literals of different types are never the same object, so they
+	// only compare; any other operator is a type mismatch.
+	if isLiteral(node.Left) && isLiteral(node.Right) {
+		return foldEquality(node, false)
+	}
+	return nil
+}
+
+func foldIntegers(node *ast.InfixExpression, left, right int64) ast.Expression {
+	switch node.Op {
+	case ast.OpAdd:
+		return integerLiteral(node, left+right)
+	case ast.OpSub:
+		return integerLiteral(node, left-right)
+	case ast.OpMul:
+		return integerLiteral(node, left*right)
+	case ast.OpDiv:
+		if right == 0 {
+			return nil
+		}
+		return integerLiteral(node, left/right)
+	case ast.OpLess:
+		return booleanLiteral(node, left < right)
+	case ast.OpGreater:
+		return booleanLiteral(node, left > right)
+	}
+	return foldEquality(node, left == right)
+}
+
+func foldStrings(node *ast.InfixExpression, left, right string) ast.Expression {
+	if node.Op == ast.OpAdd {
+		return stringLiteral(node, left+right)
+	}
+	return foldEquality(node, left == right)
+}
+
+// foldEquality folds == and != given whether the operands are equal.
+func foldEquality(node *ast.InfixExpression, equal bool) ast.Expression {
+	switch node.Op {
+	case ast.OpEqual:
+		return booleanLiteral(node, equal)
+	case ast.OpNotEqual:
+		return booleanLiteral(node, !equal)
+	}
+	return nil
+}
+
+func isLiteral(node ast.Expression) bool {
+	switch node.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.BooleanExpression:
+		return true
+	}
+	return false
+}
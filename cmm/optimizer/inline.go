@@ -0,0 +1,186 @@
+package optimizer
+
+import (
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+)
+
+// canInline reports whether calls to fn, bound to name, can be replaced by
+// its body. name must be bound once and never shadowed, so that every call
+// through it calls fn. fn's body must be a single expression of at most
+// maxInlineSize nodes that refers to nothing but its parameters. Each of
+// them must be used outside of any if branch, and first in the order of
+// the parameters, so that the same arguments are looked up in the same
+// order as by the call.
+func (o *optimizer) canInline(name string, fn *ast.FunctionLiteral) bool {
+	if o.lets[name] != 1 || o.params[name] {
+		return false
+	}
+
+	body := inlineBody(fn)
+	if body == nil {
+		return false
+	}
+
+	c := &inlineCheck{params: make(map[string]bool), used: make(map[string]bool)}
+	for _, p := range fn.Parameters {
+		if c.params[p.Value] {
+			return false
+		}
+		c.params[p.Value] = true
+	}
+
+	if !c.check(body, false) || c.size > maxInlineSize || len(c.order) != len(fn.Parameters) {
+		return false
+	}
+
+	for i, p := range fn.Parameters {
+		if c.order[i] != p.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// inlineBody returns the expression fn's body consists of, or nil.
+func inlineBody(fn *ast.FunctionLiteral) ast.Expression {
+	if len(fn.Body.Statements) != 1 {
+		return nil
+	}
+
+	switch s := fn.Body.Statements[0].(type) {
+	case *ast.ExpressionStatement:
+		return s.Expression
+	case *ast.ReturnStatement:
+		return s.ReturnValue
+	}
+	return nil
+}
+
+type inlineCheck struct {
+	params map[string]bool
+
+	// order lists the parameters in the order of their first use outside
+	// of if branches
+	used  map[string]bool
+	order []string
+
+	size int
+}
+
+// check reports whether node only refers to parameters, counting nodes
+// and recording uses along the way. conditional is whether node is in an
+// if branch.
+func (c *inlineCheck) check(node ast.Node, conditional bool) bool {
+	c.size++
+
+	switch node := node.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.BooleanExpression:
+		return true
+	case *ast.Identifier:
+		if !c.params[node.Value] {
+			return false
+		}
+		if !conditional && !c.used[node.Value] {
+			c.used[node.Value] = true
+			c.order = append(c.order, node.Value)
+		}
+		return true
+	case *ast.PrefixExpression:
+		return c.check(node.Right, conditional)
+	case *ast.InfixExpression:
+		return c.check(node.Left, conditional) && c.check(node.Right, conditional)
+	case *ast.IfExpression:
+		if !c.check(node.Condition, conditional) || !c.check(node.Consequence, true) {
+			return false
+		}
+		return node.Alternative == nil || c.check(node.Alternative, true)
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			es, ok := s.(*ast.ExpressionStatement)
+			if !ok || !c.check(es.Expression, conditional) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// inlineCall returns the body of the function node calls with its
+// arguments in place of the parameters, or nil if the call cannot be
+// inlined. The arguments must be literals or identifiers, which are cheap
+// to repeat and cannot fail other than by being undefined.
+func (o *optimizer) inlineCall(node *ast.CallExpression) ast.Expression {
+	name, ok := node.Function.(*ast.Identifier)
+	if !ok {
+		return nil
+	}
+
+	fn, ok := o.inline[name.Value]
+	if !ok || len(node.Arguments) != len(fn.Parameters) {
+		return nil
+	}
+
+	args := make(map[string]ast.Expression)
+	for i, a := range node.Arguments {
+		if _, ok := a.(*ast.Identifier); !ok && !isLiteral(a) {
+			return nil
+		}
+		args[fn.Parameters[i].Value] = a
+	}
+
+	return substitute(inlineBody(fn), args).(ast.Expression)
+}
+
+// substitute copies node with the parameters in args replaced by copies of
+// their arguments. Every node is copied, as the resolver annotates
+// identifiers in place.
+func substitute(node ast.Node, args map[string]ast.Expression) ast.Node {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if arg, ok := args[node.Value]; ok {
+			return substitute(arg, nil)
+		}
+		return &ast.Identifier{Token: node.Token, Value: node.Value}
+	case *ast.IntegerLiteral:
+		copied := *node
+		return &copied
+	case *ast.StringLiteral:
+		copied := *node
+		return &copied
+	case *ast.BooleanExpression:
+		copied := *node
+		return &copied
+	case *ast.PrefixExpression:
+		copied := *node
+		copied.Right = substitute(node.Right, args).(ast.Expression)
+		return &copied
+	case *ast.InfixExpression:
+		copied := *node
+		copied.Left = substitute(node.Left, args).(ast.Expression)
+		copied.Right = substitute(node.Right, args).(ast.Expression)
+		return &copied
+	case *ast.IfExpression:
+		copied := *node
+		copied.Condition = substitute(node.Condition, args).(ast.Expression)
+		copied.Consequence = substitute(node.Consequence, args).(*ast.BlockStatement)
+		if node.Alternative != nil {
+			copied.Alternative = substitute(node.Alternative, args).(*ast.BlockStatement)
+		}
+		return &copied
+	case *ast.BlockStatement:
+		copied := *node
+		copied.Statements = make([]ast.Statement, len(node.Statements))
+		for i, s := range node.Statements {
+			copied.Statements[i] = substitute(s, args).(ast.Statement)
+		}
+		return &copied
+	case *ast.ExpressionStatement:
+		copied := *node
+		copied.Expression = substitute(node.Expression, args).(ast.Expression)
+		return &copied
+	}
+
+	// canInline only lets the nodes above through
+	panic("optimizer: cannot copy " + node.String())
+}
@@ -0,0 +1,309 @@
+// Package optimizer rewrites a program into one that does less work when
+// it runs but gives the same results and errors:
+//
+//   - operators applied to literals are folded into their result, unless
+//     they would fail at runtime;
+//   - an if whose condition is a literal is replaced by the branch taken;
+//   - statements after a return are dropped;
+//   - calls to small functions that only use their parameters are
+//     replaced by the function's body.
+//
+// Function values print their optimized body.
+package optimizer
+
+import (
+	"strconv"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+)
+
+// maxInlineSize is the largest number of nodes a function body may have
+// to be inlined.
+const maxInlineSize = 16
+
+type optimizer struct {
+	// lets counts the lets of each name, params the names used as
+	// parameters, anywhere in the program.
+	lets   map[string]int
+	params map[string]bool
+
+	// inline holds the functions bound by the lets run so far that calls
+	// can be replaced by.
+	inline map[string]*ast.FunctionLiteral
+
+	// nested counts the functions and branches around the statement being
+	// optimized. Only lets outside all of them are sure to have run.
+	nested int
+}
+
+// Optimize rewrites program in place and returns it.
+func Optimize(program *ast.Program) *ast.Program {
+	o := &optimizer{
+		lets:   make(map[string]int),
+		params: make(map[string]bool),
+		inline: make(map[string]*ast.FunctionLiteral),
+	}
+	o.count(program)
+
+	program.Statements = o.statements(program.Statements)
+	return program
+}
+
+func (o *optimizer) statements(statements []ast.Statement) []ast.Statement {
+	var result []ast.Statement
+
+	for i, s := range statements {
+		last := i == len(statements)-1
+
+		for _, s := range o.statement(s, last) {
+			result = append(result, s)
+			if _, ok := s.(*ast.ReturnStatement); ok {
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+// statement optimizes node, which may turn it into any number of
+// statements. last is whether it is the last statement of its block, whose
+// value is the block's value.
+func (o *optimizer) statement(node ast.Statement, last bool) []ast.Statement {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		node.Value = o.expression(node.Value)
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok && o.nested == 0 && o.canInline(node.Name.Value, fn) {
+			o.inline[node.Name.Value] = fn
+		}
+
+	case *ast.ReturnStatement:
+		node.ReturnValue = o.expression(node.ReturnValue)
+
+	case *ast.ExpressionStatement:
+		ifExpr, ok := node.Expression.(*ast.IfExpression)
+		if !ok {
+			node.Expression = o.expression(node.Expression)
+			break
+		}
+
+		// The statements of the branch taken can replace the if, since
+		// blocks share the environment around them.
+		ifExpr.Condition = o.expression(ifExpr.Condition)
+		truthy, known := truthiness(ifExpr.Condition)
+		if !known {
+			node.Expression = o.ifExpression(ifExpr)
+			break
+		}
+
+		branch := ifExpr.Alternative
+		if truthy {
+			branch = ifExpr.Consequence
+		}
+
+		if branch == nil || len(branch.Statements) == 0 {
+			if last {
+				// the block's value is the if's null or nothing
+				node.Expression = o.ifExpression(ifExpr)
+				return []ast.Statement{node}
+			}
+			return nil
+		}
+
+		return o.statements(branch.Statements)
+
+	case *ast.BlockStatement:
+		node.Statements = o.statements(node.Statements)
+	}
+
+	return []ast.Statement{node}
+}
+
+func (o *optimizer) expression(node ast.Expression) ast.Expression {
+	switch node := node.(type) {
+	case *ast.PrefixExpression:
+		node.Right = o.expression(node.Right)
+		if folded := foldPrefix(node); folded != nil {
+			return folded
+		}
+
+	case *ast.InfixExpression:
+		node.Left = o.expression(node.Left)
+		node.Right = o.expression(node.Right)
+		if folded := foldInfix(node); folded != nil {
+			return folded
+		}
+
+	case *ast.IfExpression:
+		node.Condition = o.expression(node.Condition)
+		return o.ifExpression(node)
+
+	case *ast.FunctionLiteral:
+		o.nested++
+		node.Body.Statements = o.statements(node.Body.Statements)
+		o.nested--
+
+	case *ast.CallExpression:
+		node.Function = o.expression(node.Function)
+		for i, a := range node.Arguments {
+			node.Arguments[i] = o.expression(a)
+		}
+
+		if inlined := o.inlineCall(node); inlined != nil {
+			return o.expression(inlined)
+		}
+
+	case *ast.IndexExpression:
+		node.Left = o.expression(node.Left)
+		node.Index = o.expression(node.Index)
+	}
+
+	return node
+}
+
+// ifExpression optimizes the branches of node, whose condition has been
+// optimized already, and drops the branch a literal condition never takes.
+func (o *optimizer) ifExpression(node *ast.IfExpression) ast.Expression {
+	o.nested++
+	node.Consequence.Statements = o.statements(node.Consequence.Statements)
+	if node.Alternative != nil {
+		node.Alternative.Statements = o.statements(node.Alternative.Statements)
+	}
+	o.nested--
+
+	truthy, known := truthiness(node.Condition)
+	if !known {
+		return node
+	}
+
+	branch := node.Alternative
+	if truthy {
+		branch = node.Consequence
+	}
+	if branch == nil {
+		// the if is null, but a literal cannot say so
+		node.Consequence = &ast.BlockStatement{Token: node.Consequence.Token}
+		return node
+	}
+
+	if len(branch.Statements) == 1 {
+		if s, ok := branch.Statements[0].(*ast.ExpressionStatement); ok {
+			return s.Expression
+		}
+	}
+
+	// keep a block with several statements, but only that one
+	node.Condition = booleanLiteral(node.Condition, true)
+	node.Consequence = branch
+	node.Alternative = nil
+	return node
+}
+
+// truthiness reports whether a literal condition is truthy. known is false
+// when node is not a literal.
+func truthiness(node ast.Expression) (truthy, known bool) {
+	switch node := node.(type) {
+	case *ast.BooleanExpression:
+		return node.Value, true
+	case *ast.IntegerLiteral, *ast.StringLiteral:
+		return true, true
+	}
+	return false, false
+}
+
+// count fills in o.lets and o.params.
+func (o *optimizer) count(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			o.count(s)
+		}
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			o.count(s)
+		}
+	case *ast.LetStatement:
+		o.lets[node.Name.Value]++
+		o.count(node.Value)
+	case *ast.ReturnStatement:
+		o.count(node.ReturnValue)
+	case *ast.ExpressionStatement:
+		o.count(node.Expression)
+	case *ast.PrefixExpression:
+		o.count(node.Right)
+	case *ast.InfixExpression:
+		o.count(node.Left)
+		o.count(node.Right)
+	case *ast.IfExpression:
+		o.count(node.Condition)
+		o.count(node.Consequence)
+		if node.Alternative != nil {
+			o.count(node.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		for _, p := range node.Parameters {
+			o.params[p.Value] = true
+		}
+		o.count(node.Body)
+	case *ast.CallExpression:
+		o.count(node.Function)
+		for _, a := range node.Arguments {
+			o.count(a)
+		}
+	case *ast.IndexExpression:
+		o.count(node.Left)
+		o.count(node.Index)
+	}
+}
+
+func integerLiteral(at ast.Expression, value int64) *ast.IntegerLiteral {
+	return &ast.IntegerLiteral{Token: literalToken(at, token.INT, strconv.FormatInt(value, 10)), Value: value}
+}
+
+func stringLiteral(at ast.Expression, value string) *ast.StringLiteral {
+	return &ast.StringLiteral{Token: literalToken(at, token.STRING, value), Value: value}
+}
+
+func booleanLiteral(at ast.Expression, value bool) *ast.BooleanExpression {
+	if value {
+		return &ast.BooleanExpression{Token: literalToken(at, token.TRUE, "true"), Value: true}
+	}
+	return &ast.BooleanExpression{Token: literalToken(at, token.FALSE, "false"), Value: false}
+}
+
+// literalToken returns a token for a literal that replaces the expression
+// at, in the same place in the source.
+func literalToken(at ast.Expression, typ token.TokenType, literal string) token.Token {
+	t := token.Token{Type: typ, Literal: literal}
+	if pos := position(at); pos != nil {
+		t.Line, t.Column = pos.Line, pos.Column
+	}
+	return t
+}
+
+func position(node ast.Expression) *token.Token {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &node.Token
+	case *ast.StringLiteral:
+		return &node.Token
+	case *ast.BooleanExpression:
+		return &node.Token
+	case *ast.Identifier:
+		return &node.Token
+	case *ast.PrefixExpression:
+		return &node.Token
+	case *ast.InfixExpression:
+		return position(node.Left)
+	case *ast.IfExpression:
+		return &node.Token
+	case *ast.FunctionLiteral:
+		return &node.Token
+	case *ast.CallExpression:
+		return position(node.Function)
+	case *ast.IndexExpression:
+		return position(node.Left)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package optimizer
+
+import (
+	"testing"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+	"github.com/shoebilyas123/cminusminus/cmm/resolver"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// folding
+		{"2 * (5 + 10)", "30"},
+		{"-(1 + 2)", "-3"},
+		{"!true; !5; !!\"s\"", "false" + "false" + "true"},
+		{"1 < 2 == true", "true"},
+		{`"a" + "b" == "ab"`, "true"},
+		{`1 == "1"; true != 1`, "false" + "true"},
+		{"1 / 0", "(1 / 0)"},
+		{"1 + true", "(1 + true)"},
+		{`"a" - "b"`, `("a" - "b")`},
+		{"x + 2 * 3", "(x + 6)"},
+
+		// dead branches
+		{"if (true) { a } else { b }", "a"},
+		{"if (false) { a } else { b }", "b"},
+		{"if (1 > 2) { a }; c", "c"},
+		{"if (1 > 2) { a }", "if false "},
+		{"let x = if (0) { let y = 1; y } else { 2 }; x", "let x = if true let y = 1;y;x"},
+		{"fn() { if (true) { return 1; } 2 }", "fn()return 1;"},
+
+		// returns
+		{"fn() { return 1; 2; 3 }", "fn()return 1;"},
+		{"return 1; let x = 2;", "return 1;"},
+
+		// inlining
+		{"let double = fn(x) { x * 2 }; double(21)", "let double = fn(x)(x * 2);42"},
+		{"let max = fn(a, b) { if (a > b) { a } else { b } }; max(n, 3)",
+			"let max = fn(a, b)if (a > b) aelse b;if (n > 3) nelse 3"},
+		{"let f = fn(x) { x }; let g = fn(y) { f(y) + 1 }; g(2)",
+			"let f = fn(x)x;let g = fn(y)(y + 1);3"},
+		// the calls below have to stay
+		{"double(1); let double = fn(x) { x * 2 };", "double(1)let double = fn(x)(x * 2);"},
+		{"let f = fn(x) { x }; let f = fn(x) { 2 }; f(1)", "let f = fn(x)x;let f = fn(x)2;f(1)"},
+		{"let f = fn(x) { x + g }; f(1)", "let f = fn(x)(x + g);f(1)"},
+		{"let f = fn(x) { x }; f(1 + y)", "let f = fn(x)x;f((1 + y))"},
+		{"let f = fn(a, b) { b + a }; f(x, y)", "let f = fn(a, b)(b + a);f(x, y)"},
+		{"let f = fn(a, b) { if (a) { b } }; f(x, y)", "let f = fn(a, b)if a b;f(x, y)"},
+		{"let f = fn(x) { x }; fn(f) { f(1) }", "let f = fn(x)x;fn(f)f(1)"},
+		{"if (c) { let f = fn(x) { x }; }; f(1)", "if c let f = fn(x)x;f(1)"},
+	}
+
+	for _, tt := range tests {
+		program := Optimize(parse(t, tt.input))
+		if program.String() != tt.expected {
+			t.Errorf("%q: want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+// TestPreservesSemantics runs programs with and without optimizing them:
+// they have to give the same value or the same error.
+func TestPreservesSemantics(t *testing.T) {
+	tests := []string{
+		"2 * (5 + 10)", "(5 + 10 * 2 + 15 / 3) * 2 + -10", "!!5", "-(3 - 5) * 2",
+		`"Hello" + " " + "World!"`, `"a" == "a"`, `1 == "1"`, "true == (1 < 2)",
+		"1 / 0", "1 + true", "-true", `"a" - "b"`, "true + false; 5",
+		"if (true) { 10 } else { 20 }", "if (false) { 10 }", "if (1 > 2) { 10 }; 5",
+		"if (true) { }", "let x = if (0) { let y = 1; y + 1 } else { 2 }; x",
+		"if (true) { let a = 1; }; a", "if (false) { let a = 1; }; a",
+		"return 10; 9;", "9; return 2 * 5; 9;",
+		"if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+		"let f = fn() { if (true) { return 1; } 2 }; f()",
+		"let f = fn() { return 1; undefined }; f()",
+		"let double = fn(x) { x * 2 }; double(21)",
+		"let double = fn(x) { x * 2 }; double(true)",
+		"let double = fn(x) { x * 2 }; double(nope)",
+		"let max = fn(a, b) { if (a > b) { a } else { b } }; max(2, 3) + max(7, 4)",
+		"let max = fn(a, b) { if (a > b) { a } else { b } }; let n = 9; max(n, 3)",
+		"let sub = fn(a, b) { a - b }; sub(missing, alsoMissing)",
+		"let f = fn(a, b) { b + a }; f(missing, alsoMissing)",
+		"let f = fn(a, b) { if (a) { b } }; f(false, missing)",
+		"let f = fn(x) { x }; f(1, 2)",
+		"double(1); let double = fn(x) { x * 2 };",
+		"let f = fn(x) { x }; let g = fn(y) { f(y) + 1 }; g(2)",
+		"let f = fn(x) { x }; let h = fn(f) { f(1) }; h(fn(y) { y + 5 })",
+		`
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(15)`,
+		"let newAdder = fn(x) { fn(y) { x + y } }; newAdder(2)(3)",
+	}
+
+	for _, input := range tests {
+		want := eval.Eval(parse(t, input), object.NewEnvironment())
+
+		program := Optimize(parse(t, input))
+		got := eval.Eval(program, object.NewEnvironment())
+
+		if inspect(got) != inspect(want) {
+			t.Errorf("%q: want=%s, got=%s", input, inspect(want), inspect(got))
+		}
+
+		// resolving the optimized program must not change it either
+		program = Optimize(parse(t, input))
+		resolver.Resolve(program, nil)
+		got = eval.Eval(program, object.NewEnvironment())
+
+		if inspect(got) != inspect(want) {
+			t.Errorf("%q resolved: want=%s, got=%s", input, inspect(want), inspect(got))
+		}
+	}
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "<nil>"
+	}
+	return obj.Inspect()
+}
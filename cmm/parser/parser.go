@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/shoebilyas123/cminusminus/cmm/ast"
 	"github.com/shoebilyas123/cminusminus/cmm/lexer"
@@ -18,6 +19,7 @@ const (
 	PRODUCT     // *, /
 	PREFIX      // -X or +X
 	CALL        // myFunc(x)
+	INDEX       // array[i]
 )
 
 type (
@@ -36,12 +38,16 @@ var precedences = map[token.TokenType]int{
 	token.FOR_SLASH: PRODUCT,
 	token.ASTERISK:  PRODUCT,
 	token.LPAREN:    CALL,
+	token.LBRACKET:  INDEX,
 }
 
 type Parser struct {
 	l      *lexer.Lexer
 	errors []string
 
+	// errorTokens holds the token each error was found at
+	errorTokens []token.Token
+
 	// curToken is the current token under examination
 	// Based on the peek token we will identify whether there's more ops
 	// e.g; 5;
@@ -89,7 +95,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	if err != nil {
 		msg := fmt.Sprintf("Could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.curToken, msg)
 		return nil
 	}
 
@@ -97,6 +103,10 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return lit
 }
 
+func (p *Parser) parseStringLiteral() ast.Expression {
+	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+}
+
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
@@ -105,6 +115,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParsingFns = make(map[token.TokenType]prefixParsingFn)
 	p.registerPrefixFn(token.IDENT, p.parseIdentifier)
 	p.registerPrefixFn(token.INT, p.parseIntegerLiteral)
+	p.registerPrefixFn(token.STRING, p.parseStringLiteral)
 	p.registerPrefixFn(token.EXCLAIM, p.parsePrefixExpression)
 	p.registerPrefixFn(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefixFn(token.TRUE, p.parseBooleanExpression)
@@ -123,6 +134,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfixFn(token.ASTERISK, p.parseInfixExpression)
 	p.registerInfixFn(token.FOR_SLASH, p.parseInfixExpression)
 	p.registerInfixFn(token.LPAREN, p.parseCallExpression)
+	p.registerInfixFn(token.LBRACKET, p.parseIndexExpression)
 
 	p.nextToken()
 	p.nextToken()
@@ -133,15 +145,44 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// ParseError holds every error the parser reported for a source.
+type ParseError struct {
+	Errors []string
+}
+
+func (pe *ParseError) Error() string {
+	return "parse error: " + strings.Join(pe.Errors, "; ")
+}
+
+// Err returns the errors reported so far as a *ParseError, or nil if there
+// are none.
+func (p *Parser) Err() error {
+	if len(p.errors) == 0 {
+		return nil
+	}
+	return &ParseError{Errors: p.errors}
+}
+
+// ErrorTokens returns the tokens the errors were found at, in the order of
+// Errors, to tell where they are.
+func (p *Parser) ErrorTokens() []token.Token {
+	return p.errorTokens
+}
+
+func (p *Parser) errorAt(tok token.Token, msg string) {
+	p.errors = append(p.errors, msg)
+	p.errorTokens = append(p.errorTokens, tok)
+}
+
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorAt(p.curToken, msg)
 }
 
 func (p *Parser) peekError(t token.TokenType) {
 	errMsg := fmt.Sprintf("Invalid token: Expected %s, got %s", t, p.peekToken.Type)
 
-	p.errors = append(p.errors, errMsg)
+	p.errorAt(p.peekToken, errMsg)
 }
 
 func (p *Parser) nextToken() {
@@ -240,6 +281,7 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	pref := &ast.PrefixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
+		Op:       ast.LookupPrefix(p.curToken.Literal),
 	}
 
 	p.nextToken()
@@ -285,6 +327,12 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Type = p.parseType()
+	}
+
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -303,6 +351,7 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	exp := &ast.InfixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
+		Op:       ast.LookupInfix(p.curToken.Literal),
 		Left:     left,
 	}
 
@@ -357,6 +406,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	block.Rbrace = p.curToken
 
 	return block
 }
@@ -372,7 +422,13 @@ func (p *Parser) parseFunctionLiterals() ast.Expression {
 		return nil
 	}
 
-	fnlit.Parameters = p.parseFunctionParameters()
+	fnlit.Parameters, fnlit.ParameterTypes = p.parseFunctionParameters()
+
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken()
+		p.nextToken()
+		fnlit.ReturnType = p.parseType()
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -383,45 +439,100 @@ func (p *Parser) parseFunctionLiterals() ast.Expression {
 	return fnlit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	idns := []*ast.Identifier{}
+// parseFunctionParameters parses the parameters of a function literal
+// along with their type annotations. types is nil if none has one.
+func (p *Parser) parseFunctionParameters() (idns []*ast.Identifier, types []*ast.TypeExpression) {
+	idns = []*ast.Identifier{}
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return idns
+		return idns, nil
 	}
 
-	p.nextToken()
-
-	id := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-
-	idns = append(idns, id)
+	annotated := false
+	parameter := func() {
+		p.nextToken()
+		idns = append(idns, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+		var typ *ast.TypeExpression
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			typ = p.parseType()
+			annotated = true
+		}
+		types = append(types, typ)
+	}
 
 	// (x, y)
 	//  |
+	parameter()
 	for p.peekTokenIs(token.COMMA) {
-
-		// (x, y)
-		//  |
-		p.nextToken()
-
 		// NOW ==> (x, y)
 		//           |
 		p.nextToken()
+		parameter()
+	}
 
-		// NOW ==> (x, y)
-		//             |
+	if !p.expectPeek(token.RPAREN) {
+		return nil, nil
+	}
+
+	if !annotated {
+		types = nil
+	}
+	return idns, types
+}
 
-		id := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+// parseType parses the type annotation starting at the current token: a
+// type name, or fn(T, ...) -> T.
+func (p *Parser) parseType() *ast.TypeExpression {
+	typ := &ast.TypeExpression{Token: p.curToken}
 
-		idns = append(idns, id)
+	switch p.curToken.Type {
+	case token.IDENT:
+		typ.Name = p.curToken.Literal
+		return typ
+	case token.FN:
+	default:
+		p.errorAt(p.curToken, fmt.Sprintf("Invalid token: Expected a type, got %s", p.curToken.Type))
+		return nil
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+	} else {
+		p.nextToken()
+		typ.Parameters = append(typ.Parameters, p.parseType())
+		for p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+			p.nextToken()
+			typ.Parameters = append(typ.Parameters, p.parseType())
+		}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.ARROW) {
 		return nil
 	}
+	p.nextToken()
 
-	return idns
+	typ.Result = p.parseType()
+	if typ.Result == nil {
+		return nil
+	}
+	for _, param := range typ.Parameters {
+		if param == nil {
+			return nil
+		}
+	}
+	return typ
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
@@ -430,6 +541,19 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	return callexpression
 }
 
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+
+	p.nextToken()
+	exp.Index = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return exp
+}
+
 func (p *Parser) parseCallArguments() []ast.Expression {
 	args := []ast.Expression{}
 
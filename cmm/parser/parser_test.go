@@ -1,7 +1,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/shoebilyas123/cminusminus/cmm/ast"
@@ -22,6 +24,25 @@ func checkParseErrors(t *testing.T, p *Parser) {
 	}
 	t.FailNow()
 }
+
+func TestErr(t *testing.T) {
+	p := New(lexer.New("let x = 1;"))
+	p.ParseProgram()
+	if err := p.Err(); err != nil {
+		t.Errorf("got error %v for a valid program", err)
+	}
+
+	p = New(lexer.New("let = 1; let x 2;"))
+	p.ParseProgram()
+	var parseErr *ParseError
+	if !errors.As(p.Err(), &parseErr) || len(parseErr.Errors) != len(p.Errors()) {
+		t.Fatalf("got %v, want a *ParseError of %d errors", p.Err(), len(p.Errors()))
+	}
+	if want := "parse error: " + strings.Join(p.Errors(), "; "); parseErr.Error() != want {
+		t.Errorf("got %q, want %q", parseErr.Error(), want)
+	}
+}
+
 func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	if s.TokenLiteral() != "let" {
 		t.Errorf("s.TokenLiteral not 'let'. got=%q", s.TokenLiteral())
@@ -165,6 +186,9 @@ func TestParsingPrefixExpressions(t *testing.T) {
 			t.Fatalf("exp.Operator is not '%s'. got=%s",
 				tt.operator, exp.Operator)
 		}
+		if exp.Op.String() != tt.operator {
+			t.Fatalf("exp.Op is not '%s'. got=%s", tt.operator, exp.Op)
+		}
 		if !testIntegerLiteral(t, exp.Right, tt.integerValue) {
 			return
 		}
@@ -230,6 +254,9 @@ func TestParsingInfixExpressions(t *testing.T) {
 			t.Fatalf("exp.Operator is not '%s'. got=%s",
 				tt.operator, exp.Operator)
 		}
+		if exp.Op.String() != tt.operator {
+			t.Fatalf("exp.Op is not '%s'. got=%s", tt.operator, exp.Op)
+		}
 		if !testIntegerLiteral(t, exp.Right, tt.rightValue) {
 			return
 		}
@@ -378,6 +405,18 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a + b + c * d / f + g)",
 			"add((((a + b) + ((c * d) / f)) + g))",
 		},
+		{
+			"a * args[b * c] * d",
+			"((a * (args[(b * c)])) * d)",
+		},
+		{
+			"-args[0]",
+			"(-(args[0]))",
+		},
+		{
+			"f(args[1])[2]",
+			"(f((args[1]))[2])",
+		},
 	}
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
@@ -555,3 +594,82 @@ func TestLetStatements(t *testing.T) {
 		}
 	}
 }
+
+func TestStringLiteralExpression(t *testing.T) {
+	input := `"hello \"world\"";`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.StringLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Value != `hello "world"` {
+		t.Errorf("literal.Value not %q. got=%q", `hello "world"`, literal.Value)
+	}
+	if program.String() != `"hello \"world\""` {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 4;", "let x: int = 4;"},
+		{"let add = fn(a: int, b: int) -> int { a + b };", "let add = fn(a: int, b: int) -> int (a + b);"},
+		{"fn(a, b: string) { a }", "fn(a, b: string)a"},
+		{"fn(a, b) { a }", "fn(a, b)a"},
+		{"let f: fn(int, fn() -> bool) -> string = g;", "let f: fn(int, fn() -> bool) -> string = g;"},
+		{"x - -1", "(x - (-1))"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("program.String() wrong. expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	for _, input := range []string{"let x: 5 = 1;", "fn(a: int) -> { a }", "let f: fn(int) = g;"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parse errors for %q", input)
+		}
+	}
+}
+
+func TestErrorTokens(t *testing.T) {
+	tests := []struct {
+		input  string
+		line   int
+		column int
+	}{
+		{"let = 5;", 1, 5},
+		{"let x = ;", 1, 9},
+		{"let x = 5;\nf(1,\n", 3, 1},
+		{"let f = fn(a: 5) { a };", 1, 15},
+		{"99999999999999999999", 1, 1},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		tokens := p.ErrorTokens()
+		if len(tokens) == 0 || len(tokens) != len(p.Errors()) {
+			t.Errorf("%q: got %d error tokens for %d errors", tt.input, len(tokens), len(p.Errors()))
+			continue
+		}
+		if tokens[0].Line != tt.line || tokens[0].Column != tt.column {
+			t.Errorf("%q: first error at %d:%d, want %d:%d (%s)",
+				tt.input, tokens[0].Line, tokens[0].Column, tt.line, tt.column, p.Errors()[0])
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// Package profiler finds where cmm programs spend their time, by the cmm
+// functions they call rather than by the Go functions of the evaluator.
+//
+// A Profiler follows the call stack of a program with the hooks of an
+// evaluator:
+//
+//	p := profiler.New(0)
+//	p.Add("script.cmm", program)
+//	e := eval.New(eval.Config{})
+//	e.SetHooks(p.Hooks())
+//	e.Eval(program, env)
+//	p.Write(out)
+//
+// Each statement the program runs counts as a step of the stack it runs
+// in, and each period of time that passes as a sample of the stack that
+// was running when it ended. Write writes both in the profile.proto format
+// of pprof, so that
+//
+//	go tool pprof -http=: cpu.pprof
+//
+// shows them as a flame graph of cmm functions. The steps are the default,
+// since they do not vary from run to run and a program too short to last
+// a period still has them; -sample_index=cpu shows the time.
+package profiler
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// DefaultPeriod is the time between samples if New is given none.
+const DefaultPeriod = 10 * time.Millisecond
+
+// mainName is the name of the top level of a file, which is the bottom
+// frame of every stack.
+const mainName = "main"
+
+// The values each sample has, in the order of the sample types.
+const (
+	valueSamples = iota
+	valueCPU
+	valueSteps
+	numValues
+)
+
+// function is a function of the program, or the top level of a file.
+type function struct {
+	id        uint64
+	name      string
+	file      string
+	startLine int
+}
+
+// frame is a call in progress and the line it has got to.
+type frame struct {
+	fn   *function
+	line int
+}
+
+type location struct {
+	fn   uint64
+	line int
+}
+
+type sample struct {
+	locations []uint64 // the innermost first
+	values    [numValues]int64
+}
+
+// Profiler counts the steps and samples of the stacks of cmm calls a
+// program goes through. It is not safe for concurrent use.
+type Profiler struct {
+	period time.Duration
+	start  time.Time
+	// last is when the last sample ended, and now when the last hook ran
+	last, now time.Time
+
+	functions []*function
+	bodies    map[*ast.BlockStatement]*function
+	// tops holds the top level function of the file each statement at the
+	// top level of a file belongs to
+	tops      map[ast.Statement]*function
+	anonymous *function
+
+	stack []frame
+
+	locations map[location]uint64
+	samples   map[string]*sample
+
+	// ids and key are reused by record for the stack it looks up
+	ids []uint64
+	key []byte
+}
+
+// New returns a profiler that takes a sample every period, or every
+// DefaultPeriod if period is zero. Its clock starts now.
+func New(period time.Duration) *Profiler {
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+	now := time.Now()
+	p := &Profiler{
+		period:    period,
+		start:     now,
+		last:      now,
+		now:       now,
+		bodies:    make(map[*ast.BlockStatement]*function),
+		tops:      make(map[ast.Statement]*function),
+		locations: make(map[location]uint64),
+		samples:   make(map[string]*sample),
+	}
+	// functions that were not added, such as those of code evaluated in
+	// a debugger, are lumped together
+	p.anonymous = p.function("fn", "", 0)
+	p.stack = []frame{{fn: p.anonymous}}
+	return p
+}
+
+func (p *Profiler) function(name, file string, startLine int) *function {
+	fn := &function{id: uint64(len(p.functions) + 1), name: name, file: file, startLine: startLine}
+	p.functions = append(p.functions, fn)
+	return fn
+}
+
+// Add learns the functions of program, the source file name. A function
+// is named after the let that binds it, or else after where it starts.
+func (p *Profiler) Add(name string, program *ast.Program) {
+	top := p.function(mainName, name, 1)
+	for _, stmt := range program.Statements {
+		p.tops[stmt] = top
+	}
+
+	names := make(map[*ast.FunctionLiteral]string)
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+				names[fn] = node.Name.Value
+			}
+		case *ast.FunctionLiteral:
+			fnName, ok := names[node]
+			if !ok {
+				fnName = fmt.Sprintf("fn@%d:%d", node.Token.Line, node.Token.Column)
+			}
+			p.bodies[node.Body] = p.function(fnName, name, node.Token.Line)
+		}
+		return true
+	})
+}
+
+// Hooks returns the hooks that follow the program.
+func (p *Profiler) Hooks() eval.Hooks {
+	return eval.Hooks{Statement: p.statement, Call: p.call, Return: p.returned}
+}
+
+func (p *Profiler) statement(stmt ast.Statement, _ *object.Environment) {
+	p.tick()
+	top := &p.stack[len(p.stack)-1]
+	if len(p.stack) == 1 {
+		if fn, ok := p.tops[stmt]; ok {
+			top.fn = fn
+		}
+	}
+	top.line = ast.Position(stmt).Line
+
+	var values [numValues]int64
+	values[valueSteps] = 1
+	p.record(values)
+}
+
+func (p *Profiler) call(fn *object.Function, _ *object.Environment) {
+	p.tick()
+	f, ok := p.bodies[fn.Body]
+	if !ok {
+		f = p.anonymous
+	}
+	p.stack = append(p.stack, frame{fn: f, line: fn.Body.Token.Line})
+}
+
+func (p *Profiler) returned(*object.Function, object.Object) {
+	p.tick()
+	if len(p.stack) > 1 {
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+}
+
+// tick takes the samples of the periods that ended since the last one,
+// all of which the stack as it is now ran through.
+func (p *Profiler) tick() {
+	p.now = time.Now()
+	elapsed := p.now.Sub(p.last)
+	if elapsed < p.period {
+		return
+	}
+
+	n := int64(elapsed / p.period)
+	var values [numValues]int64
+	values[valueSamples] = n
+	values[valueCPU] = n * int64(p.period)
+	p.record(values)
+	p.last = p.last.Add(time.Duration(n) * p.period)
+}
+
+// record adds values to the sample of the current stack.
+func (p *Profiler) record(values [numValues]int64) {
+	p.ids = p.ids[:0]
+	p.key = p.key[:0]
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		loc := location{p.stack[i].fn.id, p.stack[i].line}
+		id, ok := p.locations[loc]
+		if !ok {
+			id = uint64(len(p.locations) + 1)
+			p.locations[loc] = id
+		}
+		p.ids = append(p.ids, id)
+		p.key = binary.AppendUvarint(p.key, id)
+	}
+
+	s, ok := p.samples[string(p.key)]
+	if !ok {
+		s = &sample{locations: slices.Clone(p.ids)}
+		p.samples[string(p.key)] = s
+	}
+	for i, v := range values {
+		s.values[i] += v
+	}
+}
+
+// Write writes the profile gzipped in the profile.proto format.
+func (p *Profiler) Write(w io.Writer) error {
+	indexes := map[string]int64{"": 0}
+	table := []string{""}
+	str := func(s string) int64 {
+		if i, ok := indexes[s]; ok {
+			return i
+		}
+		indexes[s] = int64(len(table))
+		table = append(table, s)
+		return indexes[s]
+	}
+	valueType := func(typ, unit string) func(m *buffer) {
+		return func(m *buffer) {
+			m.int64(valueTypeType, str(typ))
+			m.int64(valueTypeUnit, str(unit))
+		}
+	}
+
+	var b buffer
+	b.message(profileSampleType, valueType("samples", "count"))
+	b.message(profileSampleType, valueType("cpu", "nanoseconds"))
+	b.message(profileSampleType, valueType("steps", "count"))
+
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := p.samples[key]
+		b.message(profileSample, func(m *buffer) {
+			m.packed(sampleLocationID, s.locations)
+			values := make([]uint64, len(s.values))
+			for i, v := range s.values {
+				values[i] = uint64(v)
+			}
+			m.packed(sampleValue, values)
+		})
+	}
+
+	// a single mapping stands for the interpreter, whose locations come
+	// with their functions, so pprof has nothing to look up
+	b.message(profileMapping, func(m *buffer) {
+		m.int64(mappingID, 1)
+		m.int64(mappingFilename, str("cminusminus"))
+		m.int64(mappingHasFunctions, 1)
+	})
+
+	locations := make([]location, len(p.locations))
+	for loc, id := range p.locations {
+		locations[id-1] = loc
+	}
+	for i, loc := range locations {
+		b.message(profileLocation, func(m *buffer) {
+			m.int64(locationID, int64(i+1))
+			m.int64(locationMappingID, 1)
+			m.message(locationLine, func(l *buffer) {
+				l.int64(lineFunctionID, int64(loc.fn))
+				l.int64(lineLine, int64(loc.line))
+			})
+		})
+	}
+
+	for _, fn := range p.functions {
+		b.message(profileFunction, func(m *buffer) {
+			m.int64(functionID, int64(fn.id))
+			m.int64(functionName, str(fn.name))
+			m.int64(functionSystemName, str(fn.name))
+			m.int64(functionFilename, str(fn.file))
+			m.int64(functionStartLine, int64(fn.startLine))
+		})
+	}
+
+	b.int64(profileTimeNanos, p.start.UnixNano())
+	b.int64(profileDurationNanos, int64(p.now.Sub(p.start)))
+	b.message(profilePeriodType, valueType("cpu", "nanoseconds"))
+	b.int64(profilePeriod, int64(p.period))
+	b.int64(profileDefaultSampleType, str("steps"))
+
+	// the string table goes last, once every string is in it
+	for _, s := range table {
+		b.string(profileStringTable, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,219 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+)
+
+const source = `let fib = fn(n) {
+	if (n < 2) { return n; }
+	fib(n - 1) + fib(n - 2)
+};
+let twice = fn(f, x) { f(f(x)) };
+twice(fn(x) { x + 1 }, 1);
+fib(3)
+`
+
+// fields is a decoded message: the values of each of its fields, which
+// are numbers or, for length-delimited fields, bytes.
+type fields map[int][]interface{}
+
+func decode(t *testing.T, data []byte) fields {
+	t.Helper()
+	msg := make(fields)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+		field, wire := int(key>>3), key&7
+		switch wire {
+		case wireVarint:
+			v, n := binary.Uvarint(data)
+			msg[field] = append(msg[field], v)
+			data = data[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			msg[field] = append(msg[field], data[n:n+int(length)])
+			data = data[n+int(length):]
+		default:
+			t.Fatalf("unexpected wire type %d", wire)
+		}
+	}
+	return msg
+}
+
+func (f fields) int(field int) uint64 {
+	if len(f[field]) == 0 {
+		return 0
+	}
+	return f[field][0].(uint64)
+}
+
+// packed decodes a packed repeated field.
+func (f fields) packed(field int) []uint64 {
+	var xs []uint64
+	for _, v := range f[field] {
+		data := v.([]byte)
+		for len(data) > 0 {
+			x, n := binary.Uvarint(data)
+			xs = append(xs, x)
+			data = data[n:]
+		}
+	}
+	return xs
+}
+
+// profile runs source with a profiler and returns the stacks it recorded,
+// innermost first as function:line, with their values.
+func profile(t *testing.T, period time.Duration) (map[string][]uint64, fields, []string) {
+	t.Helper()
+	program := parser.New(lexer.New(source)).ParseProgram()
+	p := New(period)
+	p.Add("fib.cmm", program)
+	e := eval.New(eval.Config{})
+	e.SetHooks(p.Hooks())
+	if result := e.Eval(program, object.NewEnvironment()); result.Inspect() != "2" {
+		t.Fatalf("got %s", result.Inspect())
+	}
+
+	var out bytes.Buffer
+	if err := p.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prof := decode(t, data)
+	var table []string
+	for _, s := range prof[profileStringTable] {
+		table = append(table, string(s.([]byte)))
+	}
+	if len(table) == 0 || table[0] != "" {
+		t.Fatalf("bad string table %q", table)
+	}
+
+	functions := make(map[uint64]string)
+	for _, v := range prof[profileFunction] {
+		fn := decode(t, v.([]byte))
+		functions[fn.int(functionID)] = table[fn.int(functionName)] + "@" + table[fn.int(functionFilename)]
+	}
+	locations := make(map[uint64]string)
+	for _, v := range prof[profileLocation] {
+		loc := decode(t, v.([]byte))
+		line := decode(t, loc[locationLine][0].([]byte))
+		name := strings.TrimSuffix(functions[line.int(lineFunctionID)], "@fib.cmm")
+		locations[loc.int(locationID)] = name + ":" + fmt.Sprint(line.int(lineLine))
+	}
+
+	stacks := make(map[string][]uint64)
+	for _, v := range prof[profileSample] {
+		s := decode(t, v.([]byte))
+		var frames []string
+		for _, id := range s.packed(sampleLocationID) {
+			frames = append(frames, locations[id])
+		}
+		stacks[strings.Join(frames, " ")] = s.packed(sampleValue)
+	}
+	return stacks, prof, table
+}
+
+func TestSteps(t *testing.T) {
+	stacks, _, _ := profile(t, time.Hour)
+
+	steps := make(map[string]uint64)
+	for stack, values := range stacks {
+		if values[valueSamples] != 0 || values[valueCPU] != 0 {
+			t.Errorf("%s has samples %v within the first period", stack, values)
+		}
+		steps[stack] = values[valueSteps]
+	}
+	// the tail call of twice runs in place of twice, and fib returns on
+	// the line of its if
+	want := map[string]uint64{
+		"main:1":                   1,
+		"main:5":                   1,
+		"main:6":                   1,
+		"twice:5 main:6":           1,
+		"fn@6:7:6 twice:5 main:6":  1,
+		"fn@6:7:6 main:6":          1,
+		"main:7":                   1,
+		"fib:2 main:7":             1,
+		"fib:3 main:7":             1,
+		"fib:2 fib:3 main:7":       3,
+		"fib:3 fib:3 main:7":       1,
+		"fib:2 fib:3 fib:3 main:7": 4,
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got steps %v, want %v", steps, want)
+	}
+}
+
+func TestSamples(t *testing.T) {
+	stacks, prof, table := profile(t, time.Nanosecond)
+
+	var samples, cpu, steps uint64
+	for _, values := range stacks {
+		samples += values[valueSamples]
+		cpu += values[valueCPU]
+		steps += values[valueSteps]
+	}
+	if samples == 0 || cpu != samples || steps != 17 {
+		t.Errorf("got %d samples of %dns and %d steps", samples, cpu, steps)
+	}
+
+	var types []string
+	for _, v := range prof[profileSampleType] {
+		vt := decode(t, v.([]byte))
+		types = append(types, table[vt.int(valueTypeType)]+"/"+table[vt.int(valueTypeUnit)])
+	}
+	if got := strings.Join(types, " "); got != "samples/count cpu/nanoseconds steps/count" {
+		t.Errorf("sample types %s", got)
+	}
+	if table[prof.int(profileDefaultSampleType)] != "steps" || prof.int(profilePeriod) != 1 {
+		t.Errorf("default sample type %s, period %d", table[prof.int(profileDefaultSampleType)], prof.int(profilePeriod))
+	}
+	if prof.int(profileTimeNanos) == 0 || prof.int(profileDurationNanos) == 0 {
+		t.Error("no time or duration")
+	}
+}
+
+// TestShortProgram checks that a program that ends within the default
+// period still has something to show by the default sample type.
+func TestShortProgram(t *testing.T) {
+	stacks, prof, table := profile(t, 0)
+
+	index := -1
+	for i, v := range prof[profileSampleType] {
+		if vt := decode(t, v.([]byte)); vt.int(valueTypeType) == prof.int(profileDefaultSampleType) {
+			index = i
+		}
+	}
+	if index < 0 {
+		t.Fatalf("default sample type %q is not a sample type", table[prof.int(profileDefaultSampleType)])
+	}
+
+	var total uint64
+	for _, values := range stacks {
+		total += values[index]
+	}
+	if total == 0 {
+		t.Errorf("no %s in the profile", table[prof.int(profileDefaultSampleType)])
+	}
+}
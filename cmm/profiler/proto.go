@@ -0,0 +1,109 @@
+package profiler
+
+// The parts of the profile.proto format of pprof the profiler writes, see
+// https://github.com/google/pprof/blob/main/proto/profile.proto. Each
+// message is encoded by hand with the protobuf wire format.
+
+// The field numbers of the messages.
+const (
+	// Profile
+	profileSampleType        = 1
+	profileSample            = 2
+	profileMapping           = 3
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+
+	// ValueType
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	// Sample
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	// Mapping
+	mappingID           = 1
+	mappingFilename     = 5
+	mappingHasFunctions = 7
+
+	// Location
+	locationID        = 1
+	locationMappingID = 2
+	locationLine      = 4
+
+	// Line
+	lineFunctionID = 1
+	lineLine       = 2
+
+	// Function
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+// The wire types.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// buffer builds an encoded message.
+type buffer struct {
+	data []byte
+}
+
+func (b *buffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *buffer) key(field, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+// int64 writes a varint field, which is left out if it is zero as proto3
+// does.
+func (b *buffer) int64(field int, x int64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, wireVarint)
+	b.varint(uint64(x))
+}
+
+func (b *buffer) bytes(field int, data []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *buffer) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+// message writes the message that encode builds as a field.
+func (b *buffer) message(field int, encode func(m *buffer)) {
+	var m buffer
+	encode(&m)
+	b.bytes(field, m.data)
+}
+
+// packed writes a repeated varint field in its packed form.
+func (b *buffer) packed(field int, xs []uint64) {
+	var m buffer
+	for _, x := range xs {
+		m.varint(x)
+	}
+	b.bytes(field, m.data)
+}
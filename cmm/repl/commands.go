@@ -0,0 +1,221 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+)
+
+const help = `Type code to run it, or one of these commands:
+
+  :help        list the commands
+  :type CODE   print the type of CODE without running it
+  :env         list the globals defined so far, with their types
+  :ast CODE    print the syntax tree of CODE
+  :tokens CODE print the tokens of CODE
+  :time CODE   run CODE and report how long it took and what it allocated
+  :load FILE   run the code in FILE
+  :save FILE   write the code that ran without errors so far to FILE
+  :reset       forget the globals and the code run so far
+  :color on|off
+               turn colored output on or off
+  exit()       leave the REPL
+`
+
+// A command is a line of the REPL that starts with a colon, followed by
+// the name of the command and its argument.
+type command struct {
+	run func(s *session, arg string)
+
+	// arg names the argument the command takes, if any
+	arg string
+
+	// code reports whether the argument is code, which can go on over
+	// several lines like other input
+	code bool
+}
+
+var commands = map[string]command{
+	"help":   {run: (*session).help},
+	"type":   {run: (*session).typeOf, arg: "CODE", code: true},
+	"env":    {run: (*session).env},
+	"ast":    {run: (*session).ast, arg: "CODE", code: true},
+	"tokens": {run: (*session).tokens, arg: "CODE", code: true},
+	"time":   {run: (*session).time, arg: "CODE", code: true},
+	"load":   {run: (*session).load, arg: "FILE"},
+	"save":   {run: (*session).save, arg: "FILE"},
+	"reset":  {run: (*session).resetCommand},
+	"color":  {run: (*session).colorCommand, arg: "on|off"},
+}
+
+// commandLine splits a command line into the name of the command and its
+// argument, or reports false if line is not a command.
+func commandLine(line string) (name, arg string, ok bool) {
+	rest, ok := strings.CutPrefix(line, ":")
+	if !ok {
+		return "", "", false
+	}
+	name, arg, _ = strings.Cut(rest, " ")
+	return name, strings.TrimSpace(arg), true
+}
+
+// command runs the command name with arg.
+func (s *session) command(name, arg string) {
+	cmd, ok := commands[name]
+	switch {
+	case !ok:
+		s.printError(fmt.Errorf("unknown command :%s, :help lists the commands", name))
+	case cmd.arg != "" && arg == "":
+		s.printError(fmt.Errorf("usage: :%s %s", name, cmd.arg))
+	case cmd.arg == "" && arg != "":
+		s.printError(fmt.Errorf("usage: :%s", name))
+	default:
+		cmd.run(s, arg)
+	}
+}
+
+func (s *session) help(string) {
+	io.WriteString(s.out, help)
+}
+
+// typeOf prints the type of the value of src, which is not run.
+func (s *session) typeOf(src string) {
+	program, ok := s.parse(src)
+	if !ok {
+		return
+	}
+
+	t, errs := s.checker.TypeOf(program)
+	if len(errs) != 0 {
+		for _, err := range errs {
+			s.printError(err)
+		}
+		return
+	}
+	io.WriteString(s.out, s.highlight(t.String())+"\n")
+}
+
+// env prints the globals with their types, sorted by name.
+func (s *session) env(string) {
+	names := s.globalNames()
+	sort.Strings(names)
+	for _, name := range names {
+		if t, ok := s.checker.Global(name); ok {
+			fmt.Fprintf(s.out, "%s: %s\n", name, s.highlight(t.String()))
+		} else {
+			fmt.Fprintf(s.out, "%s\n", name)
+		}
+	}
+}
+
+func (s *session) ast(src string) {
+	if program, ok := s.parse(src); ok {
+		ast.Fprint(s.out, program)
+	}
+}
+
+func (s *session) tokens(src string) {
+	l := lexer.New(src)
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			return
+		}
+		fmt.Fprintf(s.out, "%d:%d\t%s\t%q\n", tok.Line, tok.Column, tok.Type, tok.Literal)
+	}
+}
+
+// time runs src and reports how long it ran, leaving out parsing and
+// checking it, and the memory it allocated meanwhile.
+func (s *session) time(src string) {
+	program, ok := s.prepare(src)
+	if !ok {
+		return
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	result, ok := s.execute(program)
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	if ok {
+		if result != nil {
+			s.printValue(result)
+		}
+		s.inputs = append(s.inputs, src)
+	}
+	fmt.Fprintf(s.out, "time: %s, %d allocations, %d bytes\n",
+		elapsed, after.Mallocs-before.Mallocs, after.TotalAlloc-before.TotalAlloc)
+}
+
+// load runs the code in file as one input.
+func (s *session) load(file string) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		s.printError(err)
+		return
+	}
+	s.run(string(src))
+}
+
+// save writes the inputs that ran without errors to file, so that :load
+// can bring the session back.
+func (s *session) save(file string) {
+	var src strings.Builder
+	for _, input := range s.inputs {
+		src.WriteString(terminated(input) + "\n")
+	}
+	if err := os.WriteFile(file, []byte(src.String()), 0644); err != nil {
+		s.printError(err)
+	}
+}
+
+func (s *session) resetCommand(string) {
+	s.reset()
+}
+
+func (s *session) colorCommand(arg string) {
+	switch arg {
+	case "on":
+		s.color = true
+	case "off":
+		s.color = false
+	default:
+		s.printError(errors.New("usage: :color on|off"))
+	}
+}
+
+// terminated returns input ending in a semicolon, so that the input saved
+// after it cannot go on with its last expression, as "-1" would after "1".
+func terminated(input string) string {
+	input = strings.TrimRight(input, " \t\r\n")
+
+	l := lexer.New(input)
+	var last token.Token
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		last = tok
+	}
+	if last.Type == "" || last.Type == token.SEMICOLON {
+		return input
+	}
+
+	// a comment at the end would swallow the semicolon
+	if comments := l.Comments(); len(comments) != 0 {
+		c := comments[len(comments)-1]
+		if c.Line > last.Line || c.Line == last.Line && c.Column > last.Column {
+			return input + "\n;"
+		}
+	}
+	return input + ";"
+}
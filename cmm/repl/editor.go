@@ -0,0 +1,386 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ctrl returns the character a control key sends along with key.
+func ctrl(key rune) rune {
+	return key & 0x1f
+}
+
+// The keys that send escape sequences.
+const (
+	keyNone = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+)
+
+// editor edits a line of input on a terminal in raw mode. It moves over
+// the line with the arrow keys, Home and End or Ctrl-A, Ctrl-E, Ctrl-B and
+// Ctrl-F, goes through the history with Up and Down or Ctrl-P and Ctrl-N,
+// searches it backwards with Ctrl-R and completes names with Tab. Ctrl-K,
+// Ctrl-U and Ctrl-W delete to the end of the line, to its start and the
+// word before the cursor.
+type editor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	history *history
+
+	// names returns the names Tab completes
+	names func() []string
+
+	// highlight, if set, returns a line as it is shown
+	highlight func(string) string
+
+	prompt string
+	buf    []rune
+	pos    int
+
+	// browsing is the index of the history entry shown, or
+	// len(history.entries) for the line being typed, which draft keeps
+	// while another is shown.
+	browsing int
+	draft    []rune
+}
+
+func newEditor(in io.Reader, out io.Writer, h *history, names func() []string) *editor {
+	return &editor{in: bufio.NewReader(in), out: out, history: h, names: names}
+}
+
+// readLine shows prompt and returns the line the user enters, which it
+// adds to the history. It returns errInterrupted for Ctrl-C, and io.EOF
+// for Ctrl-D on an empty line.
+func (e *editor) readLine(prompt string) (string, error) {
+	e.prompt, e.buf, e.pos = prompt, nil, 0
+	e.browsing, e.draft = len(e.history.entries), nil
+	e.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			return e.accept(), nil
+		case ctrl('C'):
+			io.WriteString(e.out, "^C\r\n")
+			return "", errInterrupted
+		case ctrl('D'):
+			if len(e.buf) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.deleteAt(e.pos)
+		case ctrl('A'):
+			e.pos = 0
+		case ctrl('E'):
+			e.pos = len(e.buf)
+		case ctrl('B'):
+			e.pos = max(e.pos-1, 0)
+		case ctrl('F'):
+			e.pos = min(e.pos+1, len(e.buf))
+		case ctrl('K'):
+			e.buf = e.buf[:e.pos]
+		case ctrl('U'):
+			e.buf = e.buf[e.pos:]
+			e.pos = 0
+		case ctrl('W'):
+			e.deleteWord()
+		case ctrl('P'):
+			e.browse(e.browsing - 1)
+		case ctrl('N'):
+			e.browse(e.browsing + 1)
+		case ctrl('L'):
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+		case ctrl('R'):
+			run, err := e.search()
+			if err != nil {
+				return "", err
+			}
+			if run {
+				return e.accept(), nil
+			}
+		case '\t':
+			e.complete()
+		case 0x7f, ctrl('H'):
+			if e.pos > 0 {
+				e.pos--
+				e.deleteAt(e.pos)
+			}
+		case 0x1b:
+			e.escape(e.readEscape())
+		default:
+			if unicode.IsPrint(r) {
+				e.insert([]rune{r})
+			}
+		}
+		e.refresh()
+	}
+}
+
+// accept ends the line being edited and returns it.
+func (e *editor) accept() string {
+	io.WriteString(e.out, "\r\n")
+	line := string(e.buf)
+	e.history.add(line)
+	return line
+}
+
+// refresh redraws the line and puts the cursor in place.
+func (e *editor) refresh() {
+	e.draw(e.prompt, string(e.buf), e.pos)
+}
+
+func (e *editor) draw(prompt, line string, pos int) {
+	column := utf8.RuneCountInString(prompt) + pos
+	if e.highlight != nil {
+		line = e.highlight(line)
+	}
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K\r", prompt, line)
+	if column > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dC", column)
+	}
+}
+
+func (e *editor) insert(runes []rune) {
+	e.buf = append(e.buf[:e.pos], append(runes, e.buf[e.pos:]...)...)
+	e.pos += len(runes)
+}
+
+func (e *editor) deleteAt(pos int) {
+	if pos < len(e.buf) {
+		e.buf = append(e.buf[:pos], e.buf[pos+1:]...)
+	}
+}
+
+// deleteWord deletes the word before the cursor and the spaces after it.
+func (e *editor) deleteWord() {
+	start := e.pos
+	for start > 0 && unicode.IsSpace(e.buf[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(e.buf[start-1]) {
+		start--
+	}
+	e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+	e.pos = start
+}
+
+// browse shows history entry i in place of the line.
+func (e *editor) browse(i int) {
+	entries := e.history.entries
+	if i < 0 || i > len(entries) || i == e.browsing {
+		return
+	}
+
+	if e.browsing == len(entries) {
+		e.draft = e.buf
+	}
+	e.browsing = i
+	if i == len(entries) {
+		e.buf = e.draft
+	} else {
+		e.buf = []rune(entries[i])
+	}
+	e.pos = len(e.buf)
+}
+
+// readEscape reads the rest of an escape sequence and returns the key it
+// stands for, or keyNone.
+func (e *editor) readEscape() int {
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return keyNone
+	}
+
+	var seq string
+	switch r {
+	case '[':
+		// parameters, then a final character from @ to ~
+		for {
+			r, _, err := e.in.ReadRune()
+			if err != nil {
+				return keyNone
+			}
+			seq += string(r)
+			if r >= '@' && r <= '~' {
+				break
+			}
+		}
+	case 'O':
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return keyNone
+		}
+		seq = string(r)
+	default:
+		return keyNone
+	}
+
+	switch seq {
+	case "A":
+		return keyUp
+	case "B":
+		return keyDown
+	case "C":
+		return keyRight
+	case "D":
+		return keyLeft
+	case "H", "1~", "7~":
+		return keyHome
+	case "F", "4~", "8~":
+		return keyEnd
+	case "3~":
+		return keyDelete
+	}
+	return keyNone
+}
+
+func (e *editor) escape(key int) {
+	switch key {
+	case keyUp:
+		e.browse(e.browsing - 1)
+	case keyDown:
+		e.browse(e.browsing + 1)
+	case keyLeft:
+		e.pos = max(e.pos-1, 0)
+	case keyRight:
+		e.pos = min(e.pos+1, len(e.buf))
+	case keyHome:
+		e.pos = 0
+	case keyEnd:
+		e.pos = len(e.buf)
+	case keyDelete:
+		e.deleteAt(e.pos)
+	}
+}
+
+// search searches the history backwards for the lines that contain what
+// the user types. Ctrl-R goes on to an older match and Ctrl-G or Ctrl-C
+// give up the search. Enter puts the match in place of the line and
+// reports true, to run it; any other key puts it there to edit.
+func (e *editor) search() (run bool, err error) {
+	var query []rune
+	match := -1
+	last := len(e.history.entries) - 1
+
+	for {
+		found := ""
+		status := "reverse-i-search"
+		if match >= 0 {
+			found = e.history.entries[match]
+		} else if len(query) != 0 {
+			status = "failing reverse-i-search"
+		}
+		prompt := fmt.Sprintf("(%s)`%s': ", status, string(query))
+		pos := max(strings.Index(found, string(query)), 0)
+		e.draw(prompt, found, utf8.RuneCountInString(found[:pos]))
+
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case r == ctrl('R'):
+			if match > 0 {
+				if older := e.history.find(string(query), match-1); older >= 0 {
+					match = older
+				}
+			}
+		case r == ctrl('G') || r == ctrl('C'):
+			return false, nil
+		case r == 0x7f || r == ctrl('H'):
+			match = -1
+			if len(query) != 0 {
+				query = query[:len(query)-1]
+			}
+			if len(query) != 0 {
+				match = e.history.find(string(query), last)
+			}
+		case unicode.IsPrint(r):
+			query = append(query, r)
+			from := last
+			if match >= 0 {
+				from = match
+			}
+			match = e.history.find(string(query), from)
+		default:
+			if r == 0x1b {
+				e.readEscape()
+			}
+			if match < 0 {
+				return false, nil
+			}
+			e.buf = []rune(found)
+			e.pos = len(e.buf)
+			return r == '\r' || r == '\n', nil
+		}
+	}
+}
+
+// complete completes the name before the cursor. With more than one
+// completion, it fills in the part they share or else lists them.
+func (e *editor) complete() {
+	start := e.pos
+	for start > 0 && isNameRune(e.buf[start-1]) {
+		start--
+	}
+	prefix := string(e.buf[start:e.pos])
+	if prefix == "" {
+		return
+	}
+
+	candidates := completions(prefix, e.names())
+	if len(candidates) == 0 {
+		io.WriteString(e.out, "\a")
+		return
+	}
+
+	shared := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, shared) {
+			shared = shared[:len(shared)-1]
+		}
+	}
+	if len(shared) > len(prefix) {
+		e.insert([]rune(shared[len(prefix):]))
+		return
+	}
+	if len(candidates) > 1 {
+		io.WriteString(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+	}
+}
+
+// completions returns the names that start with prefix, sorted and
+// without repeats.
+func completions(prefix string, names []string) []string {
+	seen := map[string]bool{}
+	var found []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			found = append(found, name)
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+func isNameRune(r rune) bool {
+	return r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
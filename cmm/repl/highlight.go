@@ -0,0 +1,125 @@
+package repl
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+)
+
+// A style is the ANSI escape sequence that starts a kind of text.
+type style string
+
+const (
+	styleKeyword style = "\x1b[35m"
+	styleLiteral style = "\x1b[36m"
+	styleString  style = "\x1b[32m"
+	styleBuiltin style = "\x1b[34m"
+	styleComment style = "\x1b[90m"
+	styleError   style = "\x1b[1;31m"
+
+	styleReset = "\x1b[0m"
+)
+
+// paint returns text in style.
+func paint(s style, text string) string {
+	return string(s) + text + styleReset
+}
+
+// useColor reports whether output to out is colored: out has to be a
+// terminal and NO_COLOR, see https://no-color.org, not set.
+func useColor(out io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := out.(*os.File)
+	return ok && isTerminal(int(f.Fd()))
+}
+
+// tokenStyle returns the style of tok, or "" for text left as it is.
+func tokenStyle(tok token.Token) style {
+	switch tok.Type {
+	case token.FN, token.LET, token.IF, token.ELSE, token.RETURN:
+		return styleKeyword
+	case token.TRUE, token.FALSE, token.INT:
+		return styleLiteral
+	case token.STRING:
+		return styleString
+	case token.COMMENT:
+		return styleComment
+	case token.IDENT:
+		if object.GetBuiltinByName(tok.Literal) != nil {
+			return styleBuiltin
+		}
+	}
+	return ""
+}
+
+// span is the text of a token or comment in the source.
+type span struct {
+	start, end int
+	style      style
+}
+
+// highlight returns src with its tokens and comments in the styles of
+// their kinds. The text between them is kept as it is.
+func highlight(src string) string {
+	// the offsets the lines of src start at
+	lines := []int{0}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	offset := func(tok token.Token) int {
+		return lines[tok.Line-1] + tok.Column - 1
+	}
+
+	var spans []span
+	l := lexer.New(src)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		start := offset(tok)
+		end := start + len(tok.Literal)
+		if tok.Type == token.STRING || tok.Type == token.ILLEGAL && src[start] == '"' {
+			// the literal of a string has its escapes replaced
+			tok.Type = token.STRING
+			end = stringEnd(src, start)
+		}
+		if s := tokenStyle(tok); s != "" {
+			spans = append(spans, span{start, end, s})
+		}
+	}
+	for _, c := range l.Comments() {
+		start := offset(c)
+		spans = append(spans, span{start, start + len(c.Literal), styleComment})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var out strings.Builder
+	last := 0
+	for _, s := range spans {
+		out.WriteString(src[last:s.start])
+		out.WriteString(paint(s.style, src[s.start:s.end]))
+		last = s.end
+	}
+	out.WriteString(src[last:])
+	return out.String()
+}
+
+// stringEnd returns the offset after the string literal that starts at
+// start in src, or the end of src if the string is not closed.
+func stringEnd(src string, start int) int {
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(src)
+}
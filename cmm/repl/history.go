@@ -0,0 +1,88 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// historySize is the number of lines the history keeps.
+const historySize = 1000
+
+// history holds the lines entered in the REPL, oldest first, and the file
+// it is saved to between sessions, if any.
+type history struct {
+	entries []string
+	path    string
+}
+
+// historyPath returns the file the history is saved to, in the user's
+// config directory.
+func historyPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cminusminus", "history")
+}
+
+// loadHistory reads the history saved at path. A history that cannot be
+// read starts out empty.
+func loadHistory(path string) *history {
+	h := &history{path: path}
+	if path == "" {
+		return h
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		h.add(line)
+	}
+	return h
+}
+
+// add appends line to the history, unless it is blank or repeats the last
+// line.
+func (h *history) add(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(h.entries); n != 0 && h.entries[n-1] == line {
+		return
+	}
+
+	h.entries = append(h.entries, line)
+	if len(h.entries) > historySize {
+		h.entries = h.entries[len(h.entries)-historySize:]
+	}
+}
+
+// find returns the index of the latest entry at or before from that
+// contains query, or -1.
+func (h *history) find(query string, from int) int {
+	for i := min(from, len(h.entries)-1); i >= 0; i-- {
+		if strings.Contains(h.entries[i], query) {
+			return i
+		}
+	}
+	return -1
+}
+
+// save writes the history to its file.
+func (h *history) save() error {
+	if h.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0700); err != nil {
+		return err
+	}
+
+	var data strings.Builder
+	for _, line := range h.entries {
+		data.WriteString(line + "\n")
+	}
+	return os.WriteFile(h.path, []byte(data.String()), 0600)
+}
@@ -0,0 +1,163 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+)
+
+// continues holds the tokens that cannot end a piece of input, since an
+// operand or another parameter has to follow them.
+var continues = map[token.TokenType]bool{
+	token.ASSIGN:    true,
+	token.PLUS:      true,
+	token.MINUS:     true,
+	token.ASTERISK:  true,
+	token.FOR_SLASH: true,
+	token.EXCLAIM:   true,
+	token.GREATER:   true,
+	token.SMALLER:   true,
+	token.EQ:        true,
+	token.NOT_EQ:    true,
+	token.COMMA:     true,
+	token.COLON:     true,
+	token.ARROW:     true,
+}
+
+// complete reports whether src can run as it is, or is the start of input
+// that goes on over more lines: it leaves a brace, parenthesis, bracket or
+// string open, or ends in an operator. Input with more closing brackets
+// than opening ones is complete, so that the parser reports it.
+func complete(src string) bool {
+	l := lexer.New(src)
+	depth := 0
+	var last token.Token
+	for {
+		tok := l.NextToken()
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		case token.ILLEGAL:
+			if openString(src, tok) {
+				return false
+			}
+		case token.EOF:
+			return depth <= 0 && !continues[last.Type]
+		}
+		if depth < 0 {
+			return true
+		}
+		last = tok
+	}
+}
+
+// openString reports whether the illegal token tok is a string that the
+// input ends before the closing quote of.
+func openString(src string, tok token.Token) bool {
+	lines := strings.Split(src, "\n")
+	line := lines[tok.Line-1]
+	return tok.Column <= len(line) && line[tok.Column-1] == '"'
+}
+
+// errInterrupted is returned by a lineReader when the user interrupts the
+// input with Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// A lineReader reads the input of the REPL a line at a time.
+type lineReader interface {
+	// readLine shows prompt and returns the next line. It returns
+	// errInterrupted if the user interrupts it and io.EOF at the end of the
+	// input.
+	readLine(prompt string) (string, error)
+	close()
+}
+
+// newLineReader returns a terminal reader, which edits the lines, if in is
+// a terminal, and a plain reader otherwise. The terminal reader completes
+// names with those returned by names and shows lines as highlight returns
+// them.
+func newLineReader(in io.Reader, out io.Writer, names func() []string, highlight func(string) string) lineReader {
+	if f, ok := in.(*os.File); ok && isTerminal(int(f.Fd())) {
+		h := loadHistory(historyPath())
+		e := newEditor(in, out, h, names)
+		e.highlight = highlight
+		return &terminalReader{fd: int(f.Fd()), editor: e}
+	}
+	return newPlainReader(in, out)
+}
+
+// terminalReader puts the terminal in raw mode while the editor reads a
+// line, and saves the history when it is closed.
+type terminalReader struct {
+	fd int
+	*editor
+}
+
+func (t *terminalReader) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(t.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	return t.editor.readLine(prompt)
+}
+
+func (t *terminalReader) close() {
+	t.history.save()
+}
+
+// plainReader reads lines as they come, for input that is not a terminal
+// or a terminal that is left in its usual mode. It stops waiting for a
+// line on SIGINT, which it only catches while it waits, so that SIGINT
+// still stops a program that runs away.
+type plainReader struct {
+	lines      <-chan string
+	interrupts chan os.Signal
+	out        io.Writer
+}
+
+func newPlainReader(in io.Reader, out io.Writer) *plainReader {
+	return &plainReader{lines: readLines(in), interrupts: make(chan os.Signal, 1), out: out}
+}
+
+func (r *plainReader) readLine(prompt string) (string, error) {
+	signal.Notify(r.interrupts, os.Interrupt)
+	defer signal.Stop(r.interrupts)
+
+	io.WriteString(r.out, prompt)
+	select {
+	case line, ok := <-r.lines:
+		if !ok {
+			return "", io.EOF
+		}
+		return line, nil
+	case <-r.interrupts:
+		io.WriteString(r.out, "\n")
+		return "", errInterrupted
+	}
+}
+
+func (r *plainReader) close() {}
+
+// readLines sends the lines of in on the channel it returns, which it
+// closes at the end of in. Reading in the background lets the REPL stop
+// waiting for a line when it is interrupted.
+func readLines(in io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
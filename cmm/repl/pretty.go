@@ -0,0 +1,89 @@
+package repl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/format"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// The limits of pretty printing, past which a value is cut short.
+const (
+	// maxWidth is the width of the longest array kept on one line
+	maxWidth = 72
+	// maxElements is the number of elements of an array shown
+	maxElements = 50
+	// maxDepth is the depth of the arrays shown in arrays
+	maxDepth = 5
+	// maxString is the number of bytes of a string shown in an array
+	maxString = 200
+	// maxLines is the number of lines of a function shown
+	maxLines = 20
+)
+
+// pretty returns obj laid out as source, which highlight can color. A
+// long array has an element per line, a function is formatted, and deep
+// or long values are cut short, with "..." for what is left out.
+func pretty(obj object.Object) string {
+	return prettyValue(obj, 0, "")
+}
+
+// prettyValue returns obj nested depth arrays deep, on lines indented by
+// indent.
+func prettyValue(obj object.Object, depth int, indent string) string {
+	switch obj := obj.(type) {
+	case *object.StringObject:
+		value := obj.Value
+		if len(value) > maxString {
+			value = value[:maxString] + "..."
+		}
+		return ast.QuoteString(value)
+	case *object.ArrayObject:
+		return prettyArray(obj, depth, indent)
+	case *object.Function, *object.Closure:
+		return prettyFunction(obj.Inspect(), indent)
+	}
+	return obj.Inspect()
+}
+
+func prettyArray(array *object.ArrayObject, depth int, indent string) string {
+	if len(array.Elements) == 0 {
+		return "[]"
+	}
+	if depth == maxDepth {
+		return "[...]"
+	}
+
+	inner := indent + "    "
+	var elements []string
+	for i, e := range array.Elements {
+		if i == maxElements {
+			elements = append(elements, fmt.Sprintf("... %d more", len(array.Elements)-i))
+			break
+		}
+		elements = append(elements, prettyValue(e, depth+1, inner))
+	}
+
+	line := "[" + strings.Join(elements, ", ") + "]"
+	if len(indent)+len(line) <= maxWidth && !strings.Contains(line, "\n") {
+		return line
+	}
+	return "[\n" + inner + strings.Join(elements, ",\n"+inner) + "\n" + indent + "]"
+}
+
+// prettyFunction formats the source of a function, as its Inspect gives
+// it, and indents its lines after the first by indent.
+func prettyFunction(src, indent string) string {
+	formatted, err := format.Source([]byte(src))
+	if err != nil {
+		return src
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(formatted), ";\n"), "\n")
+	if len(lines) > maxLines {
+		lines = append(lines[:maxLines-1], "    ...", "}")
+	}
+	return strings.Join(lines, "\n"+indent)
+}
@@ -1,57 +1,256 @@
 package repl
 
 import (
-	"bufio"
-	"fmt"
+	"errors"
 	"io"
+	"strings"
 
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/compiler"
 	"github.com/shoebilyas123/cminusminus/cmm/eval"
 	"github.com/shoebilyas123/cminusminus/cmm/lexer"
 	"github.com/shoebilyas123/cminusminus/cmm/object"
 	"github.com/shoebilyas123/cminusminus/cmm/parser"
+	"github.com/shoebilyas123/cminusminus/cmm/resolver"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+	"github.com/shoebilyas123/cminusminus/cmm/types"
+	"github.com/shoebilyas123/cminusminus/cmm/vm"
 )
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+// The engines a program can run on.
+const (
+	EngineEval = "eval"
+	EngineVM   = "vm"
+)
+
+// Start reads input from in, a line at a time, and prints what it
+// evaluates to. Input that is not complete, such as a function whose body
+// is still open, goes on over the following lines, shown with a
+// continuation prompt, and an interrupt drops it. If in is a terminal,
+// lines can be edited, with a history saved between sessions and
+// completion of the names in scope. Lines starting with a colon are
+// commands, which :help lists.
+func Start(in io.Reader, out io.Writer, engine string) {
 	PROMPT := ">> "
-	environment := object.NewEnvironment()
+	CONTINUATION := ".. "
 
-	for {
-		fmt.Printf(PROMPT)
+	s := newSession(out, engine)
+	s.color = useColor(out)
+	reader := newLineReader(in, out, s.names, s.highlight)
+	defer reader.close()
+
+	// the lines of the input read so far
+	var pending []string
 
-		scanned := scanner.Scan()
+	for {
+		prompt := PROMPT
+		if len(pending) != 0 {
+			prompt = CONTINUATION
+		}
 
-		if !scanned {
+		next, err := reader.readLine(prompt)
+		if err == errInterrupted {
+			pending = nil
+			continue
+		}
+		if err != nil {
 			return
 		}
 
-		line := scanner.Text()
+		pending = append(pending, next)
+		line := strings.Join(pending, "\n")
+		if !s.complete(line) {
+			continue
+		}
+		pending = nil
 
 		if line == "exit()" {
 			break
 		}
 
-		l := lexer.New(line)
-		p := parser.New(l)
+		if name, arg, ok := commandLine(line); ok {
+			s.command(name, arg)
+			continue
+		}
+
+		s.run(line)
+	}
+}
 
-		program := p.ParseProgram()
+// session holds the state of a REPL session: the values and types of the
+// globals defined so far and the inputs that defined them.
+type session struct {
+	out    io.Writer
+	engine string
 
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
-			continue
+	// color reports whether output is colored
+	color bool
+
+	environment *object.Environment
+	checker     *types.Checker
+
+	// state the vm engine keeps between inputs
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+
+	// inputs holds the inputs that ran without errors, for :save
+	inputs []string
+}
+
+func newSession(out io.Writer, engine string) *session {
+	s := &session{out: out, engine: engine}
+	s.reset()
+	return s
+}
+
+// reset drops the globals and inputs of the session.
+func (s *session) reset() {
+	s.environment = object.NewEnvironment()
+	s.checker = types.NewChecker()
+	s.constants = []object.Object{}
+	s.globals = vm.NewGlobalsStore()
+	s.symbolTable = compiler.New().SymbolTable()
+	s.inputs = nil
+}
+
+// complete reports whether input is complete, as a command, or as code
+// which may go on over more lines.
+func (s *session) complete(input string) bool {
+	if name, arg, ok := commandLine(input); ok {
+		cmd, ok := commands[name]
+		return !ok || !cmd.code || complete(arg)
+	}
+	return complete(input)
+}
+
+// names returns the keywords, builtins and globals, which the line editor
+// completes.
+func (s *session) names() []string {
+	names := token.Keywords()
+	for _, builtin := range object.Builtins {
+		names = append(names, builtin.Name)
+	}
+	return append(names, s.globalNames()...)
+}
+
+// globalNames returns the names of the globals defined so far.
+func (s *session) globalNames() []string {
+	if s.engine == EngineVM {
+		return s.symbolTable.Names()
+	}
+	return s.environment.Names()
+}
+
+// run runs src and prints its value, or its errors. It reports whether src
+// ran without errors.
+func (s *session) run(src string) bool {
+	program, ok := s.prepare(src)
+	if !ok {
+		return false
+	}
+	result, ok := s.execute(program)
+	if !ok {
+		return false
+	}
+	if result != nil {
+		s.printValue(result)
+	}
+
+	s.inputs = append(s.inputs, src)
+	return true
+}
+
+// prepare parses and checks src, printing the errors that keep it from
+// running.
+func (s *session) prepare(src string) (*ast.Program, bool) {
+	program, ok := s.parse(src)
+	if !ok {
+		return nil, false
+	}
+	if errs := types.Blocking(s.checker.Check(program)); len(errs) != 0 {
+		for _, err := range errs {
+			s.printError(err)
 		}
+		return nil, false
+	}
+	return program, true
+}
+
+func (s *session) parse(src string) (*ast.Program, bool) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			s.printError(errors.New(msg))
+		}
+		return nil, false
+	}
+	return program, true
+}
 
-		evaluated := eval.Eval(program, environment)
+// execute runs program on the engine of the session and returns its value,
+// or prints its error.
+func (s *session) execute(program *ast.Program) (object.Object, bool) {
+	if s.engine == EngineVM {
+		comp := compiler.NewWithState(s.symbolTable, s.constants)
+		if err := comp.Compile(program); err != nil {
+			s.printRuntimeError(err.Error())
+			return nil, false
+		}
+		bytecode := comp.Bytecode()
+		s.constants = bytecode.Constants
 
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+		machine := vm.NewWithGlobalsStore(bytecode, s.globals)
+		if err := machine.Run(); err != nil {
+			s.printRuntimeError(err.Error())
+			return nil, false
 		}
+		return machine.LastPoppedStackElem(), true
+	}
+
+	resolver.Resolve(program, nil)
+
+	evaluated := eval.Eval(program, s.environment)
+	if errObj, ok := evaluated.(*object.ErrorObject); ok {
+		s.printRuntimeError(errObj.Message)
+		return nil, false
+	}
+	return evaluated, true
+}
+
+// printValue prints a value, laid out by pretty and highlighted. A string
+// is printed as it is.
+func (s *session) printValue(obj object.Object) {
+	if str, ok := obj.(*object.StringObject); ok {
+		io.WriteString(s.out, s.paint(styleString, str.Value)+"\n")
+		return
+	}
+	io.WriteString(s.out, s.highlight(pretty(obj))+"\n")
+}
+
+// printError prints an error that kept the input from running.
+func (s *session) printError(err error) {
+	io.WriteString(s.out, "\t"+s.paint(styleError, err.Error())+"\n")
+}
+
+// printRuntimeError prints an error the input ran into.
+func (s *session) printRuntimeError(msg string) {
+	io.WriteString(s.out, s.paint(styleError, "ERROR: "+msg)+"\n")
+}
+
+// highlight returns src highlighted, if output is colored.
+func (s *session) highlight(src string) string {
+	if !s.color {
+		return src
 	}
+	return highlight(src)
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+// paint returns text in style st, if output is colored.
+func (s *session) paint(st style, text string) string {
+	if !s.color {
+		return text
 	}
+	return paint(st, text)
 }
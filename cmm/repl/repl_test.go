@@ -0,0 +1,325 @@
+package repl
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"", true},
+		{"1 + 2", true},
+		{"let add = fn(a, b) {", false},
+		{"let add = fn(a, b) {\n    a + b\n", false},
+		{"let add = fn(a, b) {\n    a + b\n}", true},
+		{"f(1,", false},
+		{"f(1,\n2)", true},
+		{"args[", false},
+		{"let x =", false},
+		{"1 +", false},
+		{"1 ==", false},
+		{"fn(a) ->", false},
+		{"let x:", false},
+		{"1 + // more to come", false},
+		{`"open`, false},
+		{"\"open\nstill", false},
+		{`"closed"`, true},
+		{"@", true},
+		{"1 }", true},
+		{"} {", true},
+	}
+
+	for _, tt := range tests {
+		if got := complete(tt.input); got != tt.want {
+			t.Errorf("complete(%q) = %t, want %t", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestMultiLineInput(t *testing.T) {
+	input := "let add = fn(a, b) {\n\n    a +\n        b\n};\nadd(1,\n2)\n"
+	want := ">> .. .. .. .. fn(a, b) { a + b }\n>> .. 3\n>> "
+	for _, engine := range []string{EngineEval, EngineVM} {
+		var out strings.Builder
+		Start(strings.NewReader(input), &out, engine)
+
+		if got := out.String(); got != want {
+			t.Errorf("%s: output %q, want %q", engine, got, want)
+		}
+	}
+}
+
+func TestEditor(t *testing.T) {
+	tests := []struct {
+		keys string
+		want string
+	}{
+		{"abc\r", "abc"},
+		{"abc\x7f\x7fd\r", "ad"},
+		{"ac\x1b[Db\r", "abc"},
+		{"ac\x02b\x06d\n", "abcd"},
+		{"bc\x01a\x05d\r", "abcd"},
+		{"bc\x1b[Ha\x1b[Fd\r", "abcd"},
+		{"bc\x1bOHa\x1bOFd\r", "abcd"},
+		{"xy\x1b[H\x1b[3~\r", "y"},
+		{"xy\x01\x04\r", "y"},
+		{"let x = 1\x17\r", "let x = "},
+		{"abc\x1b[D\x1b[D\x0b\r", "a"},
+		{"abc\x1b[D\x15\r", "c"},
+		{"\x1b[A\r", "a + b"},
+		{"\x10\x10\r", "let b = 2"},
+		{"\x1b[A\x1b[A\x1b[A\x1b[A\r", "let a = 1"},
+		{"x\x1b[A\x1b[A\x1b[B\x1b[B\r", "x"},
+		{"x\x1b[A\x0e\r", "x"},
+		{"\x12b =\r", "let b = 2"},
+		{"\x12let\x12\x1b[Cx\r", "let a = 1x"},
+		{"\x12let\x12\x12\r", "let a = 1"},
+		{"\x12zzz\x07q\r", "q"},
+		{"\x12zz\x7f\x7f+\r", "a + b"},
+		{"ap\t(1)\r", "apply(1)"},
+		{"a\t\r", "a"},
+		{"f\t\r", "fn"},
+		{"x\t\r", "x"},
+		{"(le\t\r", "(le"},
+		{"let l\tn\r", "let len"},
+	}
+
+	for _, tt := range tests {
+		h := &history{entries: []string{"let a = 1", "let b = 2", "a + b"}}
+		names := func() []string { return []string{"add", "apply", "fn", "len", "let"} }
+		var out strings.Builder
+		e := newEditor(strings.NewReader(tt.keys), &out, h, names)
+
+		got, err := e.readLine(">> ")
+		if err != nil {
+			t.Errorf("keys %q: error %v", tt.keys, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("keys %q: line %q, want %q", tt.keys, got, tt.want)
+		}
+		if last := h.entries[len(h.entries)-1]; last != tt.want {
+			t.Errorf("keys %q: last history entry %q, want %q", tt.keys, last, tt.want)
+		}
+	}
+}
+
+func TestEditorEnd(t *testing.T) {
+	tests := []struct {
+		keys string
+		want error
+	}{
+		{"abc\x03", errInterrupted},
+		{"\x04", io.EOF},
+		{"abc", io.EOF},
+	}
+
+	for _, tt := range tests {
+		e := newEditor(strings.NewReader(tt.keys), io.Discard, &history{}, func() []string { return nil })
+		if _, err := e.readLine(">> "); err != tt.want {
+			t.Errorf("keys %q: error %v, want %v", tt.keys, err, tt.want)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cminusminus", "history")
+	h := loadHistory(path)
+	for _, line := range []string{"a", "", "b", "b", "  ", "a"} {
+		h.add(line)
+	}
+	if want := []string{"a", "b", "a"}; !reflect.DeepEqual(h.entries, want) {
+		t.Errorf("entries %q, want %q", h.entries, want)
+	}
+
+	if i := h.find("b", 2); i != 1 {
+		t.Errorf("find(b, 2) = %d, want 1", i)
+	}
+	if i := h.find("c", 2); i != -1 {
+		t.Errorf("find(c, 2) = %d, want -1", i)
+	}
+
+	if err := h.save(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded := loadHistory(path); !reflect.DeepEqual(loaded.entries, h.entries) {
+		t.Errorf("loaded entries %q, want %q", loaded.entries, h.entries)
+	}
+
+	for i := 0; i < historySize+10; i++ {
+		h.add(strconv.Itoa(i))
+	}
+	if len(h.entries) != historySize || h.entries[0] != "10" {
+		t.Errorf("history of %d entries starting at %q, want %d starting at %q",
+			len(h.entries), h.entries[0], historySize, "10")
+	}
+}
+
+// runSession runs input in the REPL and returns what it prints, without
+// the prompts.
+func runSession(input, engine string) string {
+	var out strings.Builder
+	Start(strings.NewReader(input), &out, engine)
+	return strings.NewReplacer(">> ", "", ".. ", "").Replace(out.String())
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{":type fn(a) { a + 1 }", "fn(int) -> int\n"},
+		{":type fn(a) {\n a }", "fn(a) -> a\n"},
+		{"let x = 1;\nlet id = fn(a) { a };\n:env", "id: fn(a) -> a\nx: int\n"},
+		{":ast -a", "Program\n  ExpressionStatement (1:1)\n    PrefixExpression - (1:1)\n      Identifier a (1:2)\n"},
+		{":tokens f(\n1)", "1:1\tIDENT\t\"f\"\n1:2\t(\t\"(\"\n2:1\tINT\t\"1\"\n2:2\t)\t\")\"\n"},
+		{":type", "\tusage: :type CODE\n"},
+		{":env x", "\tusage: :env\n"},
+		{":load", "\tusage: :load FILE\n"},
+		{":nope", "\tunknown command :nope, :help lists the commands\n"},
+		{"let x = 1;\n:reset\n:env", ""},
+	}
+
+	for _, engine := range []string{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			got := runSession(tt.input, engine)
+			// leave out the values of the lets
+			got = strings.TrimPrefix(got, "1\nfn(a) { a }\n")
+			got = strings.TrimPrefix(got, "1\n")
+			if got != tt.want {
+				t.Errorf("%s: input %q: output %q, want %q", engine, tt.input, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	for _, engine := range []string{EngineEval, EngineVM} {
+		got := runSession("let f = fn(n) { 1 + f(n) };\nf(1)\n2", engine)
+		if want := "fn(n) { 1 + f(n) }\nERROR: stack overflow\n2\n"; got != want {
+			t.Errorf("%s: output %q, want %q", engine, got, want)
+		}
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.cmm")
+	input := "let x = 1\nlet y = x + z;\nx // one\n-1\nlet f = fn(a) {\n a * 2 }\n:save " + file
+	for _, engine := range []string{EngineEval, EngineVM} {
+		runSession(input, engine)
+
+		saved, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "let x = 1;\nx // one\n;\n-1;\nlet f = fn(a) {\n a * 2 };\n"
+		if string(saved) != want {
+			t.Errorf("%s: saved %q, want %q", engine, saved, want)
+		}
+
+		if got := runSession(":load "+file+"\n(f(x))", engine); !strings.HasSuffix(got, "\n2\n") {
+			t.Errorf("%s: output %q after :load, want it to end in 2", engine, got)
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	got := runSession(":time 1 + 2", EngineEval)
+	if !strings.HasPrefix(got, "3\ntime: ") || !strings.HasSuffix(got, " bytes\n") {
+		t.Errorf("output %q, want the value and the time", got)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	k, l, s, b, c := styleKeyword, styleLiteral, styleString, styleBuiltin, styleComment
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let x = 5;", paint(k, "let") + " x = " + paint(l, "5") + ";"},
+		{"if (true) { len(\"a\\\"b\") }", paint(k, "if") + " (" + paint(l, "true") + ") { " +
+			paint(b, "len") + "(" + paint(s, `"a\"b"`) + ") }"},
+		{"x // note\ny", "x " + paint(c, "// note") + "\ny"},
+		{"f(\"open", "f(" + paint(s, `"open`)},
+		{"fn(a: int) -> int", paint(k, "fn") + "(a: int) -> int"},
+		{"@ x", "@ x"},
+	}
+
+	for _, tt := range tests {
+		if got := highlight(tt.input); got != tt.want {
+			t.Errorf("highlight(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPretty(t *testing.T) {
+	array := func(elements ...object.Object) *object.ArrayObject {
+		return &object.ArrayObject{Elements: elements}
+	}
+	str := func(s string) object.Object { return &object.StringObject{Value: s} }
+	num := func(n int64) object.Object { return &object.IntegerObject{Value: n} }
+
+	long := array()
+	for i := 0; i < maxElements+3; i++ {
+		long.Elements = append(long.Elements, num(0))
+	}
+	deep := array(num(1))
+	for i := 0; i < maxDepth; i++ {
+		deep = array(deep)
+	}
+
+	tests := []struct {
+		value object.Object
+		want  string
+	}{
+		{num(5), "5"},
+		{array(), "[]"},
+		{array(num(1), str("a\n")), `[1, "a\n"]`},
+		{array(str(strings.Repeat("x", maxString+1))), "[\n    \"" + strings.Repeat("x", maxString) + "...\"\n]"},
+		{array(str(strings.Repeat("a", 40)), str(strings.Repeat("b", 40))),
+			"[\n    \"" + strings.Repeat("a", 40) + "\",\n    \"" + strings.Repeat("b", 40) + "\"\n]"},
+		{long, "[\n    " + strings.TrimSuffix(strings.Repeat("0,\n    ", maxElements), "\n    ") +
+			"\n    ... 3 more\n]"},
+		{deep, "[[[[[[...]]]]]]"},
+	}
+
+	for _, tt := range tests {
+		if got := pretty(tt.value); got != tt.want {
+			t.Errorf("pretty(%s) = %q, want %q", tt.value.Inspect(), got, tt.want)
+		}
+	}
+}
+
+func TestPrettyFunction(t *testing.T) {
+	var body strings.Builder
+	for i := 0; i < maxLines; i++ {
+		body.WriteString("let x = 1;\n")
+	}
+	got := runSession("let f = fn() {\n"+body.String()+"x };", EngineEval)
+
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != maxLines+1 || lines[0] != "fn() {" || lines[maxLines-1] != "    ..." {
+		t.Errorf("output %q, want the function cut short after %d lines", got, maxLines)
+	}
+}
+
+func TestColor(t *testing.T) {
+	got := runSession(":color on\n\"s\"\nlet x: int = true;\n:color off\nlet x: int = true;\n:color blue", EngineEval)
+	want := paint(styleString, "s") + "\n" +
+		"\t" + paint(styleError, "1:14: cannot use true (bool) as int in let x") + "\n" +
+		"\t1:14: cannot use true (bool) as int in let x\n" +
+		"\tusage: :color on|off\n"
+	if got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package repl
+
+import "errors"
+
+// Line editing is only supported on Linux; elsewhere the REPL reads its
+// input in plain mode.
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode is not supported")
+}
@@ -0,0 +1,236 @@
+// Package resolver binds the variables of a program before it runs, so
+// that eval can find them by position instead of by name.
+//
+// Every parameter and let of a function gets a slot in the function's
+// environment, and every identifier that refers to one is given its
+// ast.Binding. Globals keep being looked up by name: they may be defined
+// after the functions that use them, or from Go.
+//
+// Within a function, a let is visible from where it appears, as it is to
+// eval. Functions nested in it see all of its lets, since by the time
+// they run the lets before them have usually been run too; eval looks up
+// one that has not by name, as a global.
+//
+// The undefined variables Resolve returns are for cmm check and the
+// language server to report. A program that has them still runs, and
+// fails only if it reaches one.
+package resolver
+
+import (
+	"fmt"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// Error reports an identifier that refers to no variable.
+type Error struct {
+	Name   string
+	Line   int
+	Column int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: undefined variable %s", e.Line, e.Column, e.Name)
+}
+
+// scope holds the variables of one function.
+type scope struct {
+	slots map[string]int
+
+	// visible holds the variables declared so far
+	visible map[string]bool
+}
+
+type resolver struct {
+	scopes  []*scope
+	globals map[string]bool
+	defined func(name string) bool
+	errors  []*Error
+}
+
+// Resolve binds the identifiers of program and returns the ones that refer
+// to nothing: no variable, global of the program, or builtin. defined
+// reports other globals that exist when the program runs, such as those of
+// earlier programs in the same environment; it may be nil.
+//
+// Resolve may be called again on the same program.
+func Resolve(program *ast.Program, defined func(name string) bool) []*Error {
+	r := &resolver{globals: make(map[string]bool), defined: defined}
+
+	// Globals are late bound, so any let outside a function defines one
+	// for the whole program.
+	for _, name := range declarations(program.Statements) {
+		r.globals[name] = true
+	}
+
+	r.statements(program.Statements)
+	return r.errors
+}
+
+func (r *resolver) statements(statements []ast.Statement) {
+	for _, s := range statements {
+		r.statement(s)
+	}
+}
+
+func (r *resolver) statement(node ast.Statement) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		r.expression(node.Value)
+
+		node.Name.Binding = nil
+		if len(r.scopes) > 0 {
+			current := r.scopes[len(r.scopes)-1]
+			current.visible[node.Name.Value] = true
+			node.Name.Binding = &ast.Binding{Depth: 0, Slot: current.slots[node.Name.Value]}
+		}
+	case *ast.ReturnStatement:
+		r.expression(node.ReturnValue)
+	case *ast.ExpressionStatement:
+		r.expression(node.Expression)
+	case *ast.BlockStatement:
+		r.statements(node.Statements)
+	}
+}
+
+func (r *resolver) expression(node ast.Expression) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		r.identifier(node)
+	case *ast.PrefixExpression:
+		r.expression(node.Right)
+	case *ast.InfixExpression:
+		r.expression(node.Left)
+		r.expression(node.Right)
+	case *ast.IfExpression:
+		r.expression(node.Condition)
+		r.statement(node.Consequence)
+		if node.Alternative != nil {
+			r.statement(node.Alternative)
+		}
+	case *ast.CallExpression:
+		r.expression(node.Function)
+		for _, a := range node.Arguments {
+			r.expression(a)
+		}
+	case *ast.IndexExpression:
+		r.expression(node.Left)
+		r.expression(node.Index)
+	case *ast.FunctionLiteral:
+		r.function(node)
+	}
+}
+
+func (r *resolver) function(node *ast.FunctionLiteral) {
+	s := &scope{slots: make(map[string]int), visible: make(map[string]bool)}
+
+	for _, p := range node.Parameters {
+		slot := s.declare(p.Value)
+		s.visible[p.Value] = true
+		p.Binding = &ast.Binding{Depth: 0, Slot: slot}
+	}
+	for _, name := range declarations(node.Body.Statements) {
+		s.declare(name)
+	}
+	node.NumLocals = len(s.slots)
+
+	r.scopes = append(r.scopes, s)
+	r.statement(node.Body)
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+// declare gives name a slot, or returns the one it already has: a
+// parameter or let declared again reuses it, just as a second let
+// overwrites the binding in an environment.
+func (s *scope) declare(name string) int {
+	if slot, ok := s.slots[name]; ok {
+		return slot
+	}
+
+	slot := len(s.slots)
+	s.slots[name] = slot
+	return slot
+}
+
+func (r *resolver) identifier(node *ast.Identifier) {
+	node.Binding = nil
+
+	last := len(r.scopes) - 1
+	for i := last; i >= 0; i-- {
+		s := r.scopes[i]
+
+		slot, ok := s.slots[node.Value]
+		if ok && (i < last || s.visible[node.Value]) {
+			node.Binding = &ast.Binding{Depth: last - i, Slot: slot}
+			return
+		}
+	}
+
+	if r.globals[node.Value] || object.GetBuiltinByName(node.Value) != nil {
+		return
+	}
+	if r.defined != nil && r.defined(node.Value) {
+		return
+	}
+
+	r.errors = append(r.errors, &Error{
+		Name:   node.Value,
+		Line:   node.Token.Line,
+		Column: node.Token.Column,
+	})
+}
+
+// declarations returns the names bound by the lets among statements,
+// including those in nested blocks but not in nested functions.
+func declarations(statements []ast.Statement) []string {
+	var names []string
+
+	var walkStatement func(ast.Statement)
+	var walkExpression func(ast.Expression)
+
+	walkStatement = func(node ast.Statement) {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			walkExpression(node.Value)
+			names = append(names, node.Name.Value)
+		case *ast.ReturnStatement:
+			walkExpression(node.ReturnValue)
+		case *ast.ExpressionStatement:
+			walkExpression(node.Expression)
+		case *ast.BlockStatement:
+			for _, s := range node.Statements {
+				walkStatement(s)
+			}
+		}
+	}
+
+	walkExpression = func(node ast.Expression) {
+		switch node := node.(type) {
+		case *ast.PrefixExpression:
+			walkExpression(node.Right)
+		case *ast.InfixExpression:
+			walkExpression(node.Left)
+			walkExpression(node.Right)
+		case *ast.IfExpression:
+			walkExpression(node.Condition)
+			walkStatement(node.Consequence)
+			if node.Alternative != nil {
+				walkStatement(node.Alternative)
+			}
+		case *ast.CallExpression:
+			walkExpression(node.Function)
+			for _, a := range node.Arguments {
+				walkExpression(a)
+			}
+		case *ast.IndexExpression:
+			walkExpression(node.Left)
+			walkExpression(node.Index)
+		}
+	}
+
+	for _, s := range statements {
+		walkStatement(s)
+	}
+	return names
+}
@@ -0,0 +1,183 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// identifiers returns the identifiers of program named name, in source
+// order, leaving out let names and parameters.
+func identifiers(program *ast.Program, name string) []*ast.Identifier {
+	var found []*ast.Identifier
+
+	var walk func(node ast.Node)
+	walk = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.Program:
+			for _, s := range node.Statements {
+				walk(s)
+			}
+		case *ast.BlockStatement:
+			for _, s := range node.Statements {
+				walk(s)
+			}
+		case *ast.LetStatement:
+			walk(node.Value)
+		case *ast.ReturnStatement:
+			walk(node.ReturnValue)
+		case *ast.ExpressionStatement:
+			walk(node.Expression)
+		case *ast.Identifier:
+			if node.Value == name {
+				found = append(found, node)
+			}
+		case *ast.PrefixExpression:
+			walk(node.Right)
+		case *ast.InfixExpression:
+			walk(node.Left)
+			walk(node.Right)
+		case *ast.IfExpression:
+			walk(node.Condition)
+			walk(node.Consequence)
+			if node.Alternative != nil {
+				walk(node.Alternative)
+			}
+		case *ast.CallExpression:
+			walk(node.Function)
+			for _, a := range node.Arguments {
+				walk(a)
+			}
+		case *ast.FunctionLiteral:
+			walk(node.Body)
+		}
+	}
+
+	walk(program)
+	return found
+}
+
+func TestBindings(t *testing.T) {
+	tests := []struct {
+		input    string
+		name     string
+		expected []*ast.Binding
+	}{
+		{"let a = 1; a", "a", []*ast.Binding{nil}},
+		{"fn(a, b) { b }", "b", []*ast.Binding{{Depth: 0, Slot: 1}}},
+		{"fn(a) { let b = a; b }", "b", []*ast.Binding{{Depth: 0, Slot: 1}}},
+		{"fn(a) { fn(b) { a } }", "a", []*ast.Binding{{Depth: 1, Slot: 0}}},
+		{"fn(a) { fn() { fn() { a } } }", "a", []*ast.Binding{{Depth: 2, Slot: 0}}},
+		// a let is visible from where it appears...
+		{"let x = 1; fn() { let y = x; let x = 2; x }", "x",
+			[]*ast.Binding{nil, {Depth: 0, Slot: 1}}},
+		// ...but nested functions see all of the lets around them
+		{"fn() { let f = fn() { g() }; let g = fn() { f() }; }", "g",
+			[]*ast.Binding{{Depth: 1, Slot: 1}}},
+		{"fn(a) { let a = a + 1; a }", "a",
+			[]*ast.Binding{{Depth: 0, Slot: 0}, {Depth: 0, Slot: 0}}},
+		{"fn() { if (true) { let c = 1; }; c }", "c", []*ast.Binding{{Depth: 0, Slot: 0}}},
+		{"fn() { len }", "len", []*ast.Binding{nil}},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		if errs := Resolve(program, nil); len(errs) != 0 {
+			t.Errorf("%q: unexpected errors %v", tt.input, errs)
+			continue
+		}
+
+		found := identifiers(program, tt.name)
+		if len(found) != len(tt.expected) {
+			t.Fatalf("%q: found %d identifiers %s, want %d",
+				tt.input, len(found), tt.name, len(tt.expected))
+		}
+
+		for i, ident := range found {
+			want, got := tt.expected[i], ident.Binding
+			if (want == nil) != (got == nil) || (want != nil && *want != *got) {
+				t.Errorf("%q: wrong binding for %s #%d. want=%+v, got=%+v",
+					tt.input, tt.name, i, want, got)
+			}
+		}
+	}
+}
+
+func TestUndefined(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let a = 1; a + len", nil},
+		{"let f = fn() { later }; let later = 1;", nil},
+		{"if (true) { let g = 1; }; g", nil},
+		{"defined + 1", nil},
+		{"a + b", []string{"1:1: undefined variable a", "1:5: undefined variable b"}},
+		{"fn(x) {\n  x + y\n}", []string{"2:7: undefined variable y"}},
+		{"fn() { let y = x; let x = 1; }", []string{"1:16: undefined variable x"}},
+		{"fn() { let z = 1; }; z", []string{"1:22: undefined variable z"}},
+	}
+
+	defined := func(name string) bool { return name == "defined" }
+
+	for _, tt := range tests {
+		errs := Resolve(parse(t, tt.input), defined)
+		if len(errs) != len(tt.expected) {
+			t.Errorf("%q: wrong errors. want=%v, got=%v", tt.input, tt.expected, errs)
+			continue
+		}
+		for i, err := range errs {
+			if err.Error() != tt.expected[i] {
+				t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected[i], err)
+			}
+		}
+	}
+}
+
+// TestEvalResolved checks that eval gives the same results whether or not
+// the program has been resolved.
+func TestEvalResolved(t *testing.T) {
+	tests := []string{
+		"let a = 5; let b = a * 2; b",
+		"let add = fn(a, b) { a + b }; add(1, add(2, 3))",
+		"let newAdder = fn(x) { fn(y) { x + y } }; let addTwo = newAdder(2); addTwo(3)",
+		"let f = fn(a) { let a = a * 10; let b = a + 1; b }; f(4)",
+		"let x = 1; let f = fn() { let y = x; let x = 2; x + y }; f()",
+		`let f = fn(n) {
+			let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+			let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+			isEven(n)
+		}; f(11)`,
+		"let loop = fn(n, acc) { if (n == 0) { return acc; } loop(n - 1, acc + n) }; loop(1000, 0)",
+		"let f = fn() { if (false) { let c = 1; }; c }; f()",
+		"let f = fn(a, a) { a }; f(1, 2)",
+		"let counter = fn() { let n = 0; fn() { n + 1 } }; counter()()",
+	}
+
+	for _, input := range tests {
+		want := eval.Eval(parse(t, input), object.NewEnvironment())
+
+		program := parse(t, input)
+		Resolve(program, nil)
+		got := eval.Eval(program, object.NewEnvironment())
+
+		if got.Inspect() != want.Inspect() {
+			t.Errorf("%q: want=%s, got=%s", input, want.Inspect(), got.Inspect())
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package tester
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/shoebilyas123/cminusminus/cmm/format"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// Details returns what to show below the message of f: a diff of the
+// values assert_eq compared if they are strings of several lines, or else
+// nothing, as the message shows them already.
+func (f *Failure) Details() string {
+	got, ok1 := f.Got.(*object.StringObject)
+	want, ok2 := f.Want.(*object.StringObject)
+	if !ok1 || !ok2 || !strings.Contains(got.Value, "\n") && !strings.Contains(want.Value, "\n") {
+		return ""
+	}
+	return string(format.Unified("want", "got", []byte(want.Value+"\n"), []byte(got.Value+"\n")))
+}
+
+// WriteText writes the outcome of f the way go test does: each failure,
+// or with verbose each test, and then a line for the file.
+func WriteText(w io.Writer, f *File, verbose bool) {
+	if f.Err != nil {
+		for _, line := range strings.Split(f.Err.Error(), "\n") {
+			fmt.Fprintln(w, line)
+		}
+		fmt.Fprintf(w, "FAIL\t%s [build failed]\n", f.Name)
+		return
+	}
+
+	for _, t := range f.Tests {
+		if verbose {
+			fmt.Fprintf(w, "=== RUN   %s\n", t.Name)
+		}
+		if t.Failure == nil {
+			if verbose {
+				fmt.Fprintf(w, "--- PASS: %s (%s)\n", t.Name, seconds(t.Elapsed))
+			}
+			continue
+		}
+
+		fmt.Fprintf(w, "--- FAIL: %s (%s)\n", t.Name, seconds(t.Elapsed))
+		fmt.Fprintf(w, "    %s: %s\n", t.Failure.Position(), t.Failure.Message)
+		for _, line := range lines(t.Failure.Details()) {
+			fmt.Fprintf(w, "        %s\n", line)
+		}
+	}
+
+	switch {
+	case f.Failed():
+		fmt.Fprintf(w, "FAIL\t%s\t%s\n", f.Name, seconds(f.Elapsed))
+	case len(f.Tests) == 0:
+		fmt.Fprintf(w, "ok  \t%s\t%s [no tests to run]\n", f.Name, seconds(f.Elapsed))
+	default:
+		fmt.Fprintf(w, "ok  \t%s\t%s\n", f.Name, seconds(f.Elapsed))
+	}
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.2fs", d.Seconds())
+}
+
+// lines splits text into its lines, without the newline after the last.
+func lines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// WriteTAP writes the outcome of files in the Test Anything Protocol,
+// version 13, with a test point for each test and for each file that could
+// not be loaded.
+func WriteTAP(w io.Writer, files []*File) {
+	var points int
+	for _, f := range files {
+		if f.Err != nil {
+			points++
+		}
+		points += len(f.Tests)
+	}
+
+	fmt.Fprintln(w, "TAP version 13")
+	fmt.Fprintf(w, "1..%d\n", points)
+
+	n := 0
+	for _, f := range files {
+		if f.Err != nil {
+			n++
+			fmt.Fprintf(w, "not ok %d - %s\n", n, f.Name)
+			writeYAML(w, f.Err.Error(), "", "")
+			continue
+		}
+		for _, t := range f.Tests {
+			n++
+			if t.Failure == nil {
+				fmt.Fprintf(w, "ok %d - %s: %s\n", n, f.Name, t.Name)
+				continue
+			}
+			fmt.Fprintf(w, "not ok %d - %s: %s\n", n, f.Name, t.Name)
+			writeYAML(w, t.Failure.Message, t.Failure.Position(), t.Failure.Details())
+		}
+	}
+}
+
+// writeYAML writes the diagnostics of a test point that failed.
+func writeYAML(w io.Writer, message, at, details string) {
+	fmt.Fprintln(w, "  ---")
+	writeBlock(w, "message", message)
+	if at != "" {
+		fmt.Fprintf(w, "  at: %q\n", at)
+	}
+	if details != "" {
+		writeBlock(w, "diff", details)
+	}
+	fmt.Fprintln(w, "  ...")
+}
+
+// writeBlock writes text as a YAML literal block.
+func writeBlock(w io.Writer, key, text string) {
+	fmt.Fprintf(w, "  %s: |\n", key)
+	for _, line := range lines(text) {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+}
+
+// The elements of a JUnit XML report.
+type (
+	junitSuites struct {
+		XMLName  xml.Name     `xml:"testsuites"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Errors   int          `xml:"errors,attr"`
+		Time     string       `xml:"time,attr"`
+		Suites   []junitSuite `xml:"testsuite"`
+	}
+
+	junitSuite struct {
+		Name     string      `xml:"name,attr"`
+		Tests    int         `xml:"tests,attr"`
+		Failures int         `xml:"failures,attr"`
+		Errors   int         `xml:"errors,attr"`
+		Time     string      `xml:"time,attr"`
+		Cases    []junitCase `xml:"testcase"`
+	}
+
+	junitCase struct {
+		Name      string        `xml:"name,attr"`
+		ClassName string        `xml:"classname,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitMessage `xml:"failure,omitempty"`
+		Error     *junitMessage `xml:"error,omitempty"`
+	}
+
+	junitMessage struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",cdata"`
+	}
+)
+
+// WriteJUnit writes the outcome of files as JUnit XML, with a test suite
+// for each file. A file that could not be loaded has a single test case
+// with an error.
+func WriteJUnit(w io.Writer, files []*File) error {
+	var report junitSuites
+	var elapsed time.Duration
+	for _, f := range files {
+		suite := junitSuite{Name: f.Name, Time: junitTime(f.Elapsed)}
+		if f.Err != nil {
+			suite.Errors = 1
+			suite.Cases = append(suite.Cases, junitCase{
+				Name:      f.Name,
+				ClassName: f.Name,
+				Time:      junitTime(0),
+				Error:     &junitMessage{Message: "could not load the tests", Text: f.Err.Error()},
+			})
+		}
+		for _, t := range f.Tests {
+			c := junitCase{Name: t.Name, ClassName: f.Name, Time: junitTime(t.Elapsed)}
+			if t.Failure != nil {
+				suite.Failures++
+				text := t.Failure.Position() + ": " + t.Failure.Message
+				if details := t.Failure.Details(); details != "" {
+					text += "\n" + details
+				}
+				c.Failure = &junitMessage{Message: t.Failure.Message, Text: text}
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		suite.Tests = len(suite.Cases)
+
+		report.Suites = append(report.Suites, suite)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		elapsed += f.Elapsed
+	}
+	report.Time = junitTime(elapsed)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
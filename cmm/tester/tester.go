@@ -0,0 +1,416 @@
+// Package tester runs the tests written in cmm.
+//
+// Tests live in files whose names end in _test.cmm. A test is a function
+// without parameters bound by a let at the top level of such a file, whose
+// name is test or starts with test_ or with test and an upper case letter:
+//
+//	let test_add = fn() {
+//		assert_eq(add(1, 2), 3);
+//	};
+//
+// A test fails if calling it fails, which the assert, assert_eq and
+// assert_error builtins do when what they check does not hold. If the file
+// foo.cmm sits next to foo_test.cmm, its globals are visible to the tests.
+//
+// Each test runs in an environment of its own: both files are evaluated in
+// a fresh one before the test is called, so that no test sees what another
+// one changed.
+package tester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/cover"
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+	"github.com/shoebilyas123/cminusminus/cmm/resolver"
+	"github.com/shoebilyas123/cminusminus/cmm/types"
+)
+
+// Suffix ends the names of test files.
+const Suffix = "_test.cmm"
+
+// Options control how the tests of a file run.
+type Options struct {
+	// Run selects the tests whose names it matches. nil selects all.
+	Run *regexp.Regexp
+	// Timeout bounds the time each test may take. Zero means no limit.
+	Timeout time.Duration
+	// Cover, if set, counts the statements and if arms of the test file
+	// and the file it tests as the tests run.
+	Cover *cover.Profile
+}
+
+// File is the outcome of running the tests of a file.
+type File struct {
+	Name  string
+	Tests []*Test
+	// Err is set if the file could not be loaded, in which case no test
+	// ran.
+	Err     error
+	Elapsed time.Duration
+}
+
+// Failed reports whether the file could not be loaded or a test failed.
+func (f *File) Failed() bool {
+	if f.Err != nil {
+		return true
+	}
+	for _, t := range f.Tests {
+		if t.Failure != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Test is the outcome of a test.
+type Test struct {
+	Name string
+	// Failure is nil if the test passed.
+	Failure *Failure
+	Elapsed time.Duration
+}
+
+// Failure says why and where a test failed. The position is that of the
+// statement of the test file that was running in the innermost call the
+// error ended, or of the file it tests if there was none.
+type Failure struct {
+	File         string
+	Line, Column int
+	Message      string
+
+	// Got and Want are the values an assert_eq that failed compared.
+	Got, Want object.Object
+}
+
+// Position returns where the failure happened as file:line:column.
+func (f *Failure) Position() string {
+	if f.Line == 0 {
+		return f.File
+	}
+	return fmt.Sprintf("%s:%d:%d", f.File, f.Line, f.Column)
+}
+
+// Find returns the test files named by paths. A directory stands for the
+// test files anywhere below it.
+func Find(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.HasSuffix(name, Suffix) {
+				files = append(files, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// IsTest reports whether name is the name of a test.
+func IsTest(name string) bool {
+	rest, ok := strings.CutPrefix(name, "test")
+	if !ok || rest == "" {
+		return ok
+	}
+	if rest[0] == '_' {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+	return unicode.IsUpper(r)
+}
+
+// source is a parsed file.
+type source struct {
+	name    string
+	program *ast.Program
+}
+
+// runner runs the tests of a file.
+type runner struct {
+	sources   []*source // the file under test, if any, then the test file
+	evaluator *eval.Evaluator
+
+	// statements maps the statements of the sources to their file
+	statements map[ast.Statement]string
+	test       string
+
+	// at is where the test is in the current call, and callers where it
+	// was in the calls that are under way. tail is where the caller of a
+	// call that ended in a tail call is, until the call that replaces it
+	// starts. failed is where the test was when an error that was not
+	// caught first ended a call.
+	at      place
+	callers []place
+	tail    *place
+	failed  *place
+
+	// compared holds the values of the assert_eq calls that failed, by
+	// the error they failed with
+	compared map[*object.ErrorObject][2]object.Object
+}
+
+// Run runs the tests of the test file name.
+func Run(name string, opts Options) *File {
+	start := time.Now()
+	file := &File{Name: name}
+
+	r, tests, err := load(name)
+	if err != nil {
+		file.Err = err
+		return file
+	}
+	hooks := eval.Hooks{Statement: r.statement, Call: r.call, Return: r.returned}
+	if opts.Cover != nil {
+		for _, src := range r.sources {
+			opts.Cover.Add(src.name, src.program)
+		}
+		hooks.Statement = func(stmt ast.Statement, env *object.Environment) {
+			r.statement(stmt, env)
+			opts.Cover.Statement(stmt, env)
+		}
+		hooks.Branch = opts.Cover.Branch
+	}
+	// Calls nest up to eval.DefaultMaxDepth, so that runaway recursion
+	// fails the test it is in rather than the whole run.
+	r.evaluator = eval.New(eval.Config{Timeout: opts.Timeout})
+	r.evaluator.SetHooks(hooks)
+
+	for _, test := range tests {
+		if opts.Run != nil && !opts.Run.MatchString(test) {
+			continue
+		}
+		file.Tests = append(file.Tests, r.run(test))
+	}
+	file.Elapsed = time.Since(start)
+	return file
+}
+
+// load parses and checks the test file name and the file it tests, and
+// returns a runner for them and the names of the tests.
+func load(name string) (*runner, []string, error) {
+	r := &runner{statements: make(map[ast.Statement]string), test: name}
+
+	subject := strings.TrimSuffix(name, Suffix) + ".cmm"
+	if _, err := os.Stat(subject); err == nil {
+		src, err := parse(subject)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.sources = append(r.sources, src)
+	}
+	src, err := parse(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.sources = append(r.sources, src)
+
+	checker := types.NewChecker()
+	for _, src := range r.sources {
+		var errs []error
+		for _, err := range types.Blocking(checker.Check(src.program)) {
+			errs = append(errs, fmt.Errorf("%s:%w", src.name, err))
+		}
+		if len(errs) != 0 {
+			return nil, nil, errors.Join(errs...)
+		}
+		resolver.Resolve(src.program, nil)
+		collect(src.program, src.name, r.statements)
+	}
+
+	var tests []string
+	for _, stmt := range src.program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || !IsTest(let.Name.Value) {
+			continue
+		}
+		fn, ok := let.Value.(*ast.FunctionLiteral)
+		if !ok {
+			continue
+		}
+		if len(fn.Parameters) != 0 {
+			return nil, nil, fmt.Errorf("%s:%d:%d: test %s must not take parameters",
+				name, let.Token.Line, let.Token.Column, let.Name.Value)
+		}
+		tests = append(tests, let.Name.Value)
+	}
+	return r, tests, nil
+}
+
+func parse(name string) (*source, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.New(lexer.New(string(data)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s: parse error: %s", name, strings.Join(p.Errors(), "; "))
+	}
+	return &source{name: name, program: program}, nil
+}
+
+// collect adds the statements in node, and those of the functions and if
+// expressions in it, to statements.
+func collect(node ast.Node, file string, statements map[ast.Statement]string) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement, *ast.ReturnStatement, *ast.ExpressionStatement:
+			statements[node.(ast.Statement)] = file
+		}
+		return true
+	})
+}
+
+// place is where a test is: the last statement of the test file that ran,
+// and the last of any file.
+type place struct {
+	last, lastAny ast.Statement
+}
+
+// statement is the Statement hook, which follows where the test is.
+func (r *runner) statement(stmt ast.Statement, _ *object.Environment) {
+	file, ok := r.statements[stmt]
+	if !ok {
+		return
+	}
+	if r.tail != nil {
+		// a builtin called in tail position has returned
+		r.at, r.tail = *r.tail, nil
+	}
+	r.at.lastAny = stmt
+	if file == r.test {
+		r.at.last = stmt
+	}
+}
+
+// call is the Call hook, which keeps where the caller is.
+func (r *runner) call(*object.Function, *object.Environment) {
+	caller := r.at
+	if r.tail != nil {
+		caller, r.tail = *r.tail, nil
+	}
+	r.callers = append(r.callers, caller)
+}
+
+// returned is the Return hook, which goes back to where the caller is. A
+// call that ends in a tail call stays where it is, since what it calls in
+// tail position, an assert builtin say, still runs for it. If the call
+// ended in an error, where it was raised is kept for the failure unless an
+// inner call already ended in one.
+func (r *runner) returned(_ *object.Function, result object.Object) {
+	caller := r.callers[len(r.callers)-1]
+	r.callers = r.callers[:len(r.callers)-1]
+	if result == nil {
+		r.tail = &caller
+		return
+	}
+
+	if result.Type() == object.ERROR_OBJ && r.failed == nil {
+		failed := r.at
+		r.failed = &failed
+	}
+	r.at, r.tail = caller, nil
+}
+
+// run runs the test name in a fresh environment.
+func (r *runner) run(name string) *Test {
+	start := time.Now()
+	r.at, r.callers, r.tail, r.failed = place{}, nil, nil, nil
+	r.compared = make(map[*object.ErrorObject][2]object.Object)
+
+	env := object.NewEnvironment()
+	env.Set("assert_eq", &object.Builtin{Name: "assert_eq", Fn: r.assertEq})
+	env.Set("assert_error", &object.Builtin{Name: "assert_error", CallFn: r.assertError})
+
+	result, err := r.runTest(name, env)
+	test := &Test{Name: name, Elapsed: time.Since(start)}
+	switch {
+	case err != nil:
+		test.Failure = r.failure(err.Error())
+	case result != nil && result.Type() == object.ERROR_OBJ:
+		errObj := result.(*object.ErrorObject)
+		test.Failure = r.failure(errObj.Message)
+		if values, ok := r.compared[errObj]; ok {
+			test.Failure.Got, test.Failure.Want = values[0], values[1]
+		}
+	}
+	return test
+}
+
+// runTest evaluates the sources in env and then calls the test name.
+func (r *runner) runTest(name string, env *object.Environment) (object.Object, error) {
+	for _, src := range r.sources {
+		result, err := r.evaluator.Run(context.Background(), src.program, env)
+		if err != nil || result != nil && result.Type() == object.ERROR_OBJ {
+			return result, err
+		}
+	}
+
+	fn, _ := env.Get(name)
+	return r.evaluator.Call(context.Background(), fn, nil, env)
+}
+
+// assertEq is the assert_eq builtin, which also keeps the values it
+// compared when they differ, so that the failure can show them.
+func (r *runner) assertEq(args ...object.Object) object.Object {
+	result := object.GetBuiltinByName("assert_eq").Fn(args...)
+	if errObj, ok := result.(*object.ErrorObject); ok && len(args) == 2 {
+		r.compared[errObj] = [2]object.Object{args[0], args[1]}
+	}
+	return result
+}
+
+// assertError is the assert_error builtin, which also forgets where the
+// error it caught was raised.
+func (r *runner) assertError(call object.Caller, args ...object.Object) object.Object {
+	failed := r.failed
+	result := object.GetBuiltinByName("assert_error").CallFn(call, args...)
+	r.failed = failed
+	return result
+}
+
+// failure returns a failure with message where the error was raised.
+func (r *runner) failure(message string) *Failure {
+	at := r.at
+	if r.failed != nil {
+		at = *r.failed
+	}
+	stmt := at.last
+	if stmt == nil {
+		stmt = at.lastAny
+	}
+	if stmt == nil {
+		return &Failure{File: r.test, Message: message}
+	}
+	pos := ast.Position(stmt)
+	return &Failure{File: r.statements[stmt], Line: pos.Line, Column: pos.Column, Message: message}
+}
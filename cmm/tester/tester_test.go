@@ -0,0 +1,320 @@
+package tester
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shoebilyas123/cminusminus/cmm/cover"
+)
+
+const subject = `let add = fn(a, b) { a + b };
+let div = fn(a, b) { a / b };
+`
+
+const tests = `let calls = 0;
+
+let test_add = fn() {
+	assert_eq(add(1, 2), 3);
+};
+
+let test_fail = fn() {
+	assert_eq(add(1, 2), 4);
+};
+
+let test_div = fn() {
+	assert_eq(assert_error(fn() { div(1, 0) }), "division by zero");
+	div(2, 0);
+};
+
+let testLines = fn() {
+	assert_error(fn() { assert_eq(1, 2) });
+	assert_eq("a\nb", "a\nc");
+};
+
+let check = fn(x) { assert(x) };
+let test_helper = fn() { check(false) };
+
+let test_loop = fn() {
+	let loop = fn() { loop() };
+	loop()
+};
+
+let helper = fn() { 1 };
+let tested = 1;
+`
+
+// write writes the files of a test directory and returns the path of the
+// test file.
+func write(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "math.cmm"), []byte(subject), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "math_test.cmm")
+	if err := os.WriteFile(path, []byte(tests), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	path := write(t)
+	f := Run(path, Options{Timeout: 50 * time.Millisecond})
+	if f.Err != nil {
+		t.Fatal(f.Err)
+	}
+
+	want := []struct {
+		name    string
+		failure string
+		details string
+	}{
+		{"test_add", "", ""},
+		{"test_fail", "math_test.cmm:8:2: assert_eq failed: got 3, want 4", ""},
+		{"test_div", "math_test.cmm:13:2: division by zero", ""},
+		{"testLines", `math_test.cmm:18:2: assert_eq failed: got "a\nb", want "a\nc"`,
+			"--- want\n+++ got\n@@ -1,2 +1,2 @@\n a\n-c\n+b\n"},
+		{"test_helper", "math_test.cmm:21:21: assertion failed", ""},
+		{"test_loop", "math_test.cmm:25:20: timeout: deadline exceeded", ""},
+	}
+	if len(f.Tests) != len(want) {
+		t.Fatalf("got %d tests, want %d", len(f.Tests), len(want))
+	}
+	for i, tt := range want {
+		test := f.Tests[i]
+		var failure, details string
+		if test.Failure != nil {
+			failure = filepath.Base(test.Failure.Position()) + ": " + test.Failure.Message
+			details = test.Failure.Details()
+		}
+		if test.Name != tt.name || !strings.HasPrefix(failure, tt.failure) || details != tt.details {
+			t.Errorf("test %d: got %s %q %q, want %s %q %q", i, test.Name, failure, details, tt.name, tt.failure, tt.details)
+		}
+	}
+	if !f.Failed() {
+		t.Error("the file did not fail")
+	}
+}
+
+// TestFailurePosition checks that a failure is reported at the assertion
+// that failed, not at the statement of a function it called.
+func TestFailurePosition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "math_test.cmm")
+	src := "let add = fn(a, b) { a + b };\n\nlet test_fail = fn() { assert_eq(add(1, 2), 4) };\n"
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := Run(path, Options{})
+	if f.Err != nil {
+		t.Fatal(f.Err)
+	}
+	if len(f.Tests) != 1 || f.Tests[0].Failure == nil {
+		t.Fatalf("test_fail did not fail: %+v", f.Tests)
+	}
+	if got := filepath.Base(f.Tests[0].Failure.Position()); got != "math_test.cmm:3:24" {
+		t.Errorf("wrong position. want=%q, got=%q", "math_test.cmm:3:24", got)
+	}
+}
+
+// TestStackOverflow checks that runaway recursion fails only the test it
+// is in, and that the tests after it and the reports still run.
+func TestStackOverflow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deep_test.cmm")
+	src := "let dive = fn(n) { 1 + dive(n + 1) };\n\nlet test_deep = fn() { dive(0) };\nlet test_after = fn() { assert_eq(1, 1) };\n"
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := Run(path, Options{})
+	if f.Err != nil {
+		t.Fatal(f.Err)
+	}
+	if len(f.Tests) != 2 || f.Tests[0].Failure == nil || f.Tests[1].Failure != nil {
+		t.Fatalf("want test_deep to fail and test_after to pass: %+v", f.Tests)
+	}
+	failure := filepath.Base(f.Tests[0].Failure.Position()) + ": " + f.Tests[0].Failure.Message
+	if want := "deep_test.cmm:1:20: stack overflow"; failure != want {
+		t.Errorf("wrong failure. want=%q, got=%q", want, failure)
+	}
+
+	var tap bytes.Buffer
+	WriteTAP(&tap, []*File{f})
+	if !strings.Contains(tap.String(), "not ok 1 - ") || !strings.Contains(tap.String(), "ok 2 - ") {
+		t.Errorf("TAP report:\n%s", tap.String())
+	}
+}
+
+func TestRunFilter(t *testing.T) {
+	path := write(t)
+	f := Run(path, Options{Run: regexp.MustCompile("add|Lines")})
+	var names []string
+	for _, test := range f.Tests {
+		names = append(names, test.Name)
+	}
+	if got := strings.Join(names, " "); got != "test_add testLines" {
+		t.Errorf("ran %s", got)
+	}
+}
+
+// TestIsolation checks that each test starts from the globals the files
+// define, whatever the tests before it did.
+func TestIsolation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "env_test.cmm")
+	src := `let n = 1;
+let test_a = fn() { let n = 2; assert_eq(n, 2) };
+let test_b = fn() { assert_eq(n, 1) };
+let test_c = fn() { assert_eq(len("ab"), 2) };
+`
+	os.WriteFile(path, []byte(src), 0o644)
+
+	for _, test := range Run(path, Options{}).Tests {
+		if test.Failure != nil {
+			t.Errorf("%s: %s", test.Name, test.Failure.Message)
+		}
+	}
+}
+
+func TestCover(t *testing.T) {
+	path := write(t)
+	profile := cover.New()
+	Run(path, Options{Run: regexp.MustCompile("add|div"), Cover: profile})
+
+	var summary bytes.Buffer
+	profile.WriteSummary(&summary)
+	want := "math.cmm: 100.0% of 4 statements, no branches\n" +
+		"math_test.cmm: 58.3% of 24 statements, no branches\n" +
+		"total: 64.3% of 28 statements, no branches\n"
+	if got := strings.ReplaceAll(summary.String(), filepath.Dir(path)+string(filepath.Separator), ""); got != want {
+		t.Errorf("coverage:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		subject, src string
+		want         string
+	}{
+		{"", "let test_a = ;", "a_test.cmm: parse error"},
+		{"\nlet x: int = true;", "let test_a = fn() { 1 };", "a.cmm:2:14: cannot use true"},
+		{"let f = fn(x: int) { x };", "let test_a = fn() { f(true) };", "a_test.cmm:1:23: cannot use true"},
+		{"", "let test_a = fn(t) { t };", "a_test.cmm:1:1: test test_a must not take parameters"},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "a.cmm"), []byte(tt.subject), 0o644)
+		path := filepath.Join(dir, "a_test.cmm")
+		os.WriteFile(path, []byte(tt.src), 0o644)
+
+		f := Run(path, Options{})
+		if f.Err == nil || !strings.Contains(f.Err.Error(), tt.want) || len(f.Tests) != 0 {
+			t.Errorf("%s: got %v, want %s", tt.src, f.Err, tt.want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a_test.cmm", "a.cmm", "sub/b_test.cmm", "sub/c.cmm"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, nil, 0o644)
+	}
+
+	files, err := Find([]string{dir, filepath.Join(dir, "sub", "c.cmm")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		files[i], _ = filepath.Rel(dir, files[i])
+	}
+	if got := strings.Join(files, " "); got != "a_test.cmm sub/b_test.cmm sub/c.cmm" {
+		t.Errorf("found %s", got)
+	}
+
+	if _, err := Find([]string{filepath.Join(dir, "none")}); err == nil {
+		t.Error("no error for a missing path")
+	}
+}
+
+func TestIsTest(t *testing.T) {
+	for name, want := range map[string]bool{
+		"test": true, "test_add": true, "testAdd": true, "test_": true,
+		"tested": false, "add_test": false, "Test": false, "tes": false,
+	} {
+		if IsTest(name) != want {
+			t.Errorf("IsTest(%q) = %t", name, !want)
+		}
+	}
+}
+
+func TestReports(t *testing.T) {
+	files := []*File{
+		{Name: "a_test.cmm", Tests: []*Test{
+			{Name: "test_ok"},
+			{Name: "test_bad", Failure: &Failure{File: "a_test.cmm", Line: 3, Column: 2, Message: "assertion failed"}},
+		}},
+		{Name: "b_test.cmm", Err: os.ErrNotExist},
+	}
+
+	var text bytes.Buffer
+	for _, f := range files {
+		WriteText(&text, f, true)
+	}
+	wantText := `=== RUN   test_ok
+--- PASS: test_ok (0.00s)
+=== RUN   test_bad
+--- FAIL: test_bad (0.00s)
+    a_test.cmm:3:2: assertion failed
+FAIL	a_test.cmm	0.00s
+file does not exist
+FAIL	b_test.cmm [build failed]
+`
+	if text.String() != wantText {
+		t.Errorf("text report:\n%s\nwant:\n%s", text.String(), wantText)
+	}
+
+	var tap bytes.Buffer
+	WriteTAP(&tap, files)
+	wantTAP := `TAP version 13
+1..3
+ok 1 - a_test.cmm: test_ok
+not ok 2 - a_test.cmm: test_bad
+  ---
+  message: |
+    assertion failed
+  at: "a_test.cmm:3:2"
+  ...
+not ok 3 - b_test.cmm
+  ---
+  message: |
+    file does not exist
+  ...
+`
+	if tap.String() != wantTAP {
+		t.Errorf("TAP report:\n%s\nwant:\n%s", tap.String(), wantTAP)
+	}
+
+	var junit bytes.Buffer
+	if err := WriteJUnit(&junit, files); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<testsuites tests="3" failures="1" errors="1" time="0.000">`,
+		`<testsuite name="a_test.cmm" tests="2" failures="1" errors="0" time="0.000">`,
+		`<testcase name="test_ok" classname="a_test.cmm" time="0.000"></testcase>`,
+		`<failure message="assertion failed"><![CDATA[a_test.cmm:3:2: assertion failed]]></failure>`,
+		`<error message="could not load the tests"><![CDATA[file does not exist]]></error>`,
+	} {
+		if !strings.Contains(junit.String(), want) {
+			t.Errorf("JUnit report has no %s:\n%s", want, junit.String())
+		}
+	}
+}
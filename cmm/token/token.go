@@ -1,10 +1,17 @@
 package token
 
+import "sort"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+
+	// Line and Column locate the first character of the token, both
+	// counting from 1.
+	Line   int
+	Column int
 }
 
 var keywords = map[string]TokenType{
@@ -17,6 +24,16 @@ var keywords = map[string]TokenType{
 	"false":  FALSE,
 }
 
+// Keywords returns the keywords of the language, sorted.
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
+}
+
 func LookupIdentifier(ident string) TokenType {
 	if tok, ok := keywords[ident]; ok {
 		return tok
@@ -28,10 +45,12 @@ func LookupIdentifier(ident string) TokenType {
 const (
 	ILLEGAL = "ILLEGAL" // unsupported token
 	EOF     = "EOF"     // end of file
+	COMMENT = "COMMENT" // only returned by Lexer.Comments
 
 	// IDENTIFIERS AND LITERALS
-	IDENT = "IDENT"
-	INT   = "INT"
+	IDENT  = "IDENT"
+	INT    = "INT"
+	STRING = "STRING"
 
 	// OPERATORS
 	ASSIGN = "="
@@ -40,10 +59,14 @@ const (
 	// DELIMITERS
 	COMMA     = ","
 	SEMICOLON = ";"
+	COLON     = ":"
+	ARROW     = "->"
 	LPAREN    = "("
 	RPAREN    = ")"
 	LBRACE    = "{"
 	RBRACE    = "}"
+	LBRACKET  = "["
+	RBRACKET  = "]"
 
 	EXCLAIM   = "!"
 	MINUS     = "-"
@@ -0,0 +1,745 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/token"
+)
+
+// Error reports an operation that fails for the types of its operands, or
+// a value that does not match its annotation.
+type Error struct {
+	Line    int
+	Column  int
+	Message string
+
+	// Annotated is whether the error comes from the annotations of the
+	// program: it has no error there when checked without them.
+	Annotated bool
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// Blocking returns the errors of errs that come from annotations, which
+// stop a program from running. Programs without annotations run as they
+// always have, and meet their other errors, if at all, at runtime; cmm
+// check reports those.
+func Blocking(errs []*Error) []*Error {
+	var blocking []*Error
+	for _, err := range errs {
+		if err.Annotated {
+			blocking = append(blocking, err)
+		}
+	}
+	return blocking
+}
+
+// builtins holds the types of the builtin functions.
+var builtins = map[string]Type{
+	"len":          &Func{Params: []Type{String}, Result: Int},
+	"assert":       &Func{Params: []Type{Bool}, Result: Unknown},
+	"assert_eq":    &Func{Params: []Type{Unknown, Unknown}, Result: Unknown},
+	"assert_error": &Func{Params: []Type{&Func{Result: Unknown}}, Result: String},
+}
+
+// scope holds the variables of one function, or the globals.
+type scope struct {
+	types map[string]Type
+
+	// lets counts the lets of each name anywhere in the function, so that
+	// functions nested in it know which variables may change.
+	lets map[string]int
+
+	outer *scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{types: make(map[string]Type), lets: make(map[string]int), outer: outer}
+}
+
+// copy returns a copy of s's variables.
+func (s *scope) copy() *scope {
+	copied := newScope(s.outer)
+	for name, t := range s.types {
+		copied.types[name] = t
+	}
+	for name, n := range s.lets {
+		copied.lets[name] = n
+	}
+	return copied
+}
+
+// function holds what is known about the function being checked.
+type function struct {
+	// result is the annotated result type, or nil
+	result Type
+
+	// returns holds the types of the values it returns
+	returns []Type
+}
+
+// Checker checks programs. The globals of the programs it checked without
+// blocking errors are known to the ones after them, as in a REPL session.
+type Checker struct {
+	globals *scope
+
+	scope *scope
+	fn    *function
+
+	// conditional counts the if branches around the statement being
+	// checked, within the current function.
+	conditional int
+
+	// level counts the lets around the expression being checked.
+	level int
+
+	// trail records the variables unify changed, to undo them.
+	trail []binding
+
+	// types holds the type inferred for each expression, before its
+	// variables are followed, to explain errors.
+	types map[ast.Expression]Type
+
+	errors []*Error
+
+	// bare checks the same programs as if they had no annotations, to
+	// tell which errors come from them. It is nil in bare itself.
+	bare *Checker
+	// ignoreAnnotations is set in bare
+	ignoreAnnotations bool
+}
+
+// NewChecker returns a checker that knows no globals but the builtins.
+func NewChecker() *Checker {
+	return &Checker{
+		globals: newScope(nil),
+		bare:    &Checker{globals: newScope(nil), ignoreAnnotations: true},
+	}
+}
+
+// Check checks program with a new Checker.
+func Check(program *ast.Program) []*Error {
+	return NewChecker().Check(program)
+}
+
+// Check returns the type errors of program. If none of them is blocking,
+// the program runs, so the ones after it know its globals.
+func (c *Checker) Check(program *ast.Program) []*Error {
+	saved, savedBare := c.globals.copy(), c.bare.globals.copy()
+	c.run(program)
+	c.bare.run(program)
+	c.markAnnotated()
+
+	if len(Blocking(c.errors)) != 0 {
+		c.undo(saved)
+		c.bare.undo(savedBare)
+	}
+	return c.errors
+}
+
+// markAnnotated sets Annotated on the errors of the program just run that
+// bare did not find in it.
+func (c *Checker) markAnnotated() {
+	bare := make(map[token.Token]bool, len(c.bare.errors))
+	for _, err := range c.bare.errors {
+		bare[token.Token{Line: err.Line, Column: err.Column}] = true
+	}
+	for _, err := range c.errors {
+		err.Annotated = !bare[token.Token{Line: err.Line, Column: err.Column}]
+	}
+}
+
+// TypeOf returns the type of the value of program, which is left out of
+// the globals whether it checks or not.
+func (c *Checker) TypeOf(program *ast.Program) (Type, []*Error) {
+	saved := c.globals.copy()
+	t := c.run(program)
+	if t == nil {
+		t = Unknown
+	}
+	t = resolve(t, make(map[*Var]*Var))
+	c.undo(saved)
+	return t, c.errors
+}
+
+// Info checks program with a new Checker and returns, along with its
+// errors, the type inferred for each of its expressions. An identifier has
+// the type of the variable it refers to, instantiated where it is used.
+func Info(program *ast.Program) (map[ast.Expression]Type, []*Error) {
+	c := NewChecker()
+	c.run(program)
+
+	types := make(map[ast.Expression]Type, len(c.types))
+	for node, t := range c.types {
+		types[node] = resolve(t, make(map[*Var]*Var))
+	}
+	c.bare.run(program)
+	c.markAnnotated()
+	return types, c.errors
+}
+
+// Global returns the type of the global name, as the programs checked so
+// far left it.
+func (c *Checker) Global(name string) (Type, bool) {
+	t, ok := c.globals.types[name]
+	if !ok {
+		return nil, false
+	}
+	return resolve(t, make(map[*Var]*Var)), true
+}
+
+func (c *Checker) run(program *ast.Program) Type {
+	c.scope, c.fn, c.conditional, c.level = c.globals, nil, 0, 0
+	c.trail, c.types, c.errors = c.trail[:0], make(map[ast.Expression]Type), nil
+
+	countLets(program, c.globals.lets)
+	return c.statements(program.Statements)
+}
+
+// undo restores the globals to saved, and every variable unify changed
+// since the program started.
+func (c *Checker) undo(saved *scope) {
+	c.rollback(0)
+	c.globals = saved
+}
+
+// resolve returns a copy of t that no longer depends on the instances of
+// its variables.
+func resolve(t Type, vars map[*Var]*Var) Type {
+	switch t := prune(t).(type) {
+	case *Var:
+		if _, ok := vars[t]; !ok {
+			vars[t] = &Var{addable: t.addable}
+		}
+		return vars[t]
+	case *Func:
+		f := &Func{Params: make([]Type, len(t.Params)), Result: resolve(t.Result, vars)}
+		for i, p := range t.Params {
+			f.Params[i] = resolve(p, vars)
+		}
+		return f
+	case *scheme:
+		return resolve(t.t, vars)
+	default:
+		return t
+	}
+}
+
+// statements checks a list of statements and returns the type of the
+// value of the last one. It is nil if the last one is a return, whose
+// value is not the list's.
+func (c *Checker) statements(statements []ast.Statement) Type {
+	var last Type = Unknown
+
+	for i, s := range statements {
+		if i < len(statements)-1 {
+			c.statement(s)
+			continue
+		}
+
+		switch s := s.(type) {
+		case *ast.ExpressionStatement:
+			if ifExpr, ok := s.Expression.(*ast.IfExpression); ok {
+				last = c.ifExpression(ifExpr)
+			} else {
+				last = c.expression(s.Expression)
+			}
+		case *ast.ReturnStatement:
+			c.statement(s)
+			last = nil
+		default:
+			c.statement(s)
+		}
+	}
+
+	return last
+}
+
+func (c *Checker) statement(node ast.Statement) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		c.let(node)
+
+	case *ast.ReturnStatement:
+		t := c.expression(node.ReturnValue)
+		if c.fn == nil {
+			break
+		}
+		c.fn.returns = append(c.fn.returns, t)
+		if c.fn.result != nil && !c.tryUnify(t, c.fn.result, node.ReturnValue) {
+			c.errorf(node.ReturnValue, "cannot return %s (%s) from a function returning %s%s",
+				node.ReturnValue, t, c.fn.result, c.explain(node.ReturnValue))
+		}
+
+	case *ast.ExpressionStatement:
+		c.expression(node.Expression)
+
+	case *ast.BlockStatement:
+		c.statements(node.Statements)
+	}
+}
+
+func (c *Checker) let(node *ast.LetStatement) {
+	name := node.Name.Value
+	prev, bound := c.scope.types[name]
+
+	c.level++
+
+	var declared Type
+	if node.Type != nil && !c.ignoreAnnotations {
+		declared = c.annotation(node.Type)
+	}
+
+	var t Type
+	if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+		// the function may call itself through the name
+		self := declared
+		if self == nil {
+			self = c.newVar()
+		}
+		c.scope.types[name] = self
+
+		t = c.function(fn)
+		c.types[fn] = t
+		if declared == nil && !c.tryUnify(self, t, node.Value) {
+			c.errorf(node.Value, "%s is %s, but its body uses it as %s%s", name, t, self, c.explainType(self))
+		}
+	} else {
+		t = c.expression(node.Value)
+	}
+
+	if declared != nil {
+		if !c.tryUnify(t, declared, node.Value) {
+			c.errorf(node.Value, "cannot use %s (%s) as %s in let %s%s",
+				node.Value, t, declared, name, c.explain(node.Value))
+		}
+		t = declared
+	}
+
+	c.level--
+
+	if bound {
+		c.scope.types[name] = prev
+	} else {
+		delete(c.scope.types, name)
+	}
+	c.bind(name, c.generalize(t))
+}
+
+// bind gives name type t in the current scope. A let in an if branch may
+// not run, so if it changes the type of a variable, the variable's type is
+// no longer known.
+func (c *Checker) bind(name string, t Type) {
+	if prev, ok := c.scope.types[name]; ok && c.conditional > 0 && prev != t && !Equal(prev, t) {
+		t = Unknown
+	}
+	c.scope.types[name] = t
+}
+
+// lookup returns the type of the variable name. A function nested in the
+// one that declares it may run after any of its lets, so the type is only
+// known to it when there is a single one, and it has been checked.
+func (c *Checker) lookup(name string) Type {
+	nested := false
+	for s := c.scope; s != nil; s = s.outer {
+		t, ok := s.types[name]
+		if nested && (s.lets[name] > 1 || s.lets[name] == 1 && !ok) {
+			return Unknown
+		}
+		if ok {
+			return c.instantiate(t)
+		}
+		nested = true
+	}
+
+	if t, ok := builtins[name]; ok {
+		return t
+	}
+	return Unknown
+}
+
+func (c *Checker) expression(node ast.Expression) Type {
+	t := c.infer(node)
+	c.types[node] = t
+	return t
+}
+
+func (c *Checker) infer(node ast.Expression) Type {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.StringLiteral:
+		return String
+	case *ast.BooleanExpression:
+		return Bool
+	case *ast.Identifier:
+		return c.lookup(node.Value)
+	case *ast.PrefixExpression:
+		return c.prefix(node, c.expression(node.Right))
+	case *ast.InfixExpression:
+		left := c.expression(node.Left)
+		right := c.expression(node.Right)
+		return c.infix(node, left, right)
+	case *ast.IfExpression:
+		if t := c.ifExpression(node); t != nil {
+			return t
+		}
+	case *ast.FunctionLiteral:
+		return c.function(node)
+	case *ast.CallExpression:
+		return c.call(node)
+	case *ast.IndexExpression:
+		return c.index(node)
+	}
+	return Unknown
+}
+
+func (c *Checker) prefix(node *ast.PrefixExpression, right Type) Type {
+	right = prune(right)
+	if right == Unknown {
+		return Unknown
+	}
+	if _, ok := right.(*Var); !ok && object.HasPrefix(node.Op, objectType(right)) {
+		return Unknown
+	}
+
+	if node.Op == ast.OpNot {
+		return Bool
+	}
+	if c.tryUnify(right, Int, node) {
+		return Int
+	}
+
+	c.errorf(node, "unknown operator: %s%s in %s%s", node.Op, right, node, c.explain(node.Right))
+	return Unknown
+}
+
+// infixResults holds the result types of the operators on the builtin
+// types. They match the operators object registers for them.
+var infixResults = map[ast.Operator]map[Type]Type{
+	ast.OpAdd:      {Int: Int, String: String},
+	ast.OpSub:      {Int: Int},
+	ast.OpMul:      {Int: Int},
+	ast.OpDiv:      {Int: Int},
+	ast.OpLess:     {Int: Bool},
+	ast.OpGreater:  {Int: Bool},
+	ast.OpEqual:    {Int: Bool, String: Bool},
+	ast.OpNotEqual: {Int: Bool, String: Bool},
+}
+
+// infix returns the type of node's value. + takes two ints or two
+// strings, and the other arithmetic and comparison operators two ints. ==
+// and != take any two values.
+func (c *Checker) infix(node *ast.InfixExpression, left, right Type) Type {
+	left, right = prune(left), prune(right)
+	if left == Unknown || right == Unknown {
+		return Unknown
+	}
+
+	_, leftVar := left.(*Var)
+	_, rightVar := right.(*Var)
+	if !leftVar && !rightVar {
+		if left == right {
+			if t, ok := infixResults[node.Op][left]; ok {
+				return t
+			}
+		}
+		if object.HasInfix(objectType(left), node.Op, objectType(right)) {
+			// an operator added by the embedding program
+			return Unknown
+		}
+	}
+
+	switch node.Op {
+	case ast.OpEqual, ast.OpNotEqual:
+		return Bool
+	case ast.OpAdd:
+		if leftVar || rightVar {
+			mark := len(c.trail)
+			for _, t := range []Type{left, right} {
+				if v, ok := t.(*Var); ok {
+					c.save(v)
+					v.addable = true
+				}
+			}
+			if c.unify(left, right, node) {
+				return left
+			}
+			c.rollback(mark)
+		}
+	default:
+		if leftVar || rightVar {
+			mark := len(c.trail)
+			if c.unify(left, Int, node) && c.unify(right, Int, node) {
+				return infixResults[node.Op][Int]
+			}
+			c.rollback(mark)
+		}
+	}
+
+	explain := c.explain(node.Left) + c.explain(node.Right)
+	switch {
+	case leftVar || rightVar:
+		operand := left
+		if leftVar {
+			operand = right
+		}
+		c.errorf(node, "operator %s is not defined on %s in %s%s", node.Op, operand, node, explain)
+	case !Equal(left, right):
+		c.errorf(node, "type mismatch: %s %s %s in %s%s", left, node.Op, right, node, explain)
+	default:
+		c.errorf(node, "unknown operator: %s %s %s in %s%s", left, node.Op, right, node, explain)
+	}
+	return Unknown
+}
+
+// ifExpression returns the type of node's value: that of its branches if
+// they agree, or nil if neither one ends without returning.
+func (c *Checker) ifExpression(node *ast.IfExpression) Type {
+	c.expression(node.Condition)
+
+	c.conditional++
+	consequence := c.statements(node.Consequence.Statements)
+	var alternative Type = Unknown
+	if node.Alternative != nil {
+		alternative = c.statements(node.Alternative.Statements)
+	}
+	c.conditional--
+
+	switch {
+	case consequence == nil:
+		return alternative
+	case alternative == nil:
+		return consequence
+	case c.tryUnify(consequence, alternative, node):
+		return consequence
+	}
+	return Unknown
+}
+
+// function checks the body of fn and returns its type. The types of
+// parameters and the result that are not annotated are inferred from the
+// body.
+func (c *Checker) function(fn *ast.FunctionLiteral) Type {
+	sig := &Func{Params: make([]Type, len(fn.Parameters))}
+	for i := range fn.Parameters {
+		if i < len(fn.ParameterTypes) && fn.ParameterTypes[i] != nil && !c.ignoreAnnotations {
+			sig.Params[i] = c.annotation(fn.ParameterTypes[i])
+		} else {
+			sig.Params[i] = c.newVar()
+		}
+	}
+
+	inner := newScope(c.scope)
+	for i, p := range fn.Parameters {
+		inner.types[p.Value] = sig.Params[i]
+		inner.lets[p.Value]++
+	}
+	countLets(fn.Body, inner.lets)
+
+	saved, savedFn, savedConditional := c.scope, c.fn, c.conditional
+	c.scope, c.fn, c.conditional = inner, &function{}, 0
+	if fn.ReturnType != nil && !c.ignoreAnnotations {
+		c.fn.result = c.annotation(fn.ReturnType)
+	}
+
+	last := c.statements(fn.Body.Statements)
+	if c.fn.result != nil {
+		sig.Result = c.fn.result
+		if last != nil && !c.tryUnify(last, sig.Result, fn) {
+			body := lastExpression(fn.Body)
+			c.errorf(body, "cannot return %s (%s) from a function returning %s%s",
+				body, last, sig.Result, c.explain(body))
+		}
+	} else {
+		sig.Result = c.join(append(c.fn.returns, last), fn)
+	}
+
+	c.scope, c.fn, c.conditional = saved, savedFn, savedConditional
+	return sig
+}
+
+// join returns the type all of types can be made, ignoring nils, or
+// Unknown if they cannot.
+func (c *Checker) join(types []Type, cause ast.Node) Type {
+	var result Type
+	for _, t := range types {
+		switch {
+		case t == nil:
+		case result == nil:
+			result = t
+		case !c.tryUnify(result, t, cause):
+			return Unknown
+		}
+	}
+	if result == nil {
+		return Unknown
+	}
+	return result
+}
+
+func (c *Checker) call(node *ast.CallExpression) Type {
+	callee := c.expression(node.Function)
+	args := make([]Type, len(node.Arguments))
+	for i, a := range node.Arguments {
+		args[i] = c.expression(a)
+	}
+
+	switch fn := prune(callee).(type) {
+	case *Func:
+		if len(args) != len(fn.Params) {
+			c.errorf(node, "wrong number of arguments to %s: want=%d, got=%d%s",
+				node.Function, len(fn.Params), len(args), c.explain(node.Function))
+			return fn.Result
+		}
+		for i, a := range args {
+			if !c.tryUnify(a, fn.Params[i], node) {
+				c.errorf(node.Arguments[i], "cannot use %s (%s) as %s in argument %d to %s%s",
+					node.Arguments[i], a, fn.Params[i], i+1, node.Function,
+					c.explain(node.Function)+c.explain(node.Arguments[i]))
+			}
+		}
+		return fn.Result
+
+	case *Var:
+		result := c.newVar()
+		called := &Func{Params: args, Result: result}
+		if c.tryUnify(fn, called, node) {
+			return result
+		}
+		c.errorf(node, "cannot call %s (%s) as %s", node.Function, fn, called)
+		return Unknown
+	}
+
+	if callee == Unknown {
+		return Unknown
+	}
+	c.errorf(node, "cannot call %s (%s)%s", node.Function, callee, c.explain(node.Function))
+	return Unknown
+}
+
+// index checks an element of an array. Arrays only come from Go, so they
+// and their elements are Unknown.
+func (c *Checker) index(node *ast.IndexExpression) Type {
+	left := prune(c.expression(node.Left))
+	index := c.expression(node.Index)
+
+	if !c.tryUnify(index, Int, node) {
+		c.errorf(node.Index, "cannot use %s (%s) as an index in %s%s",
+			node.Index, index, node, c.explain(node.Index))
+	}
+
+	if _, ok := left.(*Var); !ok && left != Unknown {
+		c.errorf(node, "cannot index %s (%s)%s", node.Left, left, c.explain(node.Left))
+	}
+	return Unknown
+}
+
+// explain returns why node has the type it has, if the type was inferred
+// from another expression.
+func (c *Checker) explain(node ast.Expression) string {
+	t, ok := c.types[node]
+	if !ok {
+		return ""
+	}
+	if why := c.explainType(t); why != "" {
+		return fmt.Sprintf("; %s is %s%s", node, t, why)
+	}
+	return ""
+}
+
+// explainType returns where the variable t was given its instance.
+func (c *Checker) explainType(t Type) string {
+	for {
+		v, ok := t.(*Var)
+		if !ok || v.instance == nil {
+			return ""
+		}
+		if v.cause != nil {
+			pos := ast.Position(v.cause)
+			return fmt.Sprintf(" because of %s at %d:%d", v.cause, pos.Line, pos.Column)
+		}
+		t = v.instance
+	}
+}
+
+// annotation returns the type an annotation names.
+func (c *Checker) annotation(te *ast.TypeExpression) Type {
+	if !te.IsFunction() {
+		t, ok := names[te.Name]
+		if !ok {
+			c.errorAt(te.Token, "unknown type %s", te.Name)
+			return Unknown
+		}
+		return t
+	}
+
+	fn := &Func{Params: make([]Type, len(te.Parameters)), Result: c.annotation(te.Result)}
+	for i, p := range te.Parameters {
+		fn.Params[i] = c.annotation(p)
+	}
+	return fn
+}
+
+func (c *Checker) errorf(node ast.Node, format string, a ...interface{}) {
+	c.errorAt(ast.Position(node), format, a...)
+}
+
+func (c *Checker) errorAt(tok token.Token, format string, a ...interface{}) {
+	c.errors = append(c.errors, &Error{
+		Line:    tok.Line,
+		Column:  tok.Column,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// lastExpression returns the expression of the last statement of block,
+// which the checker only asks for when it is one.
+func lastExpression(block *ast.BlockStatement) ast.Expression {
+	return block.Statements[len(block.Statements)-1].(*ast.ExpressionStatement).Expression
+}
+
+// countLets adds the lets of node to lets, leaving out those of nested
+// functions.
+func countLets(node ast.Node, lets map[string]int) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			countLets(s, lets)
+		}
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			countLets(s, lets)
+		}
+	case *ast.LetStatement:
+		lets[node.Name.Value]++
+		countLets(node.Value, lets)
+	case *ast.ReturnStatement:
+		countLets(node.ReturnValue, lets)
+	case *ast.ExpressionStatement:
+		countLets(node.Expression, lets)
+	case *ast.PrefixExpression:
+		countLets(node.Right, lets)
+	case *ast.InfixExpression:
+		countLets(node.Left, lets)
+		countLets(node.Right, lets)
+	case *ast.IfExpression:
+		countLets(node.Condition, lets)
+		countLets(node.Consequence, lets)
+		if node.Alternative != nil {
+			countLets(node.Alternative, lets)
+		}
+	case *ast.CallExpression:
+		countLets(node.Function, lets)
+		for _, a := range node.Arguments {
+			countLets(a, lets)
+		}
+	case *ast.IndexExpression:
+		countLets(node.Left, lets)
+		countLets(node.Index, lets)
+	}
+}
@@ -0,0 +1,265 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"1 + true", []string{"1:1: type mismatch: int + bool in (1 + true)"}},
+		{`"a" - "b"`, []string{`1:1: unknown operator: string - string in ("a" - "b")`}},
+		{"-true", []string{"1:1: unknown operator: -bool in (-true)"}},
+		{"let x = 5; x(1)", []string{"1:12: cannot call x (int)"}},
+		{"let x: int = \"a\";", []string{`1:14: cannot use "a" (string) as int in let x`}},
+		{"let x: number = 1;", []string{"1:8: unknown type number"}},
+		{"let f = fn(a: int) { a }; f(\"s\")", []string{`1:29: cannot use "s" (string) as int in argument 1 to f`}},
+		{"let f = fn(a, b) { a }; f(1)", []string{"1:25: wrong number of arguments to f: want=2, got=1"}},
+		{"let f = fn() -> int { \"s\" };", []string{`1:23: cannot return "s" (string) from a function returning int`}},
+		{"let f = fn() -> int { return true; };", []string{"1:30: cannot return true (bool) from a function returning int"}},
+		{"let f = fn(a: int) { a * 2 }; f(1) + \"s\"", []string{`1:31: type mismatch: int + string in (f(1) + "s")`}},
+		{"let g: fn(int) -> bool = fn(n) { n > 1 }; g(1) - 1", []string{"1:43: type mismatch: bool - int in (g(1) - 1)"}},
+		{"len(1)", []string{"1:5: cannot use 1 (int) as string in argument 1 to len"}},
+		{"assert(1)", []string{"1:8: cannot use 1 (int) as bool in argument 1 to assert"}},
+		{"assert_eq(1)", []string{"1:1: wrong number of arguments to assert_eq: want=2, got=1"}},
+		{"assert_error(fn(x) { x })", []string{"1:14: cannot use fn(x)x (fn(a) -> a) as fn() -> unknown in argument 1 to assert_error"}},
+		{"assert_error(fn() { 1 }) - 1", []string{"1:1: type mismatch: string - int in (assert_error(fn()1) - 1)"}},
+		{"let f = fn(a) { a - 1; a + \"s\" };", []string{`1:24: type mismatch: int + string in (a + "s"); a is int because of (a - 1) at 1:17`}},
+		{"fn(f) { f(1); f(\"a\") }", []string{`1:17: cannot use "a" (string) as int in argument 1 to f; f is fn(int) -> a because of f(1) at 1:9`}},
+		{"let compose = fn(f, g) { fn(x) { f(g(x)) } }; compose(len, 2)", []string{"1:60: cannot use 2 (int) as fn(a) -> string in argument 2 to compose"}},
+		{"let f = fn(x) { f(1, 2) };", []string{"1:9: f is fn(a) -> b, but its body uses it as fn(int, int) -> a because of f(1, 2) at 1:17"}},
+		{"fn(x) { x + true }", []string{"1:9: operator + is not defined on bool in (x + true)"}},
+		{"fn(x) { x < \"s\" }", []string{`1:9: operator < is not defined on string in (x < "s")`}},
+		{"fn(f) { f(f) }", []string{"1:9: cannot call f (a) as fn(a) -> b"}},
+		{"let add = fn(a, b) { a + b }; add(1, 2); add(\"a\", \"b\"); add(true, false)", []string{
+			"1:61: cannot use true (bool) as a where a is int or string in argument 1 to add",
+			"1:67: cannot use false (bool) as a where a is int or string in argument 2 to add",
+		}},
+		{"let x = 5; x[0]", []string{"1:12: cannot index x (int)"}},
+		{"fn(a) { a[\"k\"] }", []string{`1:11: cannot use "k" (string) as an index in (a["k"])`}},
+		{"if (true) { 1 } else { 2 } + \"s\"", []string{`1:1: type mismatch: int + string in (if true 1else 2 + "s")`}},
+	}
+
+	for _, tt := range tests {
+		errs := Check(parse(t, tt.input))
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("Check(%q) =\n%s\nwant\n%s", tt.input, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+// TestValid checks programs that run without errors, most of them because
+// the checker cannot tell the types of their variables for sure.
+func TestValid(t *testing.T) {
+	tests := []string{
+		"let add = fn(a: int, b: int) -> int { a + b }; add(1, 2)",
+		"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(5)",
+		"let fib = fn(n: int) -> int { if (n < 2) { return n; } return fib(n - 1) + fib(n - 2); }; fib(10)",
+		"let id = fn(x) { x }; id(1) + id(2); id(\"a\") + \"b\"",
+		"let x = \"a\"; let f = fn() { x - 1 }; let x = 2; f()",
+		"let x = 1; if (false) { let x = \"s\"; }; x + 1",
+		"let f = fn() { let g = fn() { y - 1 }; let y = 2; g() }; f()",
+		"let apply = fn(f: fn(int) -> int, x: int) -> int { f(x) }; apply(fn(n) { n * 2 }, 3)",
+		"let x = if (true) { 1 } else { \"a\" }; x",
+		"1 == true",
+		"len(\"abc\") + 1",
+		"let first = fn(a) { a[0] }; first",
+		"let f = fn() -> int { let x = 1; x }; f()",
+		"assert(1 < 2); assert_eq(len(\"ab\"), 2); assert_eq(\"a\", \"a\")",
+		"assert_error(fn() { 1 / 0 }) + \"!\"",
+	}
+
+	for _, input := range tests {
+		program := parse(t, input)
+		if errs := Check(program); len(errs) != 0 {
+			t.Errorf("Check(%q) = %v", input, errs)
+			continue
+		}
+		if result := eval.Eval(program, object.NewEnvironment()); result != nil && result.Type() == object.ERROR_OBJ {
+			t.Errorf("%q does not run: %s", input, result.Inspect())
+		}
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1", "int"},
+		{"\"a\" + \"b\"", "string"},
+		{"!5", "bool"},
+		{"fn(a: int, b: int) { a < b }", "fn(int, int) -> bool"},
+		{"fn(a) { a }", "fn(a) -> a"},
+		{"fn(a, b) { a }", "fn(a, b) -> a"},
+		{"fn(a, b) { a + b }", "fn(a, a) -> a where a is int or string"},
+		{"fn(a) { a + 1 }", "fn(int) -> int"},
+		{"fn(f, g) { fn(x) { f(g(x)) } }", "fn(fn(a) -> b, fn(c) -> a) -> fn(c) -> b"},
+		{"fn(f: fn(int) -> string) { f(1) }", "fn(fn(int) -> string) -> string"},
+		{"fn(f, x) { f(f(x)) }", "fn(fn(a) -> a, a) -> a"},
+		{"fn(n) { if (n) { return 1; } 2 }", "fn(a) -> int"},
+		{"fn(n) { if (n) { return 1; } \"a\" }", "fn(a) -> unknown"},
+		{"let id = fn(x) { x }; id(id)(3)", "int"},
+		{"let id = fn(x) { x }; fn(y) { id(y) }", "fn(a) -> a"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact", "fn(int) -> int"},
+		{"let x: int = 1; fn() { x }", "fn() -> int"},
+		{"let x = 1; let x = 2; fn() { x }", "fn() -> unknown"},
+		{"len", "fn(string) -> int"},
+	}
+
+	for _, tt := range tests {
+		got, errs := NewChecker().TypeOf(parse(t, tt.input))
+		if len(errs) != 0 {
+			t.Errorf("TypeOf(%q) errors: %v", tt.input, errs)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("TypeOf(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestCheckerKeepsGlobals(t *testing.T) {
+	c := NewChecker()
+	if errs := c.Check(parse(t, "let x = 1;")); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if errs := c.Check(parse(t, "let y: int = true; x + y")); len(Blocking(errs)) != 1 {
+		t.Fatalf("got %d blocking errors, want 1: %v", len(Blocking(errs)), errs)
+	}
+	// the globals of a program that does not run are dropped
+	if errs := c.Check(parse(t, "x + 1; y - 1")); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// a program whose errors are not blocking runs, and keeps them
+	if errs := c.Check(parse(t, "let z = true; x + z")); len(errs) != 1 || len(Blocking(errs)) != 0 {
+		t.Fatalf("got errors %v, want 1 that is not blocking", errs)
+	}
+	if errs := c.Check(parse(t, "z - 1")); len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1 for bool - int: %v", len(errs), errs)
+	}
+
+	// an annotation of an earlier program still blocks
+	if errs := c.Check(parse(t, "let f = fn(n: int) { n };")); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if errs := c.Check(parse(t, "f(true)")); len(Blocking(errs)) != 1 {
+		t.Fatalf("got errors %v, want 1 that is blocking", errs)
+	}
+}
+
+func TestAnnotated(t *testing.T) {
+	tests := []struct {
+		input     string
+		annotated []bool
+	}{
+		{"if (false) { 1 + true } else { 2 }", []bool{false}},
+		{`let apply = fn(h) { h(1); h("a") }; apply(fn(x) { x })`, []bool{false}},
+		{`let pick = fn(b, x) { if (b) { x + 1 } else { x } }; pick(false, "s")`, []bool{false}},
+		{`let f = fn(x: int) { x }; f("a")`, []bool{true}},
+		{`let f = fn(x) { x + 1 }; f("a")`, []bool{false}},
+		{`let x: int = "a";`, []bool{true}},
+		{`let f = fn() -> int { "a" };`, []bool{true}},
+		{`let f = fn() -> int { return "a"; };`, []bool{true}},
+		{`let x: num = 1;`, []bool{true}},
+		{`let x: int = 1; x + true`, []bool{false}},
+	}
+
+	for _, tt := range tests {
+		errs := Check(parse(t, tt.input))
+		got := make([]bool, len(errs))
+		for i, err := range errs {
+			got[i] = err.Annotated
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.annotated) {
+			t.Errorf("%q: got annotated %v, want %v: %v", tt.input, got, tt.annotated, errs)
+		}
+	}
+}
+
+func TestGlobal(t *testing.T) {
+	c := NewChecker()
+	if errs := c.Check(parse(t, "let x = 1; let id = fn(a) { a };")); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"x", "int"},
+		{"id", "fn(a) -> a"},
+		{"len", ""},
+		{"y", ""},
+	}
+
+	for _, tt := range tests {
+		got, ok := c.Global(tt.name)
+		if !ok {
+			if tt.want != "" {
+				t.Errorf("Global(%q) not found, want %s", tt.name, tt.want)
+			}
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("Global(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	program := parse(t, "let id = fn(a) { a }; let n = id(1); n + true")
+	types, errs := Info(program)
+	if len(errs) != 1 {
+		t.Errorf("got %d errors, want 1: %v", len(errs), errs)
+	}
+
+	let := func(i int) ast.Expression {
+		return program.Statements[i].(*ast.LetStatement).Value
+	}
+	call := let(1).(*ast.CallExpression)
+	tests := []struct {
+		node ast.Expression
+		want string
+	}{
+		{let(0), "fn(a) -> a"},
+		{call, "int"},
+		{call.Function, "fn(int) -> int"},
+		{let(0).(*ast.FunctionLiteral).Body.Statements[0].(*ast.ExpressionStatement).Expression, "a"},
+	}
+	for _, tt := range tests {
+		got, ok := types[tt.node]
+		if !ok {
+			t.Errorf("no type for %s", tt.node)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("type of %s = %s, want %s", tt.node, got, tt.want)
+		}
+	}
+}
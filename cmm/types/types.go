@@ -0,0 +1,185 @@
+// Package types infers and checks the types of a program before it runs.
+//
+// Lets and function parameters and results may be annotated with a type:
+//
+//	let x: int = 4;
+//	let add = fn(a: int, b: int) -> int { a + b };
+//
+// The types of everything else are inferred in the manner of
+// Hindley–Milner: a function gets the most general type its body allows,
+// and a function bound by a let can be used at any instance of it, so
+// that
+//
+//	let compose = fn(f, g) { fn(x) { f(g(x)) } };
+//
+// has type fn(fn(a) -> b, fn(c) -> a) -> fn(c) -> b.
+//
+// What the checker cannot tell the type of, such as a variable that lets
+// bind to values of different types, has type Unknown, which any use is
+// allowed for. The branches of an if and the results of a function may
+// disagree, as they can when the program runs; their value is Unknown.
+package types
+
+import (
+	"strings"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// Type is the type of a value.
+type Type interface {
+	String() string
+}
+
+type basic string
+
+func (b basic) String() string { return string(b) }
+
+var (
+	Int    Type = basic("int")
+	String Type = basic("string")
+	Bool   Type = basic("bool")
+
+	// Unknown is the type of values whose type the checker cannot tell.
+	Unknown Type = basic("unknown")
+)
+
+// names maps the type names annotations may use to their types.
+var names = map[string]Type{
+	"int":    Int,
+	"string": String,
+	"bool":   Bool,
+}
+
+// Func is the type of a function.
+type Func struct {
+	Params []Type
+	Result Type
+}
+
+func (f *Func) String() string { return format(f) }
+
+// Var is a type variable: a type that is not known yet, or that may be any
+// type in a generalized function type.
+type Var struct {
+	// instance is the type the variable was found to be, or nil
+	instance Type
+
+	// addable is set when the variable is an operand of +, which only
+	// takes ints and strings.
+	addable bool
+
+	// level is the number of lets around the one that made the variable.
+	// Variables made inside a let's value are generalized by it.
+	level int
+
+	// cause is the expression that gave the variable its instance.
+	cause ast.Node
+}
+
+func (v *Var) String() string { return format(v) }
+
+// scheme is the type of a variable bound by a let, which is instantiated
+// with new variables in place of vars where the variable is used.
+type scheme struct {
+	vars []*Var
+	t    Type
+}
+
+func (s *scheme) String() string { return format(s.t) }
+
+// prune returns the type t stands for, following the instances of
+// variables.
+func prune(t Type) Type {
+	for {
+		v, ok := t.(*Var)
+		if !ok || v.instance == nil {
+			return t
+		}
+		t = v.instance
+	}
+}
+
+// Equal reports whether a and b are the same type.
+func Equal(a, b Type) bool {
+	a, b = prune(a), prune(b)
+	fa, ok := a.(*Func)
+	if !ok {
+		return a == b
+	}
+	fb, ok := b.(*Func)
+	if !ok || len(fa.Params) != len(fb.Params) || !Equal(fa.Result, fb.Result) {
+		return false
+	}
+	for i := range fa.Params {
+		if !Equal(fa.Params[i], fb.Params[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// format writes t with its variables named a, b, c, ... in the order they
+// appear, followed by the constraints on them.
+func format(t Type) string {
+	f := &formatter{names: make(map[*Var]string)}
+	s := f.format(t)
+	if len(f.addable) != 0 {
+		s += " where " + strings.Join(f.addable, ", ") + " is int or string"
+	}
+	return s
+}
+
+type formatter struct {
+	names   map[*Var]string
+	addable []string
+}
+
+func (f *formatter) format(t Type) string {
+	switch t := prune(t).(type) {
+	case *Var:
+		name, ok := f.names[t]
+		if !ok {
+			name = varName(len(f.names))
+			f.names[t] = name
+			if t.addable {
+				f.addable = append(f.addable, name)
+			}
+		}
+		return name
+	case *Func:
+		params := make([]string, len(t.Params))
+		for i, p := range t.Params {
+			params[i] = f.format(p)
+		}
+		return "fn(" + strings.Join(params, ", ") + ") -> " + f.format(t.Result)
+	case *scheme:
+		return f.format(t.t)
+	default:
+		return t.String()
+	}
+}
+
+// varName returns a, b, ..., z, a', b', ...
+func varName(i int) string {
+	name := string(rune('a' + i%26))
+	if i >= 26 {
+		name += strings.Repeat("'", i/26)
+	}
+	return name
+}
+
+// objectType returns the runtime type of values of type t, to look up
+// the operators registered for it.
+func objectType(t Type) object.ObjectType {
+	switch prune(t) {
+	case Int:
+		return object.INTEGER_OBJ
+	case String:
+		return object.STRING_OBJ
+	case Bool:
+		return object.BOOLEAN_OBJ
+	}
+	return object.FUNCTION_OBJ
+}
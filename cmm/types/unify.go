@@ -0,0 +1,183 @@
+package types
+
+import "github.com/shoebilyas123/cminusminus/cmm/ast"
+
+// binding records the state of a variable before unify changed it, so that
+// a failed attempt can be undone.
+type binding struct {
+	v        *Var
+	instance Type
+	addable  bool
+	level    int
+	cause    ast.Node
+}
+
+func (c *Checker) newVar() *Var {
+	return &Var{level: c.level}
+}
+
+// save records v before it is changed.
+func (c *Checker) save(v *Var) {
+	c.trail = append(c.trail, binding{v, v.instance, v.addable, v.level, v.cause})
+}
+
+// unify makes a and b the same type by giving their variables instances,
+// and reports whether they can be. cause is the expression that requires
+// it. Unknown unifies with anything.
+func (c *Checker) unify(a, b Type, cause ast.Node) bool {
+	a, b = prune(a), prune(b)
+	if a == b || a == Unknown || b == Unknown {
+		return true
+	}
+
+	if va, ok := a.(*Var); ok {
+		return c.bindVar(va, b, cause)
+	}
+	if vb, ok := b.(*Var); ok {
+		return c.bindVar(vb, a, cause)
+	}
+
+	fa, ok := a.(*Func)
+	if !ok {
+		return false
+	}
+	fb, ok := b.(*Func)
+	if !ok || len(fa.Params) != len(fb.Params) {
+		return false
+	}
+	for i := range fa.Params {
+		if !c.unify(fa.Params[i], fb.Params[i], cause) {
+			return false
+		}
+	}
+	return c.unify(fa.Result, fb.Result, cause)
+}
+
+// tryUnify is unify, but leaves every variable as it was if a and b cannot
+// be made the same.
+func (c *Checker) tryUnify(a, b Type, cause ast.Node) bool {
+	mark := len(c.trail)
+	if c.unify(a, b, cause) {
+		return true
+	}
+	c.rollback(mark)
+	return false
+}
+
+// rollback undoes the changes to variables since the trail was mark long.
+func (c *Checker) rollback(mark int) {
+	for i := len(c.trail) - 1; i >= mark; i-- {
+		b := c.trail[i]
+		b.v.instance, b.v.addable, b.v.level, b.v.cause = b.instance, b.addable, b.level, b.cause
+	}
+	c.trail = c.trail[:mark]
+}
+
+func (c *Checker) bindVar(v *Var, t Type, cause ast.Node) bool {
+	if other, ok := t.(*Var); ok {
+		c.save(other)
+		other.addable = other.addable || v.addable
+		if v.level < other.level {
+			other.level = v.level
+		}
+	} else {
+		if v.addable && t != Int && t != String {
+			return false
+		}
+		if !c.adjust(t, v) {
+			return false
+		}
+	}
+
+	c.save(v)
+	v.instance = t
+	v.cause = cause
+	return true
+}
+
+// adjust lowers the level of the variables in t to v's, as t now belongs
+// to v, and reports whether t does not contain v.
+func (c *Checker) adjust(t Type, v *Var) bool {
+	switch t := prune(t).(type) {
+	case *Var:
+		if t == v {
+			return false
+		}
+		if t.level > v.level {
+			c.save(t)
+			t.level = v.level
+		}
+	case *Func:
+		for _, p := range t.Params {
+			if !c.adjust(p, v) {
+				return false
+			}
+		}
+		return c.adjust(t.Result, v)
+	}
+	return true
+}
+
+// generalize returns the scheme of t that quantifies the variables made
+// inside the current let, or t itself if there are none.
+func (c *Checker) generalize(t Type) Type {
+	var vars []*Var
+	seen := make(map[*Var]bool)
+
+	var collect func(t Type)
+	collect = func(t Type) {
+		switch t := prune(t).(type) {
+		case *Var:
+			if t.level > c.level && !seen[t] {
+				seen[t] = true
+				vars = append(vars, t)
+			}
+		case *Func:
+			for _, p := range t.Params {
+				collect(p)
+			}
+			collect(t.Result)
+		}
+	}
+	collect(t)
+
+	if len(vars) == 0 {
+		return t
+	}
+	return &scheme{vars: vars, t: t}
+}
+
+// instantiate returns t with new variables in place of those its scheme
+// quantifies.
+func (c *Checker) instantiate(t Type) Type {
+	s, ok := t.(*scheme)
+	if !ok {
+		return t
+	}
+
+	fresh := make(map[*Var]*Var, len(s.vars))
+	for _, v := range s.vars {
+		nv := c.newVar()
+		nv.addable = v.addable
+		fresh[v] = nv
+	}
+
+	var copyType func(t Type) Type
+	copyType = func(t Type) Type {
+		switch t := prune(t).(type) {
+		case *Var:
+			if nv, ok := fresh[t]; ok {
+				return nv
+			}
+			return t
+		case *Func:
+			f := &Func{Params: make([]Type, len(t.Params)), Result: copyType(t.Result)}
+			for i, p := range t.Params {
+				f.Params[i] = copyType(p)
+			}
+			return f
+		}
+		return t
+	}
+	return copyType(s.t)
+}
@@ -0,0 +1,22 @@
+package vm
+
+import (
+	"github.com/shoebilyas123/cminusminus/cmm/code"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+// Frame is one call in progress. basePointer is where its locals start on
+// the stack; the closure being called sits just below it.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
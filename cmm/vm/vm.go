@@ -0,0 +1,541 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/code"
+	"github.com/shoebilyas123/cminusminus/cmm/compiler"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+)
+
+const (
+	StackSize   = 1 << 16
+	GlobalsSize = 1 << 16
+	MaxFrames   = 1 << 14
+)
+
+var (
+	True  = object.TRUE
+	False = object.FALSE
+	Null  = object.NULL
+)
+
+// VM runs the bytecode produced by the compiler package on a stack
+// machine. Runtime errors have the same messages as the errors eval.Eval
+// returns for the same program.
+type VM struct {
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+
+	stack []object.Object
+	sp    int // Always points to the next value. Top of stack is stack[sp-1]
+
+	frames      []*Frame
+	framesIndex int
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.Globals,
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// NewWithGlobalsStore returns a VM that reads and writes globals in s, so
+// that they outlive it, as the REPL needs between lines.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+func NewGlobalsStore() []object.Object {
+	return make([]object.Object, GlobalsSize)
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow")
+	}
+
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// LastPoppedStackElem returns the value of the last expression statement
+// run, which is the result of the program.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
+func (vm *VM) Run() error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint32(ins[ip+1:])
+			vm.currentFrame().ip += 4
+
+			if err := vm.push(vm.constants[constIndex]); err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			if err := vm.executeBinaryOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpBang, code.OpMinus:
+			if err := vm.executePrefixOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.pushResult(object.Index(left, index)); err != nil {
+				return err
+			}
+
+		case code.OpTrue:
+			if err := vm.push(True); err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			if err := vm.push(False); err != nil {
+				return err
+			}
+
+		case code.OpNull:
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpJump:
+			pos := int(code.ReadUint32(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint32(ins[ip+1:]))
+			vm.currentFrame().ip += 4
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			value := vm.globals[globalIndex]
+			if value == nil {
+				return undefinedError(vm.globalNames, int(globalIndex))
+			}
+
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			frame := vm.currentFrame()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
+			if cell, ok := (*slot).(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				*slot = vm.pop()
+			}
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			frame := vm.currentFrame()
+			value := vm.stack[frame.basePointer+int(localIndex)]
+			if cell, ok := value.(*object.Cell); ok {
+				value = cell.Value
+			}
+			if value == nil {
+				var err error
+				if value, err = vm.global(localName(frame, int(localIndex))); err != nil {
+					return err
+				}
+			}
+
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.push(object.Builtins[builtinIndex]); err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			value := vm.currentFrame().cl.Free[freeIndex]
+			if cell, ok := value.(*object.Cell); ok {
+				value = cell.Value
+				if value == nil {
+					var err error
+					if value, err = vm.global(cell.Name); err != nil {
+						return err
+					}
+				}
+			}
+
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpGetLocalCell:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.localCell(int(localIndex))); err != nil {
+				return err
+			}
+
+		case code.OpGetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.push(vm.currentFrame().cl.Free[freeIndex]); err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(currentClosure); err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint32(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+5:])
+			vm.currentFrame().ip += 5
+
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeTailCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			if vm.framesIndex == 1 {
+				// a return at the top level ends the program
+				vm.stack[vm.sp] = returnValue
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func undefinedError(names []string, index int) error {
+	name := "?"
+	if index < len(names) {
+		name = names[index]
+	}
+	return fmt.Errorf("NOT FOUND: undefined identifier - %s", name)
+}
+
+// localCell returns the cell holding local index of the current frame,
+// moving the local into a new one if it is not in one yet.
+func (vm *VM) localCell(index int) *object.Cell {
+	frame := vm.currentFrame()
+	slot := &vm.stack[frame.basePointer+index]
+	if cell, ok := (*slot).(*object.Cell); ok {
+		return cell
+	}
+
+	cell := &object.Cell{Name: localName(frame, index), Value: *slot}
+	*slot = cell
+	return cell
+}
+
+func localName(frame *Frame, index int) string {
+	if index < len(frame.cl.Fn.LocalNames) {
+		return frame.cl.Fn.LocalNames[index]
+	}
+	return "?"
+}
+
+// global looks up name the way eval does for a variable read before its
+// let has run: as a global, and then as a builtin.
+func (vm *VM) global(name string) (object.Object, error) {
+	for i, global := range vm.globalNames {
+		if global == name && vm.globals[i] != nil {
+			return vm.globals[i], nil
+		}
+	}
+	if builtin := object.GetBuiltinByName(name); builtin != nil {
+		return builtin, nil
+	}
+	return nil, fmt.Errorf("NOT FOUND: undefined identifier - %s", name)
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
+
+	return vm.enterLocals(frame, numArgs)
+}
+
+// executeTailCall calls a closure by reusing the current frame instead of
+// pushing a new one, so that tail recursion runs in constant space.
+func (vm *VM) executeTailCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	cl, ok := callee.(*object.Closure)
+	if !ok || vm.framesIndex == 1 {
+		return vm.executeCall(numArgs)
+	}
+
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := vm.currentFrame()
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+
+	frame.cl = cl
+	frame.ip = -1
+	return vm.enterLocals(frame, numArgs)
+}
+
+// enterLocals makes room for the locals of frame above its arguments, and
+// clears them so that reading one before it is set is an error.
+func (vm *VM) enterLocals(frame *Frame, numArgs int) error {
+	top := frame.basePointer + frame.cl.Fn.NumLocals
+	if top >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	for i := frame.basePointer + numArgs; i < top; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = top
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Call(vm.call, args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if errObj, ok := result.(*object.ErrorObject); ok {
+		return fmt.Errorf("%s", errObj.Message)
+	}
+
+	if result == nil {
+		result = Null
+	}
+
+	return vm.push(result)
+}
+
+// call calls fn for a builtin. It runs on a VM of its own, which shares
+// the constants and globals, so that an error ends only the call.
+func (vm *VM) call(fn object.Object, args ...object.Object) object.Object {
+	caller := &object.CompiledFunction{Instructions: code.Make(code.OpCall, len(args))}
+	sub := NewWithGlobalsStore(&compiler.Bytecode{Constants: vm.constants, Globals: vm.globalNames}, vm.globals)
+	sub.frames[0] = NewFrame(&object.Closure{Fn: caller}, 0)
+
+	sub.stack[0] = fn
+	copy(sub.stack[1:], args)
+	sub.sp = 1 + len(args)
+
+	if err := sub.Run(); err != nil {
+		return &object.ErrorObject{Message: err.Error()}
+	}
+	return sub.stack[sub.sp-1]
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj {
+	case True:
+		return true
+	case False, Null:
+		return false
+	default:
+		return true
+	}
+}
+
+// operators maps the opcodes of operators to the operators they compile
+// from.
+var operators = map[code.Opcode]ast.Operator{
+	code.OpAdd:         ast.OpAdd,
+	code.OpSub:         ast.OpSub,
+	code.OpMul:         ast.OpMul,
+	code.OpDiv:         ast.OpDiv,
+	code.OpEqual:       ast.OpEqual,
+	code.OpNotEqual:    ast.OpNotEqual,
+	code.OpGreaterThan: ast.OpGreater,
+	code.OpLessThan:    ast.OpLess,
+	code.OpBang:        ast.OpNot,
+	code.OpMinus:       ast.OpNegate,
+}
+
+// executeBinaryOperation applies op to the two values on top of the stack,
+// through the same operator table as eval.
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	return vm.pushResult(object.Infix(operators[op], left, right))
+}
+
+// executePrefixOperation applies op to the value on top of the stack.
+func (vm *VM) executePrefixOperation(op code.Opcode) error {
+	return vm.pushResult(object.Prefix(operators[op], vm.pop()))
+}
+
+// pushResult pushes the result of an operator, or returns its error.
+func (vm *VM) pushResult(result object.Object) error {
+	if errObj, ok := result.(*object.ErrorObject); ok {
+		return fmt.Errorf("%s", errObj.Message)
+	}
+	return vm.push(result)
+}
@@ -0,0 +1,276 @@
+package vm
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/shoebilyas123/cminusminus/cmm/ast"
+	"github.com/shoebilyas123/cminusminus/cmm/compiler"
+	"github.com/shoebilyas123/cminusminus/cmm/eval"
+	"github.com/shoebilyas123/cminusminus/cmm/lexer"
+	"github.com/shoebilyas123/cminusminus/cmm/object"
+	"github.com/shoebilyas123/cminusminus/cmm/parser"
+)
+
+// TestParity runs each program through both engines: the VM has to give
+// the same value, or fail with the same message, as eval.
+func TestParity(t *testing.T) {
+	tests := []string{
+		// integers
+		"64", "-5", "5 + 5 + 5 + 5 - 10", "2 * 2 * 2 * 2 * 2", "-50 + 100 + -50",
+		"5 * 2 + 10", "20 + 2 * -10", "50 / 2 * 2 + 10", "2 * (5 + 10)",
+		"(5 + 10 * 2 + 15 / 3) * 2 + -10",
+
+		// booleans
+		"!true", "!false", "!5", "!!true", "!!5", "1 < 2", "1 > 2", "1 < 1",
+		"1 == 1", "1 != 1", "1 == 2", "1 != 2", "true == true", "true != false",
+		"(1 < 2) == true", "(1 > 2) == true",
+
+		// conditionals
+		"if (true) { 10 }", "if (false) { 10 }", "if (1) { 10 }",
+		"if (1 > 2) { 10 } else { 20 }", "if (1 < 2) { 10 } else { 20 }",
+		"if (true) { }", "if (true) { let a = 5; }",
+
+		// returns
+		"return 10;", "return 10; 9;", "9; return 2 * 5; 9;",
+		"if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+
+		// errors
+		"5 + true;", "5 + true; 5;", "-true", "true + false;",
+		"5; true + false; 5", "if (10 > 1) { true + false; }",
+		"if (10 > 1) { if (10 > 1) { return true + false; } return 1; }",
+		"foobar", "1 / 0", `"a" - "b"`, `"a" + 1`, "5(1)",
+		"fn(a) { a }()", "fn() { x }()", "let f = fn() { g() }; f()",
+
+		// bindings and functions
+		"let a = 5; a;", "let a = 5 * 5; a;", "let a = 5; let b = a; let c = a + b + 5; c;",
+		"let a = 1; let a = a + 1; a", "let identity = fn(x) { x; }; identity(5);",
+		"let identity = fn(x) { return x; }; identity(5);",
+		"let double = fn(x) { x * 2; }; double(5);",
+		"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));",
+		"fn(x) { x; }(5)", "fn(x) { x + 2; }", "let f = fn() { }; f()",
+		"let f = fn() { let a = 1; }; f()", "let f = fn(x) { if (x) { 1 } }; f(false)",
+		"let early = fn() { if (true) { return 1; }; 2 }; early()",
+		"let f = fn() { later }; let later = 3; f()",
+		"let f = fn() { let g = fn() { h() }; let h = fn() { 7 }; g() }; f()",
+		"let f = fn() { let g = fn() { fn() { h } }; let k = g(); let h = 8; k() }; f()",
+		"let f = fn() { let g = fn() { h }; let h = 1; let h = h + 1; g() }; f()",
+		"let f = fn() { let g = fn() { h }; g(); let h = 1; }; f()",
+		"let x = 1; let f = fn() { let g = fn() { x }; let y = g(); let x = 2; y }; f()",
+		"let x = 1; let f = fn(c) { if (c) { let x = 2; }; x }; f(false)",
+		"let f = fn(c) { if (c) { let len = 0; }; len }; f(false)(\"ab\")",
+		`
+let newAdder = fn(x) {
+fn(y) { x + y };
+};
+let addTwo = newAdder(2);
+addTwo(2);`,
+		`
+let newClosure = fn(a, b) {
+let one = fn() { a; };
+let two = fn() { b; };
+fn() { one() + two(); };
+};
+newClosure(9, 90)();`,
+
+		// recursion
+		`
+let loop = fn(n, acc) {
+if (n == 0) { return acc; }
+loop(n - 1, acc + 1)
+};
+loop(1000000, 0);`,
+		`
+let count = fn(n, acc) {
+if (n == 0) { acc } else { return count(n - 1, acc + 2); }
+};
+count(100000, 0);`,
+		`
+let isEven = fn(n) { if (n == 0) { 1 } else { isOdd(n - 1) } };
+let isOdd = fn(n) { if (n == 0) { 0 } else { isEven(n - 1) } };
+isEven(100001);`,
+		"let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(10);",
+		`
+let wrapper = fn() {
+let countDown = fn(x) { if (x == 0) { 0 } else { countDown(x - 1) } };
+countDown(10);
+};
+wrapper();`,
+
+		// strings and builtins
+		`"Hello" + " " + "World!"`, `"a" == "a"`, `"a" != "a"`, `len("four")`,
+		`let len = fn(s) { 0 }; len("four")`, "len(1)", `len("a", "b")`, "len",
+
+		// assertions
+		"assert(1 < 2)", "assert(1 > 2)", "assert(1)", `assert_eq(len("ab"), 2)`,
+		`assert_eq("a", "b")`, "assert_eq(1, true)", "assert_error(fn() { 1 / 0 })",
+		"assert_error(fn() { 1 })", "assert_error(1)", `assert_error(fn() { assert_eq(1, 2) })`,
+		"let f = fn(x) { 10 / x }; assert_error(fn() { f(0) }); f(5)",
+		"let g = 2; assert_error(fn() { assert_eq(g, 3) })",
+		"let f = fn() { assert_error(fn() { assert(false) }) }; f() + \"!\"",
+	}
+
+	// programs whose constant indexes, local slots and jump targets do not
+	// fit in 16 or 8 bits
+	var lets strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&lets, "let %s = %d; ", varName(i), i)
+	}
+	tests = append(tests,
+		strings.Repeat("1;\n", 70000)+"1000",
+		"let f = fn() { "+lets.String()+varName(299)+" }; f()",
+		"if (false) { "+strings.Repeat("true; ", 40000)+"} else { 7 }",
+	)
+
+	defer debug.SetMaxStack(debug.SetMaxStack(8 << 20))
+
+	for _, input := range tests {
+		want := runEval(t, input)
+		if want == nil {
+			// eval gives no value at all for an empty block, where the VM
+			// has to push something
+			want = Null
+		}
+
+		got, err := runVM(t, input)
+		if errObj, ok := want.(*object.ErrorObject); ok {
+			if err == nil {
+				t.Errorf("%q: expected error %q, got=%s", input, errObj.Message, got.Inspect())
+			} else if err.Error() != errObj.Message {
+				t.Errorf("%q: wrong error. want=%q, got=%q", input, errObj.Message, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: vm error: %s", input, err)
+			continue
+		}
+		if got.Type() != want.Type() || got.Inspect() != want.Inspect() {
+			t.Errorf("%q: want=%s (%s), got=%s (%s)",
+				input, want.Inspect(), want.Type(), got.Inspect(), got.Type())
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	_, err := runVM(t, "let f = fn(n) { 1 + f(n) }; f(1)")
+	if err == nil || err.Error() != "stack overflow" {
+		t.Errorf("expected stack overflow, got=%v", err)
+	}
+}
+
+func TestGlobalsStore(t *testing.T) {
+	globals := NewGlobalsStore()
+	symbolTable := compiler.NewSymbolTable()
+	for i, b := range object.Builtins {
+		symbolTable.DefineBuiltin(i, b.Name)
+	}
+	constants := []object.Object{}
+
+	var result object.Object
+	for _, line := range []string{"let a = 2;", "let double = fn(x) { x * a };", "double(21)"} {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(parse(t, line)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		constants = comp.Bytecode().Constants
+
+		machine := NewWithGlobalsStore(comp.Bytecode(), globals)
+		if err := machine.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		result = machine.LastPoppedStackElem()
+	}
+
+	if result.Inspect() != "42" {
+		t.Errorf("wrong result. want=42, got=%s", result.Inspect())
+	}
+}
+
+func TestIndex(t *testing.T) {
+	args := &object.ArrayObject{Elements: []object.Object{
+		&object.StringObject{Value: "a"},
+		object.NewInteger(2),
+	}}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"args[0]", "a"},
+		{"args[2 - 1] + 1", "3"},
+		{"len(args)", "2"},
+		{"args[2]", "index out of range: 2 with length 2"},
+		{`args["0"]`, "array index must be INTEGER, got STRING"},
+		{"1[0]", "index operator not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		symbolTable := compiler.NewSymbolTable()
+		for i, b := range object.Builtins {
+			symbolTable.DefineBuiltin(i, b.Name)
+		}
+		globals := NewGlobalsStore()
+		globals[symbolTable.Define("args").Index] = args
+
+		comp := compiler.NewWithState(symbolTable, []object.Object{})
+		if err := comp.Compile(parse(t, tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		machine := NewWithGlobalsStore(comp.Bytecode(), globals)
+		got := ""
+		if err := machine.Run(); err != nil {
+			got = err.Error()
+		} else {
+			got = machine.LastPoppedStackElem().Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// varName returns a distinct identifier for each i: va, vb, ..., vz, vba,
+// and so on, as identifiers cannot hold digits.
+func varName(i int) string {
+	name := string(rune('a' + i%26))
+	for i /= 26; i > 0; i /= 26 {
+		name = string(rune('a'+i%26)) + name
+	}
+	return "v" + name
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func runEval(t *testing.T, input string) object.Object {
+	t.Helper()
+	return eval.Eval(parse(t, input), object.NewEnvironment())
+}
+
+func runVM(t *testing.T, input string) (object.Object, error) {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(t, input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	machine := New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		return nil, err
+	}
+	return machine.LastPoppedStackElem(), nil
+}
@@ -1,20 +1,11 @@
 package main
 
 import (
-	"fmt"
 	"os"
-	"os/user"
 
-	"github.com/shoebilyas123/cminusminus/cmm/repl"
+	"github.com/shoebilyas123/cminusminus/cmm/cli"
 )
 
 func main() {
-	user, err := user.Current()
-	if err != nil {
-		panic(err)
-	}
-	fmt.Printf("Hello %s! This is the cminusminus programming language!\n",
-		user.Username)
-	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	os.Exit(cli.Main(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }